@@ -0,0 +1,171 @@
+package transportc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gaukas/transportc/internal/utils"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	CERTIFICATE_VALIDITY_DEFAULT = 10 * 365 * 24 * time.Hour
+	FINGERPRINT_ALGORITHM        = "sha-256"
+)
+
+var (
+	ErrRemoteCertificateRejected = errors.New("remote DTLS certificate rejected")
+	ErrNoRemoteCertificate       = errors.New("no remote DTLS certificate")
+)
+
+// LoadCertificatePEM loads a DTLS certificate from the PEM-encoded X.509 certificate
+// and private key, e.g. those generated by GenerateCertificatePEM or openssl.
+func LoadCertificatePEM(certPEM, keyPEM []byte) (*webrtc.Certificate, error) {
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	certificate := webrtc.CertificateFromX509(keyPair.PrivateKey, leaf)
+	return &certificate, nil
+}
+
+// LoadCertificateFile loads a DTLS certificate from the PEM files of the X.509 certificate
+// and private key.
+func LoadCertificateFile(certFile, keyFile string) (*webrtc.Certificate, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return LoadCertificatePEM(certPEM, keyPEM)
+}
+
+// GenerateCertificatePEM generates a self-signed ECDSA P-256 certificate valid for
+// CERTIFICATE_VALIDITY_DEFAULT, to be saved and loaded with LoadCertificatePEM.
+func GenerateCertificatePEM() (certPEM, keyPEM []byte, err error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: new(big.Int).SetUint64(utils.RandUint64()),
+		Subject:      pkix.Name{CommonName: "transportc"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(CERTIFICATE_VALIDITY_DEFAULT),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// CertificateFingerprint returns the fingerprint of the certificate in the form
+// expected by Config.RemoteFingerprints, e.g. "sha-256 AB:CD:...".
+func CertificateFingerprint(certificate *webrtc.Certificate) (string, error) {
+	fingerprints, err := certificate.GetFingerprints()
+	if err != nil {
+		return "", err
+	}
+	for _, fingerprint := range fingerprints {
+		if fingerprint.Algorithm == FINGERPRINT_ALGORITHM {
+			return normalizeFingerprint(fingerprint.Algorithm + " " + fingerprint.Value), nil
+		}
+	}
+	return "", fmt.Errorf("no %s fingerprint", FINGERPRINT_ALGORITHM)
+}
+
+// certificateVerifier verifies the remote DTLS certificate against the allowlist
+// of fingerprints and the callback.
+type certificateVerifier struct {
+	fingerprints map[string]bool
+	verify       func(fingerprint string) error
+}
+
+// newCertificateVerifier returns nil if neither an allowlist nor a callback is set.
+func newCertificateVerifier(fingerprints []string, verify func(fingerprint string) error) *certificateVerifier {
+	if len(fingerprints) == 0 && verify == nil {
+		return nil
+	}
+
+	cv := &certificateVerifier{
+		verify: verify,
+	}
+	if len(fingerprints) > 0 {
+		cv.fingerprints = make(map[string]bool)
+		for _, fingerprint := range fingerprints {
+			cv.fingerprints[normalizeFingerprint(fingerprint)] = true
+		}
+	}
+	return cv
+}
+
+// verifyCertificate verifies the DER-encoded certificate.
+func (cv *certificateVerifier) verifyCertificate(rawCert []byte) error {
+	if len(rawCert) == 0 {
+		return ErrNoRemoteCertificate
+	}
+
+	fingerprint := rawCertificateFingerprint(rawCert)
+
+	if cv.fingerprints != nil && !cv.fingerprints[fingerprint] {
+		return fmt.Errorf("%w: %s not allowed", ErrRemoteCertificateRejected, fingerprint)
+	}
+	if cv.verify != nil {
+		if err := cv.verify(fingerprint); err != nil {
+			return fmt.Errorf("%w: %v", ErrRemoteCertificateRejected, err)
+		}
+	}
+	return nil
+}
+
+// rawCertificateFingerprint returns the FINGERPRINT_ALGORITHM fingerprint of the
+// DER-encoded certificate.
+func rawCertificateFingerprint(rawCert []byte) string {
+	digest := sha256.Sum256(rawCert)
+	return normalizeFingerprint(FINGERPRINT_ALGORITHM + " " + hex.EncodeToString(digest[:]))
+}
+
+// normalizeFingerprint formats the fingerprint as "algorithm AB:CD:...". A fingerprint
+// without algorithm is assumed to be FINGERPRINT_ALGORITHM, and the colons are optional.
+func normalizeFingerprint(fingerprint string) string {
+	algorithm, value := FINGERPRINT_ALGORITHM, strings.TrimSpace(fingerprint)
+	if parts := strings.Fields(value); len(parts) == 2 {
+		algorithm, value = strings.ToLower(parts[0]), parts[1]
+	}
+
+	value = strings.ToUpper(strings.ReplaceAll(value, ":", ""))
+	pairs := make([]string, 0, len(value)/2)
+	for i := 0; i+1 < len(value); i += 2 {
+		pairs = append(pairs, value[i:i+2])
+	}
+	return algorithm + " " + strings.Join(pairs, ":")
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/gaukas/logging"
+	"github.com/gaukas/transportc"
+	"github.com/gaukas/transportc/internal/pt"
+	"github.com/gaukas/transportc/internal/relay"
+	"github.com/gaukas/transportc/internal/socks5"
+)
+
+// runClient launches a SOCKS5 listener per client transport.
+func runClient() error {
+	info, err := pt.ClientSetup([]string{METHOD_NAME})
+	if err != nil {
+		return err
+	}
+	if info.ProxyURL != nil {
+		pt.ProxyError("proxy is not supported")
+		return fmt.Errorf("proxy %s is not supported", info.ProxyURL)
+	}
+
+	for _, name := range info.MethodNames {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			pt.CmethodError(name, err.Error())
+			continue
+		}
+		go acceptSOCKS(ln)
+		pt.Cmethod(name, ln.Addr())
+	}
+	pt.CmethodsDone()
+	return nil
+}
+
+func acceptSOCKS(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() { // skipcq: SCC-SA1019
+				continue
+			}
+			return
+		}
+		go handleSOCKS(conn)
+	}
+}
+
+// handleSOCKS dials a Conn with the arguments of the SOCKS5 request and pipes the
+// SOCKS5 connection through it.
+func handleSOCKS(conn net.Conn) {
+	defer conn.Close()
+
+	req, err := socks5.ReadRequest(conn)
+	if err != nil {
+		pt.Log("warning", fmt.Sprintf("SOCKS5 handshake failed: %v", err))
+		return
+	}
+	if req.Command != socks5.CMD_CONNECT {
+		req.Reply(socks5.REP_COMMAND_NOT_SUPPORTED, nil) // skipcq: GSC-G104
+		return
+	}
+
+	remote, cleanup, err := dial(req)
+	if err != nil {
+		pt.Log("warning", fmt.Sprintf("dial %s failed: %v", req.Addr, err))
+		req.Reply(socks5.REP_GENERAL_FAILURE, nil) // skipcq: GSC-G104
+		return
+	}
+	defer cleanup()
+
+	if err := req.Reply(socks5.REP_SUCCEEDED, nil); err != nil {
+		remote.Close() // skipcq: GSC-G104
+		return
+	}
+	relay.Pipe(conn, remote)
+}
+
+// dial dials a Conn to the bridge with a new Dialer configured by the arguments
+// of the request. The returned cleanup closes the Dialer and the Signal.
+func dial(req *socks5.Request) (net.Conn, func(), error) {
+	args, err := pt.ParseClientArgs(req.Username, req.Password)
+	if err != nil {
+		return nil, nil, err
+	}
+	psk, err := preSharedKey(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var s transportc.Signal
+	closeSignal := func() {}
+	if front, ok := args.Get("front"); ok {
+		host, _ := args.Get("host")
+		sni, _ := args.Get("sni")
+		hs, err := transportc.NewFrontedHTTPSignal(&transportc.FrontingConfig{
+			FrontURL:   front,
+			Host:       host,
+			ServerName: sni,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		s = hs
+		if len(psk) > 0 {
+			s = transportc.NewSecureSignalPSK(s, psk)
+		}
+	} else {
+		s, closeSignal, err = newSignal(args, "http://"+req.Addr+"/", psk)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	config := &transportc.Config{
+		Logger:       logging.DefaultStderrLogger(logging.LOG_ERROR),
+		PreSharedKey: psk,
+		Signal:       s,
+	}
+	config.WebRTCConfiguration.ICEServers = iceServers(args)
+	if fingerprint, ok := args.Get("dtls-fingerprint"); ok {
+		config.RemoteFingerprints = []string{transportc.FINGERPRINT_ALGORITHM + " " + fingerprint}
+	}
+
+	dialer, err := config.NewDialer()
+	if err != nil {
+		closeSignal()
+		return nil, nil, err
+	}
+	cleanup := func() {
+		dialer.Close() // skipcq: GSC-G104
+		closeSignal()
+	}
+
+	label, ok := args.Get("label")
+	if !ok {
+		label = DEFAULT_LABEL
+	}
+	ctx, cancel := dialContext()
+	defer cancel()
+	conn, err := dialer.DialContext(ctx, label)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return conn, cleanup, nil
+}
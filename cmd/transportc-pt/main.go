@@ -0,0 +1,129 @@
+// Command transportc-pt is a Tor pluggable transport (PT 1.0) managed proxy
+// carrying the connections of Tor over WebRTC DataChannels.
+//
+// Launched by Tor as a ClientTransportPlugin, it listens for SOCKS5 connections
+// and dials a Conn per connection with the arguments of the bridge line, carried
+// in the SOCKS5 credentials:
+//
+//	signal=<spec>           the Signal to the server, by default http://<bridge address>/
+//	front=<url>             with host=, reach the HTTPSignalServer by domain fronting
+//	host=<host>             the Host header of the fronted requests
+//	sni=<name>              the TLS server name of the fronted requests
+//	ice=<url>               an ICE server, e.g. stun:stun.l.google.com:19302 (repeatable)
+//	psk=<hex>               a pre-shared key sealing the signaling and authenticating the Conn
+//	dtls-fingerprint=<hex>  the sha-256 fingerprint of the DTLS certificate of the server
+//	label=<label>           the label of the DataChannel
+//
+// Launched as a ServerTransportPlugin, it hosts an HTTPSignalServer on the bind
+// address unless the signal option is set in ServerTransportOptions, accepts
+// Conns with a Listener and forwards them to the ORPort. The ice and psk options
+// are also supported, and timeout=<duration> sets the idle timeout of the Conns,
+// 1h by default. The DTLS certificate is kept in the state directory and its
+// fingerprint advertised in the SMETHOD line.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gaukas/transportc"
+	"github.com/gaukas/transportc/internal/pt"
+	"github.com/gaukas/transportc/internal/signalspec"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	METHOD_NAME   = "transportc"
+	DIAL_TIMEOUT  = 30 * time.Second
+	SIGNAL_TTL    = time.Minute
+	DEFAULT_LABEL = "transportc"
+)
+
+func main() {
+	var err error
+	if pt.IsClient() {
+		err = runClient()
+	} else {
+		err = runServer()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	done := make(chan struct{})
+	if pt.ExitOnStdinClose() {
+		go func() {
+			io.Copy(io.Discard, os.Stdin) // skipcq: GSC-G104
+			close(done)
+		}()
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-sigs:
+	case <-done:
+	}
+}
+
+// iceServers returns the ICE servers in the ice arguments.
+func iceServers(args pt.Args) []webrtc.ICEServer {
+	var servers []webrtc.ICEServer
+	for _, url := range args["ice"] {
+		servers = append(servers, webrtc.ICEServer{URLs: []string{url}})
+	}
+	return servers
+}
+
+// preSharedKey returns the key in the hex-encoded psk argument, if any.
+func preSharedKey(args pt.Args) ([]byte, error) {
+	value, ok := args.Get("psk")
+	if !ok {
+		return nil, nil
+	}
+	psk, err := hex.DecodeString(value)
+	if err != nil || len(psk) == 0 {
+		return nil, fmt.Errorf("invalid psk %q", value)
+	}
+	return psk, nil
+}
+
+// newSignal creates the Signal in the signal argument, or from defaultSpec if
+// unset, sealed under the psk if any. The returned cleanup closes it if needed.
+func newSignal(args pt.Args, defaultSpec string, psk []byte) (transportc.Signal, func(), error) {
+	spec, ok := args.Get("signal")
+	if !ok {
+		spec = defaultSpec
+	}
+	s, err := signalspec.Parse(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cleanup := func() {}
+	if closer, ok := s.(io.Closer); ok {
+		cleanup = func() { closer.Close() } // skipcq: GSC-G104
+	}
+	if len(psk) > 0 {
+		s = transportc.NewSecureSignalPSK(s, psk)
+	}
+	return s, cleanup, nil
+}
+
+// dialContext returns a context cancelled after DIAL_TIMEOUT.
+func dialContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), DIAL_TIMEOUT)
+}
+
+// fingerprintArg returns the fingerprint without the algorithm, as advertised in
+// the SMETHOD line and passed back in the bridge line, which cannot hold spaces.
+func fingerprintArg(fingerprint string) string {
+	return strings.TrimPrefix(fingerprint, transportc.FINGERPRINT_ALGORITHM+" ")
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gaukas/logging"
+	"github.com/gaukas/transportc"
+	"github.com/gaukas/transportc/internal/pt"
+	"github.com/gaukas/transportc/internal/relay"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	CERT_FILE = "transportc-cert.pem"
+	KEY_FILE  = "transportc-key.pem"
+
+	// SERVER_TIMEOUT_DEFAULT is the idle timeout of the Conns, long enough for the
+	// OR connections Tor keeps open without traffic between its keepalive cells.
+	SERVER_TIMEOUT_DEFAULT = time.Hour
+)
+
+// runServer launches a Listener per server transport.
+func runServer() error {
+	info, err := pt.ServerSetup([]string{METHOD_NAME})
+	if err != nil {
+		return err
+	}
+
+	certificate, err := loadCertificate(info.StateLocation)
+	if err != nil {
+		for _, bindaddr := range info.Bindaddrs {
+			pt.SmethodError(bindaddr.MethodName, err.Error())
+		}
+		pt.SmethodsDone()
+		return err
+	}
+	fingerprint, err := transportc.CertificateFingerprint(certificate)
+	if err != nil {
+		return err
+	}
+
+	for _, bindaddr := range info.Bindaddrs {
+		addr, err := listen(bindaddr, certificate, info.OrAddr)
+		if err != nil {
+			pt.SmethodError(bindaddr.MethodName, err.Error())
+			continue
+		}
+		pt.Smethod(bindaddr.MethodName, addr, pt.Args{"dtls-fingerprint": {fingerprintArg(fingerprint)}})
+	}
+	pt.SmethodsDone()
+	return nil
+}
+
+// listen starts a Listener configured by the options of the bindaddr and forwards
+// the accepted Conns to the ORPort. Unless the signal option is set, it hosts an
+// HTTPSignalServer on the bind address for the Listener and the clients. It
+// returns the address to advertise.
+func listen(bindaddr pt.Bindaddr, certificate *webrtc.Certificate, orAddr *net.TCPAddr) (net.Addr, error) {
+	psk, err := preSharedKey(bindaddr.Options)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := serverTimeout(bindaddr.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	var addr net.Addr = bindaddr.Addr
+	defaultSpec := ""
+	if _, ok := bindaddr.Options.Get("signal"); !ok {
+		ln, err := net.ListenTCP("tcp", bindaddr.Addr)
+		if err != nil {
+			return nil, err
+		}
+		go http.Serve(ln, transportc.NewHTTPSignalServer(SIGNAL_TTL)) // skipcq: GO-S2114
+		addr = ln.Addr()
+		defaultSpec = "http://" + loopback(ln.Addr().(*net.TCPAddr)).String() + "/"
+	}
+
+	s, _, err := newSignal(bindaddr.Options, defaultSpec, psk)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &transportc.Config{
+		Certificate:  certificate,
+		Logger:       logging.DefaultStderrLogger(logging.LOG_ERROR),
+		PreSharedKey: psk,
+		Signal:       s,
+		Timeout:      timeout,
+	}
+	config.WebRTCConfiguration.ICEServers = iceServers(bindaddr.Options)
+
+	listener, err := config.NewListener()
+	if err != nil {
+		return nil, err
+	}
+	if err := listener.Start(); err != nil {
+		return nil, err
+	}
+	go acceptConns(listener, orAddr)
+	return addr, nil
+}
+
+// serverTimeout returns the idle timeout in the timeout option, or
+// SERVER_TIMEOUT_DEFAULT if unset.
+func serverTimeout(options pt.Args) (time.Duration, error) {
+	value, ok := options.Get("timeout")
+	if !ok {
+		return SERVER_TIMEOUT_DEFAULT, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q", value)
+	}
+	return timeout, nil
+}
+
+func acceptConns(listener *transportc.Listener, orAddr *net.TCPAddr) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			or, err := net.DialTCP("tcp", nil, orAddr)
+			if err != nil {
+				pt.Log("warning", fmt.Sprintf("dial ORPort failed: %v", err))
+				conn.Close() // skipcq: GSC-G104
+				return
+			}
+			relay.Pipe(conn, or)
+		}()
+	}
+}
+
+// loadCertificate loads the DTLS certificate from the state directory, generating
+// it on the first run so its fingerprint stays the same across restarts.
+func loadCertificate(stateLocation string) (*webrtc.Certificate, error) {
+	certFile := filepath.Join(stateLocation, CERT_FILE)
+	keyFile := filepath.Join(stateLocation, KEY_FILE)
+
+	certificate, err := transportc.LoadCertificateFile(certFile, keyFile)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return certificate, err
+	}
+
+	certPEM, keyPEM, err := transportc.GenerateCertificatePEM()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(stateLocation, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		return nil, err
+	}
+	return transportc.LoadCertificatePEM(certPEM, keyPEM)
+}
+
+// loopback returns the address to reach a listener on addr from the same host.
+func loopback(addr *net.TCPAddr) *net.TCPAddr {
+	if !addr.IP.IsUnspecified() {
+		return addr
+	}
+	if addr.IP.To4() != nil {
+		return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: addr.Port}
+	}
+	return &net.TCPAddr{IP: net.IPv6loopback, Port: addr.Port}
+}
@@ -0,0 +1,177 @@
+// Command transportc-signal is a standalone rendezvous server hosting the
+// HTTPSignal and WebSocketSignal endpoints, so the Dialers and the Listeners may
+// signal without any code on the server side.
+//
+// Each listener has a namespace of its own, sharing one store between its HTTP
+// and WebSocket endpoints:
+//
+//	transportc-signal -listen :8080 -storage disk -dir /var/lib/transportc-signal -listener alice
+//
+// serves the default namespace at http://host:8080/ (HTTPSignal) and
+// ws://host:8080/ws (WebSocketSignal), and the listener alice at
+// http://host:8080/listeners/alice/ and ws://host:8080/listeners/alice/ws.
+//
+// With -registration-token, listeners are also registered and unregistered at
+// runtime with PUT and DELETE /listeners/<name>, authorized by the token as a
+// bearer token. The offers to a name not registered are rejected.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gaukas/logging"
+	"github.com/gaukas/transportc"
+	"github.com/gaukas/transportc/internal/cliconfig"
+)
+
+const (
+	STORAGE_MEMORY = "memory"
+	STORAGE_DISK   = "disk"
+
+	READ_HEADER_TIMEOUT = 10 * time.Second
+	SHUTDOWN_TIMEOUT    = 5 * time.Second
+)
+
+// options are the command-line flags.
+type options struct {
+	burst             int
+	certFile          string
+	clientIPHeader    string
+	dir               string
+	keyFile           string
+	listen            string
+	listeners         cliconfig.StringList
+	noDefault         bool
+	rate              float64
+	registrationToken string
+	storage           string
+	ttl               time.Duration
+}
+
+func main() {
+	var opts options
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.StringVar(&opts.listen, "listen", ":8080", "address to serve on")
+	fs.StringVar(&opts.certFile, "cert", "", "PEM file of the TLS certificate, to serve HTTPS and WSS")
+	fs.StringVar(&opts.keyFile, "key", "", "PEM file of the private key of the TLS certificate")
+	fs.StringVar(&opts.storage, "storage", STORAGE_MEMORY, "where the offers and answers are kept, memory or disk")
+	fs.StringVar(&opts.dir, "dir", "", "directory of the disk storage")
+	fs.DurationVar(&opts.ttl, "ttl", transportc.SIGNAL_OFFER_TTL_DEFAULT, "time after which the offers and answers not consumed expire")
+	fs.Float64Var(&opts.rate, "rate", 10, "requests per second allowed per client, 0 for no limit")
+	fs.IntVar(&opts.burst, "burst", 20, "requests allowed per client in a burst")
+	fs.StringVar(&opts.clientIPHeader, "client-ip-header", "", "header holding the client IP set by a trusted reverse proxy, e.g. X-Forwarded-For")
+	fs.BoolVar(&opts.noDefault, "no-default", false, "serve only the namespaces of the listeners, not the default one")
+	fs.Var(&opts.listeners, "listener", "name of a listener to register (repeatable)")
+	fs.StringVar(&opts.registrationToken, "registration-token", "", "token authorizing the registration of listeners at runtime, disabled if empty")
+	verbose := fs.Bool("v", false, "verbose logging")
+	fs.Parse(os.Args[1:]) // skipcq: GSC-G104
+
+	level := logging.LOG_INFO
+	if *verbose {
+		level = logging.LOG_DEBUG
+	}
+	logger := logging.DefaultStderrLogger(level)
+
+	if err := run(&opts, logger); err != nil {
+		logger.Errorf("%v", err)
+		os.Exit(1)
+	}
+}
+
+func run(opts *options, logger logging.Logger) error {
+	var st storage
+	switch opts.storage {
+	case STORAGE_MEMORY:
+		st = &memoryStorage{ttl: opts.ttl}
+	case STORAGE_DISK:
+		if opts.dir == "" {
+			return errors.New("-dir is required by the disk storage")
+		}
+		if err := os.MkdirAll(opts.dir, 0o700); err != nil {
+			return err
+		}
+		st = &diskStorage{dir: opts.dir, ttl: opts.ttl}
+	default:
+		return fmt.Errorf("unknown storage %q", opts.storage)
+	}
+
+	reg := newRegistry(st, opts.ttl)
+	if !opts.noDefault {
+		if _, err := reg.register(DEFAULT_NAMESPACE); err != nil {
+			return err
+		}
+	}
+	persisted, err := st.names()
+	if err != nil {
+		return fmt.Errorf("failed to list the registered listeners: %w", err)
+	}
+	for _, name := range append(persisted, opts.listeners...) {
+		if _, err := reg.register(name); err != nil {
+			return err
+		}
+	}
+	logger.Infof("Serving %d listeners with %s storage", len(reg.names()), opts.storage)
+
+	srv := &server{
+		registry:          reg,
+		registrationToken: opts.registrationToken,
+		logger:            logger,
+		started:           time.Now(),
+	}
+	var handler http.Handler = srv
+	if opts.rate > 0 {
+		handler = newRateLimiter(opts.rate, opts.burst).limit(handler, opts.clientIPHeader)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", srv.serveHealth)
+	mux.Handle("/", handler)
+
+	httpServer := &http.Server{
+		Addr:              opts.listen,
+		Handler:           mux,
+		ReadHeaderTimeout: READ_HEADER_TIMEOUT,
+	}
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		<-sigs
+		ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer cancel()
+		httpServer.Shutdown(ctx) // skipcq: GSC-G104
+	}()
+
+	logger.Infof("Listening on %s", opts.listen)
+	if opts.certFile != "" || opts.keyFile != "" {
+		err = httpServer.ListenAndServeTLS(opts.certFile, opts.keyFile)
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) // skipcq: GSC-G104
+}
+
+// cutPrefix is strings.CutPrefix, not available before Go 1.20.
+func cutPrefix(s, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RATE_LIMIT_PRUNE_INTERVAL = time.Minute
+)
+
+// rateLimiter is a token bucket per client, refilled at rate tokens per second
+// up to burst tokens.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mutex     sync.Mutex
+	clients   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		clients:   make(map[string]*bucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// allow takes a token from the bucket of the client, if any is left.
+func (rl *rateLimiter) allow(client string) bool {
+	now := time.Now()
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	if now.Sub(rl.lastPrune) > RATE_LIMIT_PRUNE_INTERVAL {
+		rl.prune(now)
+	}
+
+	b, ok := rl.clients[client]
+	if !ok {
+		b = &bucket{tokens: rl.burst, last: now}
+		rl.clients[client] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rl.rate
+	if b.tokens > rl.burst {
+		b.tokens = rl.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// prune forgets the clients whose bucket is full again. Caller MUST hold the mutex.
+func (rl *rateLimiter) prune(now time.Time) {
+	for client, b := range rl.clients {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.burst {
+			delete(rl.clients, client)
+		}
+	}
+	rl.lastPrune = now
+}
+
+// clientIP returns the IP address of the client of the request, taken from the
+// header if set, e.g. X-Forwarded-For behind a reverse proxy.
+func clientIP(r *http.Request, header string) string {
+	if header != "" {
+		if value := r.Header.Get(header); value != "" {
+			// The last address is the one added by the trusted proxy
+			addrs := strings.Split(value, ",")
+			return strings.TrimSpace(addrs[len(addrs)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// limit rejects the requests of the clients exceeding the rate with 429 Too Many
+// Requests. A WebSocket connection counts as one request when opened.
+func (rl *rateLimiter) limit(next http.Handler, ipHeader string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rl.allow(clientIP(r, ipHeader)) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gaukas/transportc"
+)
+
+const (
+	DEFAULT_NAMESPACE = "" // served at the root, e.g. /offer
+
+	// DIR_POLL_TIMEOUT is how long a DirSignal polls the directory per read, so a
+	// long-polling request returns at most that late.
+	DIR_POLL_TIMEOUT = 250 * time.Millisecond
+)
+
+var (
+	ErrInvalidName  = errors.New("invalid listener name")
+	ErrUnregistered = errors.New("listener not registered")
+)
+
+// validName restricts the names to what is safe both in a URL path and as a
+// directory name.
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// storage opens the SignalStore of every namespace.
+type storage interface {
+	// open opens the store of the namespace, creating it if needed.
+	open(name string) (transportc.SignalStore, error)
+
+	// remove deletes the store of the namespace.
+	remove(name string) error
+
+	// names returns the names of the namespaces kept across restarts.
+	names() ([]string, error)
+
+	// check reports whether the storage is usable, for the health endpoint.
+	check() error
+}
+
+// memoryStorage keeps every namespace in memory.
+type memoryStorage struct {
+	ttl time.Duration
+}
+
+func (ms *memoryStorage) open(string) (transportc.SignalStore, error) {
+	return transportc.NewMemorySignalStore(ms.ttl), nil
+}
+
+func (*memoryStorage) remove(string) error {
+	return nil
+}
+
+func (*memoryStorage) names() ([]string, error) {
+	return nil, nil
+}
+
+func (*memoryStorage) check() error {
+	return nil
+}
+
+// diskStorage keeps every namespace in a DirSignal, the default namespace in
+// <dir>/default and the registered ones in <dir>/listeners/<name>. The registered
+// names thus survive a restart along with their offers and answers.
+type diskStorage struct {
+	dir string
+	ttl time.Duration
+}
+
+func (ds *diskStorage) path(name string) string {
+	if name == DEFAULT_NAMESPACE {
+		return filepath.Join(ds.dir, "default")
+	}
+	return filepath.Join(ds.dir, "listeners", name)
+}
+
+func (ds *diskStorage) open(name string) (transportc.SignalStore, error) {
+	dirSignal, err := transportc.NewDirSignal(ds.path(name))
+	if err != nil {
+		return nil, err
+	}
+	dirSignal.PollTimeout = DIR_POLL_TIMEOUT
+	if ds.ttl > 0 {
+		dirSignal.TTL = ds.ttl
+	}
+	return transportc.NewSignalStore(dirSignal), nil
+}
+
+func (ds *diskStorage) remove(name string) error {
+	return os.RemoveAll(ds.path(name))
+}
+
+func (ds *diskStorage) names() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(ds.dir, "listeners"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && validName.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (ds *diskStorage) check() error {
+	info, err := os.Stat(ds.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", ds.dir)
+	}
+	return nil
+}
+
+// namespace serves the HTTP and WebSocket endpoints of a listener on a shared
+// store, so the dialers and the listener may use either.
+type namespace struct {
+	http      *transportc.HTTPSignalServer
+	webSocket *transportc.WebSocketSignalServer
+}
+
+// registry holds the namespaces of the registered listeners.
+type registry struct {
+	storage storage
+	ttl     time.Duration
+
+	mutex      sync.RWMutex
+	namespaces map[string]*namespace
+}
+
+func newRegistry(storage storage, ttl time.Duration) *registry {
+	return &registry{
+		storage:    storage,
+		ttl:        ttl,
+		namespaces: make(map[string]*namespace),
+	}
+}
+
+// register creates the namespace of the name unless it exists. It returns true
+// if created.
+func (r *registry) register(name string) (bool, error) {
+	if name != DEFAULT_NAMESPACE && !validName.MatchString(name) {
+		return false, fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.namespaces[name]; ok {
+		return false, nil
+	}
+	store, err := r.storage.open(name)
+	if err != nil {
+		return false, fmt.Errorf("failed to open the store of %q: %w", name, err)
+	}
+	r.namespaces[name] = &namespace{
+		http:      transportc.NewHTTPSignalServerWithStore(store),
+		webSocket: transportc.NewWebSocketSignalServerWithStore(store, r.ttl),
+	}
+	return true, nil
+}
+
+// unregister deletes the namespace of the name and its pending offers and answers.
+func (r *registry) unregister(name string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.namespaces[name]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnregistered, name)
+	}
+	delete(r.namespaces, name)
+	return r.storage.remove(name)
+}
+
+func (r *registry) lookup(name string) (*namespace, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	ns, ok := r.namespaces[name]
+	return ns, ok
+}
+
+// names returns the registered names, without the default namespace.
+func (r *registry) names() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	names := make([]string, 0, len(r.namespaces))
+	for name := range r.namespaces {
+		if name != DEFAULT_NAMESPACE {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// serveEndpoint serves the offer, answer and ws endpoints of the namespace.
+func (ns *namespace) serveEndpoint(w http.ResponseWriter, r *http.Request, endpoint string) {
+	switch endpoint {
+	case "offer", "answer":
+		ns.http.ServeHTTP(w, r)
+	case "ws":
+		ns.webSocket.ServeHTTP(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
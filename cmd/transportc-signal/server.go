@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gaukas/logging"
+)
+
+// server routes the requests to the namespaces:
+//
+//	/offer, /answer, /ws                  the default namespace, if registered
+//	/listeners/<name>/{offer,answer,ws}   the namespace of a registered listener
+//	PUT, DELETE /listeners/<name>         register or unregister a listener
+//	GET /healthz                          health of the server and its storage
+type server struct {
+	registry          *registry
+	registrationToken string
+	logger            logging.Logger
+	started           time.Time
+}
+
+// healthResponse is the JSON body returned by GET /healthz.
+type healthResponse struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Storage   string `json:"storage"`
+	Listeners int    `json:"listeners"`
+	Uptime    string `json:"uptime"`
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.logger.Debugf("%s %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+
+	if rest, ok := cutPrefix(r.URL.Path, "/listeners/"); ok {
+		name, endpoint, _ := strings.Cut(rest, "/")
+		if endpoint == "" {
+			s.serveRegistration(w, r, name)
+			return
+		}
+		ns, ok := s.registry.lookup(name)
+		if !ok {
+			http.Error(w, ErrUnregistered.Error(), http.StatusNotFound)
+			return
+		}
+		ns.serveEndpoint(w, r, endpoint)
+		return
+	}
+
+	endpoint := strings.TrimPrefix(r.URL.Path, "/")
+	ns, ok := s.registry.lookup(DEFAULT_NAMESPACE)
+	if !ok || strings.Contains(endpoint, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	ns.serveEndpoint(w, r, endpoint)
+}
+
+// serveRegistration registers or unregisters the listener, for the clients
+// holding the registration token.
+func (s *server) serveRegistration(w http.ResponseWriter, r *http.Request, name string) {
+	if s.registrationToken == "" {
+		http.Error(w, "registration disabled", http.StatusForbidden)
+		return
+	}
+	token, _ := cutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.registrationToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "invalid registration token", http.StatusUnauthorized)
+		return
+	}
+
+	if !validName.MatchString(name) {
+		http.Error(w, ErrInvalidName.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		created, err := s.registry.register(name)
+		switch {
+		case err != nil:
+			s.logger.Errorf("%v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		case created:
+			s.logger.Infof("Listener %s registered", name)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case http.MethodDelete:
+		err := s.registry.unregister(name)
+		switch {
+		case errors.Is(err, ErrUnregistered):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			s.logger.Errorf("%v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			s.logger.Infof("Listener %s unregistered", name)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *server) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp := healthResponse{
+		Status:    "ok",
+		Storage:   storageName(s.registry.storage),
+		Listeners: len(s.registry.names()),
+		Uptime:    time.Since(s.started).Truncate(time.Second).String(),
+	}
+	status := http.StatusOK
+	if err := s.registry.storage.check(); err != nil {
+		resp.Status, resp.Error = "unavailable", err.Error()
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
+
+func storageName(st storage) string {
+	if _, ok := st.(*diskStorage); ok {
+		return STORAGE_DISK
+	}
+	return STORAGE_MEMORY
+}
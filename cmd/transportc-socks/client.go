@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gaukas/logging"
+	"github.com/gaukas/transportc"
+	"github.com/gaukas/transportc/internal/relay"
+	"github.com/gaukas/transportc/internal/socks5"
+	"github.com/gaukas/transportc/internal/utils"
+)
+
+// client is a SOCKS5 server relaying the requests to the server over Conns.
+type client struct {
+	dialer *transportc.Dialer
+	logger logging.Logger
+
+	mutex        sync.Mutex
+	datagrams    net.Conn // nil until the first UDP ASSOCIATE request
+	associations map[uint32]*clientAssociation
+}
+
+// clientAssociation is a UDP association of a SOCKS5 client.
+type clientAssociation struct {
+	udp      *net.UDPConn
+	clientIP net.IP
+
+	mutex      sync.Mutex
+	clientAddr *net.UDPAddr // source of the last datagram, where the replies go
+}
+
+func newClient(dialer *transportc.Dialer, logger logging.Logger) *client {
+	return &client{
+		dialer:       dialer,
+		logger:       logger,
+		associations: make(map[uint32]*clientAssociation),
+	}
+}
+
+func (c *client) serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go c.handle(conn)
+	}
+}
+
+func (c *client) handle(conn net.Conn) {
+	defer conn.Close()
+
+	req, err := socks5.ReadRequest(conn)
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			c.logger.Warnf("SOCKS5 handshake with %s failed: %v", conn.RemoteAddr(), err)
+		}
+		return
+	}
+
+	switch req.Command {
+	case socks5.CMD_CONNECT:
+		c.connect(conn, req)
+	case socks5.CMD_UDP_ASSOCIATE:
+		c.associate(conn, req)
+	default:
+		req.Reply(socks5.REP_COMMAND_NOT_SUPPORTED, nil) // skipcq: GSC-G104
+	}
+}
+
+// connect dials a stream Conn, sends the request and relays the stream once the
+// server connected to the destination.
+func (c *client) connect(conn net.Conn, req *socks5.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), DIAL_TIMEOUT)
+	defer cancel()
+
+	remote, err := c.dialer.DialContext(ctx, STREAM_CHANNEL_LABEL)
+	if err != nil {
+		c.logger.Errorf("Dial failed: %v", err)
+		req.Reply(socks5.REP_GENERAL_FAILURE, nil) // skipcq: GSC-G104
+		return
+	}
+
+	rep, bound, err := requestConnect(remote, req.Addr)
+	if err != nil {
+		c.logger.Warnf("CONNECT %s failed: %v", req.Addr, err)
+		remote.Close()                             // skipcq: GSC-G104
+		req.Reply(socks5.REP_GENERAL_FAILURE, nil) // skipcq: GSC-G104
+		return
+	}
+	if err := req.Reply(rep, bound); err != nil || rep != socks5.REP_SUCCEEDED {
+		remote.Close() // skipcq: GSC-G104
+		return
+	}
+	relay.Pipe(conn, remote)
+}
+
+// requestConnect sends the CONNECT request on the stream Conn and reads the reply.
+func requestConnect(remote net.Conn, addr string) (byte, net.Addr, error) {
+	msg, err := socks5.AppendHostPort([]byte{MSG_CONNECT}, addr)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := remote.Write(msg); err != nil {
+		return 0, nil, err
+	}
+
+	remote.SetReadDeadline(time.Now().Add(DIAL_TIMEOUT)) // skipcq: GSC-G104
+	defer remote.SetReadDeadline(time.Time{})            // skipcq: GSC-G104
+	buf := make([]byte, transportc.CONN_DEFAULT_MTU)
+	n, err := remote.Read(buf)
+	if err != nil {
+		return 0, nil, err
+	}
+	if n < 1 {
+		return 0, nil, errors.New("empty reply")
+	}
+	boundStr, _, err := socks5.ParseAddr(buf[1:n])
+	if err != nil {
+		return 0, nil, err
+	}
+	bound, err := net.ResolveTCPAddr("tcp", boundStr)
+	if err != nil {
+		return 0, nil, err
+	}
+	return buf[0], bound, nil
+}
+
+// associate relays the datagrams of a new UDP association until the SOCKS5
+// client closes the control connection.
+func (c *client) associate(conn net.Conn, req *socks5.Request) {
+	if _, err := c.datagramConn(); err != nil {
+		c.logger.Errorf("Dial datagram channel failed: %v", err)
+		req.Reply(socks5.REP_GENERAL_FAILURE, nil) // skipcq: GSC-G104
+		return
+	}
+
+	// Listen on the address the SOCKS5 client reached us on
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: conn.LocalAddr().(*net.TCPAddr).IP})
+	if err != nil {
+		req.Reply(socks5.REP_GENERAL_FAILURE, nil) // skipcq: GSC-G104
+		return
+	}
+	defer udp.Close()
+
+	association := &clientAssociation{
+		udp:      udp,
+		clientIP: conn.RemoteAddr().(*net.TCPAddr).IP,
+	}
+	id := c.addAssociation(association)
+	defer c.removeAssociation(id)
+
+	if err := req.Reply(socks5.REP_SUCCEEDED, udp.LocalAddr()); err != nil {
+		return
+	}
+
+	go func() {
+		buf := make([]byte, transportc.CONN_DEFAULT_MTU)
+		for {
+			n, addr, err := udp.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if !addr.IP.Equal(association.clientIP) {
+				continue // not from the SOCKS5 client
+			}
+			association.mutex.Lock()
+			association.clientAddr = addr
+			association.mutex.Unlock()
+
+			dst, data, err := socks5.ParseUDPDatagram(buf[:n])
+			if err != nil {
+				c.logger.Debugf("Dropping datagram: %v", err)
+				continue
+			}
+			msg, err := appendDatagram(nil, id, dst, data)
+			if err != nil {
+				continue
+			}
+			// The datagram channel is dialed again if closed, e.g. idle on the server
+			datagrams, err := c.datagramConn()
+			if err != nil {
+				c.logger.Warnf("Dial datagram channel failed: %v", err)
+				continue
+			}
+			datagrams.Write(msg) // skipcq: GSC-G104
+		}
+	}()
+
+	// The association terminates with the control connection
+	io.Copy(io.Discard, conn) // skipcq: GSC-G104
+}
+
+// datagramConn returns the Conn of the datagram channel, dialing it if needed.
+func (c *client) datagramConn() (net.Conn, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.datagrams != nil {
+		return c.datagrams, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DIAL_TIMEOUT)
+	defer cancel()
+	datagrams, err := c.dialer.DialNegotiatedContext(ctx, DATAGRAM_CHANNEL_ID)
+	if err != nil {
+		return nil, err
+	}
+	c.datagrams = datagrams
+	go c.readDatagrams(datagrams)
+	return datagrams, nil
+}
+
+// readDatagrams delivers the datagrams from the server to the SOCKS5 clients.
+func (c *client) readDatagrams(datagrams net.Conn) {
+	defer func() {
+		c.mutex.Lock()
+		if c.datagrams == datagrams {
+			c.datagrams = nil // dialed again by the next association
+		}
+		c.mutex.Unlock()
+		datagrams.Close()
+	}()
+
+	buf := make([]byte, transportc.CONN_DEFAULT_MTU)
+	for {
+		n, err := datagrams.Read(buf)
+		if err != nil {
+			return
+		}
+		id, src, data, err := parseDatagram(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		c.mutex.Lock()
+		association, ok := c.associations[id]
+		c.mutex.Unlock()
+		if !ok {
+			continue
+		}
+		association.mutex.Lock()
+		clientAddr := association.clientAddr
+		association.mutex.Unlock()
+		if clientAddr == nil {
+			continue
+		}
+
+		msg, err := socks5.AppendUDPDatagram(nil, src, data)
+		if err != nil {
+			continue
+		}
+		association.udp.WriteToUDP(msg, clientAddr) // skipcq: GSC-G104
+	}
+}
+
+func (c *client) addAssociation(association *clientAssociation) uint32 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for {
+		id := uint32(utils.RandUint64())
+		if _, ok := c.associations[id]; !ok {
+			c.associations[id] = association
+			return id
+		}
+	}
+}
+
+func (c *client) removeAssociation(id uint32) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.associations, id)
+}
+
+// runClient serves SOCKS5 on the listen address.
+func runClient(listen string, config *transportc.Config) error {
+	config.ReusePeerConnection = true
+	config.NegotiatedDataChannels = append(config.NegotiatedDataChannels, datagramChannel())
+
+	dialer, err := config.NewDialer()
+	if err != nil {
+		return err
+	}
+	defer dialer.Close()
+
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	config.Logger.Infof("SOCKS5 proxy listening on %s", ln.Addr())
+
+	if err := newClient(dialer, config.Logger).serve(ln); err != nil {
+		return fmt.Errorf("accept failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Command transportc-socks is a SOCKS5 proxy tunneled over WebRTC DataChannels.
+//
+// The client serves SOCKS5 locally and relays every CONNECT request through a new
+// Conn, and the UDP ASSOCIATE requests through an unreliable DataChannel, to the
+// server, which connects out to the destinations:
+//
+//	transportc-socks server -signal https://rendezvous.example.com/signal -psk 00112233
+//	transportc-socks client -signal https://rendezvous.example.com/signal -psk 00112233 -listen 127.0.0.1:1080
+//
+// The server connects to any destination requested, so the signal should be
+// sealed with -psk, or the server pinned with -cert and -fingerprint.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gaukas/logging"
+	"github.com/gaukas/transportc/internal/cliconfig"
+)
+
+const (
+	DIAL_TIMEOUT   = 30 * time.Second
+	SERVER_TIMEOUT = 5 * time.Minute
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s client|server [flags]\n", os.Args[0])
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	mode := os.Args[1]
+
+	fs := flag.NewFlagSet(os.Args[0]+" "+mode, flag.ExitOnError)
+	var flags *cliconfig.Flags
+	var listen string
+	switch mode {
+	case "client":
+		flags = cliconfig.Register(fs, 0)
+		fs.StringVar(&listen, "listen", "127.0.0.1:1080", "address to serve SOCKS5 on")
+	case "server":
+		flags = cliconfig.Register(fs, SERVER_TIMEOUT)
+	default:
+		usage()
+	}
+	verbose := fs.Bool("v", false, "verbose logging")
+	fs.Parse(os.Args[2:]) // skipcq: GSC-G104
+
+	config, closer, err := flags.Config()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+	level := logging.LOG_INFO
+	if *verbose {
+		level = logging.LOG_DEBUG
+	}
+	config.Logger = logging.DefaultStderrLogger(level)
+
+	if mode == "client" {
+		err = runClient(listen, config)
+	} else {
+		err = runServer(config)
+	}
+	if err != nil {
+		config.Logger.Errorf("%v", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/gaukas/transportc"
+	"github.com/gaukas/transportc/internal/socks5"
+)
+
+// Messages exchanged between the client and the server over the Conns.
+//
+// A stream Conn is dialed per CONNECT request. Its first message is the request
+// and the server replies with the outcome before relaying the stream:
+//
+//	client: MSG_CONNECT | address
+//	server: reply code | bound address
+//
+// All the UDP associations of the client share one unreliable, unordered
+// DataChannel pre-negotiated with DATAGRAM_CHANNEL_ID, each message carrying one
+// datagram to or from the address:
+//
+//	MSG_DATAGRAM | association ID | address | data
+//
+// Addresses are in the SOCKS5 wire format.
+const (
+	MSG_CONNECT  = socks5.CMD_CONNECT
+	MSG_DATAGRAM = socks5.CMD_UDP_ASSOCIATE
+
+	DATAGRAM_CHANNEL_ID    = 1023
+	DATAGRAM_CHANNEL_LABEL = "socks-udp"
+	STREAM_CHANNEL_LABEL   = "socks"
+)
+
+// datagramChannel is the negotiated DataChannel carrying the datagrams.
+func datagramChannel() transportc.NegotiatedDataChannel {
+	maxRetransmits := uint16(0)
+	return transportc.NegotiatedDataChannel{
+		Label:          DATAGRAM_CHANNEL_LABEL,
+		ID:             DATAGRAM_CHANNEL_ID,
+		Unordered:      true,
+		MaxRetransmits: &maxRetransmits,
+	}
+}
+
+func appendDatagram(b []byte, associationID uint32, addr string, data []byte) ([]byte, error) {
+	b = binary.BigEndian.AppendUint32(append(b, MSG_DATAGRAM), associationID)
+	b, err := socks5.AppendHostPort(b, addr)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, data...), nil
+}
+
+func parseDatagram(b []byte) (associationID uint32, addr string, data []byte, err error) {
+	if len(b) < 5 || b[0] != MSG_DATAGRAM {
+		return 0, "", nil, socks5.ErrShortDatagram
+	}
+	associationID = binary.BigEndian.Uint32(b[1:5])
+	addr, n, err := socks5.ParseAddr(b[5:])
+	if err != nil {
+		return 0, "", nil, err
+	}
+	return associationID, addr, b[5+n:], nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gaukas/logging"
+	"github.com/gaukas/transportc"
+	"github.com/gaukas/transportc/internal/relay"
+	"github.com/gaukas/transportc/internal/socks5"
+)
+
+const (
+	UDP_ASSOCIATION_TIMEOUT = 2 * time.Minute
+	UDP_ASSOCIATIONS_MAX    = 64  // per client
+	UDP_ASSOCIATION_BACKLOG = 64  // datagrams waiting for their destination to be resolved
+	UDP_RESOLVE_CACHE_SIZE  = 256 // destinations resolved per association
+	UDP_RESOLVE_CACHE_TTL   = time.Minute
+)
+
+var errTooManyAssociations = errors.New("too many UDP associations")
+
+// server accepts the Conns of the clients and connects out to the destinations.
+type server struct {
+	logger logging.Logger
+}
+
+func (s *server) serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle tells a stream Conn from the datagram channel by its first message.
+func (s *server) handle(conn net.Conn) {
+	buf := make([]byte, transportc.CONN_DEFAULT_MTU)
+	n, err := conn.Read(buf)
+	if err != nil || n == 0 {
+		conn.Close() // skipcq: GSC-G104
+		return
+	}
+
+	switch buf[0] {
+	case MSG_CONNECT:
+		s.connect(conn, buf[1:n])
+	case MSG_DATAGRAM:
+		s.relayDatagrams(conn, buf[:n])
+	default:
+		s.logger.Warnf("Unknown message type %d", buf[0])
+		conn.Close() // skipcq: GSC-G104
+	}
+}
+
+// connect connects to the destination of the CONNECT request, replies with the
+// outcome and relays the stream.
+func (s *server) connect(conn net.Conn, request []byte) {
+	addr, _, err := socks5.ParseAddr(request)
+	if err != nil {
+		conn.Write(appendReply(nil, socks5.REP_ADDRESS_TYPE_NOT_SUPPORTED, nil)) // skipcq: GSC-G104
+		conn.Close()                                                             // skipcq: GSC-G104
+		return
+	}
+
+	dst, err := net.DialTimeout("tcp", addr, DIAL_TIMEOUT)
+	if err != nil {
+		s.logger.Infof("CONNECT %s failed: %v", addr, err)
+		conn.Write(appendReply(nil, replyCode(err), nil)) // skipcq: GSC-G104
+		conn.Close()                                      // skipcq: GSC-G104
+		return
+	}
+	if _, err := conn.Write(appendReply(nil, socks5.REP_SUCCEEDED, dst.LocalAddr())); err != nil {
+		conn.Close() // skipcq: GSC-G104
+		dst.Close()  // skipcq: GSC-G104
+		return
+	}
+	relay.Pipe(conn, dst)
+}
+
+func appendReply(b []byte, rep byte, bound net.Addr) []byte {
+	return socks5.AppendAddr(append(b, rep), bound)
+}
+
+// replyCode returns the SOCKS5 reply code for the error connecting out.
+func replyCode(err error) byte {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socks5.REP_CONNECTION_REFUSED
+	case errors.Is(err, syscall.ENETUNREACH):
+		return socks5.REP_NETWORK_UNREACHABLE
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &dnsErr):
+		return socks5.REP_HOST_UNREACHABLE
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return socks5.REP_TTL_EXPIRED
+	default:
+		return socks5.REP_GENERAL_FAILURE
+	}
+}
+
+// serverAssociation is a UDP association of a client, relaying its datagrams from
+// a dedicated UDP socket.
+type serverAssociation struct {
+	udp        *net.UDPConn
+	outgoing   chan outgoingDatagram
+	done       chan struct{} // closed once the association ends
+	lastActive time.Time     // guarded by datagramRelay.mutex
+}
+
+// outgoingDatagram is a datagram to send once its destination is resolved.
+type outgoingDatagram struct {
+	addr string
+	data []byte
+}
+
+// datagramRelay relays the datagrams of the associations of a client.
+type datagramRelay struct {
+	conn   net.Conn
+	logger logging.Logger
+
+	mutex        sync.Mutex
+	associations map[uint32]*serverAssociation
+}
+
+// relayDatagrams relays the datagrams received on the datagram channel, starting
+// with the first message already read, until the channel is closed.
+func (s *server) relayDatagrams(conn net.Conn, first []byte) {
+	dr := &datagramRelay{
+		conn:         conn,
+		logger:       s.logger,
+		associations: make(map[uint32]*serverAssociation),
+	}
+	defer dr.close()
+
+	dr.send(first)
+	buf := make([]byte, transportc.CONN_DEFAULT_MTU)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		dr.send(buf[:n])
+	}
+}
+
+// send queues the datagram in the message to be sent to its destination by its
+// association, so a slow DNS lookup never holds up the datagram channel.
+func (dr *datagramRelay) send(msg []byte) {
+	id, addr, data, err := parseDatagram(msg)
+	if err != nil {
+		dr.logger.Debugf("Dropping datagram: %v", err)
+		return
+	}
+
+	association, err := dr.association(id)
+	if err != nil {
+		dr.logger.Warnf("UDP association failed: %v", err)
+		return
+	}
+	select {
+	case association.outgoing <- outgoingDatagram{addr: addr, data: append([]byte(nil), data...)}:
+	default:
+		dr.logger.Debugf("Dropping datagram to %s: backlog full", addr)
+	}
+}
+
+// association returns the association with the ID, creating it if needed.
+func (dr *datagramRelay) association(id uint32) (*serverAssociation, error) {
+	dr.mutex.Lock()
+	defer dr.mutex.Unlock()
+	if association, ok := dr.associations[id]; ok {
+		association.lastActive = time.Now()
+		return association, nil
+	}
+
+	if len(dr.associations) >= UDP_ASSOCIATIONS_MAX {
+		return nil, errTooManyAssociations
+	}
+
+	udp, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	association := &serverAssociation{
+		udp:        udp,
+		outgoing:   make(chan outgoingDatagram, UDP_ASSOCIATION_BACKLOG),
+		done:       make(chan struct{}),
+		lastActive: time.Now(),
+	}
+	dr.associations[id] = association
+	go dr.receive(id, association)
+	go dr.sendLoop(association)
+	return association, nil
+}
+
+// resolvedAddr is a destination resolved, cached until it expires.
+type resolvedAddr struct {
+	addr    *net.UDPAddr
+	expires time.Time
+}
+
+// sendLoop resolves the destinations of the datagrams queued and sends them,
+// until the association ends.
+func (dr *datagramRelay) sendLoop(association *serverAssociation) {
+	resolved := make(map[string]resolvedAddr)
+	for {
+		var datagram outgoingDatagram
+		select {
+		case datagram = <-association.outgoing:
+		case <-association.done:
+			return
+		}
+
+		dst, ok := resolved[datagram.addr]
+		if !ok || time.Now().After(dst.expires) {
+			addr, err := net.ResolveUDPAddr("udp", datagram.addr)
+			if err != nil {
+				dr.logger.Debugf("Dropping datagram to %s: %v", datagram.addr, err)
+				continue
+			}
+			if len(resolved) >= UDP_RESOLVE_CACHE_SIZE {
+				resolved = make(map[string]resolvedAddr)
+			}
+			dst = resolvedAddr{addr: addr, expires: time.Now().Add(UDP_RESOLVE_CACHE_TTL)}
+			resolved[datagram.addr] = dst
+		}
+		association.udp.WriteToUDP(datagram.data, dst.addr) // skipcq: GSC-G104
+	}
+}
+
+// receive relays the datagrams received by the association back to the client,
+// until the association is idle for UDP_ASSOCIATION_TIMEOUT.
+func (dr *datagramRelay) receive(id uint32, association *serverAssociation) {
+	defer func() {
+		dr.mutex.Lock()
+		if dr.associations[id] == association {
+			delete(dr.associations, id)
+		}
+		dr.mutex.Unlock()
+		association.udp.Close()
+		close(association.done)
+	}()
+
+	buf := make([]byte, transportc.CONN_DEFAULT_MTU)
+	for {
+		association.udp.SetReadDeadline(time.Now().Add(UDP_ASSOCIATION_TIMEOUT)) // skipcq: GSC-G104
+		n, src, err := association.udp.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				dr.mutex.Lock()
+				idle := time.Since(association.lastActive) >= UDP_ASSOCIATION_TIMEOUT
+				dr.mutex.Unlock()
+				if !idle {
+					continue
+				}
+			}
+			return
+		}
+
+		dr.mutex.Lock()
+		association.lastActive = time.Now()
+		dr.mutex.Unlock()
+
+		msg, err := appendDatagram(nil, id, src.String(), buf[:n])
+		if err != nil {
+			continue
+		}
+		if _, err := dr.conn.Write(msg); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			return
+		}
+	}
+}
+
+func (dr *datagramRelay) close() {
+	dr.conn.Close() // skipcq: GSC-G104
+	dr.mutex.Lock()
+	defer dr.mutex.Unlock()
+	for _, association := range dr.associations {
+		association.udp.Close() // skipcq: GSC-G104
+	}
+}
+
+// runServer accepts the Conns of the clients.
+func runServer(config *transportc.Config) error {
+	config.NegotiatedDataChannels = append(config.NegotiatedDataChannels, datagramChannel())
+
+	listener, err := config.NewListener()
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	if err := listener.Start(); err != nil {
+		return err
+	}
+	config.Logger.Infof("SOCKS5 server accepting Conns")
+
+	s := &server{logger: config.Logger}
+	return s.serve(listener)
+}
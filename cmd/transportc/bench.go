@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gaukas/logging"
+	"github.com/gaukas/transportc"
+	"github.com/gaukas/transportc/internal/cliconfig"
+)
+
+// Every benchmark message starts with its sequence number and the time it was
+// sent, and is echoed back as is by the server.
+const (
+	BENCH_HEADER_SIZE    = 16
+	BENCH_CHANNEL_BASE   = 1024 // ID of the first pre-negotiated DataChannel
+	BENCH_MAX_CHANNELS   = 16   // pre-negotiated DataChannels per mode
+	BENCH_DIAL_TIMEOUT   = 30 * time.Second
+	BENCH_LOSS_TIMEOUT   = time.Second // an unreliable message not echoed back as late is lost
+	BENCH_DRAIN_TIMEOUT  = 10 * time.Second
+	BENCH_PING_SEQ_FLAG  = 1 << 63 // tells the pings from the load
+	BENCH_SERVER_TIMEOUT = time.Minute
+)
+
+// benchMode is the reliability of the DataChannels of a benchmark.
+type benchMode struct {
+	name           string
+	unordered      bool
+	maxRetransmits *uint16
+}
+
+var benchModes = []benchMode{
+	{name: "reliable"},
+	{name: "unordered", unordered: true},
+	{name: "unreliable", unordered: true, maxRetransmits: new(uint16)},
+}
+
+// benchChannels returns the DataChannels pre-negotiated by both ends, the first
+// BENCH_MAX_CHANNELS ones of every mode in order.
+func benchChannels() []transportc.NegotiatedDataChannel {
+	var channels []transportc.NegotiatedDataChannel
+	for m, mode := range benchModes {
+		for i := 0; i < BENCH_MAX_CHANNELS; i++ {
+			channels = append(channels, transportc.NegotiatedDataChannel{
+				Label:          fmt.Sprintf("bench-%s-%d", mode.name, i),
+				ID:             uint16(BENCH_CHANNEL_BASE + m*BENCH_MAX_CHANNELS + i),
+				Unordered:      mode.unordered,
+				MaxRetransmits: mode.maxRetransmits,
+			})
+		}
+	}
+	return channels
+}
+
+func benchChannelID(mode, channel int) uint16 {
+	return uint16(BENCH_CHANNEL_BASE + mode*BENCH_MAX_CHANNELS + channel)
+}
+
+// benchReport is the JSON output of the benchmark.
+type benchReport struct {
+	Started time.Time     `json:"started"`
+	Config  benchConfig   `json:"config"`
+	Results []benchResult `json:"results"`
+}
+
+type benchConfig struct {
+	Duration float64 `json:"duration_seconds"`
+	Pings    int     `json:"pings"`
+	Window   int     `json:"window_messages"`
+}
+
+// benchResult is the result of one combination of mode, number of DataChannels
+// and message size. Throughput and message rate count the messages echoed back,
+// i.e. in each direction.
+type benchResult struct {
+	Mode             string       `json:"mode"`
+	Channels         int          `json:"channels"`
+	Size             int          `json:"size"`
+	ConnectSeconds   float64      `json:"connect_seconds"`
+	MessagesSent     uint64       `json:"messages_sent"`
+	MessagesReceived uint64       `json:"messages_received"`
+	Loss             float64      `json:"loss"`
+	Throughput       float64      `json:"throughput_bytes_per_second"`
+	MessageRate      float64      `json:"messages_per_second"`
+	Latency          latencyStats `json:"latency_ms"`
+	Error            string       `json:"error,omitempty"`
+}
+
+type latencyStats struct {
+	Samples int     `json:"samples"`
+	Lost    int     `json:"lost"`
+	Min     float64 `json:"min"`
+	Mean    float64 `json:"mean"`
+	P50     float64 `json:"p50"`
+	P90     float64 `json:"p90"`
+	P99     float64 `json:"p99"`
+	Max     float64 `json:"max"`
+}
+
+// newLatencyStats computes the statistics of the round-trip times.
+func newLatencyStats(rtts []time.Duration, lost int) latencyStats {
+	stats := latencyStats{Samples: len(rtts), Lost: lost}
+	if len(rtts) == 0 {
+		return stats
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	percentile := func(p float64) float64 {
+		return ms(rtts[int(math.Ceil(p*float64(len(rtts))))-1])
+	}
+
+	var sum time.Duration
+	for _, rtt := range rtts {
+		sum += rtt
+	}
+	stats.Min, stats.Max = ms(rtts[0]), ms(rtts[len(rtts)-1])
+	stats.Mean = ms(sum / time.Duration(len(rtts)))
+	stats.P50, stats.P90, stats.P99 = percentile(0.50), percentile(0.90), percentile(0.99)
+	return stats
+}
+
+func newBenchMessage(size int, seq uint64) []byte {
+	msg := make([]byte, size)
+	binary.BigEndian.PutUint64(msg, seq)
+	binary.BigEndian.PutUint64(msg[8:], uint64(time.Now().UnixNano()))
+	return msg
+}
+
+// ping measures the round-trip times of pings sent one at a time.
+func ping(conn net.Conn, size, count int) latencyStats {
+	var rtts []time.Duration
+	lost := 0
+	buf := make([]byte, transportc.CONN_DEFAULT_MTU)
+	for i := 0; i < count; i++ {
+		seq := BENCH_PING_SEQ_FLAG | uint64(i)
+		start := time.Now()
+		if _, err := conn.Write(newBenchMessage(size, seq)); err != nil {
+			break
+		}
+
+		conn.SetReadDeadline(start.Add(BENCH_LOSS_TIMEOUT)) // skipcq: GSC-G104
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				lost++
+				break
+			}
+			if n >= BENCH_HEADER_SIZE && binary.BigEndian.Uint64(buf) == seq {
+				rtts = append(rtts, time.Since(start))
+				break
+			}
+			// a late echo of a previous ping
+		}
+	}
+	conn.SetReadDeadline(time.Time{}) // skipcq: GSC-G104
+	return newLatencyStats(rtts, lost)
+}
+
+// loadCounters are the messages sent and echoed back on all DataChannels.
+type loadCounters struct {
+	mutex    sync.Mutex
+	sent     uint64
+	received uint64
+	last     time.Time // when the last echo was received
+}
+
+// load sends messages on the Conn for the duration, with at most window of them
+// waiting for their echo. Unless reliable, a message not echoed back within
+// BENCH_LOSS_TIMEOUT is lost and frees its place in the window.
+func load(conn net.Conn, size, window int, reliable bool, duration time.Duration, counters *loadCounters) {
+	slots := make(chan struct{}, window)
+	var mutex sync.Mutex
+	inFlight := make(map[uint64]time.Time)
+	release := func(seq uint64) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if _, ok := inFlight[seq]; !ok {
+			return false
+		}
+		delete(inFlight, seq)
+		<-slots
+		return true
+	}
+
+	// The reader stops counting once load returns, and ends with the Conn
+	var stopped bool // guarded by counters.mutex
+	defer func() {
+		counters.mutex.Lock()
+		stopped = true
+		counters.mutex.Unlock()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		buf := make([]byte, transportc.CONN_DEFAULT_MTU)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			if n < BENCH_HEADER_SIZE || !release(binary.BigEndian.Uint64(buf)) {
+				continue
+			}
+			counters.mutex.Lock()
+			if stopped {
+				counters.mutex.Unlock()
+				return
+			}
+			counters.received++
+			counters.last = time.Now()
+			counters.mutex.Unlock()
+		}
+	}()
+	go func() {
+		if reliable {
+			return
+		}
+		ticker := time.NewTicker(BENCH_LOSS_TIMEOUT / 10)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				mutex.Lock()
+				for seq, sent := range inFlight {
+					if now.Sub(sent) > BENCH_LOSS_TIMEOUT {
+						delete(inFlight, seq)
+						<-slots
+					}
+				}
+				mutex.Unlock()
+			}
+		}
+	}()
+	defer close(done)
+
+	deadline := time.Now().Add(duration)
+	for seq := uint64(0); time.Now().Before(deadline); seq++ {
+		select {
+		case slots <- struct{}{}:
+		case <-time.After(time.Until(deadline)):
+			continue
+		}
+		mutex.Lock()
+		inFlight[seq] = time.Now()
+		mutex.Unlock()
+		if _, err := conn.Write(newBenchMessage(size, seq)); err != nil {
+			release(seq)
+			return
+		}
+		counters.mutex.Lock()
+		counters.sent++
+		counters.mutex.Unlock()
+	}
+
+	// Wait for the last echoes
+	drain := BENCH_LOSS_TIMEOUT
+	if reliable {
+		drain = BENCH_DRAIN_TIMEOUT
+	}
+	for wait := time.Now().Add(drain); time.Now().Before(wait); time.Sleep(10 * time.Millisecond) {
+		mutex.Lock()
+		pending := len(inFlight)
+		mutex.Unlock()
+		if pending == 0 {
+			return
+		}
+	}
+}
+
+// benchmark runs one combination on a new PeerConnection.
+func benchmark(config *transportc.Config, mode, channels, size, pings, window int, duration time.Duration) benchResult {
+	result := benchResult{
+		Mode:     benchModes[mode].name,
+		Channels: channels,
+		Size:     size,
+	}
+
+	dialer, err := config.NewDialer()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer dialer.Close()
+
+	start := time.Now()
+	conns := make([]net.Conn, channels)
+	for i := range conns {
+		ctx, cancel := context.WithTimeout(context.Background(), BENCH_DIAL_TIMEOUT)
+		conns[i], err = dialer.DialNegotiatedContext(ctx, benchChannelID(mode, i))
+		cancel()
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		defer conns[i].Close()
+	}
+	result.ConnectSeconds = time.Since(start).Seconds()
+
+	result.Latency = ping(conns[0], size, pings)
+
+	reliable := benchModes[mode].maxRetransmits == nil
+	counters := &loadCounters{}
+	var wg sync.WaitGroup
+	start = time.Now()
+	for _, conn := range conns {
+		wg.Add(1)
+		go func(conn net.Conn) {
+			defer wg.Done()
+			load(conn, size, window, reliable, duration, counters)
+		}(conn)
+	}
+	wg.Wait()
+
+	counters.mutex.Lock()
+	sent, received, last := counters.sent, counters.received, counters.last
+	counters.mutex.Unlock()
+
+	result.MessagesSent, result.MessagesReceived = sent, received
+	if sent > 0 {
+		result.Loss = 1 - float64(received)/float64(sent)
+	}
+	if elapsed := last.Sub(start).Seconds(); received > 0 && elapsed > 0 {
+		result.MessageRate = float64(received) / elapsed
+		result.Throughput = result.MessageRate * float64(size)
+	}
+	return result
+}
+
+// echo echoes back every message until the Conn is closed.
+func echo(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, transportc.CONN_DEFAULT_MTU)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+func parseInts(s string) ([]int, error) {
+	var ints []int
+	for _, field := range strings.Split(s, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid list %q: %w", s, err)
+		}
+		ints = append(ints, i)
+	}
+	return ints, nil
+}
+
+func parseModes(s string) ([]int, error) {
+	var modes []int
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for m, mode := range benchModes {
+			if mode.name == strings.TrimSpace(name) {
+				modes = append(modes, m)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown mode %q", name)
+		}
+	}
+	return modes, nil
+}
+
+func writeBenchTable(w io.Writer, results []benchResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "mode\tchannels\tsize\tMB/s\tmsg/s\tloss\tp50 ms\tp90 ms\tp99 ms\t")
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(tw, "%s\t%d\t%d\terror: %s\t\n", r.Mode, r.Channels, r.Size, r.Error)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f\t%.0f\t%.2f%%\t%.2f\t%.2f\t%.2f\t\n", r.Mode, r.Channels, r.Size,
+			r.Throughput/1e6, r.MessageRate, 100*r.Loss, r.Latency.P50, r.Latency.P90, r.Latency.P99)
+	}
+	return tw.Flush()
+}
+
+func runBench(args []string) error {
+	fs, logLevel := newFlagSet("bench")
+	flags := cliconfig.Register(fs, BENCH_SERVER_TIMEOUT)
+	server := fs.Bool("server", false, "echo the messages of the benchmarking peers instead of benchmarking")
+	sizes := fs.String("sizes", "64,1024,16384", "comma-separated message sizes in bytes")
+	modes := fs.String("modes", "reliable,unreliable", "comma-separated reliability modes: reliable, unordered (reliable) and unreliable (unordered, no retransmission)")
+	channels := fs.String("channels", "1,4", fmt.Sprintf("comma-separated numbers of DataChannels carrying the load, at most %d", BENCH_MAX_CHANNELS))
+	duration := fs.Duration("duration", 5*time.Second, "duration of the load of each combination")
+	pings := fs.Int("pings", 100, "number of pings measuring the latency of each combination")
+	window := fs.Int("window", 64, "max messages per DataChannel waiting for their echo")
+	jsonOutput := fs.Bool("json", false, "write the results as JSON to stdout")
+	fs.Parse(args) // skipcq: GSC-G104
+
+	config, closer, err := flags.Config()
+	if err != nil {
+		return err
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+	config.Logger = logging.DefaultStderrLogger(logLevel())
+	config.NegotiatedDataChannels = benchChannels()
+
+	if *server {
+		listener, err := config.NewListener()
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		if err := listener.Start(); err != nil {
+			return err
+		}
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return err
+			}
+			go echo(conn)
+		}
+	}
+
+	sizeList, err := parseInts(*sizes)
+	if err != nil {
+		return err
+	}
+	for _, size := range sizeList {
+		if size < BENCH_HEADER_SIZE || size > transportc.CONN_DEFAULT_MTU {
+			return fmt.Errorf("message size %d out of [%d, %d]", size, BENCH_HEADER_SIZE, transportc.CONN_DEFAULT_MTU)
+		}
+	}
+	channelList, err := parseInts(*channels)
+	if err != nil {
+		return err
+	}
+	for _, n := range channelList {
+		if n < 1 || n > BENCH_MAX_CHANNELS {
+			return fmt.Errorf("number of DataChannels %d out of [1, %d]", n, BENCH_MAX_CHANNELS)
+		}
+	}
+	modeList, err := parseModes(*modes)
+	if err != nil {
+		return err
+	}
+	if *pings < 1 || *window < 1 {
+		return errors.New("-pings and -window must be at least 1")
+	}
+	config.ReusePeerConnection = true
+
+	report := benchReport{
+		Started: time.Now().UTC(),
+		Config: benchConfig{
+			Duration: duration.Seconds(),
+			Pings:    *pings,
+			Window:   *window,
+		},
+	}
+	for _, mode := range modeList {
+		for _, n := range channelList {
+			for _, size := range sizeList {
+				config.Logger.Infof("Benchmarking %s with %d DataChannels and %d-byte messages", benchModes[mode].name, n, size)
+				result := benchmark(config, mode, n, size, *pings, *window, *duration)
+				if result.Error != "" {
+					config.Logger.Errorf("%s", result.Error)
+				}
+				report.Results = append(report.Results, result)
+			}
+		}
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	return writeBenchTable(os.Stdout, report.Results)
+}
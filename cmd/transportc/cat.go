@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/gaukas/logging"
+	"github.com/gaukas/transportc"
+	"github.com/gaukas/transportc/internal/cliconfig"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	CAT_CHANNEL_ID   = 1023 // the pre-negotiated DataChannel of -unordered
+	CAT_DIAL_TIMEOUT = 5 * time.Minute
+	CAT_LABEL        = "cat"
+	CAT_TIMEOUT      = time.Hour
+)
+
+// iceEvents logs the progress of ICE and DTLS on every PeerConnection.
+func iceEvents(logger logging.Logger) *transportc.Events {
+	return &transportc.Events{
+		OnICEConnectionStateChange: func(_ *webrtc.PeerConnection, state webrtc.ICEConnectionState) {
+			logger.Infof("ICE connection %s", state)
+		},
+		OnSelectedCandidatePairChange: func(_ *webrtc.PeerConnection, pair *webrtc.ICECandidatePair) {
+			logger.Infof("ICE candidate pair selected: %s", pair)
+		},
+		OnDTLSHandshakeComplete: func(*webrtc.PeerConnection) {
+			logger.Infof("DTLS handshake complete")
+		},
+		OnDataChannelOpen: func(_ *webrtc.PeerConnection, dc *webrtc.DataChannel) {
+			logger.Infof("DataChannel %q open", dc.Label())
+		},
+	}
+}
+
+// acceptOne accepts the first Conn of the Listener.
+func acceptOne(config *transportc.Config) (net.Conn, io.Closer, error) {
+	listener, err := config.NewListener()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := listener.Start(); err != nil {
+		listener.Close() // skipcq: GSC-G104
+		return nil, nil, err
+	}
+	conn, err := listener.Accept()
+	if err != nil {
+		listener.Close() // skipcq: GSC-G104
+		return nil, nil, err
+	}
+	return conn, listener, nil
+}
+
+// dialOne dials a Conn, on the pre-negotiated DataChannel if unordered.
+func dialOne(config *transportc.Config, label string, unordered bool) (net.Conn, io.Closer, error) {
+	dialer, err := config.NewDialer()
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), CAT_DIAL_TIMEOUT)
+	defer cancel()
+
+	var conn net.Conn
+	if unordered {
+		conn, err = dialer.DialNegotiatedContext(ctx, CAT_CHANNEL_ID)
+	} else {
+		conn, err = dialer.DialContext(ctx, label)
+	}
+	if err != nil {
+		dialer.Close() // skipcq: GSC-G104
+		return nil, nil, err
+	}
+	return conn, dialer, nil
+}
+
+// cat copies stdin to the Conn, one message per read, and the messages of the
+// Conn to stdout until the peer closes it. On EOF of stdin, the Conn is closed
+// if closeOnEOF.
+func cat(conn net.Conn, stdin io.Reader, stdout io.Writer, closeOnEOF bool) error {
+	go func() {
+		buf := make([]byte, transportc.CONN_DEFAULT_MTU)
+		for {
+			n, err := stdin.Read(buf)
+			if n > 0 {
+				if _, err := conn.Write(buf[:n]); err != nil {
+					return
+				}
+			}
+			if err != nil {
+				if closeOnEOF {
+					conn.Close() // skipcq: GSC-G104
+				}
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, transportc.CONN_DEFAULT_MTU)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if _, err := stdout.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func runCat(args []string) error {
+	fs, logLevel := newFlagSet("cat")
+	flags := cliconfig.Register(fs, CAT_TIMEOUT)
+	listen := fs.Bool("l", false, "listen for a Conn instead of dialing")
+	closeOnEOF := fs.Bool("N", false, "close the Conn on EOF of stdin, instead of waiting for the peer to close it")
+	label := fs.String("label", CAT_LABEL, "label of the DataChannel")
+	unordered := fs.Bool("unordered", false, "deliver the messages out of order, over a pre-negotiated DataChannel (set on both ends)")
+	iceLog := fs.Bool("ice-log", false, "log the ICE connection states, the selected candidate pairs and the DTLS handshakes")
+	fs.Parse(args) // skipcq: GSC-G104
+
+	config, closer, err := flags.Config()
+	if err != nil {
+		return err
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+	config.Logger = logging.DefaultStderrLogger(logLevel())
+	if *iceLog {
+		config.Events = iceEvents(config.Logger)
+	}
+	if *unordered {
+		config.NegotiatedDataChannels = []transportc.NegotiatedDataChannel{{
+			Label:     *label,
+			ID:        CAT_CHANNEL_ID,
+			Unordered: true,
+		}}
+	}
+
+	var conn net.Conn
+	var endpoint io.Closer
+	if *listen {
+		conn, endpoint, err = acceptOne(config)
+	} else {
+		conn, endpoint, err = dialOne(config, *label, *unordered)
+	}
+	if err != nil {
+		return err
+	}
+	defer endpoint.Close()
+	config.Logger.Debugf("Connected to %s", conn.RemoteAddr())
+
+	defer conn.Close()
+	return cat(conn, os.Stdin, os.Stdout, *closeOnEOF)
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gaukas/logging"
+	"github.com/gaukas/transportc"
+	"github.com/gaukas/transportc/internal/cliconfig"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	DOCTOR_CLOSE_TIMEOUT   = 5 * time.Second
+	DOCTOR_DIAL_TIMEOUT    = 30 * time.Second
+	DOCTOR_GATHER_TIMEOUT  = 10 * time.Second
+	DOCTOR_LABEL           = "doctor"
+	DOCTOR_LOOPBACK        = "loopback"
+	DOCTOR_STATS_INTERVAL  = 250 * time.Millisecond
+	DOCTOR_DEFAULT_TIMEOUT = time.Minute
+)
+
+var ErrNegotiationFailed = errors.New("test negotiation failed")
+
+// doctorReport is the diagnosis of ICE with a Config.
+type doctorReport struct {
+	Settings    iceSettings        `json:"settings"`
+	Interfaces  []interfaceReport  `json:"interfaces"`
+	Candidates  []candidateReport  `json:"candidates"`
+	GatherError string             `json:"gather_error,omitempty"`
+	NAT         *natReport         `json:"nat,omitempty"`
+	NATError    string             `json:"nat_error,omitempty"`
+	Negotiation *negotiationReport `json:"negotiation,omitempty"`
+}
+
+// iceSettings are the ICE settings of the Config. Empty means no restriction.
+type iceSettings struct {
+	NetworkTypes []string `json:"network_types,omitempty"`
+	NAT1To1IPs   []string `json:"nat1to1_ips,omitempty"`
+	NAT1To1Type  string   `json:"nat1to1_type,omitempty"`
+	PortRange    string   `json:"port_range,omitempty"`
+	ICEServers   []string `json:"ice_servers,omitempty"`
+}
+
+// interfaceReport is a network interface of the host, allowed if the
+// InterfaceFilter of the Config lets ICE gather candidates on it.
+type interfaceReport struct {
+	Name      string   `json:"name"`
+	Up        bool     `json:"up"`
+	Loopback  bool     `json:"loopback"`
+	Addresses []string `json:"addresses"`
+	Allowed   bool     `json:"allowed"`
+}
+
+type candidateReport struct {
+	Type     string `json:"type"`
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	Related  string `json:"related,omitempty"`
+}
+
+func (c candidateReport) String() string {
+	return fmt.Sprintf("%s %s %s", c.Type, c.Protocol, c.Address)
+}
+
+// pairReport is a candidate pair checked by ICE, as seen by the local agent.
+type pairReport struct {
+	Local     candidateReport `json:"local"`
+	Remote    candidateReport `json:"remote"`
+	State     string          `json:"state"`
+	Nominated bool            `json:"nominated"`
+}
+
+// negotiationReport is the outcome of the test negotiation.
+type negotiationReport struct {
+	Peer      string       `json:"peer"`
+	Connected bool         `json:"connected"`
+	Duration  float64      `json:"duration_ms"`
+	ICEStates []string     `json:"ice_states"`
+	Pairs     []pairReport `json:"pairs"`
+	Succeeded int          `json:"succeeded"`
+	Error     string       `json:"error,omitempty"`
+}
+
+func describeSettings(config *transportc.Config) iceSettings {
+	var settings iceSettings
+	for _, networkType := range config.CandidateNetworkTypes {
+		settings.NetworkTypes = append(settings.NetworkTypes, networkType.String())
+	}
+	if config.IPs != nil {
+		settings.NAT1To1IPs = config.IPs.IPs
+		settings.NAT1To1Type = config.IPs.Type.String()
+	}
+	if config.PortRange != nil {
+		settings.PortRange = fmt.Sprintf("%d-%d", config.PortRange.Min, config.PortRange.Max)
+	}
+	for _, s := range config.WebRTCConfiguration.ICEServers {
+		settings.ICEServers = append(settings.ICEServers, s.URLs...)
+	}
+	return settings
+}
+
+func describeInterfaces(config *transportc.Config) ([]interfaceReport, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]interfaceReport, 0, len(ifaces))
+	for _, iface := range ifaces {
+		r := interfaceReport{
+			Name:      iface.Name,
+			Up:        iface.Flags&net.FlagUp != 0,
+			Loopback:  iface.Flags&net.FlagLoopback != 0,
+			Addresses: []string{},
+			Allowed:   config.InterfaceFilter == nil || config.InterfaceFilter(iface.Name),
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			r.Addresses = append(r.Addresses, addr.String())
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+// gatherCandidates gathers the local candidates of a PeerConnection set up
+// with the Config, as a Dialer or a Listener would.
+func gatherCandidates(config *transportc.Config) ([]candidateReport, error) {
+	settingEngine, err := config.BuildSettingEngine()
+	if err != nil {
+		return nil, err
+	}
+	api := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
+	pc, err := api.NewPeerConnection(config.WebRTCConfiguration)
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+
+	var mutex sync.Mutex
+	candidates := []candidateReport{}
+	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c == nil {
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		candidates = append(candidates, describeCandidate(c))
+	})
+
+	if _, err := pc.CreateDataChannel(DOCTOR_LABEL, nil); err != nil {
+		return nil, err
+	}
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return nil, err
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return nil, err
+	}
+	select {
+	case <-gathered:
+	case <-time.After(DOCTOR_GATHER_TIMEOUT):
+		err = fmt.Errorf("gathering not complete after %s", DOCTOR_GATHER_TIMEOUT)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	return candidates, err
+}
+
+func describeCandidate(c *webrtc.ICECandidate) candidateReport {
+	r := candidateReport{
+		Type:     c.Typ.String(),
+		Protocol: c.Protocol.String(),
+		Address:  net.JoinHostPort(c.Address, fmt.Sprint(c.Port)),
+	}
+	if c.RelatedAddress != "" {
+		r.Related = net.JoinHostPort(c.RelatedAddress, fmt.Sprint(c.RelatedPort))
+	}
+	return r
+}
+
+// pairWatcher follows the ICE connection states of the first PeerConnection
+// and the stats of its candidate pairs, polled until the PeerConnection closes
+// as the agent reports no stats once closed.
+type pairWatcher struct {
+	mutex  sync.Mutex
+	pc     *webrtc.PeerConnection
+	states []string
+	pairs  []pairReport
+}
+
+func (w *pairWatcher) events(logger logging.Logger) *transportc.Events {
+	events := iceEvents(logger)
+	onStateChange := events.OnICEConnectionStateChange
+	events.OnICEConnectionStateChange = func(pc *webrtc.PeerConnection, state webrtc.ICEConnectionState) {
+		onStateChange(pc, state)
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		if w.pc == nil {
+			w.pc = pc
+		}
+		if w.pc == pc {
+			w.states = append(w.states, state.String())
+		}
+	}
+	return events
+}
+
+// watch polls the stats until done is closed.
+func (w *pairWatcher) watch(done <-chan struct{}) {
+	ticker := time.NewTicker(DOCTOR_STATS_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			w.poll()
+		}
+	}
+}
+
+func (w *pairWatcher) poll() {
+	w.mutex.Lock()
+	pc := w.pc
+	w.mutex.Unlock()
+	if pc == nil {
+		return
+	}
+
+	stats := pc.GetStats()
+	candidates := make(map[string]candidateReport)
+	for _, s := range stats {
+		if c, ok := s.(webrtc.ICECandidateStats); ok {
+			candidates[c.ID] = candidateReport{
+				Type:     c.CandidateType.String(),
+				Protocol: c.Protocol,
+				Address:  net.JoinHostPort(c.IP, fmt.Sprint(c.Port)),
+			}
+		}
+	}
+	var pairs []pairReport
+	for _, s := range stats {
+		if p, ok := s.(webrtc.ICECandidatePairStats); ok {
+			pairs = append(pairs, pairReport{
+				Local:     candidates[p.LocalCandidateID],
+				Remote:    candidates[p.RemoteCandidateID],
+				State:     string(p.State),
+				Nominated: p.Nominated,
+			})
+		}
+	}
+	if len(pairs) == 0 {
+		return
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Local.String() != pairs[j].Local.String() {
+			return pairs[i].Local.String() < pairs[j].Local.String()
+		}
+		return pairs[i].Remote.String() < pairs[j].Remote.String()
+	})
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.pairs = pairs
+}
+
+func (w *pairWatcher) report(peer string, started time.Time, err error) *negotiationReport {
+	w.poll()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	r := &negotiationReport{
+		Peer:      peer,
+		Connected: err == nil,
+		Duration:  float64(time.Since(started)) / float64(time.Millisecond),
+		ICEStates: append([]string{}, w.states...),
+		Pairs:     append([]pairReport{}, w.pairs...),
+	}
+	for _, p := range r.Pairs {
+		if p.State == string(webrtc.StatsICECandidatePairStateSucceeded) {
+			r.Succeeded++
+		}
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// negotiate dials a Conn, or accepts one if listen, and reports the candidate
+// pairs checked on the way. The accepting side closes the Conn. Without a
+// Signal to a peer, the Dialer dials a Listener of this process.
+func negotiate(config *transportc.Config, peer string, listen bool) *negotiationReport {
+	watcher := &pairWatcher{}
+	done := make(chan struct{})
+	defer close(done)
+	go watcher.watch(done)
+
+	started := time.Now()
+	if listen {
+		listenerConfig := *config
+		listenerConfig.Events = watcher.events(config.Logger)
+		conn, closer, err := acceptOne(&listenerConfig)
+		if err != nil {
+			return watcher.report(peer, started, err)
+		}
+		defer closer.Close()
+		report := watcher.report(peer, started, nil)
+		conn.Close() // skipcq: GSC-G104
+		return report
+	}
+
+	if peer == DOCTOR_LOOPBACK {
+		listenerConfig := *config
+		listenerConfig.Events = nil
+		listener, err := listenerConfig.NewListener()
+		if err != nil {
+			return watcher.report(peer, started, err)
+		}
+		defer listener.Close()
+		if err := listener.Start(); err != nil {
+			return watcher.report(peer, started, err)
+		}
+		go func() {
+			if conn, err := listener.Accept(); err == nil {
+				conn.Close() // skipcq: GSC-G104
+			}
+		}()
+	}
+
+	dialerConfig := *config
+	dialerConfig.Events = watcher.events(config.Logger)
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		return watcher.report(peer, started, err)
+	}
+	defer dialer.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), DOCTOR_DIAL_TIMEOUT)
+	defer cancel()
+	conn, err := dialer.DialContext(ctx, DOCTOR_LABEL)
+	if err != nil {
+		return watcher.report(peer, started, err)
+	}
+	defer conn.Close()
+	report := watcher.report(peer, started, nil)
+
+	// Closing before the peer accepts the Conn may abort the DataChannel on its
+	// side, so the Conn is closed by the peer.
+	conn.SetReadDeadline(time.Now().Add(DOCTOR_CLOSE_TIMEOUT)) // skipcq: GSC-G104
+	io.Copy(io.Discard, conn)                                  // skipcq: GSC-G104
+	return report
+}
+
+func printDoctorReport(w io.Writer, report *doctorReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	orAll := func(values []string, all string) string {
+		if len(values) == 0 {
+			return all
+		}
+		return strings.Join(values, ", ")
+	}
+	s := report.Settings
+	fmt.Fprintf(tw, "ICE settings\n")
+	fmt.Fprintf(tw, "  network types:\t%s\n", orAll(s.NetworkTypes, "all"))
+	if len(s.NAT1To1IPs) > 0 {
+		fmt.Fprintf(tw, "  NAT 1:1 IPs:\t%s (%s)\n", strings.Join(s.NAT1To1IPs, ", "), s.NAT1To1Type)
+	} else {
+		fmt.Fprintf(tw, "  NAT 1:1 IPs:\tnone\n")
+	}
+	if s.PortRange != "" {
+		fmt.Fprintf(tw, "  port range:\t%s\n", s.PortRange)
+	} else {
+		fmt.Fprintf(tw, "  port range:\tany\n")
+	}
+	fmt.Fprintf(tw, "  ICE servers:\t%s\n", orAll(s.ICEServers, "none"))
+
+	fmt.Fprintf(tw, "\nInterfaces\n")
+	for _, iface := range report.Interfaces {
+		var flags []string
+		if !iface.Up {
+			flags = append(flags, "down")
+		}
+		if iface.Loopback {
+			flags = append(flags, "loopback")
+		}
+		if !iface.Allowed {
+			flags = append(flags, "filtered")
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", iface.Name, orAll(iface.Addresses, "-"), strings.Join(flags, " "))
+	}
+
+	fmt.Fprintf(tw, "\nLocal candidates (%d)\n", len(report.Candidates))
+	for _, c := range report.Candidates {
+		related := ""
+		if c.Related != "" {
+			related = "related " + c.Related
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", c.Type, c.Protocol, c.Address, related)
+	}
+	if report.GatherError != "" {
+		fmt.Fprintf(tw, "  error: %s\n", report.GatherError)
+	}
+
+	fmt.Fprintf(tw, "\nNAT\n")
+	if nat := report.NAT; nat != nil {
+		fmt.Fprintf(tw, "  local:\t%s\n", nat.Local)
+		for _, r := range nat.Results {
+			if r.Error != "" {
+				fmt.Fprintf(tw, "  %s:\t%s\n", r.Server, r.Error)
+			} else {
+				fmt.Fprintf(tw, "  %s:\tmapped to %s\n", r.Server, r.Mapped)
+			}
+		}
+		fmt.Fprintf(tw, "  mapping:\t%s (port preserved: %t)\n", nat.Mapping, nat.PortPreserved)
+		fmt.Fprintf(tw, "  %s\n", nat.Summary)
+	} else if report.NATError != "" {
+		fmt.Fprintf(tw, "  %s\n", report.NATError)
+	}
+
+	if n := report.Negotiation; n != nil {
+		status := fmt.Sprintf("connected in %.0fms", n.Duration)
+		if !n.Connected {
+			status = "failed: " + n.Error
+		}
+		fmt.Fprintf(tw, "\nNegotiation with %s: %s\n", n.Peer, status)
+		fmt.Fprintf(tw, "  ICE states:\t%s\n", orAll(n.ICEStates, "none"))
+		fmt.Fprintf(tw, "  candidate pairs:\t%d checked, %d succeeded\n", len(n.Pairs), n.Succeeded)
+		for _, p := range n.Pairs {
+			nominated := ""
+			if p.Nominated {
+				nominated = "nominated"
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s -> %s\n", p.State, nominated, p.Local, p.Remote)
+		}
+	}
+	return tw.Flush()
+}
+
+func runDoctor(args []string) error {
+	fs, logLevel := newFlagSet("doctor")
+	flags := cliconfig.Register(fs, DOCTOR_DEFAULT_TIMEOUT)
+	listen := fs.Bool("l", false, "accept the test negotiation of a peer running transportc doctor, instead of dialing it")
+	jsonOutput := fs.Bool("json", false, "write the report as JSON to stdout")
+	fs.Parse(args) // skipcq: GSC-G104
+
+	peer := flags.Signal()
+	if peer == "" {
+		if *listen {
+			return errors.New("-l requires -signal")
+		}
+		dir, err := os.MkdirTemp("", "transportc-doctor")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		flags.SetSignal("dir:" + dir)
+		peer = DOCTOR_LOOPBACK
+	}
+	config, closer, err := flags.Config()
+	if err != nil {
+		return err
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+	config.Logger = logging.DefaultStderrLogger(logLevel())
+
+	report := doctorReport{Settings: describeSettings(config)}
+	report.Interfaces, err = describeInterfaces(config)
+	if err != nil {
+		return err
+	}
+	config.Logger.Infof("Gathering the local candidates")
+	report.Candidates, err = gatherCandidates(config)
+	if err != nil {
+		report.GatherError = err.Error()
+	}
+	if servers := stunServers(config.WebRTCConfiguration.ICEServers); len(servers) > 0 {
+		config.Logger.Infof("Classifying the NAT with %d STUN servers", len(servers))
+		report.NAT, err = classifyNAT(servers)
+		if err != nil {
+			report.NATError = err.Error()
+		}
+	} else {
+		report.NATError = "no STUN server to classify the NAT, give one with -ice stun:host:port"
+	}
+	config.Logger.Infof("Negotiating with %s", peer)
+	report.Negotiation = negotiate(config, peer, *listen)
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = printDoctorReport(os.Stdout, &report)
+	}
+	if err != nil {
+		return err
+	}
+	if !report.Negotiation.Connected {
+		return ErrNegotiationFailed
+	}
+	return nil
+}
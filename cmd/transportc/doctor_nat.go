@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pion/ice/v2"
+	"github.com/pion/stun"
+	"github.com/pion/webrtc/v3"
+)
+
+// The mapping behaviors of RFC 4787, as far as told from the STUN servers.
+const (
+	MAPPING_NONE                       = "none"
+	MAPPING_ENDPOINT_INDEPENDENT       = "endpoint-independent"
+	MAPPING_ADDRESS_DEPENDENT          = "address-dependent"
+	MAPPING_ADDRESS_AND_PORT_DEPENDENT = "address-and-port-dependent"
+	MAPPING_ENDPOINT_DEPENDENT         = "endpoint-dependent" // either of the two above
+	MAPPING_UNKNOWN                    = "unknown"
+	MAPPING_BLOCKED                    = "blocked"
+
+	STUN_ATTEMPTS = 3
+	STUN_TIMEOUT  = time.Second
+)
+
+var (
+	ErrSTUNTimeout     = errors.New("no response from the STUN server")
+	ErrSTUNError       = errors.New("binding error response from the STUN server")
+	ErrNoMappedAddress = errors.New("no mapped address in the response of the STUN server")
+)
+
+var natSummaries = map[string]string{
+	MAPPING_NONE:                       "no NAT: the host candidates are reachable directly, unless a firewall filters them",
+	MAPPING_ENDPOINT_INDEPENDENT:       "endpoint-independent mapping (cone NAT): the srflx candidates should connect to most peers",
+	MAPPING_ADDRESS_DEPENDENT:          "address-dependent mapping (symmetric NAT): the srflx candidates only connect to peers without one, a TURN server may be needed",
+	MAPPING_ADDRESS_AND_PORT_DEPENDENT: "address-and-port-dependent mapping (symmetric NAT): the srflx candidates only connect to peers without one, a TURN server may be needed",
+	MAPPING_ENDPOINT_DEPENDENT:         "endpoint-dependent mapping (symmetric NAT): the srflx candidates only connect to peers without one, a TURN server may be needed",
+	MAPPING_UNKNOWN:                    "the mapping is not told apart with a single STUN server not supporting RFC 5780, give another one with -ice",
+	MAPPING_BLOCKED:                    "no STUN server answered: UDP may be blocked, try a TURN server over TCP or TLS",
+}
+
+// stunResult is the address mapped by a STUN server.
+type stunResult struct {
+	Server  string `json:"server"`
+	Address string `json:"address,omitempty"`
+	Mapped  string `json:"mapped,omitempty"`
+	Other   string `json:"other_address,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	mapped *net.UDPAddr
+	other  *net.UDPAddr
+	server *net.UDPAddr
+}
+
+// natReport classifies the NAT in front of the host from the addresses mapped
+// by the STUN servers to a single UDP socket.
+type natReport struct {
+	Local         string       `json:"local"`
+	Results       []stunResult `json:"stun"`
+	Mapping       string       `json:"mapping"`
+	PortPreserved bool         `json:"port_preserved"`
+	Summary       string       `json:"summary"`
+}
+
+// stunServers returns the host:port of the STUN servers among the ICE servers.
+// The TURN servers are left out, as they are checked by the gathering of the
+// relay candidates.
+func stunServers(iceServers []webrtc.ICEServer) []string {
+	var servers []string
+	for _, s := range iceServers {
+		for _, rawURL := range s.URLs {
+			u, err := ice.ParseURL(rawURL)
+			if err != nil || u.Scheme != ice.SchemeTypeSTUN {
+				continue
+			}
+			servers = append(servers, net.JoinHostPort(u.Host, strconv.Itoa(u.Port)))
+		}
+	}
+	return servers
+}
+
+// classifyNAT sends STUN Binding requests to the servers from one UDP socket
+// over IPv4 and tells the mapping behavior from the mapped addresses. If the
+// first server answering gives an OTHER-ADDRESS (RFC 5780), the mapping is
+// tested against it. Otherwise the mapped addresses of the servers are compared.
+func classifyNAT(servers []string) (*natReport, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.UDPAddr)
+
+	report := &natReport{Local: local.String(), Mapping: MAPPING_BLOCKED}
+	var first *stunResult
+	for _, server := range servers {
+		r := stunResult{Server: server}
+		addr, err := net.ResolveUDPAddr("udp4", server)
+		if err == nil {
+			r.Address = addr.String()
+			err = r.query(conn, addr)
+		}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		report.Results = append(report.Results, r)
+		if first == nil && r.mapped != nil {
+			first = &report.Results[len(report.Results)-1]
+		}
+	}
+	if first == nil {
+		report.Summary = natSummaries[report.Mapping]
+		return report, nil
+	}
+	report.PortPreserved = first.mapped.Port == local.Port
+
+	switch {
+	case first.mapped.Port == local.Port && isLocalIP(first.mapped.IP):
+		report.Mapping = MAPPING_NONE
+	case first.other != nil && !first.other.IP.Equal(first.server.IP):
+		report.Mapping = report.testOtherAddress(conn, first)
+	default:
+		report.Mapping = report.compareServers(first)
+	}
+	report.Summary = natSummaries[report.Mapping]
+	return report, nil
+}
+
+// testOtherAddress runs the tests II and III of RFC 5780 section 4.3, to the
+// alternate IP address of the server and then to its alternate port.
+func (report *natReport) testOtherAddress(conn *net.UDPConn, first *stunResult) string {
+	test := func(addr *net.UDPAddr) *net.UDPAddr {
+		r := stunResult{Server: fmt.Sprintf("OTHER-ADDRESS of %s", first.Server), Address: addr.String()}
+		if err := r.query(conn, addr); err != nil {
+			r.Error = err.Error()
+		}
+		report.Results = append(report.Results, r)
+		return r.mapped
+	}
+
+	mapped2 := test(&net.UDPAddr{IP: first.other.IP, Port: first.server.Port})
+	if mapped2 == nil {
+		return report.compareServers(first)
+	}
+	if sameAddr(mapped2, first.mapped) {
+		return MAPPING_ENDPOINT_INDEPENDENT
+	}
+	mapped3 := test(first.other)
+	switch {
+	case mapped3 == nil:
+		return MAPPING_ENDPOINT_DEPENDENT
+	case sameAddr(mapped3, mapped2):
+		return MAPPING_ADDRESS_DEPENDENT
+	default:
+		return MAPPING_ADDRESS_AND_PORT_DEPENDENT
+	}
+}
+
+// compareServers compares the address mapped by the first server to the ones
+// mapped by the servers at other addresses.
+func (report *natReport) compareServers(first *stunResult) string {
+	mapping := MAPPING_UNKNOWN
+	for _, r := range report.Results {
+		if r.mapped == nil || r.server == nil || sameAddr(r.server, first.server) {
+			continue
+		}
+		if !sameAddr(r.mapped, first.mapped) {
+			return MAPPING_ENDPOINT_DEPENDENT
+		}
+		mapping = MAPPING_ENDPOINT_INDEPENDENT
+	}
+	return mapping
+}
+
+// query sends a Binding request to the server and reads the mapped address,
+// and the OTHER-ADDRESS if any, from the response.
+func (r *stunResult) query(conn *net.UDPConn, server *net.UDPAddr) error {
+	r.server = server
+	req, err := stun.Build(stun.TransactionID, stun.BindingRequest)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1500)
+	for attempt := 0; attempt < STUN_ATTEMPTS; attempt++ {
+		if _, err := conn.WriteToUDP(req.Raw, server); err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(STUN_TIMEOUT)) // skipcq: GSC-G104
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return err
+			}
+			res := &stun.Message{Raw: append([]byte{}, buf[:n]...)}
+			if res.Decode() != nil || res.TransactionID != req.TransactionID {
+				continue // a late response to an earlier request
+			}
+			return r.parse(res)
+		}
+	}
+	return ErrSTUNTimeout
+}
+
+func (r *stunResult) parse(res *stun.Message) error {
+	if res.Type != stun.BindingSuccess {
+		return ErrSTUNError
+	}
+
+	var xorMapped stun.XORMappedAddress
+	var mapped stun.MappedAddress
+	if err := xorMapped.GetFrom(res); err == nil {
+		r.mapped = &net.UDPAddr{IP: xorMapped.IP, Port: xorMapped.Port}
+	} else if err := mapped.GetFrom(res); err == nil {
+		r.mapped = &net.UDPAddr{IP: mapped.IP, Port: mapped.Port}
+	} else {
+		return ErrNoMappedAddress
+	}
+	r.Mapped = r.mapped.String()
+
+	var other stun.OtherAddress
+	if err := other.GetFrom(res); err == nil {
+		r.other = &net.UDPAddr{IP: other.IP, Port: other.Port}
+		r.Other = r.other.String()
+	}
+	return nil
+}
+
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
+
+// isLocalIP returns whether the IP address is one of the host.
+func isLocalIP(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gaukas/logging"
+	"github.com/gaukas/transportc"
+	"github.com/gaukas/transportc/internal/cliconfig"
+	"github.com/gaukas/transportc/internal/relay"
+	"github.com/gaukas/transportc/mux"
+)
+
+// Every mux stream starts with a header made of the type and a string prefixed
+// by its length in one byte, replied to with one status byte.
+//
+//	FORWARD_CONNECT: connect to the address host:port and relay the stream
+//	FORWARD_LISTEN:  listen on the address and open a FORWARD_ACCEPT stream per
+//	                 connection accepted, until the stream is closed
+//	FORWARD_ACCEPT:  a connection accepted on the address of a FORWARD_LISTEN
+const (
+	FORWARD_CONNECT byte = iota + 1
+	FORWARD_LISTEN
+	FORWARD_ACCEPT
+
+	FORWARD_OK     byte = 0
+	FORWARD_FAILED byte = 1
+
+	FORWARD_DIAL_TIMEOUT = 30 * time.Second
+	FORWARD_RETRY_DELAY  = time.Second
+	FORWARD_LABEL        = "forward"
+)
+
+func writeForwardHeader(stream net.Conn, typ byte, addr string) error {
+	if len(addr) > 255 {
+		return fmt.Errorf("address %q too long", addr)
+	}
+	header := append([]byte{typ, byte(len(addr))}, addr...)
+	_, err := stream.Write(header)
+	return err
+}
+
+func readForwardHeader(stream net.Conn) (byte, string, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(stream, header[:]); err != nil {
+		return 0, "", err
+	}
+	addr := make([]byte, header[1])
+	if _, err := io.ReadFull(stream, addr); err != nil {
+		return 0, "", err
+	}
+	return header[0], string(addr), nil
+}
+
+// openForwardStream opens a stream with the header and waits for the status.
+func openForwardStream(session *mux.Session, typ byte, addr string) (net.Conn, error) {
+	stream, err := session.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeForwardHeader(stream, typ, addr); err != nil {
+		stream.Close() // skipcq: GSC-G104
+		return nil, err
+	}
+	var status [1]byte
+	if _, err := io.ReadFull(stream, status[:]); err != nil {
+		stream.Close() // skipcq: GSC-G104
+		return nil, err
+	}
+	if status[0] != FORWARD_OK {
+		stream.Close() // skipcq: GSC-G104
+		return nil, fmt.Errorf("peer failed to forward %s", addr)
+	}
+	return stream, nil
+}
+
+// connectForward connects the stream to the target and relays it.
+func connectForward(stream net.Conn, target string, logger logging.Logger) {
+	conn, err := net.DialTimeout("tcp", target, FORWARD_DIAL_TIMEOUT)
+	if err != nil {
+		logger.Warnf("Forward to %s failed: %v", target, err)
+		stream.Write([]byte{FORWARD_FAILED}) // skipcq: GSC-G104
+		stream.Close()                       // skipcq: GSC-G104
+		return
+	}
+	if _, err := stream.Write([]byte{FORWARD_OK}); err != nil {
+		stream.Close() // skipcq: GSC-G104
+		conn.Close()   // skipcq: GSC-G104
+		return
+	}
+	relay.Pipe(stream, conn)
+}
+
+// forwardClient dials the peer and serves the forwards over a mux session,
+// dialing again whenever the session dies.
+type forwardClient struct {
+	dialer  *transportc.Dialer
+	logger  logging.Logger
+	local   []cliconfig.ForwardSpec
+	remotes map[string]string // listen address on the peer: local target
+
+	mutex   sync.Mutex
+	session *mux.Session // nil while dialing
+	ready   chan struct{}
+}
+
+func (fc *forwardClient) run() error {
+	for _, spec := range fc.local {
+		ln, err := net.Listen("tcp", spec.Listen)
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		fc.logger.Infof("Forwarding %s to %s on the peer", ln.Addr(), spec.Target)
+		go fc.serveLocal(ln, spec.Target)
+	}
+
+	for {
+		if err := fc.connect(); err != nil {
+			fc.logger.Errorf("%v", err)
+			time.Sleep(FORWARD_RETRY_DELAY)
+			continue
+		}
+		<-fc.currentSession().CloseChan()
+		fc.logger.Warnf("Session closed, dialing again")
+		fc.mutex.Lock()
+		fc.session = nil
+		fc.ready = make(chan struct{})
+		fc.mutex.Unlock()
+	}
+}
+
+// connect dials a new session and requests the remote forwards on it.
+func (fc *forwardClient) connect() error {
+	ctx, cancel := context.WithTimeout(context.Background(), FORWARD_DIAL_TIMEOUT)
+	defer cancel()
+	conn, err := fc.dialer.DialContext(ctx, FORWARD_LABEL)
+	if err != nil {
+		return fmt.Errorf("dial failed: %w", err)
+	}
+	session, err := mux.Client(conn, nil)
+	if err != nil {
+		conn.Close() // skipcq: GSC-G104
+		return err
+	}
+
+	for listen, target := range fc.remotes {
+		// The control stream stays open as long as the session
+		if _, err := openForwardStream(session, FORWARD_LISTEN, listen); err != nil {
+			session.Close() // skipcq: GSC-G104
+			return fmt.Errorf("remote forward on %s: %w", listen, err)
+		}
+		fc.logger.Infof("Forwarding %s on the peer to %s", listen, target)
+	}
+	go fc.acceptRemote(session)
+
+	fc.mutex.Lock()
+	fc.session = session
+	close(fc.ready)
+	fc.mutex.Unlock()
+	return nil
+}
+
+func (fc *forwardClient) currentSession() *mux.Session {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return fc.session
+}
+
+// waitSession waits for a session to be up.
+func (fc *forwardClient) waitSession() *mux.Session {
+	for {
+		fc.mutex.Lock()
+		session, ready := fc.session, fc.ready
+		fc.mutex.Unlock()
+		if session == nil {
+			<-ready
+			continue
+		}
+		if !session.IsClosed() {
+			return session
+		}
+		time.Sleep(FORWARD_RETRY_DELAY / 10) // until run resets the session
+	}
+}
+
+// serveLocal forwards the connections accepted locally to the target on the peer.
+func (fc *forwardClient) serveLocal(ln net.Listener, target string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			stream, err := openForwardStream(fc.waitSession(), FORWARD_CONNECT, target)
+			if err != nil {
+				fc.logger.Warnf("Forward to %s failed: %v", target, err)
+				conn.Close() // skipcq: GSC-G104
+				return
+			}
+			relay.Pipe(conn, stream)
+		}()
+	}
+}
+
+// acceptRemote connects the connections accepted on the peer to the local targets.
+func (fc *forwardClient) acceptRemote(session *mux.Session) {
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			return
+		}
+		go func() {
+			typ, listen, err := readForwardHeader(stream)
+			target, ok := fc.remotes[listen]
+			if err != nil || typ != FORWARD_ACCEPT || !ok {
+				stream.Write([]byte{FORWARD_FAILED}) // skipcq: GSC-G104
+				stream.Close()                       // skipcq: GSC-G104
+				return
+			}
+			connectForward(stream, target, fc.logger)
+		}()
+	}
+}
+
+// forwardServer accepts the sessions of the clients and serves their forwards.
+type forwardServer struct {
+	logger logging.Logger
+}
+
+func (srv *forwardServer) serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		session, err := mux.Server(conn, nil)
+		if err != nil {
+			conn.Close() // skipcq: GSC-G104
+			continue
+		}
+		go srv.serveSession(session)
+	}
+}
+
+func (srv *forwardServer) serveSession(session *mux.Session) {
+	defer session.Close()
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			return
+		}
+		go func() {
+			typ, addr, err := readForwardHeader(stream)
+			if err != nil {
+				stream.Close() // skipcq: GSC-G104
+				return
+			}
+			switch typ {
+			case FORWARD_CONNECT:
+				connectForward(stream, addr, srv.logger)
+			case FORWARD_LISTEN:
+				srv.listen(session, stream, addr)
+			default:
+				stream.Write([]byte{FORWARD_FAILED}) // skipcq: GSC-G104
+				stream.Close()                       // skipcq: GSC-G104
+			}
+		}()
+	}
+}
+
+// listen serves a remote forward until the control stream or the session is closed.
+func (srv *forwardServer) listen(session *mux.Session, control net.Conn, addr string) {
+	defer control.Close()
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		srv.logger.Warnf("Remote forward on %s failed: %v", addr, err)
+		control.Write([]byte{FORWARD_FAILED}) // skipcq: GSC-G104
+		return
+	}
+	defer ln.Close()
+	if _, err := control.Write([]byte{FORWARD_OK}); err != nil {
+		return
+	}
+	srv.logger.Infof("Forwarding %s to the peer", ln.Addr())
+
+	go func() {
+		io.Copy(io.Discard, control) // skipcq: GSC-G104
+		ln.Close()                   // skipcq: GSC-G104
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			stream, err := openForwardStream(session, FORWARD_ACCEPT, addr)
+			if err != nil {
+				conn.Close() // skipcq: GSC-G104
+				return
+			}
+			relay.Pipe(conn, stream)
+		}()
+	}
+}
+
+func runForward(args []string) error {
+	fs, logLevel := newFlagSet("forward")
+	flags := cliconfig.Register(fs, time.Minute)
+	var locals, remotes cliconfig.StringList
+	fs.Var(&locals, "L", "local forward [bind_address:]port:host:hostport, from a local port to host:hostport reached by the peer (repeatable)")
+	fs.Var(&remotes, "R", "remote forward [bind_address:]port:host:hostport, from a port on the peer to host:hostport reached locally (repeatable)")
+	server := fs.Bool("server", false, "accept the forwards of the peers instead of dialing. The server connects to and listens on\n"+
+		"any address a peer requests, so seal the signal with -psk or pin the peers with -fingerprint")
+	fs.Parse(args) // skipcq: GSC-G104
+
+	config, closer, err := flags.Config()
+	if err != nil {
+		return err
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+	config.Logger = logging.DefaultStderrLogger(logLevel())
+
+	if *server {
+		if len(locals) > 0 || len(remotes) > 0 {
+			return errors.New("-L and -R are set on the dialing side")
+		}
+		listener, err := config.NewListener()
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		if err := listener.Start(); err != nil {
+			return err
+		}
+		return (&forwardServer{logger: config.Logger}).serve(listener)
+	}
+
+	if len(locals) == 0 && len(remotes) == 0 {
+		return errors.New("no forward, set -L or -R")
+	}
+	fc := &forwardClient{
+		logger:  config.Logger,
+		remotes: make(map[string]string),
+		ready:   make(chan struct{}),
+	}
+	for _, s := range locals {
+		spec, err := cliconfig.ParseForwardSpec(s)
+		if err != nil {
+			return err
+		}
+		fc.local = append(fc.local, spec)
+	}
+	for _, s := range remotes {
+		spec, err := cliconfig.ParseForwardSpec(s)
+		if err != nil {
+			return err
+		}
+		fc.remotes[spec.Listen] = spec.Target
+	}
+
+	fc.dialer, err = config.NewDialer()
+	if err != nil {
+		return err
+	}
+	defer fc.dialer.Close()
+	return fc.run()
+}
@@ -0,0 +1,70 @@
+// Command transportc is a toolbox of transportc over the command line.
+//
+// Usage:
+//
+//	transportc <command> [flags]
+//
+// Run transportc <command> -h for the flags of a command.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/gaukas/logging"
+)
+
+// command is a subcommand run with its arguments.
+type command struct {
+	run     func(args []string) error
+	summary string
+}
+
+var commands = map[string]command{
+	"cat":     {runCat, "pipe stdin and stdout through a Conn, like nc"},
+	"bench":   {runBench, "measure the throughput, latency and message rate to a peer"},
+	"doctor":  {runDoctor, "diagnose ICE: local candidates, NAT type and candidate pairs of a test negotiation"},
+	"forward": {runForward, "forward TCP ports to and from a peer, like ssh -L and -R"},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: transportc <command> [flags]\n\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].summary)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "transportc %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// newFlagSet creates the FlagSet of the command, with the -v flag returning the
+// logging level.
+func newFlagSet(name string) (*flag.FlagSet, func() uint8) {
+	fs := flag.NewFlagSet("transportc "+name, flag.ExitOnError)
+	verbose := fs.Bool("v", false, "verbose logging")
+	return fs, func() uint8 {
+		if *verbose {
+			return logging.LOG_DEBUG
+		}
+		return logging.LOG_INFO
+	}
+}
@@ -0,0 +1,102 @@
+package transportc
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// CompactSignal wraps a Signal to exchange offers and answers in the compact binary
+// form of MarshalCompactSDP instead of JSON, for signaling channels with a limited
+// capacity such as DNS or QR codes. Both peers MUST use a CompactSignal.
+type CompactSignal struct {
+	Signal
+}
+
+// compactTrickleSignal is a CompactSignal wrapping a TrickleSignal. The candidates
+// are passed through as is.
+type compactTrickleSignal struct {
+	*CompactSignal
+	trickle TrickleSignal
+}
+
+// NewCompactSignal wraps the Signal with a CompactSignal. If the Signal implements
+// TrickleSignal, so does the returned Signal.
+func NewCompactSignal(s Signal) Signal {
+	cs := &CompactSignal{Signal: s}
+	if ts, ok := s.(TrickleSignal); ok {
+		return &compactTrickleSignal{CompactSignal: cs, trickle: ts}
+	}
+	return cs
+}
+
+// Offer implements Signal.Offer.
+func (cs *CompactSignal) Offer(offer []byte) (uint64, error) {
+	compact, err := compactSDP(offer)
+	if err != nil {
+		return 0, err
+	}
+	return cs.Signal.Offer(compact)
+}
+
+// ReadOffer implements Signal.ReadOffer.
+func (cs *CompactSignal) ReadOffer() (uint64, []byte, error) {
+	offerID, compact, err := cs.Signal.ReadOffer()
+	if err != nil {
+		return 0, nil, err
+	}
+	offer, err := expandSDP(compact)
+	if err != nil {
+		return 0, nil, err
+	}
+	return offerID, offer, nil
+}
+
+// Answer implements Signal.Answer.
+func (cs *CompactSignal) Answer(offerID uint64, answer []byte) error {
+	compact, err := compactSDP(answer)
+	if err != nil {
+		return err
+	}
+	return cs.Signal.Answer(offerID, compact)
+}
+
+// ReadAnswer implements Signal.ReadAnswer.
+func (cs *CompactSignal) ReadAnswer(offerID uint64) ([]byte, error) {
+	compact, err := cs.Signal.ReadAnswer(offerID)
+	if err != nil {
+		return nil, err
+	}
+	return expandSDP(compact)
+}
+
+// Candidate implements TrickleSignal.Candidate.
+func (cts *compactTrickleSignal) Candidate(offerID uint64, fromOfferer bool, candidate []byte) error {
+	return cts.trickle.Candidate(offerID, fromOfferer, candidate)
+}
+
+// ReadCandidate implements TrickleSignal.ReadCandidate.
+func (cts *compactTrickleSignal) ReadCandidate(offerID uint64, fromOfferer bool) ([]byte, error) {
+	return cts.trickle.ReadCandidate(offerID, fromOfferer)
+}
+
+// compactSDP converts the JSON SDP to the compact binary form.
+func compactSDP(sdpJSON []byte) ([]byte, error) {
+	var desc webrtc.SessionDescription
+	if err := json.Unmarshal(sdpJSON, &desc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal SDP: %w", err)
+	}
+	return MarshalCompactSDP(desc)
+}
+
+// expandSDP converts the compact binary form back to the JSON SDP.
+func expandSDP(compact []byte) ([]byte, error) {
+	desc, err := UnmarshalCompactSDP(compact)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(desc)
+}
+
+var _ TrickleSignal = (*compactTrickleSignal)(nil)
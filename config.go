@@ -1,6 +1,8 @@
 package transportc
 
 import (
+	"errors"
+	"fmt"
 	"net"
 	"time"
 
@@ -14,12 +16,25 @@ const (
 	MAX_RECV_TIMEOUT_DEFAULT = time.Second * 10
 )
 
+var (
+	ErrDuplicateDataChannelID = errors.New("duplicate negotiated DataChannel ID")
+)
+
 // Config is the configuration for the Dialer and Listener.
 type Config struct {
 	// CandidateNetworkTypes restricts ICE agent to gather
 	// on only selected types of networks.
 	CandidateNetworkTypes []webrtc.NetworkType
 
+	// Certificate is the local DTLS certificate used for all PeerConnections,
+	// e.g. loaded with LoadCertificatePEM, so the remote peer may pin its fingerprint.
+	// If nil, a new certificate is generated for every PeerConnection.
+	Certificate *webrtc.Certificate
+
+	// Events defines optional callbacks fired over the lifecycle of
+	// PeerConnections and DataChannels.
+	Events *Events
+
 	// InterfaceFilter restricts ICE agent to gather ICE candidates
 	// on only selected interfaces.
 	InterfaceFilter func(interfaceName string) (allowed bool)
@@ -36,9 +51,31 @@ type Config struct {
 
 	Logger logging.Logger
 
+	// NegotiatedDataChannels are DataChannels created by both Dialer and Listener
+	// on every new PeerConnection with pre-negotiated IDs. No two of them may
+	// share the same ID, and PSK_HANDSHAKE_DATACHANNEL_ID is reserved.
+	//
+	// Dialer exposes them via Dialer.DialNegotiated and Listener delivers them
+	// through Listener.Accept once opened.
+	NegotiatedDataChannels []NegotiatedDataChannel
+
 	// PortRange is the range of ports to use for the DataChannel.
 	PortRange *PortRange
 
+	// PreSharedKey, if set, authenticates the peer on every new PeerConnection with a
+	// mutual challenge-response bound to the DTLS certificates of both peers, over a
+	// dedicated reliable DataChannel, completed before Dial returns or Accept delivers
+	// any Conn of the PeerConnection. Both Dialer and Listener MUST use the same key.
+	// The PeerConnection of a peer failing the handshake is closed with ErrHandshakeFailed.
+	PreSharedKey []byte
+
+	// RemoteFingerprints is an allowlist of the remote DTLS certificate fingerprints,
+	// e.g. "sha-256 AB:CD:..." as returned by CertificateFingerprint. If set, a
+	// PeerConnection with a remote certificate not listed is closed before any
+	// connection is returned by Dial or Accept, independent of the fingerprint
+	// exchanged in the SDP.
+	RemoteFingerprints []string
+
 	// ReusePeerConnection indicates whether to reuse the same PeerConnection
 	// if possible, when Dialer dials multiple times.
 	//
@@ -54,6 +91,11 @@ type Config struct {
 	// UDPMux allows serving multiple DataChannels over the one or more pre-established UDP socket.
 	UDPMux ice.UDPMux
 
+	// VerifyRemoteFingerprint, if set, is called with the fingerprint of the remote
+	// DTLS certificate once the DTLS handshake completes, after the RemoteFingerprints
+	// check if any. A PeerConnection is closed if it returns an error.
+	VerifyRemoteFingerprint func(fingerprint string) error
+
 	// WebRTCConfiguration is the configuration for the underlying WebRTC PeerConnection.
 	WebRTCConfiguration webrtc.Configuration
 }
@@ -65,6 +107,10 @@ func (c *Config) NewDialer() (*Dialer, error) {
 		return nil, err
 	}
 
+	if err := c.validateNegotiatedDataChannels(); err != nil {
+		return nil, err
+	}
+
 	if c.Logger == nil {
 		c.Logger = logging.DefaultStderrLogger(logging.LOG_WARN)
 	}
@@ -73,8 +119,12 @@ func (c *Config) NewDialer() (*Dialer, error) {
 		logger:              c.Logger,
 		signal:              c.Signal,
 		timeout:             c.Timeout,
+		events:              c.Events,
+		verifier:            newCertificateVerifier(c.RemoteFingerprints, c.VerifyRemoteFingerprint),
+		psk:                 c.PreSharedKey,
+		negotiated:          c.NegotiatedDataChannels,
 		settingEngine:       settingEngine,
-		configuration:       c.WebRTCConfiguration,
+		configuration:       c.buildConfiguration(),
 		reusePeerConnection: c.ReusePeerConnection,
 	}, nil
 }
@@ -86,6 +136,10 @@ func (c *Config) NewListener() (*Listener, error) {
 		return nil, err
 	}
 
+	if err := c.validateNegotiatedDataChannels(); err != nil {
+		return nil, err
+	}
+
 	if c.Logger == nil {
 		c.Logger = logging.DefaultStderrLogger(logging.LOG_ERROR)
 	}
@@ -96,10 +150,14 @@ func (c *Config) NewListener() (*Listener, error) {
 		logger:          c.Logger,
 		signal:          c.Signal,
 		timeout:         c.Timeout,
+		events:          c.Events,
+		verifier:        newCertificateVerifier(c.RemoteFingerprints, c.VerifyRemoteFingerprint),
+		psk:             c.PreSharedKey,
+		negotiated:      c.NegotiatedDataChannels,
 		runningStatus:   LISTENER_NEW,
 		settingEngine:   settingEngine,
-		configuration:   c.WebRTCConfiguration,
-		peerConnections: make(map[uint64]*webrtc.PeerConnection),
+		configuration:   c.buildConfiguration(),
+		peerConnections: make(map[uint64]*peerConnection),
 		conns:           make(chan net.Conn),
 		closed:          make(chan bool),
 	}
@@ -140,3 +198,26 @@ func (c *Config) BuildSettingEngine() (webrtc.SettingEngine, error) {
 
 	return settingEngine, nil
 }
+
+// buildConfiguration returns the WebRTCConfiguration with the Certificate if set.
+func (c *Config) buildConfiguration() webrtc.Configuration {
+	configuration := c.WebRTCConfiguration
+	if c.Certificate != nil {
+		configuration.Certificates = []webrtc.Certificate{*c.Certificate}
+	}
+	return configuration
+}
+
+func (c *Config) validateNegotiatedDataChannels() error {
+	ids := make(map[uint16]bool)
+	for _, n := range c.NegotiatedDataChannels {
+		if ids[n.ID] {
+			return fmt.Errorf("%w: %d", ErrDuplicateDataChannelID, n.ID)
+		}
+		if n.ID == PSK_HANDSHAKE_DATACHANNEL_ID {
+			return fmt.Errorf("%w: %d", ErrReservedDataChannelID, n.ID)
+		}
+		ids[n.ID] = true
+	}
+	return nil
+}
@@ -2,11 +2,15 @@ package transportc
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"os"
 	"sync/atomic"
 	"time"
+
+	"github.com/pion/webrtc/v3"
 )
 
 const (
@@ -138,6 +142,33 @@ func (c *Conn) RemoteAddr() net.Addr {
 	return c.remoteAddr
 }
 
+// setAddrs sets LocalAddr and RemoteAddr from the ICE Candidate pair
+// selected for the PeerConnection.
+func (c *Conn) setAddrs(pc *webrtc.PeerConnection) error {
+	if sctp := pc.SCTP(); sctp != nil {
+		if dtls := sctp.Transport(); dtls != nil {
+			if ice := dtls.ICETransport(); ice != nil {
+				icePair, err := ice.GetSelectedCandidatePair()
+				if err != nil {
+					return fmt.Errorf("failed to get selected ICE Candidate pair: %w", err)
+				}
+				if icePair == nil {
+					return errors.New("no ICE Candidate pair selected")
+				}
+				c.localAddr = &Addr{
+					Hostname: icePair.Local.Address,
+					Port:     icePair.Local.Port,
+				}
+				c.remoteAddr = &Addr{
+					Hostname: icePair.Remote.Address,
+					Port:     icePair.Remote.Port,
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // SetDeadline sets the deadline for future Read and Write calls.
 func (c *Conn) SetDeadline(t time.Time) error {
 	c.deadlineRd = t
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gaukas/logging"
@@ -21,6 +22,13 @@ type Dialer struct {
 	logger  logging.Logger
 	signal  Signal
 	timeout time.Duration
+	events  *Events
+
+	// verifier verifies the remote DTLS certificate, nil if not configured
+	verifier *certificateVerifier
+
+	// psk authenticates the peer on every new PeerConnection, see startPSKHandshake
+	psk []byte
 
 	// WebRTC configuration
 	settingEngine webrtc.SettingEngine
@@ -28,14 +36,45 @@ type Dialer struct {
 
 	// WebRTC PeerConnection
 	mutex               sync.Mutex // mutex makes peerConnection thread-safe
-	peerConnection      *webrtc.PeerConnection
+	peerConnection      *peerConnection
 	reusePeerConnection bool
+
+	// Pre-negotiated DataChannels
+	negotiated             []NegotiatedDataChannel
+	negotiatedDataChannels map[uint16]*negotiatedDataChannel // on current peerConnection
 }
 
 var (
 	ErrBrokenDialer = errors.New("dialer need to be recreated")
+
+	// ErrUnknownDataChannelID is returned by DialNegotiated when the ID is not
+	// configured in Config.NegotiatedDataChannels.
+	ErrUnknownDataChannelID = errors.New("unknown negotiated DataChannel ID")
+
+	// ErrDataChannelInUse is returned by DialNegotiated when the pre-negotiated
+	// DataChannel has already been dialed on the current PeerConnection.
+	ErrDataChannelInUse = errors.New("negotiated DataChannel already in use")
 )
 
+// negotiatedDataChannel tracks a pre-negotiated DataChannel on the current PeerConnection.
+type negotiatedDataChannel struct {
+	detached chan datachannel.ReadWriteCloser // receives the detached DataChannel once opened
+	dialed   bool
+	closed   atomic.Bool // set once the dialed DataChannel is closed, it can't be opened again
+}
+
+// negotiatedReadWriteCloser marks the pre-negotiated DataChannel closed once its
+// Conn closes it, on Close or on a read error.
+type negotiatedReadWriteCloser struct {
+	datachannel.ReadWriteCloser
+	negotiated *negotiatedDataChannel
+}
+
+func (n *negotiatedReadWriteCloser) Close() error {
+	n.negotiated.closed.Store(true)
+	return n.ReadWriteCloser.Close()
+}
+
 // Dial connects to a remote peer with SDP-based negotiation.
 //
 // Internally calls DialContext with context.Background().
@@ -79,8 +118,10 @@ func (d *Dialer) DialContext(ctx context.Context, label string) (net.Conn, error
 	conn := NewConn(nil, CONN_DEFAULT_CONCURRENCY)
 
 	// set event handlers
+	pc := d.peerConnection
 	var detachChan chan datachannel.ReadWriteCloser = make(chan datachannel.ReadWriteCloser)
 	dataChannel.OnOpen(func() {
+		d.events.dataChannelOpen(pc.PeerConnection, dataChannel)
 		// detach from wrapper
 		dc, err := dataChannel.Detach()
 		if err != nil {
@@ -94,6 +135,7 @@ func (d *Dialer) DialContext(ctx context.Context, label string) (net.Conn, error
 	dataChannel.OnClose(func() {
 		// TODO: possibly tear down the PeerConnection if it is the last DataChannel?
 		conn.Close()
+		d.events.dataChannelClose(pc.PeerConnection, dataChannel)
 	})
 
 	// OnError won't be used as pion's readLoop is ignored
@@ -104,30 +146,101 @@ func (d *Dialer) DialContext(ctx context.Context, label string) (net.Conn, error
 	select {
 	case <-ctx.Done():
 		return nil, ctx.Err()
+	case <-pc.closed:
+		return nil, fmt.Errorf("dialer: PeerConnection closed: %w", pc.reason)
 	case dataChannelDetach := <-detachChan:
 		if dataChannelDetach == nil {
 			return nil, errors.New("failed to receive datachannel")
 		}
 		conn.dataChannel = dataChannelDetach
 
+		if err := pc.verifyRemoteCertificate(); err != nil {
+			pc.closeWithReason(err)
+			return nil, fmt.Errorf("dialer: %w", err)
+		}
+
 		// Set LocalAddr and RemoteAddr
-		if sctp := d.peerConnection.SCTP(); sctp != nil {
-			if dtls := sctp.Transport(); dtls != nil {
-				if ice := dtls.ICETransport(); ice != nil {
-					icePair, err := ice.GetSelectedCandidatePair()
-					if err != nil {
-						return nil, fmt.Errorf("dialer: failed to get selected ICE Candidate pair: %w", err)
-					}
-					conn.localAddr = &Addr{
-						Hostname: icePair.Local.Address,
-						Port:     icePair.Local.Port,
-					}
-					conn.remoteAddr = &Addr{
-						Hostname: icePair.Remote.Address,
-						Port:     icePair.Remote.Port,
-					}
-				}
-			}
+		if err := conn.setAddrs(pc.PeerConnection); err != nil {
+			return nil, fmt.Errorf("dialer: %w", err)
+		}
+		if err := pc.waitAuthenticated(ctx); err != nil {
+			conn.Close() // skipcq: GSC-G104
+			return nil, fmt.Errorf("dialer: %w", err)
+		}
+		go conn.idleloop(d.timeout) // start the read loop
+
+		return conn, nil
+	}
+}
+
+// DialNegotiated returns a connection backed by the pre-negotiated DataChannel
+// with the given ID, as configured in Config.NegotiatedDataChannels.
+//
+// Internally calls DialNegotiatedContext with context.Background().
+func (d *Dialer) DialNegotiated(id uint16) (net.Conn, error) {
+	return d.DialNegotiatedContext(context.Background(), id)
+}
+
+// DialNegotiatedContext returns a connection backed by the pre-negotiated DataChannel
+// with the given ID using the provided context.
+//
+// Pre-negotiated DataChannels are created on every new PeerConnection and open as soon
+// as SCTP is up, without any in-band open message. Each of them can be dialed only once
+// per PeerConnection: once it is closed, a new PeerConnection is negotiated to dial it
+// again, leaving the Conns on the current one open. If ReusePeerConnection is not set,
+// a new PeerConnection is negotiated for each call.
+func (d *Dialer) DialNegotiatedContext(ctx context.Context, id uint16) (net.Conn, error) {
+	// check if context is done
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if !d.hasNegotiatedDataChannel(id) {
+		return nil, fmt.Errorf("dialer: %w: %d", ErrUnknownDataChannelID, id)
+	}
+
+	if d.peerConnection == nil || !d.reusePeerConnection || d.negotiatedDataChannels[id].closed.Load() {
+		if err := d.createPeerConnection(); err != nil {
+			return nil, err
+		}
+		if err := d.exchangeOffer(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	pc := d.peerConnection
+	negotiated := d.negotiatedDataChannels[id]
+	if negotiated.dialed {
+		return nil, fmt.Errorf("dialer: %w: %d", ErrDataChannelInUse, id)
+	}
+
+	// wait for datachannel
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-pc.closed:
+		return nil, fmt.Errorf("dialer: PeerConnection closed: %w", pc.reason)
+	case dataChannelDetach := <-negotiated.detached:
+		if dataChannelDetach == nil {
+			return nil, errors.New("failed to receive datachannel")
+		}
+		negotiated.dialed = true
+
+		if err := pc.verifyRemoteCertificate(); err != nil {
+			pc.closeWithReason(err)
+			return nil, fmt.Errorf("dialer: %w", err)
+		}
+
+		conn := NewConn(&negotiatedReadWriteCloser{ReadWriteCloser: dataChannelDetach, negotiated: negotiated}, CONN_DEFAULT_CONCURRENCY)
+		if err := conn.setAddrs(pc.PeerConnection); err != nil {
+			return nil, fmt.Errorf("dialer: %w", err)
+		}
+		if err := pc.waitAuthenticated(ctx); err != nil {
+			conn.Close() // skipcq: GSC-G104
+			return nil, fmt.Errorf("dialer: %w", err)
 		}
 		go conn.idleloop(d.timeout) // start the read loop
 
@@ -143,7 +256,7 @@ func (d *Dialer) Close() error {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 	if d.peerConnection != nil {
-		return d.peerConnection.Close()
+		return d.peerConnection.closeWithReason(ErrDialerClosed)
 	}
 	return nil
 }
@@ -162,7 +275,7 @@ func (d *Dialer) nextDataChannel(ctx context.Context, label string) (*webrtc.Dat
 	if err != nil {
 		// error: retry after getting a new peer connection.
 		// if errors.Is(err, webrtc.ErrConnectionClosed) {
-		d.peerConnection.Close()
+		d.peerConnection.closeWithReason(err)
 		d.peerConnection = nil
 		dataChannel, err = d.startPeerConnection(ctx, label)
 		if err != nil {
@@ -184,21 +297,43 @@ func (d *Dialer) nextDataChannel(ctx context.Context, label string) (*webrtc.Dat
 //
 // Not thread-safe. Caller MUST hold the mutex before calling this function.
 func (d *Dialer) startPeerConnection(ctx context.Context, dataChannelLabel string) (*webrtc.DataChannel, error) {
-	api := webrtc.NewAPI(webrtc.WithSettingEngine(d.settingEngine))
+	err := d.createPeerConnection()
+	if err != nil {
+		return nil, err
+	}
 
-	peerConnection, err := api.NewPeerConnection(d.configuration)
+	dataChannel, err := d.peerConnection.CreateDataChannel(dataChannelLabel, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.exchangeOffer(ctx)
 	if err != nil {
 		return nil, err
-	} else if peerConnection == nil {
-		return nil, errors.New("dialer: created nil PeerConnection")
+	}
+
+	return dataChannel, nil
+}
+
+// createPeerConnection creates a new PeerConnection with all pre-negotiated
+// DataChannels and sets it as the current PeerConnection.
+//
+// Not thread-safe. Caller MUST hold the mutex before calling this function.
+func (d *Dialer) createPeerConnection() error {
+	api := webrtc.NewAPI(webrtc.WithSettingEngine(d.settingEngine))
+
+	peerConnection, err := newPeerConnection(api, d.configuration, d.events, d.verifier)
+	if err != nil {
+		return fmt.Errorf("dialer: %w", err)
 	}
 
 	peerConnection.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
 		// TODO: handle this better
 		if s > webrtc.PeerConnectionStateConnected {
 			d.logger.Warnf("dialer: PeerConnection disconnected.")
+			// Closed before taking the mutex, held by the Dial calls waiting on it
+			peerConnection.closeWithReason(stateCloseReason(s)) // skipcq: GSC-G104
 			d.mutex.Lock()
-			peerConnection.Close()
 			if d.peerConnection == peerConnection {
 				d.peerConnection = nil
 			}
@@ -206,27 +341,72 @@ func (d *Dialer) startPeerConnection(ctx context.Context, dataChannelLabel strin
 		}
 	})
 
-	d.peerConnection = peerConnection
+	if err := peerConnection.startPSKHandshake(d.psk, true, d.logger); err != nil {
+		peerConnection.closeWithReason(err)
+		return fmt.Errorf("dialer: %w", err)
+	}
 
-	dataChannel, err := d.peerConnection.CreateDataChannel(dataChannelLabel, nil)
-	if err != nil {
-		return nil, err
+	negotiatedDataChannels := make(map[uint16]*negotiatedDataChannel)
+	for i := range d.negotiated {
+		dataChannel, err := peerConnection.CreateDataChannel(d.negotiated[i].Label, d.negotiated[i].dataChannelInit())
+		if err != nil {
+			peerConnection.closeWithReason(err)
+			return fmt.Errorf("dialer: failed to create negotiated DataChannel %d: %w", d.negotiated[i].ID, err)
+		}
+
+		negotiated := &negotiatedDataChannel{
+			detached: make(chan datachannel.ReadWriteCloser, 1),
+		}
+		dataChannel.OnOpen(func() {
+			d.events.dataChannelOpen(peerConnection.PeerConnection, dataChannel)
+			// detach from wrapper
+			dc, err := dataChannel.Detach()
+			if err != nil {
+				close(negotiated.detached)
+			} else {
+				negotiated.detached <- dc
+			}
+		})
+		dataChannel.OnClose(func() {
+			d.events.dataChannelClose(peerConnection.PeerConnection, dataChannel)
+		})
+		negotiatedDataChannels[d.negotiated[i].ID] = negotiated
 	}
 
+	d.peerConnection = peerConnection
+	d.negotiatedDataChannels = negotiatedDataChannels
+
+	return nil
+}
+
+// exchangeOffer does the Offer/Answer exchange for the current PeerConnection
+// if Dialer.signal is set.
+//
+// Not thread-safe. Caller MUST hold the mutex before calling this function.
+func (d *Dialer) exchangeOffer(ctx context.Context) error {
 	// Automatic Signalling when possible
 	if d.signal != nil {
 		offerID, err := d.SendOffer(ctx)
 		if err != nil {
-			return nil, fmt.Errorf("dialer: failed to send offer: %w", err)
+			return fmt.Errorf("dialer: failed to send offer: %w", err)
 		}
 
 		err = d.SetAnswer(ctx, offerID)
 		if err != nil {
-			return nil, fmt.Errorf("dialer: failed to set answer: %w", err)
+			return fmt.Errorf("dialer: failed to set answer: %w", err)
 		}
 	}
 
-	return dataChannel, nil
+	return nil
+}
+
+func (d *Dialer) hasNegotiatedDataChannel(id uint16) bool {
+	for i := range d.negotiated {
+		if d.negotiated[i].ID == id {
+			return true
+		}
+	}
+	return false
 }
 
 // SendOffer creates a local offer and sets it as the local description,
@@ -240,7 +420,15 @@ func (d *Dialer) SendOffer(ctx context.Context) (uint64, error) {
 	}
 
 	// Create channel that is blocked until ICE Gathering is complete
-	gatherComplete := webrtc.GatheringCompletePromise(d.peerConnection)
+	gatherComplete := webrtc.GatheringCompletePromise(d.peerConnection.PeerConnection)
+
+	// With a TrickleSignal, candidates are sent as gathered once the offer is submitted
+	ts, trickle := d.signal.(TrickleSignal)
+	candidates := &candidateBuffer{}
+	if trickle {
+		d.peerConnection.OnICECandidate(candidates.add)
+		gatherComplete = closedChan()
+	}
 
 	// Sets the LocalDescription, and starts our UDP listeners
 	err = d.peerConnection.SetLocalDescription(localDescription)
@@ -250,8 +438,7 @@ func (d *Dialer) SendOffer(ctx context.Context) (uint64, error) {
 
 	// Block until ICE Gathering is complete, disabling trickle ICE
 	// we do this because we only can exchange one signaling message
-	// in a production application you should exchange ICE Candidates via OnICECandidate
-	// TODO: use OnICECandidate callback instead
+	// unless the Signal is a TrickleSignal.
 	select {
 	case <-ctx.Done():
 		return 0, fmt.Errorf("dialer: context done before ICE gathering complete: %w", ctx.Err())
@@ -266,6 +453,13 @@ func (d *Dialer) SendOffer(ctx context.Context) (uint64, error) {
 		if err != nil {
 			return 0, fmt.Errorf("dialer: failed to signal local offer: %w", err)
 		}
+		d.events.offerSent(d.peerConnection.PeerConnection, offerID)
+
+		if trickle {
+			candidates.flush(func(candidate []byte) error {
+				return ts.Candidate(offerID, true, candidate)
+			})
+		}
 
 		return offerID, nil
 	}
@@ -306,10 +500,16 @@ func (d *Dialer) SetAnswer(ctx context.Context, offerID uint64) error {
 			return remoteErr
 		}
 	}
+	d.events.answerReceived(d.peerConnection.PeerConnection, offerID)
+
 	err := d.peerConnection.SetRemoteDescription(answerUnmarshal)
 	if err != nil {
 		return fmt.Errorf("dialer: failed to set remote description: %w", err)
 	}
 
+	if ts, ok := d.signal.(TrickleSignal); ok {
+		go trickleCandidates(ts, d.peerConnection.PeerConnection, offerID, false)
+	}
+
 	return nil
 }
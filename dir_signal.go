@@ -0,0 +1,281 @@
+package transportc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gaukas/transportc/internal/utils"
+)
+
+const (
+	DIR_SIGNAL_POLL_DEFAULT  = time.Second
+	DIR_SIGNAL_POLL_INTERVAL = 50 * time.Millisecond
+)
+
+const (
+	dirSignalOfferPrefix   = "offer-"   // offer not read yet
+	dirSignalClaimedPrefix = "claimed-" // offer read but not answered
+	dirSignalAnswerPrefix  = "answer-"  // answer not read yet
+	dirSignalTempPrefix    = ".tmp-"    // file being written or consumed
+)
+
+// DirSignal implements Signal by exchanging offers and answers as files in a
+// directory shared by the Dialer and the Listener, e.g. a volume mounted by two
+// containers on the same host.
+//
+// Each file is written to a temporary file first and then moved in place, so a
+// reader never sees a partial offer or answer. Offers and answers are claimed by
+// atomically renaming their files, so multiple processes may read from the same
+// directory. The directory must be on a file system supporting hard links.
+//
+// Offers and answers not consumed within the TTL expire.
+type DirSignal struct {
+	// PollTimeout is the max duration ReadOffer and ReadAnswer poll the directory
+	// before returning ErrOfferNotReady or ErrAnswerNotReady.
+	PollTimeout time.Duration
+
+	// TTL is the duration after which the offers and answers not consumed are
+	// deleted, since they were last written or read.
+	TTL time.Duration
+
+	dir string
+}
+
+// NewDirSignal creates a new DirSignal exchanging files in dir, which is created
+// if it does not exist.
+func NewDirSignal(dir string) (*DirSignal, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create signal directory: %w", err)
+	}
+	return &DirSignal{
+		PollTimeout: DIR_SIGNAL_POLL_DEFAULT,
+		TTL:         SIGNAL_OFFER_TTL_DEFAULT,
+		dir:         dir,
+	}, nil
+}
+
+// Offer implements Signal.Offer.
+// It writes the offer to a new file named after a random offer ID.
+func (ds *DirSignal) Offer(offer []byte) (uint64, error) {
+	ds.expire()
+
+	tmp, err := ds.writeTemp(offer)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp) // skipcq: GSC-G104
+
+	for {
+		id := utils.RandUint64()
+		if ds.knownID(id) {
+			continue
+		}
+		// Unlike rename, link fails if the offer ID is taken in the meantime.
+		err := os.Link(tmp, ds.path(dirSignalOfferPrefix, id))
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to write offer: %w", err)
+		}
+		return id, nil
+	}
+}
+
+// ReadOffer implements Signal.ReadOffer.
+// It polls the directory for the oldest offer not read yet and claims it.
+func (ds *DirSignal) ReadOffer() (uint64, []byte, error) {
+	deadline := time.Now().Add(ds.PollTimeout)
+	for {
+		ds.expire()
+		for _, id := range ds.list(dirSignalOfferPrefix) {
+			claimed := ds.path(dirSignalClaimedPrefix, id)
+			if err := os.Rename(ds.path(dirSignalOfferPrefix, id), claimed); err != nil {
+				continue // claimed by another reader
+			}
+
+			now := time.Now()
+			os.Chtimes(claimed, now, now) // skipcq: GSC-G104
+			offer, err := os.ReadFile(claimed)
+			if err != nil {
+				return 0, nil, fmt.Errorf("failed to read offer: %w", err)
+			}
+			return id, offer, nil
+		}
+
+		if !ds.wait(deadline) {
+			return 0, nil, ErrOfferNotReady
+		}
+	}
+}
+
+// Answer implements Signal.Answer.
+// It writes the answer to the file of an offer read.
+func (ds *DirSignal) Answer(offerID uint64, answer []byte) error {
+	ds.expire()
+
+	claimed := ds.path(dirSignalClaimedPrefix, offerID)
+	if _, err := os.Stat(claimed); err != nil {
+		return ErrInvalidOfferID
+	}
+
+	tmp, err := ds.writeTemp(answer)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp) // skipcq: GSC-G104
+
+	err = os.Link(tmp, ds.path(dirSignalAnswerPrefix, offerID))
+	if os.IsExist(err) {
+		return ErrInvalidOfferID // answered already
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write answer: %w", err)
+	}
+	os.Remove(claimed) // skipcq: GSC-G104
+	return nil
+}
+
+// ReadAnswer implements Signal.ReadAnswer.
+// It polls the directory for the answer to the offer and consumes it.
+func (ds *DirSignal) ReadAnswer(offerID uint64) ([]byte, error) {
+	deadline := time.Now().Add(ds.PollTimeout)
+	for {
+		ds.expire()
+
+		tmp := ds.path(dirSignalTempPrefix, utils.RandUint64())
+		if err := os.Rename(ds.path(dirSignalAnswerPrefix, offerID), tmp); err == nil {
+			answer, err := os.ReadFile(tmp)
+			os.Remove(tmp) // skipcq: GSC-G104
+			if err != nil {
+				return nil, fmt.Errorf("failed to read answer: %w", err)
+			}
+			return answer, nil
+		}
+		if !ds.knownID(offerID) {
+			return nil, ErrInvalidOfferID
+		}
+
+		if !ds.wait(deadline) {
+			return nil, ErrAnswerNotReady
+		}
+	}
+}
+
+func (ds *DirSignal) path(prefix string, id uint64) string {
+	return filepath.Join(ds.dir, fmt.Sprintf("%s%016x", prefix, id))
+}
+
+// writeTemp writes the data to a new temporary file in the directory and returns
+// its path.
+func (ds *DirSignal) writeTemp(data []byte) (string, error) {
+	f, err := os.CreateTemp(ds.dir, dirSignalTempPrefix+"*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create file: %w", err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name()) // skipcq: GSC-G104
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// list returns the IDs of the files with the prefix, oldest first.
+func (ds *DirSignal) list(prefix string) []uint64 {
+	entries, err := os.ReadDir(ds.dir)
+	if err != nil {
+		return nil
+	}
+
+	type file struct {
+		id      uint64
+		modTime time.Time
+	}
+	var files []file
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimPrefix(entry.Name(), prefix), 16, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // consumed in the meantime
+		}
+		files = append(files, file{id: id, modTime: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	ids := make([]uint64, len(files))
+	for i := range files {
+		ids[i] = files[i].id
+	}
+	return ids
+}
+
+// knownID reports whether the offerID is pending, claimed or answered.
+func (ds *DirSignal) knownID(offerID uint64) bool {
+	for _, prefix := range []string{dirSignalOfferPrefix, dirSignalClaimedPrefix, dirSignalAnswerPrefix} {
+		if _, err := os.Stat(ds.path(prefix, offerID)); !errors.Is(err, os.ErrNotExist) {
+			return true
+		}
+	}
+	return false
+}
+
+// expire removes all the files older than the TTL.
+func (ds *DirSignal) expire() {
+	ttl := ds.TTL
+	if ttl <= 0 {
+		ttl = SIGNAL_OFFER_TTL_DEFAULT
+	}
+
+	entries, err := os.ReadDir(ds.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, dirSignalOfferPrefix) &&
+			!strings.HasPrefix(name, dirSignalClaimedPrefix) &&
+			!strings.HasPrefix(name, dirSignalAnswerPrefix) &&
+			!strings.HasPrefix(name, dirSignalTempPrefix) {
+			continue // not ours
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > ttl {
+			os.Remove(filepath.Join(ds.dir, name)) // skipcq: GSC-G104
+		}
+	}
+}
+
+// wait sleeps for DIR_SIGNAL_POLL_INTERVAL unless the deadline is reached first,
+// in which case it returns false.
+func (*DirSignal) wait(deadline time.Time) bool {
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return false
+	}
+	if remaining > DIR_SIGNAL_POLL_INTERVAL {
+		remaining = DIR_SIGNAL_POLL_INTERVAL
+	}
+	time.Sleep(remaining)
+	return true
+}
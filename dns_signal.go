@@ -0,0 +1,793 @@
+package transportc
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gaukas/transportc/internal/utils"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	DNS_SIGNAL_QUERY_TIMEOUT    = 2 * time.Second
+	DNS_SIGNAL_QUERY_ATTEMPTS   = 3
+	DNS_SIGNAL_POLL_DEFAULT     = time.Second
+	DNS_SIGNAL_POLL_INTERVAL    = 200 * time.Millisecond
+	DNS_SIGNAL_UDP_SIZE         = 1232 // EDNS0 UDP payload size advertised by the client and honored by the server
+	DNS_SIGNAL_MAX_MESSAGE_SIZE = 64 * 1024
+)
+
+var (
+	ErrInvalidDNSResponse = errors.New("invalid DNS response")
+)
+
+// Operations carried in the queries, as the first byte of the payload.
+const (
+	dnsSignalOpOffer      byte = iota + 1 // uploadID | total | offset | chunk
+	dnsSignalOpAnswer                     // uploadID | offerID | total | offset | chunk
+	dnsSignalOpReadOffer                  // transferID
+	dnsSignalOpReadAnswer                 // transferID | offerID
+	dnsSignalOpFetch                      // transferID | offset
+)
+
+// Statuses carried in the responses, as the first byte of the payload.
+const (
+	dnsSignalStatusOK byte = iota
+	dnsSignalStatusNotReady
+	dnsSignalStatusInvalidOfferID
+	dnsSignalStatusQueueFull
+	dnsSignalStatusError // followed by the error message
+)
+
+// dnsSignalName is the base32 encoding used for the payload in the query names,
+// decoded case-insensitively as resolvers may randomize the case of the names.
+var dnsSignalName = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// DNSSignal implements Signal over DNS, as a client of a DNSSignalServer
+// authoritative for a domain and reached through any recursive resolver.
+//
+// Offers and answers are uploaded in chunks encoded in the names of TXT queries and
+// downloaded in chunks carried by the TXT records of the responses. As every
+// query costs a round trip through the resolver, offers and answers should be
+// kept small by wrapping the DNSSignal with NewCompactSignal.
+type DNSSignal struct {
+	// Domain is the domain the DNSSignalServer is authoritative for, e.g. t.example.com
+	Domain string
+
+	// PollTimeout is the max duration ReadOffer and ReadAnswer poll the server before
+	// returning ErrOfferNotReady or ErrAnswerNotReady.
+	PollTimeout time.Duration
+
+	// Resolver is the address of the DNS resolver to send the queries to over UDP,
+	// e.g. 8.8.8.8:53, or the DNSSignalServer itself.
+	Resolver string
+
+	// Timeout is the max duration to wait for the response to a query. Each query
+	// is made up to DNS_SIGNAL_QUERY_ATTEMPTS times.
+	Timeout time.Duration
+}
+
+// NewDNSSignal creates a new DNSSignal querying the resolver for names under the
+// domain of a DNSSignalServer.
+func NewDNSSignal(domain, resolver string) *DNSSignal {
+	return &DNSSignal{
+		Domain:      domain,
+		PollTimeout: DNS_SIGNAL_POLL_DEFAULT,
+		Resolver:    resolver,
+		Timeout:     DNS_SIGNAL_QUERY_TIMEOUT,
+	}
+}
+
+// Offer implements Signal.Offer.
+// It uploads the offer and returns the offer ID assigned by the server.
+func (ds *DNSSignal) Offer(offer []byte) (uint64, error) {
+	resp, err := ds.upload(dnsSignalOpOffer, nil, offer)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp) != 8 {
+		return 0, fmt.Errorf("%w: bad offer ID", ErrInvalidDNSResponse)
+	}
+	return binary.BigEndian.Uint64(resp), nil
+}
+
+// ReadOffer implements Signal.ReadOffer.
+// It polls the server for the next offer and downloads it.
+func (ds *DNSSignal) ReadOffer() (uint64, []byte, error) {
+	deadline := time.Now().Add(ds.PollTimeout)
+	for {
+		transferID := utils.RandUint64()
+		status, resp, err := ds.query(dnsSignalOpReadOffer, binary.BigEndian.AppendUint64(nil, transferID))
+		if err != nil {
+			return 0, nil, err
+		}
+		if status == dnsSignalStatusNotReady {
+			if !dnsSignalWait(deadline) {
+				return 0, nil, ErrOfferNotReady
+			}
+			continue
+		}
+		if err := dnsSignalError(status, resp); err != nil {
+			return 0, nil, err
+		}
+
+		data, err := ds.download(transferID, resp)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(data) < 8 {
+			return 0, nil, fmt.Errorf("%w: bad offer", ErrInvalidDNSResponse)
+		}
+		return binary.BigEndian.Uint64(data[:8]), data[8:], nil
+	}
+}
+
+// Answer implements Signal.Answer.
+// It uploads the answer to an offer read.
+func (ds *DNSSignal) Answer(offerID uint64, answer []byte) error {
+	_, err := ds.upload(dnsSignalOpAnswer, binary.BigEndian.AppendUint64(nil, offerID), answer)
+	return err
+}
+
+// ReadAnswer implements Signal.ReadAnswer.
+// It polls the server for the answer to the offer and downloads it.
+func (ds *DNSSignal) ReadAnswer(offerID uint64) ([]byte, error) {
+	deadline := time.Now().Add(ds.PollTimeout)
+	for {
+		transferID := utils.RandUint64()
+		payload := binary.BigEndian.AppendUint64(nil, transferID)
+		payload = binary.BigEndian.AppendUint64(payload, offerID)
+		status, resp, err := ds.query(dnsSignalOpReadAnswer, payload)
+		if err != nil {
+			return nil, err
+		}
+		if status == dnsSignalStatusNotReady {
+			if !dnsSignalWait(deadline) {
+				return nil, ErrAnswerNotReady
+			}
+			continue
+		}
+		if err := dnsSignalError(status, resp); err != nil {
+			return nil, err
+		}
+		return ds.download(transferID, resp)
+	}
+}
+
+// upload sends the data in as many queries as needed, each made of the op, a
+// random upload ID, the header, the total size, the offset and a chunk of the data.
+// It returns the response to the last chunk.
+func (ds *DNSSignal) upload(op byte, header []byte, data []byte) ([]byte, error) {
+	if len(data) > DNS_SIGNAL_MAX_MESSAGE_SIZE {
+		return nil, fmt.Errorf("message of %d bytes exceeds the max size %d", len(data), DNS_SIGNAL_MAX_MESSAGE_SIZE)
+	}
+
+	uploadID := utils.RandUint64()
+	chunkSize := dnsSignalQueryCapacity(ds.domain()) - 1 - 8 - len(header) - 8
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("domain %s too long", ds.Domain)
+	}
+
+	for offset := 0; ; {
+		n := len(data) - offset
+		if n > chunkSize {
+			n = chunkSize
+		}
+
+		payload := binary.BigEndian.AppendUint64(nil, uploadID)
+		payload = append(payload, header...)
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(data)))
+		payload = binary.BigEndian.AppendUint32(payload, uint32(offset))
+		payload = append(payload, data[offset:offset+n]...)
+
+		status, resp, err := ds.query(op, payload)
+		if err != nil {
+			return nil, err
+		}
+		if err := dnsSignalError(status, resp); err != nil {
+			return nil, err
+		}
+
+		offset += n
+		if offset >= len(data) {
+			return resp, nil
+		}
+	}
+}
+
+// download reads the data of the transfer, starting with the first chunk already
+// received, made of the total size and the data.
+func (ds *DNSSignal) download(transferID uint64, first []byte) ([]byte, error) {
+	total, chunk, err := parseDNSSignalChunk(first)
+	if err != nil {
+		return nil, err
+	}
+	data := append(make([]byte, 0, total), chunk...)
+
+	for len(data) < total {
+		payload := binary.BigEndian.AppendUint64(nil, transferID)
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(data)))
+		status, resp, err := ds.query(dnsSignalOpFetch, payload)
+		if err != nil {
+			return nil, err
+		}
+		if err := dnsSignalError(status, resp); err != nil {
+			return nil, err
+		}
+
+		_, chunk, err := parseDNSSignalChunk(resp)
+		if err != nil {
+			return nil, err
+		}
+		if len(chunk) == 0 || len(data)+len(chunk) > total {
+			return nil, fmt.Errorf("%w: bad chunk", ErrInvalidDNSResponse)
+		}
+		data = append(data, chunk...)
+	}
+	return data, nil
+}
+
+// query sends the op and payload in a TXT query and returns the status and the
+// payload of the response, retrying on timeout.
+func (ds *DNSSignal) query(op byte, payload []byte) (byte, []byte, error) {
+	name, err := dnsmessage.NewName(encodeDNSSignalName(append([]byte{op}, payload...), ds.domain()))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := ds.exchange(name)
+		if err == nil {
+			if len(resp) == 0 {
+				return 0, nil, fmt.Errorf("%w: empty payload", ErrInvalidDNSResponse)
+			}
+			return resp[0], resp[1:], nil
+		}
+
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() || attempt >= DNS_SIGNAL_QUERY_ATTEMPTS {
+			return 0, nil, err
+		}
+	}
+}
+
+// exchange sends a TXT query for the name from a new UDP socket and returns the
+// payload decoded from the TXT record of the response.
+func (ds *DNSSignal) exchange(name dnsmessage.Name) ([]byte, error) {
+	id := uint16(utils.RandUint64())
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	builder.EnableCompression()
+	builder.StartQuestions()                                                                                 // skipcq: GSC-G104
+	builder.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET}) // skipcq: GSC-G104
+	builder.StartAdditionals()                                                                               // skipcq: GSC-G104
+	var opt dnsmessage.ResourceHeader
+	opt.SetEDNS0(DNS_SIGNAL_UDP_SIZE, dnsmessage.RCodeSuccess, false) // skipcq: GSC-G104
+	builder.OPTResource(opt, dnsmessage.OPTResource{})                // skipcq: GSC-G104
+	query, err := builder.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial("udp", ds.Resolver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	timeout := ds.Timeout
+	if timeout <= 0 {
+		timeout = DNS_SIGNAL_QUERY_TIMEOUT
+	}
+	conn.SetDeadline(time.Now().Add(timeout)) // skipcq: GSC-G104
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		var parser dnsmessage.Parser
+		header, err := parser.Start(buf[:n])
+		if err != nil || header.ID != id || !header.Response {
+			continue // not the response to the query
+		}
+		question, err := parser.Question()
+		if err != nil || !strings.EqualFold(question.Name.String(), name.String()) {
+			continue
+		}
+		if header.RCode != dnsmessage.RCodeSuccess {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDNSResponse, header.RCode)
+		}
+		if header.Truncated {
+			return nil, fmt.Errorf("%w: truncated", ErrInvalidDNSResponse)
+		}
+		if err := parser.SkipAllQuestions(); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDNSResponse, err)
+		}
+		return parseDNSSignalAnswer(&parser)
+	}
+}
+
+func (ds *DNSSignal) domain() string {
+	return strings.ToLower(strings.Trim(ds.Domain, "."))
+}
+
+// DNSSignalServer is the authoritative DNS server for a domain serving as the
+// rendezvous for DNSSignal clients.
+//
+// Offers and answers not read within the TTL expire, as well as the uploads and
+// downloads left unfinished.
+type DNSSignalServer struct {
+	domain string
+	store  *memorySignalStore
+
+	mutex     sync.Mutex
+	uploads   map[uint64]*dnsSignalUpload
+	downloads map[uint64]*dnsSignalDownload
+}
+
+type dnsSignalUpload struct {
+	chunks   map[uint32][]byte // offset:chunk
+	total    uint32
+	received uint32
+	expires  time.Time
+
+	done   bool // set once all chunks are received
+	status byte
+	result []byte
+}
+
+type dnsSignalDownload struct {
+	data    []byte
+	expires time.Time
+}
+
+// NewDNSSignalServer creates a new DNSSignalServer answering the queries for names
+// under the domain, e.g. t.example.com. If ttl is 0, SIGNAL_OFFER_TTL_DEFAULT is used.
+func NewDNSSignalServer(domain string, ttl time.Duration) *DNSSignalServer {
+	return &DNSSignalServer{
+		domain:    strings.ToLower(strings.Trim(domain, ".")),
+		store:     newMemorySignalStore(ttl),
+		uploads:   make(map[uint64]*dnsSignalUpload),
+		downloads: make(map[uint64]*dnsSignalDownload),
+	}
+}
+
+// Serve answers the queries received on the PacketConn, usually listening on UDP
+// port 53, until it is closed.
+func (dss *DNSSignalServer) Serve(conn net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		resp, err := dss.handleQuery(buf[:n])
+		if err != nil {
+			continue // not worth a response
+		}
+		conn.WriteTo(resp, addr) // skipcq: GSC-G104
+	}
+}
+
+// handleQuery returns the response to the DNS query.
+func (dss *DNSSignalServer) handleQuery(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil || header.Response {
+		return nil, ErrInvalidDNSResponse
+	}
+	questions, err := parser.AllQuestions()
+	if err != nil {
+		return nil, err
+	}
+
+	// The response size is capped by the EDNS0 UDP payload size of the query.
+	budget := 512
+	var edns bool
+	if err := parser.SkipAllAnswers(); err == nil {
+		if err := parser.SkipAllAuthorities(); err == nil {
+			additionals, _ := parser.AllAdditionals()
+			for _, additional := range additionals {
+				if additional.Header.Type == dnsmessage.TypeOPT {
+					edns = true
+					if size := int(additional.Header.Class); size > budget {
+						budget = size
+					}
+				}
+			}
+		}
+	}
+	if budget > DNS_SIGNAL_UDP_SIZE {
+		budget = DNS_SIGNAL_UDP_SIZE
+	}
+
+	respHeader := dnsmessage.Header{
+		ID:               header.ID,
+		Response:         true,
+		OpCode:           header.OpCode,
+		Authoritative:    true,
+		RecursionDesired: header.RecursionDesired,
+	}
+
+	var payload []byte
+	switch {
+	case header.OpCode != 0 || len(questions) != 1:
+		respHeader.RCode = dnsmessage.RCodeFormatError
+	case !dss.inDomain(questions[0].Name.String()):
+		respHeader.RCode = dnsmessage.RCodeRefused
+	case questions[0].Type != dnsmessage.TypeTXT:
+		// NOERROR without answer, e.g. for the A queries of QNAME minimization
+	default:
+		var ok bool
+		payload, ok = decodeDNSSignalName(questions[0].Name.String(), dss.domain)
+		if !ok {
+			respHeader.RCode = dnsmessage.RCodeNameError
+			break
+		}
+
+		overhead := 12 + int(questions[0].Name.Length) + 2 + 4 + 2 + 10 // header, question, answer
+		if edns {
+			overhead += 11
+		}
+		payload = dss.handlePayload(payload, dnsSignalTXTCapacity(budget-overhead)-1-4)
+	}
+
+	builder := dnsmessage.NewBuilder(nil, respHeader)
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, question := range questions {
+		if err := builder.Question(question); err != nil {
+			return nil, err
+		}
+	}
+	if err := builder.StartAnswers(); err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		txt := dnsmessage.ResourceHeader{Name: questions[0].Name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET}
+		if err := builder.TXTResource(txt, dnsmessage.TXTResource{TXT: encodeDNSSignalTXT(payload)}); err != nil {
+			return nil, err
+		}
+	}
+	if edns {
+		if err := builder.StartAdditionals(); err != nil {
+			return nil, err
+		}
+		var opt dnsmessage.ResourceHeader
+		if err := opt.SetEDNS0(DNS_SIGNAL_UDP_SIZE, dnsmessage.RCodeSuccess, false); err != nil {
+			return nil, err
+		}
+		if err := builder.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+			return nil, err
+		}
+	}
+	return builder.Finish()
+}
+
+// handlePayload executes the operation in the payload of a query and returns the
+// payload of the response, with chunks of up to chunkSize bytes.
+func (dss *DNSSignalServer) handlePayload(payload []byte, chunkSize int) []byte {
+	if len(payload) == 0 {
+		return dnsSignalErrorPayload("empty query")
+	}
+	op, payload := payload[0], payload[1:]
+
+	dss.mutex.Lock()
+	defer dss.mutex.Unlock()
+	dss.expire()
+
+	switch op {
+	case dnsSignalOpOffer, dnsSignalOpAnswer:
+		headerSize := 8 + 4 + 4
+		if op == dnsSignalOpAnswer {
+			headerSize += 8
+		}
+		if len(payload) < headerSize {
+			return dnsSignalErrorPayload("bad upload")
+		}
+		uploadID := binary.BigEndian.Uint64(payload[:8])
+		var offerID uint64
+		if op == dnsSignalOpAnswer {
+			offerID = binary.BigEndian.Uint64(payload[8:16])
+		}
+		total := binary.BigEndian.Uint32(payload[headerSize-8 : headerSize-4])
+		offset := binary.BigEndian.Uint32(payload[headerSize-4 : headerSize])
+		chunk := payload[headerSize:]
+
+		upload, err := dss.upload(uploadID, total, offset, chunk)
+		if err != nil {
+			return dnsSignalErrorPayload(err.Error())
+		}
+		if upload.received < upload.total {
+			return []byte{dnsSignalStatusOK}
+		}
+		if !upload.done {
+			dss.complete(upload, op, offerID)
+		}
+		return append([]byte{upload.status}, upload.result...)
+	case dnsSignalOpReadOffer, dnsSignalOpReadAnswer:
+		if (op == dnsSignalOpReadOffer && len(payload) != 8) || (op == dnsSignalOpReadAnswer && len(payload) != 16) {
+			return dnsSignalErrorPayload("bad read")
+		}
+		transferID := binary.BigEndian.Uint64(payload[:8])
+		if download, ok := dss.downloads[transferID]; ok { // retransmitted query
+			return dnsSignalChunkPayload(download.data, 0, chunkSize)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel() // don't wait, the resolver won't either
+		var data []byte
+		var err error
+		if op == dnsSignalOpReadOffer {
+			var offerID uint64
+			var offer []byte
+			offerID, offer, err = dss.store.TakeOffer(ctx)
+			data = append(binary.BigEndian.AppendUint64(nil, offerID), offer...)
+		} else {
+			data, err = dss.store.TakeAnswer(ctx, binary.BigEndian.Uint64(payload[8:16]))
+		}
+		switch {
+		case errors.Is(err, ErrOfferNotReady) || errors.Is(err, ErrAnswerNotReady):
+			return []byte{dnsSignalStatusNotReady}
+		case errors.Is(err, ErrInvalidOfferID):
+			return []byte{dnsSignalStatusInvalidOfferID}
+		case err != nil:
+			return dnsSignalErrorPayload(err.Error())
+		}
+
+		dss.downloads[transferID] = &dnsSignalDownload{
+			data:    data,
+			expires: time.Now().Add(dss.ttl()),
+		}
+		return dnsSignalChunkPayload(data, 0, chunkSize)
+	case dnsSignalOpFetch:
+		if len(payload) != 12 {
+			return dnsSignalErrorPayload("bad fetch")
+		}
+		download, ok := dss.downloads[binary.BigEndian.Uint64(payload[:8])]
+		if !ok {
+			return dnsSignalErrorPayload("unknown transfer")
+		}
+		offset := int(binary.BigEndian.Uint32(payload[8:12]))
+		if offset > len(download.data) {
+			return dnsSignalErrorPayload("bad offset")
+		}
+		return dnsSignalChunkPayload(download.data, offset, chunkSize)
+	default:
+		return dnsSignalErrorPayload("unknown operation")
+	}
+}
+
+// upload adds the chunk to the upload, created on its first chunk.
+// Caller MUST hold the mutex.
+func (dss *DNSSignalServer) upload(uploadID uint64, total, offset uint32, chunk []byte) (*dnsSignalUpload, error) {
+	upload, ok := dss.uploads[uploadID]
+	if !ok {
+		if total > DNS_SIGNAL_MAX_MESSAGE_SIZE {
+			return nil, errors.New("message too large")
+		}
+		upload = &dnsSignalUpload{
+			chunks:  make(map[uint32][]byte),
+			total:   total,
+			expires: time.Now().Add(dss.ttl()),
+		}
+		dss.uploads[uploadID] = upload
+	}
+	if upload.total != total || uint64(offset)+uint64(len(chunk)) > uint64(total) {
+		return nil, errors.New("bad chunk")
+	}
+
+	if _, ok := upload.chunks[offset]; !ok && !upload.done { // not a retransmitted query
+		upload.chunks[offset] = append([]byte(nil), chunk...)
+		upload.received += uint32(len(chunk))
+	}
+	return upload, nil
+}
+
+// complete submits the offer or answer uploaded and keeps the result for the
+// retransmitted queries. Caller MUST hold the mutex.
+func (dss *DNSSignalServer) complete(upload *dnsSignalUpload, op byte, offerID uint64) {
+	data := make([]byte, upload.total)
+	for offset, chunk := range upload.chunks {
+		copy(data[offset:], chunk)
+	}
+	upload.chunks = nil
+	upload.done = true
+
+	var err error
+	if op == dnsSignalOpOffer {
+		var id uint64
+		id, err = dss.store.PutOffer(data)
+		upload.result = binary.BigEndian.AppendUint64(nil, id)
+	} else {
+		err = dss.store.PutAnswer(offerID, data)
+	}
+	switch {
+	case err == nil:
+		upload.status = dnsSignalStatusOK
+	case errors.Is(err, ErrInvalidOfferID):
+		upload.status, upload.result = dnsSignalStatusInvalidOfferID, nil
+	case errors.Is(err, ErrOfferQueueFull):
+		upload.status, upload.result = dnsSignalStatusQueueFull, nil
+	default:
+		upload.status, upload.result = dnsSignalStatusError, []byte(err.Error())
+	}
+}
+
+// expire removes the uploads and downloads expired. Caller MUST hold the mutex.
+func (dss *DNSSignalServer) expire() {
+	now := time.Now()
+	for id, upload := range dss.uploads {
+		if now.After(upload.expires) {
+			delete(dss.uploads, id)
+		}
+	}
+	for id, download := range dss.downloads {
+		if now.After(download.expires) {
+			delete(dss.downloads, id)
+		}
+	}
+}
+
+func (dss *DNSSignalServer) ttl() time.Duration {
+	dss.store.mutex.Lock()
+	defer dss.store.mutex.Unlock()
+	return dss.store.ttl
+}
+
+func (dss *DNSSignalServer) inDomain(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	return name == dss.domain || strings.HasSuffix(name, "."+dss.domain)
+}
+
+// encodeDNSSignalName encodes the payload as labels of the domain.
+func encodeDNSSignalName(payload []byte, domain string) string {
+	encoded := strings.ToLower(dnsSignalName.EncodeToString(payload))
+	var sb strings.Builder
+	for len(encoded) > 63 {
+		sb.WriteString(encoded[:63])
+		sb.WriteByte('.')
+		encoded = encoded[63:]
+	}
+	if len(encoded) > 0 {
+		sb.WriteString(encoded)
+		sb.WriteByte('.')
+	}
+	sb.WriteString(domain)
+	sb.WriteByte('.')
+	return sb.String()
+}
+
+// decodeDNSSignalName decodes the payload from the labels of the name under the domain.
+func decodeDNSSignalName(name, domain string) ([]byte, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	encoded := strings.TrimSuffix(strings.TrimSuffix(name, domain), ".")
+	payload, err := dnsSignalName.DecodeString(strings.ToUpper(strings.ReplaceAll(encoded, ".", "")))
+	if err != nil {
+		return nil, false
+	}
+	return payload, true
+}
+
+// dnsSignalQueryCapacity returns the max size of the payload in a name under the domain.
+func dnsSignalQueryCapacity(domain string) int {
+	available := 253 - len(domain) - 1 // labels and their dots
+	n := available
+	for n > 0 && n+(n+62)/63 > available {
+		n--
+	}
+	return n * 5 / 8
+}
+
+// dnsSignalTXTCapacity returns the max size of the payload in TXT RDATA of size bytes.
+func dnsSignalTXTCapacity(size int) int {
+	encoded := size - (size+255)/256 // length prefix of each string
+	return encoded * 3 / 4
+}
+
+// encodeDNSSignalTXT encodes the payload as strings of a TXT record.
+func encodeDNSSignalTXT(payload []byte) []string {
+	encoded := base64.RawStdEncoding.EncodeToString(payload)
+	var txt []string
+	for len(encoded) > 255 {
+		txt = append(txt, encoded[:255])
+		encoded = encoded[255:]
+	}
+	return append(txt, encoded)
+}
+
+// parseDNSSignalAnswer decodes the payload from the TXT record of the response.
+func parseDNSSignalAnswer(parser *dnsmessage.Parser) ([]byte, error) {
+	for {
+		header, err := parser.AnswerHeader()
+		if err != nil {
+			return nil, fmt.Errorf("%w: no TXT record", ErrInvalidDNSResponse)
+		}
+		if header.Type != dnsmessage.TypeTXT {
+			if err := parser.SkipAnswer(); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidDNSResponse, err)
+			}
+			continue
+		}
+
+		txt, err := parser.TXTResource()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDNSResponse, err)
+		}
+		payload, err := base64.RawStdEncoding.DecodeString(strings.Join(txt.TXT, ""))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDNSResponse, err)
+		}
+		return payload, nil
+	}
+}
+
+// dnsSignalChunkPayload returns the payload of a response carrying a chunk of the data.
+func dnsSignalChunkPayload(data []byte, offset, chunkSize int) []byte {
+	end := offset + chunkSize
+	if end > len(data) {
+		end = len(data)
+	}
+	payload := binary.BigEndian.AppendUint32([]byte{dnsSignalStatusOK}, uint32(len(data)))
+	return append(payload, data[offset:end]...)
+}
+
+func parseDNSSignalChunk(payload []byte) (int, []byte, error) {
+	if len(payload) < 4 {
+		return 0, nil, fmt.Errorf("%w: bad chunk", ErrInvalidDNSResponse)
+	}
+	total := int(binary.BigEndian.Uint32(payload[:4]))
+	if total > DNS_SIGNAL_MAX_MESSAGE_SIZE+8 || len(payload)-4 > total {
+		return 0, nil, fmt.Errorf("%w: bad chunk", ErrInvalidDNSResponse)
+	}
+	return total, payload[4:], nil
+}
+
+func dnsSignalErrorPayload(msg string) []byte {
+	return append([]byte{dnsSignalStatusError}, msg...)
+}
+
+// dnsSignalError returns the error for a status other than dnsSignalStatusOK.
+func dnsSignalError(status byte, payload []byte) error {
+	switch status {
+	case dnsSignalStatusOK:
+		return nil
+	case dnsSignalStatusInvalidOfferID:
+		return ErrInvalidOfferID
+	case dnsSignalStatusQueueFull:
+		return ErrOfferQueueFull
+	case dnsSignalStatusError:
+		return fmt.Errorf("DNS signal server error: %s", payload)
+	default:
+		return fmt.Errorf("%w: unexpected status %d", ErrInvalidDNSResponse, status)
+	}
+}
+
+// dnsSignalWait sleeps for DNS_SIGNAL_POLL_INTERVAL unless the deadline is reached
+// first, in which case it returns false.
+func dnsSignalWait(deadline time.Time) bool {
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return false
+	}
+	if remaining > DNS_SIGNAL_POLL_INTERVAL {
+		remaining = DNS_SIGNAL_POLL_INTERVAL
+	}
+	time.Sleep(remaining)
+	return true
+}
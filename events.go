@@ -0,0 +1,231 @@
+package transportc
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+var (
+	// ErrDialerClosed is the reason reported when a PeerConnection is closed by Dialer.Close.
+	ErrDialerClosed = errors.New("dialer closed")
+
+	// ErrListenerClosed is the reason reported when a PeerConnection is closed by Listener.Close.
+	ErrListenerClosed = errors.New("listener closed")
+
+	// ErrPeerConnectionIdle is the reason reported when a PeerConnection is closed
+	// since no DataChannel is open on it.
+	ErrPeerConnectionIdle = errors.New("peer connection idle")
+
+	// ErrPeerConnectionDisconnected is the reason reported when a PeerConnection is closed
+	// after transitioning into the disconnected state.
+	ErrPeerConnectionDisconnected = errors.New("peer connection disconnected")
+
+	// ErrPeerConnectionFailed is the reason reported when a PeerConnection is closed
+	// after transitioning into the failed state.
+	ErrPeerConnectionFailed = errors.New("peer connection failed")
+
+	// ErrPeerConnectionClosed is the reason reported when a PeerConnection is closed
+	// by the remote peer or the underlying transport.
+	ErrPeerConnectionClosed = errors.New("peer connection closed")
+)
+
+// Events defines optional callbacks fired by Dialer and Listener over the lifecycle
+// of PeerConnections and DataChannels. Any callback left nil is skipped.
+//
+// Callbacks are invoked from internal goroutines and SHOULD return quickly.
+type Events struct {
+	// OnOfferSent is fired by Dialer when a local SDP offer is submitted via Signal.
+	OnOfferSent func(pc *webrtc.PeerConnection, offerID uint64)
+
+	// OnOfferReceived is fired by Listener when a remote SDP offer is read from Signal.
+	OnOfferReceived func(pc *webrtc.PeerConnection, offerID uint64)
+
+	// OnAnswerSent is fired by Listener when a local SDP answer is submitted via Signal.
+	OnAnswerSent func(pc *webrtc.PeerConnection, offerID uint64)
+
+	// OnAnswerReceived is fired by Dialer when a remote SDP answer is read from Signal.
+	OnAnswerReceived func(pc *webrtc.PeerConnection, offerID uint64)
+
+	// OnICEConnectionStateChange is fired when the ICE connection state changes.
+	OnICEConnectionStateChange func(pc *webrtc.PeerConnection, state webrtc.ICEConnectionState)
+
+	// OnSelectedCandidatePairChange is fired when the ICE agent selects a new candidate pair.
+	OnSelectedCandidatePairChange func(pc *webrtc.PeerConnection, pair *webrtc.ICECandidatePair)
+
+	// OnDTLSHandshakeComplete is fired when the DTLS transport becomes connected.
+	// It runs on a goroutine of its own, since the DTLSTransport is locked while
+	// pion fires its state change handler, so it may call e.g.
+	// pc.SCTP().Transport().GetRemoteCertificate() without stalling the handshake.
+	OnDTLSHandshakeComplete func(pc *webrtc.PeerConnection)
+
+	// OnDataChannelOpen is fired when a DataChannel backing a Conn is opened.
+	OnDataChannelOpen func(pc *webrtc.PeerConnection, dc *webrtc.DataChannel)
+
+	// OnDataChannelClose is fired when a DataChannel backing a Conn is closed.
+	OnDataChannelClose func(pc *webrtc.PeerConnection, dc *webrtc.DataChannel)
+
+	// OnPeerConnectionClose is fired exactly once when a PeerConnection is closed,
+	// with the reason being one of the ErrDialerClosed, ErrListenerClosed,
+	// ErrPeerConnectionIdle, ErrPeerConnectionDisconnected, ErrPeerConnectionFailed,
+	// ErrPeerConnectionClosed or any other error that caused the closure.
+	OnPeerConnectionClose func(pc *webrtc.PeerConnection, reason error)
+}
+
+func (e *Events) offerSent(pc *webrtc.PeerConnection, offerID uint64) {
+	if e != nil && e.OnOfferSent != nil {
+		e.OnOfferSent(pc, offerID)
+	}
+}
+
+func (e *Events) offerReceived(pc *webrtc.PeerConnection, offerID uint64) {
+	if e != nil && e.OnOfferReceived != nil {
+		e.OnOfferReceived(pc, offerID)
+	}
+}
+
+func (e *Events) answerSent(pc *webrtc.PeerConnection, offerID uint64) {
+	if e != nil && e.OnAnswerSent != nil {
+		e.OnAnswerSent(pc, offerID)
+	}
+}
+
+func (e *Events) answerReceived(pc *webrtc.PeerConnection, offerID uint64) {
+	if e != nil && e.OnAnswerReceived != nil {
+		e.OnAnswerReceived(pc, offerID)
+	}
+}
+
+func (e *Events) dataChannelOpen(pc *webrtc.PeerConnection, dc *webrtc.DataChannel) {
+	if e != nil && e.OnDataChannelOpen != nil {
+		e.OnDataChannelOpen(pc, dc)
+	}
+}
+
+func (e *Events) dataChannelClose(pc *webrtc.PeerConnection, dc *webrtc.DataChannel) {
+	if e != nil && e.OnDataChannelClose != nil {
+		e.OnDataChannelClose(pc, dc)
+	}
+}
+
+// peerConnection wraps a webrtc.PeerConnection to fire the transport-level
+// events, to verify the remote DTLS certificate and to make sure
+// OnPeerConnectionClose is fired only once.
+type peerConnection struct {
+	*webrtc.PeerConnection
+	events    *Events
+	closeOnce sync.Once
+	closed    chan struct{} // closed once closeWithReason is called
+	reason    error         // set before closed is closed
+
+	verifier   *certificateVerifier
+	verifyOnce sync.Once
+	verifyErr  error
+
+	authenticated chan struct{} // closed once the PSK handshake succeeded, nil without PSK
+}
+
+// newPeerConnection creates a new PeerConnection with the given API and configuration
+// and registers the transport-level event handlers on it.
+//
+// If verifier is set, the PeerConnection is closed once the DTLS handshake completes
+// with a remote certificate rejected by the verifier.
+func newPeerConnection(api *webrtc.API, configuration webrtc.Configuration, events *Events, verifier *certificateVerifier) (*peerConnection, error) {
+	pc, err := api.NewPeerConnection(configuration)
+	if err != nil {
+		return nil, err
+	} else if pc == nil {
+		return nil, errors.New("created nil PeerConnection")
+	}
+
+	wrapped := &peerConnection{
+		PeerConnection: pc,
+		events:         events,
+		closed:         make(chan struct{}),
+		verifier:       verifier,
+	}
+
+	if events == nil {
+		events = &Events{} // verifier may still need the DTLS handler
+	}
+
+	if events.OnICEConnectionStateChange != nil {
+		pc.OnICEConnectionStateChange(func(s webrtc.ICEConnectionState) {
+			events.OnICEConnectionStateChange(pc, s)
+		})
+	}
+
+	if sctp := pc.SCTP(); sctp != nil {
+		if dtls := sctp.Transport(); dtls != nil {
+			if events.OnDTLSHandshakeComplete != nil || verifier != nil {
+				dtls.OnStateChange(func(s webrtc.DTLSTransportState) {
+					if s != webrtc.DTLSTransportStateConnected {
+						return
+					}
+					// The DTLSTransport is locked when this handler is fired
+					if verifier != nil {
+						go func() {
+							if err := wrapped.verifyRemoteCertificate(); err != nil {
+								wrapped.closeWithReason(err)
+							}
+						}()
+					}
+					if events.OnDTLSHandshakeComplete != nil {
+						go events.OnDTLSHandshakeComplete(pc)
+					}
+				})
+			}
+			if ice := dtls.ICETransport(); ice != nil && events.OnSelectedCandidatePairChange != nil {
+				ice.OnSelectedCandidatePairChange(func(pair *webrtc.ICECandidatePair) {
+					events.OnSelectedCandidatePairChange(pc, pair)
+				})
+			}
+		}
+	}
+
+	return wrapped, nil
+}
+
+// verifyRemoteCertificate verifies the remote DTLS certificate once the DTLS handshake
+// completed. It always succeeds if no verifier is set.
+func (pc *peerConnection) verifyRemoteCertificate() error {
+	if pc.verifier == nil {
+		return nil
+	}
+	pc.verifyOnce.Do(func() {
+		var rawCert []byte
+		if sctp := pc.SCTP(); sctp != nil && sctp.Transport() != nil {
+			rawCert = sctp.Transport().GetRemoteCertificate()
+		}
+		pc.verifyErr = pc.verifier.verifyCertificate(rawCert)
+	})
+	return pc.verifyErr
+}
+
+// closeWithReason closes the PeerConnection and fires OnPeerConnectionClose
+// with the given reason. Only the first call takes effect.
+func (pc *peerConnection) closeWithReason(reason error) error {
+	var err error
+	pc.closeOnce.Do(func() {
+		pc.reason = reason
+		close(pc.closed)
+		err = pc.PeerConnection.Close()
+		if pc.events != nil && pc.events.OnPeerConnectionClose != nil {
+			pc.events.OnPeerConnectionClose(pc.PeerConnection, reason)
+		}
+	})
+	return err
+}
+
+// stateCloseReason maps a terminal PeerConnectionState to the reason of closure.
+func stateCloseReason(s webrtc.PeerConnectionState) error {
+	switch s {
+	case webrtc.PeerConnectionStateDisconnected:
+		return ErrPeerConnectionDisconnected
+	case webrtc.PeerConnectionStateFailed:
+		return ErrPeerConnectionFailed
+	default:
+		return ErrPeerConnectionClosed
+	}
+}
@@ -0,0 +1,134 @@
+package transportc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+var (
+	ErrInvalidFrontingConfig = errors.New("invalid fronting config")
+)
+
+// FrontingConfig configures an HTTPSignal created by NewFrontedHTTPSignal to reach
+// an HTTPSignalServer by domain fronting: the TLS connection is made to a front
+// domain sharing a CDN with the rendezvous, which is only named in the Host header
+// encrypted inside, as Snowflake does.
+type FrontingConfig struct {
+	// DialTLSContext, if set, establishes the TLS connections instead of crypto/tls,
+	// e.g. with a uTLS UClient mimicking the ClientHello of a browser. config is
+	// derived from the ServerName, RootCAs and Fingerprint set.
+	DialTLSContext func(ctx context.Context, network, addr string, config *tls.Config) (net.Conn, error)
+
+	// Fingerprint shapes the TLS ClientHello sent by crypto/tls. If nil, the
+	// crypto/tls defaults are used.
+	Fingerprint *TLSFingerprint
+
+	// FrontURL is the URL the requests are sent to, made of the front domain and
+	// the path of the HTTPSignalServer, e.g. https://cdn.example.net/signal
+	FrontURL string
+
+	// Host is the host of the HTTPSignalServer set in the Host header, e.g.
+	// rendezvous.example.com
+	Host string
+
+	// RootCAs is the set of root CAs to verify the certificate of the front domain.
+	// If nil, the system roots are used.
+	RootCAs *x509.CertPool
+
+	// ServerName is the TLS server name (SNI) sent and verified. If empty, the host
+	// of FrontURL is used.
+	ServerName string
+}
+
+// TLSFingerprint shapes the TLS ClientHello within the limits of crypto/tls, which
+// always sends its own set and order of extensions. To mimic the ClientHello of a
+// browser byte for byte, dial with uTLS in FrontingConfig.DialTLSContext instead.
+type TLSFingerprint struct {
+	// CipherSuites is the list of the TLS 1.0-1.2 cipher suites offered.
+	CipherSuites []uint16
+
+	// CurvePreferences is the list of the elliptic curves offered, in order of preference.
+	CurvePreferences []tls.CurveID
+
+	// MaxVersion and MinVersion are the max and min TLS versions offered.
+	MaxVersion uint16
+	MinVersion uint16
+
+	// NextProtos is the list of the ALPN protocols offered, e.g. h2 and http/1.1.
+	NextProtos []string
+}
+
+// NewFrontedHTTPSignal creates a new HTTPSignal sending its requests to the front
+// domain with the Host header set to the HTTPSignalServer.
+func NewFrontedHTTPSignal(config *FrontingConfig) (*HTTPSignal, error) {
+	frontURL, err := url.Parse(config.FrontURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFrontingConfig, err)
+	}
+	if frontURL.Scheme != "https" {
+		return nil, fmt.Errorf("%w: front URL must be https", ErrInvalidFrontingConfig)
+	}
+	if config.Host == "" {
+		return nil, fmt.Errorf("%w: no host", ErrInvalidFrontingConfig)
+	}
+
+	tlsConfig := &tls.Config{ // skipcq: GO-S1020
+		RootCAs:    config.RootCAs,
+		ServerName: config.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = frontURL.Hostname()
+	}
+	if fp := config.Fingerprint; fp != nil {
+		tlsConfig.CipherSuites = fp.CipherSuites
+		tlsConfig.CurvePreferences = fp.CurvePreferences
+		tlsConfig.NextProtos = fp.NextProtos
+		if fp.MaxVersion != 0 {
+			tlsConfig.MaxVersion = fp.MaxVersion
+		}
+		if fp.MinVersion != 0 {
+			tlsConfig.MinVersion = fp.MinVersion
+		}
+	}
+
+	transport := &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		TLSClientConfig:   tlsConfig,
+		ForceAttemptHTTP2: true,
+	}
+	if config.DialTLSContext != nil {
+		dialTLS := config.DialTLSContext
+		transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialTLS(ctx, network, addr, tlsConfig.Clone())
+		}
+	}
+
+	hs := NewHTTPSignal(config.FrontURL)
+	hs.Client = &http.Client{
+		Transport: &frontingTransport{
+			host:      config.Host,
+			transport: transport,
+		},
+	}
+	return hs, nil
+}
+
+// frontingTransport sets the Host header of every request to the fronted host.
+type frontingTransport struct {
+	host      string
+	transport http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (ft *frontingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Host = ft.host
+	return ft.transport.RoundTrip(req)
+}
@@ -6,7 +6,11 @@ require (
 	github.com/gaukas/logging v0.0.2
 	github.com/pion/datachannel v1.5.5
 	github.com/pion/ice/v2 v2.2.12
+	github.com/pion/sdp/v3 v3.0.6
+	github.com/pion/stun v0.3.5
 	github.com/pion/webrtc/v3 v3.1.50
+	golang.org/x/crypto v0.4.0
+	golang.org/x/net v0.4.0
 )
 
 require (
@@ -19,13 +23,9 @@ require (
 	github.com/pion/rtcp v1.2.10 // indirect
 	github.com/pion/rtp v1.7.13 // indirect
 	github.com/pion/sctp v1.8.5 // indirect
-	github.com/pion/sdp/v3 v3.0.6 // indirect
 	github.com/pion/srtp/v2 v2.0.10 // indirect
-	github.com/pion/stun v0.3.5 // indirect
 	github.com/pion/transport v0.14.1 // indirect
 	github.com/pion/turn/v2 v2.0.9 // indirect
 	github.com/pion/udp v0.1.1 // indirect
-	golang.org/x/crypto v0.4.0 // indirect
-	golang.org/x/net v0.4.0 // indirect
 	golang.org/x/sys v0.3.0 // indirect
 )
@@ -0,0 +1,229 @@
+package transportc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gaukas/logging"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	PSK_HANDSHAKE_VERSION         = 1
+	PSK_HANDSHAKE_NONCE_SIZE      = 32
+	PSK_HANDSHAKE_TIMEOUT_DEFAULT = 10 * time.Second
+
+	// PSK_HANDSHAKE_DATACHANNEL_ID is the ID of the reliable pre-negotiated DataChannel
+	// carrying the PSK handshake. It is never assigned to an in-band DataChannel and
+	// may not be used by Config.NegotiatedDataChannels.
+	PSK_HANDSHAKE_DATACHANNEL_ID    = 65534
+	PSK_HANDSHAKE_DATACHANNEL_LABEL = "transportc-psk"
+)
+
+// handshake message types
+const (
+	pskHandshakeChallenge byte = iota + 1
+	pskHandshakeResponse
+)
+
+var (
+	ErrHandshakeFailed = errors.New("PSK handshake failed")
+
+	// ErrReservedDataChannelID is returned when a negotiated DataChannel uses
+	// PSK_HANDSHAKE_DATACHANNEL_ID.
+	ErrReservedDataChannelID = errors.New("reserved negotiated DataChannel ID")
+)
+
+// role labels bound into the handshake responses, so a response can't be reflected
+var (
+	pskHandshakeDialerLabel   = []byte("transportc psk dialer")
+	pskHandshakeListenerLabel = []byte("transportc psk listener")
+)
+
+// startPSKHandshake creates the handshake DataChannel on a new PeerConnection and
+// runs the PSK handshake once it opens. The PeerConnection is closed with the error
+// if the handshake fails. It is a no-op if psk is empty.
+//
+// The handshake authenticates the PeerConnection once for all of its Conns, so
+// unreliable DataChannels never carry it. See waitAuthenticated.
+func (pc *peerConnection) startPSKHandshake(psk []byte, isDialer bool, logger logging.Logger) error {
+	if len(psk) == 0 {
+		return nil
+	}
+	pc.authenticated = make(chan struct{})
+
+	ordered, negotiated := true, true
+	id := uint16(PSK_HANDSHAKE_DATACHANNEL_ID)
+	dataChannel, err := pc.CreateDataChannel(PSK_HANDSHAKE_DATACHANNEL_LABEL, &webrtc.DataChannelInit{
+		Ordered:    &ordered,
+		Negotiated: &negotiated,
+		ID:         &id,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create PSK handshake DataChannel: %w", err)
+	}
+
+	dataChannel.OnOpen(func() {
+		dc, err := dataChannel.Detach()
+		if err != nil {
+			pc.closeWithReason(fmt.Errorf("%w: %v", ErrHandshakeFailed, err)) // skipcq: GSC-G104
+			return
+		}
+		localFingerprint, remoteFingerprint, err := pc.dtlsFingerprints()
+		if err != nil {
+			dc.Close()                                                        // skipcq: GSC-G104
+			pc.closeWithReason(fmt.Errorf("%w: %v", ErrHandshakeFailed, err)) // skipcq: GSC-G104
+			return
+		}
+		dialerFingerprint, listenerFingerprint := remoteFingerprint, localFingerprint
+		if isDialer {
+			dialerFingerprint, listenerFingerprint = localFingerprint, remoteFingerprint
+		}
+
+		// The DataChannel is left open, closing it may cut off the last response
+		conn := NewConn(dc, CONN_DEFAULT_CONCURRENCY)
+		if err := pskHandshake(conn, psk, isDialer, dialerFingerprint, listenerFingerprint); err != nil {
+			logger.Warnf("Rejecting PeerConnection: %v", err)
+			pc.closeWithReason(err) // skipcq: GSC-G104
+			return
+		}
+		close(pc.authenticated)
+	})
+	return nil
+}
+
+// waitAuthenticated blocks until the PSK handshake on the PeerConnection succeeded,
+// the PeerConnection is closed or ctx is done. It returns immediately if no PSK
+// handshake was started.
+func (pc *peerConnection) waitAuthenticated(ctx context.Context) error {
+	if pc.authenticated == nil {
+		return nil
+	}
+	select {
+	case <-pc.authenticated:
+		return nil
+	case <-pc.closed:
+		return fmt.Errorf("PeerConnection closed: %w", pc.reason)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pskHandshake authenticates the peer with a mutual challenge-response over the
+// handshake DataChannel, each message led by its type:
+//
+//	-> challenge(1) | version(1) | nonce(32)
+//	<- challenge(1) | version(1) | peer nonce(32)
+//	-> response(1) | HMAC-SHA256(psk, own role label | fingerprints | peer nonce | nonce)
+//	<- response(1) | HMAC-SHA256(psk, peer role label | fingerprints | nonce | peer nonce)
+//
+// The fingerprints are those of the DTLS certificates of the dialer and then the
+// listener, so the responses can't be relayed between two DTLS sessions by a man
+// in the middle. Both peers send their messages without waiting for each other.
+// It must complete within PSK_HANDSHAKE_TIMEOUT_DEFAULT.
+func pskHandshake(conn *Conn, psk []byte, isDialer bool, dialerFingerprint, listenerFingerprint string) error {
+	conn.SetDeadline(time.Now().Add(PSK_HANDSHAKE_TIMEOUT_DEFAULT)) // skipcq: GSC-G104
+	defer conn.SetDeadline(time.Time{})                             // skipcq: GSC-G104
+
+	fingerprints := []byte(dialerFingerprint + "\n" + listenerFingerprint)
+	ownLabel, peerLabel := pskHandshakeListenerLabel, pskHandshakeDialerLabel
+	if isDialer {
+		ownLabel, peerLabel = peerLabel, ownLabel
+	}
+
+	challenge := make([]byte, 2+PSK_HANDSHAKE_NONCE_SIZE)
+	challenge[0] = pskHandshakeChallenge
+	challenge[1] = PSK_HANDSHAKE_VERSION
+	if _, err := io.ReadFull(rand.Reader, challenge[2:]); err != nil {
+		return err
+	}
+	nonce := challenge[2:]
+	if _, err := conn.Write(challenge); err != nil {
+		return fmt.Errorf("%w: %v", ErrHandshakeFailed, err)
+	}
+
+	peerChallenge, err := readHandshakeMessage(conn, pskHandshakeChallenge, 1+PSK_HANDSHAKE_NONCE_SIZE)
+	if err != nil {
+		return err
+	}
+	if peerChallenge[0] != PSK_HANDSHAKE_VERSION {
+		return fmt.Errorf("%w: unsupported version %d", ErrHandshakeFailed, peerChallenge[0])
+	}
+	peerNonce := peerChallenge[1:]
+
+	response := append([]byte{pskHandshakeResponse}, pskHandshakeMAC(psk, ownLabel, fingerprints, peerNonce, nonce)...)
+	if _, err := conn.Write(response); err != nil {
+		return fmt.Errorf("%w: %v", ErrHandshakeFailed, err)
+	}
+
+	peerResponse, err := readHandshakeMessage(conn, pskHandshakeResponse, sha256.Size)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(peerResponse, pskHandshakeMAC(psk, peerLabel, fingerprints, nonce, peerNonce)) {
+		return fmt.Errorf("%w: wrong response", ErrHandshakeFailed)
+	}
+	return nil
+}
+
+func pskHandshakeMAC(psk, label, fingerprints, challengeNonce, responderNonce []byte) []byte {
+	mac := hmac.New(sha256.New, psk)
+	mac.Write(label)          // skipcq: GSC-G104
+	mac.Write(fingerprints)   // skipcq: GSC-G104
+	mac.Write(challengeNonce) // skipcq: GSC-G104
+	mac.Write(responderNonce) // skipcq: GSC-G104
+	return mac.Sum(nil)
+}
+
+// dtlsFingerprints returns the fingerprints of the local and the remote DTLS
+// certificates of the PeerConnection, once the DTLS handshake completed.
+func (pc *peerConnection) dtlsFingerprints() (local, remote string, err error) {
+	sctp := pc.SCTP()
+	if sctp == nil || sctp.Transport() == nil {
+		return "", "", errors.New("no DTLS transport")
+	}
+	dtls := sctp.Transport()
+
+	parameters, err := dtls.GetLocalParameters()
+	if err != nil {
+		return "", "", err
+	}
+	for _, fingerprint := range parameters.Fingerprints {
+		if fingerprint.Algorithm == FINGERPRINT_ALGORITHM {
+			local = normalizeFingerprint(fingerprint.Algorithm + " " + fingerprint.Value)
+			break
+		}
+	}
+	if local == "" {
+		return "", "", fmt.Errorf("no local %s fingerprint", FINGERPRINT_ALGORITHM)
+	}
+
+	rawCert := dtls.GetRemoteCertificate()
+	if len(rawCert) == 0 {
+		return "", "", ErrNoRemoteCertificate
+	}
+	return local, rawCertificateFingerprint(rawCert), nil
+}
+
+// readHandshakeMessage reads one message of the given type and returns its
+// body of exactly size bytes.
+func readHandshakeMessage(conn *Conn, msgType byte, size int) ([]byte, error) {
+	buf := make([]byte, CONN_DEFAULT_MTU)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHandshakeFailed, err)
+	}
+	if n == 0 || buf[0] != msgType {
+		return nil, fmt.Errorf("%w: unexpected message type, expected %d", ErrHandshakeFailed, msgType)
+	}
+	if n != 1+size {
+		return nil, fmt.Errorf("%w: unexpected message of %d bytes", ErrHandshakeFailed, n)
+	}
+	return buf[1:n], nil
+}
@@ -0,0 +1,358 @@
+package transportc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HTTP_SIGNAL_POLL_DEFAULT  = 30 * time.Second
+	HTTP_SIGNAL_POLL_MAX      = 60 * time.Second
+	HTTP_SIGNAL_MAX_BODY_SIZE = 64 * 1024
+)
+
+var (
+	ErrUnexpectedStatus = errors.New("unexpected HTTP status")
+)
+
+// StatusError is the ErrUnexpectedStatus returned by HTTPSignal, carrying the HTTP status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrUnexpectedStatus, e.Status)
+}
+
+func (*StatusError) Unwrap() error {
+	return ErrUnexpectedStatus
+}
+
+func unexpectedStatus(resp *http.Response) error {
+	return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
+// httpOfferResponse is the JSON body returned by POST /offer and GET /offer.
+type httpOfferResponse struct {
+	ID    uint64 `json:"id"`
+	Offer []byte `json:"offer,omitempty"`
+}
+
+// HTTPSignal implements Signal as a client of an HTTPSignalServer.
+//
+// ReadOffer and ReadAnswer long-poll the server for up to PollTimeout, then return
+// ErrOfferNotReady or ErrAnswerNotReady respectively if nothing is available.
+type HTTPSignal struct {
+	// URL is the base URL of the HTTPSignalServer, e.g. https://example.com/signal
+	URL string
+
+	// Client is the HTTP client used for all requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// PollTimeout is the max duration the server holds a long-polling request.
+	PollTimeout time.Duration
+}
+
+// NewHTTPSignal creates a new HTTPSignal with the base URL of an HTTPSignalServer.
+func NewHTTPSignal(baseURL string) *HTTPSignal {
+	return &HTTPSignal{
+		URL:         baseURL,
+		Client:      http.DefaultClient,
+		PollTimeout: HTTP_SIGNAL_POLL_DEFAULT,
+	}
+}
+
+// Offer implements Signal.Offer.
+// It POSTs the offer to the server and returns the offer ID assigned by the server.
+func (hs *HTTPSignal) Offer(offer []byte) (uint64, error) {
+	resp, err := hs.do(http.MethodPost, "offer", nil, offer)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, unexpectedStatus(resp)
+	}
+
+	var offerResp httpOfferResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, HTTP_SIGNAL_MAX_BODY_SIZE)).Decode(&offerResp); err != nil {
+		return 0, fmt.Errorf("failed to decode offer response: %w", err)
+	}
+	return offerResp.ID, nil
+}
+
+// ReadOffer implements Signal.ReadOffer.
+// It long-polls the server for the next offer.
+func (hs *HTTPSignal) ReadOffer() (uint64, []byte, error) {
+	resp, err := hs.do(http.MethodGet, "offer", hs.pollQuery(), nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var offerResp httpOfferResponse
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 2*HTTP_SIGNAL_MAX_BODY_SIZE)).Decode(&offerResp); err != nil {
+			return 0, nil, fmt.Errorf("failed to decode offer: %w", err)
+		}
+		return offerResp.ID, offerResp.Offer, nil
+	case http.StatusNoContent:
+		return 0, nil, ErrOfferNotReady
+	default:
+		return 0, nil, unexpectedStatus(resp)
+	}
+}
+
+// Answer implements Signal.Answer.
+// It POSTs the answer associated with the offerID to the server.
+func (hs *HTTPSignal) Answer(offerID uint64, answer []byte) error {
+	query := url.Values{"id": {strconv.FormatUint(offerID, 10)}}
+	resp, err := hs.do(http.MethodPost, "answer", query, answer)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return ErrInvalidOfferID
+	default:
+		return unexpectedStatus(resp)
+	}
+}
+
+// ReadAnswer implements Signal.ReadAnswer.
+// It long-polls the server for the answer associated with the offerID.
+func (hs *HTTPSignal) ReadAnswer(offerID uint64) ([]byte, error) {
+	query := hs.pollQuery()
+	query.Set("id", strconv.FormatUint(offerID, 10))
+	resp, err := hs.do(http.MethodGet, "answer", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(io.LimitReader(resp.Body, HTTP_SIGNAL_MAX_BODY_SIZE))
+	case http.StatusNoContent:
+		return nil, ErrAnswerNotReady
+	case http.StatusNotFound:
+		return nil, ErrInvalidOfferID
+	default:
+		return nil, unexpectedStatus(resp)
+	}
+}
+
+func (hs *HTTPSignal) pollQuery() url.Values {
+	return url.Values{"wait": {hs.PollTimeout.String()}}
+}
+
+func (hs *HTTPSignal) do(method, endpoint string, query url.Values, body []byte) (*http.Response, error) {
+	client := hs.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	u, err := url.Parse(hs.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signal URL: %w", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + endpoint
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hs.PollTimeout+HTTP_SIGNAL_POLL_DEFAULT)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose cancels the request context when the response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// HTTPSignalServer is an http.Handler serving as the rendezvous for HTTPSignal clients.
+//
+// It serves the following endpoints relative to where it is mounted:
+//
+//	POST /offer            submit an offer, responds with its ID
+//	GET  /offer?wait=      long-poll for the next offer
+//	POST /answer?id=       submit the answer to an offer
+//	GET  /answer?id=&wait= long-poll for the answer to an offer
+//
+// Offers not read or answered and answers not read within the TTL expire.
+type HTTPSignalServer struct {
+	store SignalStore
+}
+
+// NewHTTPSignalServer creates a new HTTPSignalServer. If ttl is 0, SIGNAL_OFFER_TTL_DEFAULT is used.
+func NewHTTPSignalServer(ttl time.Duration) *HTTPSignalServer {
+	return NewHTTPSignalServerWithStore(newMemorySignalStore(ttl))
+}
+
+// NewHTTPSignalServerWithStore creates a new HTTPSignalServer keeping the offers
+// and answers in the store, which decides when they expire.
+func NewHTTPSignalServerWithStore(store SignalStore) *HTTPSignalServer {
+	return &HTTPSignalServer{
+		store: store,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (hss *HTTPSignalServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch path.Base(r.URL.Path) {
+	case "offer":
+		switch r.Method {
+		case http.MethodPost:
+			hss.postOffer(w, r)
+		case http.MethodGet:
+			hss.getOffer(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "answer":
+		switch r.Method {
+		case http.MethodPost:
+			hss.postAnswer(w, r)
+		case http.MethodGet:
+			hss.getAnswer(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (hss *HTTPSignalServer) postOffer(w http.ResponseWriter, r *http.Request) {
+	offer, err := readBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := hss.store.PutOffer(offer)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, httpOfferResponse{ID: id})
+}
+
+func (hss *HTTPSignalServer) getOffer(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), pollDuration(r))
+	defer cancel()
+
+	id, offer, err := hss.store.TakeOffer(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, httpOfferResponse{ID: id, Offer: offer})
+}
+
+func (hss *HTTPSignalServer) postAnswer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid offer ID", http.StatusBadRequest)
+		return
+	}
+	answer, err := readBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := hss.store.PutAnswer(id, answer); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (hss *HTTPSignalServer) getAnswer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid offer ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), pollDuration(r))
+	defer cancel()
+
+	answer, err := hss.store.TakeAnswer(ctx, id)
+	switch {
+	case err == nil:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(answer) // skipcq: GSC-G104
+	case errors.Is(err, ErrAnswerNotReady):
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	}
+}
+
+// pollDuration parses the wait parameter of a long-polling request, capped at HTTP_SIGNAL_POLL_MAX.
+func pollDuration(r *http.Request) time.Duration {
+	wait, err := time.ParseDuration(r.URL.Query().Get("wait"))
+	if err != nil || wait < 0 {
+		return 0
+	}
+	if wait > HTTP_SIGNAL_POLL_MAX {
+		return HTTP_SIGNAL_POLL_MAX
+	}
+	return wait
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, HTTP_SIGNAL_MAX_BODY_SIZE+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > HTTP_SIGNAL_MAX_BODY_SIZE {
+		return nil, errors.New("body too large")
+	}
+	if len(body) == 0 {
+		return nil, errors.New("empty body")
+	}
+	return body, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v) // skipcq: GSC-G104
+}
@@ -0,0 +1,158 @@
+// Package cliconfig registers the command-line flags shared by the commands to
+// build a transportc.Config, i.e. the Signal and the WebRTC, ICE, DTLS and PSK settings.
+package cliconfig
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gaukas/transportc"
+	"github.com/gaukas/transportc/internal/signalspec"
+	"github.com/gaukas/transportc/ptadapter"
+	"github.com/pion/webrtc/v3"
+)
+
+var ErrInvalidPortRange = errors.New("invalid port range, expected min-max")
+
+// StringList is a flag.Value collecting the values of a repeatable flag.
+type StringList []string
+
+func (sl *StringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *StringList) Set(value string) error {
+	*sl = append(*sl, value)
+	return nil
+}
+
+// Flags are the flags registered by Register.
+type Flags struct {
+	config       ptadapter.Config
+	iceServers   StringList
+	fingerprints StringList
+	timeout      time.Duration
+
+	interfaces   StringList
+	networkTypes StringList
+	nat1To1IPs   StringList
+	nat1To1Type  string
+	portRange    string
+}
+
+// Register registers the flags on fs, with timeout as the default of -timeout.
+func Register(fs *flag.FlagSet, timeout time.Duration) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.config.Signal, "signal", "", "signal spec, one of:\n"+indent(signalspec.Usage))
+	fs.Var(&f.iceServers, "ice", "ICE server URL, e.g. stun:stun.l.google.com:19302 (repeatable)")
+	fs.StringVar(&f.config.PreSharedKey, "psk", "", "hex-encoded pre-shared key sealing the signaling and authenticating every PeerConnection")
+	fs.StringVar(&f.config.CertFile, "cert", "", "PEM file of the persistent DTLS certificate")
+	fs.StringVar(&f.config.KeyFile, "key", "", "PEM file of the private key of the DTLS certificate")
+	fs.Var(&f.fingerprints, "fingerprint", "allowed remote DTLS certificate fingerprint, e.g. \"sha-256 AB:CD:...\" (repeatable)")
+	fs.DurationVar(&f.timeout, "timeout", timeout, "timeout closing the Conns with nothing written for as long, and the PeerConnections not established by a Listener")
+	fs.Var(&f.interfaces, "interface", "network interface to gather ICE candidates on, all if not set (repeatable)")
+	fs.Var(&f.networkTypes, "network", "network type to gather ICE candidates on, one of udp4, udp6, tcp4 and tcp6, all if not set (repeatable)")
+	fs.Var(&f.nat1To1IPs, "nat1to1", "IP address announced as an ICE candidate, e.g. the public IP of a 1:1 NAT (repeatable)")
+	fs.StringVar(&f.nat1To1Type, "nat1to1-type", webrtc.ICECandidateTypeHost.String(), "type of the -nat1to1 candidates, host replacing the host candidates or srflx added alongside them")
+	fs.StringVar(&f.portRange, "ports", "", "range of the UDP ports of the ICE candidates, e.g. 50000-50100")
+	return f
+}
+
+// SetSignal sets the signal spec, as if given with -signal.
+func (f *Flags) SetSignal(spec string) {
+	f.config.Signal = spec
+}
+
+// Signal returns the signal spec given with -signal.
+func (f *Flags) Signal() string {
+	return f.config.Signal
+}
+
+// Config builds the transportc.Config from the flags. The returned io.Closer, if
+// not nil, closes the Signal.
+func (f *Flags) Config() (*transportc.Config, io.Closer, error) {
+	c := f.config
+	c.ICEServers = f.iceServers
+	c.RemoteFingerprints = f.fingerprints
+	if f.timeout > 0 {
+		c.Timeout = f.timeout.String()
+	}
+	config, closer, err := c.TransportConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := f.setICE(config); err != nil {
+		if closer != nil {
+			closer.Close() // skipcq: GSC-G104
+		}
+		return nil, nil, err
+	}
+	return config, closer, nil
+}
+
+// setICE sets the interfaces, network types, NAT 1:1 IPs and port range of the
+// ICE candidates.
+func (f *Flags) setICE(config *transportc.Config) error {
+	if len(f.interfaces) > 0 {
+		allowed := make(map[string]bool, len(f.interfaces))
+		for _, name := range f.interfaces {
+			allowed[name] = true
+		}
+		config.InterfaceFilter = func(name string) bool {
+			return allowed[name]
+		}
+	}
+
+	for _, network := range f.networkTypes {
+		networkType, err := webrtc.NewNetworkType(network)
+		if err != nil {
+			return fmt.Errorf("-network: %w", err)
+		}
+		config.CandidateNetworkTypes = append(config.CandidateNetworkTypes, networkType)
+	}
+
+	if len(f.nat1To1IPs) > 0 {
+		candidateType, err := webrtc.NewICECandidateType(f.nat1To1Type)
+		if err != nil {
+			return fmt.Errorf("-nat1to1-type: %w", err)
+		}
+		config.IPs = &transportc.NAT1To1IPs{
+			IPs:  f.nat1To1IPs,
+			Type: candidateType,
+		}
+	}
+
+	if f.portRange != "" {
+		portRange, err := parsePortRange(f.portRange)
+		if err != nil {
+			return fmt.Errorf("-ports: %w", err)
+		}
+		config.PortRange = portRange
+	}
+	return nil
+}
+
+func parsePortRange(s string) (*transportc.PortRange, error) {
+	lo, hi, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, ErrInvalidPortRange
+	}
+	first, err := strconv.ParseUint(lo, 10, 16)
+	if err != nil {
+		return nil, ErrInvalidPortRange
+	}
+	last, err := strconv.ParseUint(hi, 10, 16)
+	if err != nil || first == 0 || last < first {
+		return nil, ErrInvalidPortRange
+	}
+	return &transportc.PortRange{Min: uint16(first), Max: uint16(last)}, nil
+}
+
+func indent(s string) string {
+	return "  " + strings.ReplaceAll(s, "\n", "\n  ")
+}
@@ -0,0 +1,66 @@
+package cliconfig
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidForward = errors.New("invalid forward, expected [bind_address:]port:host:hostport")
+
+// ForwardSpec is a forward given as [bind_address:]port:host:hostport, as in ssh -L
+// and -R.
+type ForwardSpec struct {
+	Listen string // the address to listen on
+	Target string // the address to connect to
+}
+
+// ParseForwardSpec parses the spec with IPv6 addresses in brackets, binding to
+// localhost if no bind address is given and to all interfaces if it is empty.
+func ParseForwardSpec(spec string) (ForwardSpec, error) {
+	var fields []string
+	for rest := spec; ; {
+		var field string
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return ForwardSpec{}, fmt.Errorf("%w: %q misses ]", ErrInvalidForward, spec)
+			}
+			field, rest = rest[1:end], rest[end+1:]
+			if rest != "" && rest[0] != ':' {
+				return ForwardSpec{}, fmt.Errorf("%w: %q", ErrInvalidForward, spec)
+			}
+		} else if i := strings.IndexByte(rest, ':'); i >= 0 {
+			field, rest = rest[:i], rest[i:]
+		} else {
+			field, rest = rest, ""
+		}
+		fields = append(fields, field)
+		if rest == "" {
+			break
+		}
+		rest = rest[1:] // the colon, followed by a field even if empty
+	}
+
+	switch len(fields) {
+	case 3:
+		fields = append([]string{"localhost"}, fields...)
+	case 4:
+	default:
+		return ForwardSpec{}, fmt.Errorf("%w: %q", ErrInvalidForward, spec)
+	}
+	if strings.ContainsAny(fields[0], "[]") || fields[2] == "" || strings.ContainsAny(fields[2], "[]") {
+		return ForwardSpec{}, fmt.Errorf("%w: %q has an invalid host", ErrInvalidForward, spec)
+	}
+	for _, port := range []string{fields[1], fields[3]} {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return ForwardSpec{}, fmt.Errorf("%w: %q has an invalid port %q", ErrInvalidForward, spec, port)
+		}
+	}
+	return ForwardSpec{
+		Listen: net.JoinHostPort(fields[0], fields[1]),
+		Target: net.JoinHostPort(fields[2], fields[3]),
+	}, nil
+}
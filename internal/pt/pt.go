@@ -0,0 +1,462 @@
+// Package pt implements the managed proxy side of the Tor Pluggable Transport
+// specification, version 1.0: the configuration passed by the parent process in
+// the TOR_PT_* environment variables, the messages written back on stdout and
+// the encoding of the transport arguments.
+package pt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	VERSION = "1"
+)
+
+var (
+	ErrInvalidEnv  = errors.New("pt: invalid environment")
+	ErrInvalidArgs = errors.New("pt: invalid arguments")
+)
+
+// Stdout is where the messages to the parent process are written.
+var Stdout io.Writer = os.Stdout
+
+// Args are the key-value arguments of a transport, passed by the client per
+// connection in the SOCKS credentials or by the server operator in
+// TOR_PT_SERVER_TRANSPORT_OPTIONS. A key may have multiple values.
+type Args map[string][]string
+
+// Get returns the first value of the key, and whether the key is present.
+func (args Args) Get(key string) (string, bool) {
+	values, ok := args[key]
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// Add appends the value to the key.
+func (args Args) Add(key, value string) {
+	args[key] = append(args[key], value)
+}
+
+// ClientInfo is the configuration of a managed proxy in client mode.
+type ClientInfo struct {
+	// MethodNames are the transports to launch, among the supported ones.
+	MethodNames []string
+
+	// ProxyURL is the upstream proxy to use, from TOR_PT_PROXY, if any. A
+	// managed proxy not supporting it MUST report it with ProxyError.
+	ProxyURL *url.URL
+
+	// StateLocation is the directory to store persistent state in.
+	StateLocation string
+}
+
+// Bindaddr is a transport to launch in server mode with its address and options.
+type Bindaddr struct {
+	MethodName string
+	Addr       *net.TCPAddr
+	Options    Args
+}
+
+// ServerInfo is the configuration of a managed proxy in server mode.
+type ServerInfo struct {
+	// Bindaddrs are the transports to launch, among the supported ones.
+	Bindaddrs []Bindaddr
+
+	// OrAddr is the address of the ORPort to forward the connections to.
+	OrAddr *net.TCPAddr
+
+	// StateLocation is the directory to store persistent state in.
+	StateLocation string
+}
+
+// IsClient reports whether the parent process launched the managed proxy in client mode.
+func IsClient() bool {
+	return os.Getenv("TOR_PT_CLIENT_TRANSPORTS") != ""
+}
+
+// ExitOnStdinClose reports whether the managed proxy is expected to exit once its
+// stdin is closed, as set in TOR_PT_EXIT_ON_STDIN_CLOSE.
+func ExitOnStdinClose() bool {
+	return os.Getenv("TOR_PT_EXIT_ON_STDIN_CLOSE") == "1"
+}
+
+// ClientSetup negotiates the version with the parent process and reads the
+// client configuration from the environment. The transports requested but not
+// in methodNames are reported with CmethodError.
+//
+// An error is reported to the parent process before being returned.
+func ClientSetup(methodNames []string) (*ClientInfo, error) {
+	stateLocation, err := setup()
+	if err != nil {
+		return nil, err
+	}
+	info := &ClientInfo{StateLocation: stateLocation}
+
+	transports, err := getenvRequired("TOR_PT_CLIENT_TRANSPORTS")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Split(transports, ",") {
+		if name == "*" {
+			info.MethodNames = append([]string(nil), methodNames...)
+			break
+		}
+		if contains(methodNames, name) {
+			info.MethodNames = append(info.MethodNames, name)
+		} else {
+			CmethodError(name, "no such method")
+		}
+	}
+
+	if proxy := os.Getenv("TOR_PT_PROXY"); proxy != "" {
+		info.ProxyURL, err = url.Parse(proxy)
+		if err != nil {
+			ProxyError(fmt.Sprintf("invalid proxy URL: %v", err))
+			return nil, fmt.Errorf("%w: TOR_PT_PROXY: %v", ErrInvalidEnv, err)
+		}
+	}
+	return info, nil
+}
+
+// ServerSetup negotiates the version with the parent process and reads the
+// server configuration from the environment. The transports requested but not
+// in methodNames are reported with SmethodError.
+//
+// An error is reported to the parent process before being returned.
+func ServerSetup(methodNames []string) (*ServerInfo, error) {
+	stateLocation, err := setup()
+	if err != nil {
+		return nil, err
+	}
+	info := &ServerInfo{StateLocation: stateLocation}
+
+	orPort, err := getenvRequired("TOR_PT_ORPORT")
+	if err != nil {
+		return nil, err
+	}
+	info.OrAddr, err = net.ResolveTCPAddr("tcp", orPort)
+	if err != nil {
+		return nil, envError(fmt.Sprintf("cannot resolve TOR_PT_ORPORT %q: %v", orPort, err))
+	}
+
+	options := make(map[string]Args)
+	if s := os.Getenv("TOR_PT_SERVER_TRANSPORT_OPTIONS"); s != "" {
+		options, err = ParseServerTransportOptions(s)
+		if err != nil {
+			return nil, envError(fmt.Sprintf("cannot parse TOR_PT_SERVER_TRANSPORT_OPTIONS: %v", err))
+		}
+	}
+
+	bindaddrs, err := getenvRequired("TOR_PT_SERVER_BINDADDR")
+	if err != nil {
+		return nil, err
+	}
+	transports, err := getenvRequired("TOR_PT_SERVER_TRANSPORTS")
+	if err != nil {
+		return nil, err
+	}
+	requested := strings.Split(transports, ",")
+
+	for _, spec := range strings.Split(bindaddrs, ",") {
+		name, addrStr, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, envError(fmt.Sprintf("TOR_PT_SERVER_BINDADDR: %q doesn't contain \"-\"", spec))
+		}
+		if !contains(requested, name) && !contains(requested, "*") {
+			continue
+		}
+		if !contains(methodNames, name) {
+			SmethodError(name, "no such method")
+			continue
+		}
+		addr, err := net.ResolveTCPAddr("tcp", addrStr)
+		if err != nil {
+			return nil, envError(fmt.Sprintf("TOR_PT_SERVER_BINDADDR: cannot resolve %q: %v", addrStr, err))
+		}
+		args := options[name]
+		if args == nil {
+			args = make(Args)
+		}
+		info.Bindaddrs = append(info.Bindaddrs, Bindaddr{MethodName: name, Addr: addr, Options: args})
+	}
+	return info, nil
+}
+
+// setup negotiates the version and returns the state location.
+func setup() (string, error) {
+	versions, err := getenvRequired("TOR_PT_MANAGED_TRANSPORT_VER")
+	if err != nil {
+		return "", err
+	}
+	if !contains(strings.Split(versions, ","), VERSION) {
+		line("VERSION-ERROR", "no-version")
+		return "", fmt.Errorf("%w: no supported version in %q", ErrInvalidEnv, versions)
+	}
+	line("VERSION", VERSION)
+
+	return getenvRequired("TOR_PT_STATE_LOCATION")
+}
+
+func getenvRequired(key string) (string, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return "", envError(fmt.Sprintf("no %s environment variable", key))
+	}
+	return value, nil
+}
+
+func envError(msg string) error {
+	line("ENV-ERROR", msg)
+	return fmt.Errorf("%w: %s", ErrInvalidEnv, msg)
+}
+
+// Cmethod reports a client transport listening for SOCKS5 connections on addr.
+func Cmethod(name string, addr net.Addr) {
+	line("CMETHOD", name, "socks5", addr.String())
+}
+
+// CmethodError reports a client transport failing to launch.
+func CmethodError(name, msg string) {
+	line("CMETHOD-ERROR", name, msg)
+}
+
+// CmethodsDone reports that all the client transports are launched.
+func CmethodsDone() {
+	line("CMETHODS", "DONE")
+}
+
+// Smethod reports a server transport listening on addr. The args, if any, are
+// passed to the clients in the bridge line.
+func Smethod(name string, addr net.Addr, args Args) {
+	if len(args) == 0 {
+		line("SMETHOD", name, addr.String())
+		return
+	}
+	line("SMETHOD", name, addr.String(), "ARGS:"+EncodeSmethodArgs(args))
+}
+
+// SmethodError reports a server transport failing to launch.
+func SmethodError(name, msg string) {
+	line("SMETHOD-ERROR", name, msg)
+}
+
+// SmethodsDone reports that all the server transports are launched.
+func SmethodsDone() {
+	line("SMETHODS", "DONE")
+}
+
+// ProxyError reports that the upstream proxy in TOR_PT_PROXY cannot be used.
+func ProxyError(msg string) {
+	line("PROXY-ERROR", msg)
+}
+
+// Log sends a log message to the parent process. severity is one of error,
+// warning, notice, info and debug.
+func Log(severity, msg string) {
+	line("LOG", "SEVERITY="+severity, "MESSAGE="+quote(msg))
+}
+
+// line writes a message of keywords separated by spaces, with any newline removed.
+func line(keywords ...string) {
+	msg := strings.Join(keywords, " ")
+	msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	fmt.Fprintln(Stdout, msg) // skipcq: GSC-G104
+}
+
+// quote quotes the string as a C string, as expected in the LOG messages.
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, c := range []byte(s) {
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\r':
+			sb.WriteString(`\r`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&sb, "\\%03o", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// ParseClientArgs parses the per-connection arguments sent by the client in the
+// SOCKS5 username and password, i.e. k=v pairs separated by semicolons, with
+// backslash escaping, split across the username and the password. A password of a
+// single NUL byte is ignored.
+func ParseClientArgs(username, password string) (Args, error) {
+	s := username
+	if password != "\x00" {
+		s += password
+	}
+	args := make(Args)
+	if s == "" {
+		return args, nil
+	}
+
+	pairs, err := splitEscaped(s, ';')
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range pairs {
+		key, value, err := parsePair(pair)
+		if err != nil {
+			return nil, err
+		}
+		args.Add(key, value)
+	}
+	return args, nil
+}
+
+// EncodeClientArgs encodes the arguments as a SOCKS5 username and password to be
+// parsed by ParseClientArgs.
+func EncodeClientArgs(args Args) (username, password string, err error) {
+	var pairs []string
+	for _, key := range sortedKeys(args) {
+		for _, value := range args[key] {
+			pairs = append(pairs, escape(key, "=;\\")+"="+escape(value, ";\\"))
+		}
+	}
+	s := strings.Join(pairs, ";")
+
+	switch {
+	case len(s) > 2*255:
+		return "", "", fmt.Errorf("%w: %d bytes exceed the SOCKS5 credentials", ErrInvalidArgs, len(s))
+	case len(s) > 255:
+		return s[:255], s[255:], nil
+	case s == "":
+		return "", "", nil
+	default:
+		return s, "\x00", nil
+	}
+}
+
+// ParseServerTransportOptions parses TOR_PT_SERVER_TRANSPORT_OPTIONS, i.e.
+// transport:k=v pairs separated by semicolons, with backslash escaping.
+func ParseServerTransportOptions(s string) (map[string]Args, error) {
+	options := make(map[string]Args)
+	pairs, err := splitEscaped(s, ';')
+	if err != nil {
+		return nil, err
+	}
+	for _, pair := range pairs {
+		name, kv, ok := strings.Cut(pair, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%w: %q has no transport name", ErrInvalidArgs, pair)
+		}
+		key, value, err := parsePair(kv)
+		if err != nil {
+			return nil, err
+		}
+		if options[name] == nil {
+			options[name] = make(Args)
+		}
+		options[name].Add(key, value)
+	}
+	return options, nil
+}
+
+// EncodeSmethodArgs encodes the arguments as k=v pairs separated by commas, with
+// backslash escaping, as expected in the ARGS of SMETHOD.
+func EncodeSmethodArgs(args Args) string {
+	var pairs []string
+	for _, key := range sortedKeys(args) {
+		for _, value := range args[key] {
+			pairs = append(pairs, escape(key, "=,\\")+"="+escape(value, "=,\\"))
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+// splitEscaped splits s on the unescaped separators, keeping the escapes.
+func splitEscaped(s string, sep byte) ([]string, error) {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+			if i == len(s) {
+				return nil, fmt.Errorf("%w: trailing backslash in %q", ErrInvalidArgs, s)
+			}
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:]), nil
+}
+
+// parsePair parses an escaped k=v pair.
+func parsePair(pair string) (string, string, error) {
+	for i := 0; i < len(pair); i++ {
+		switch pair[i] {
+		case '\\':
+			i++
+		case '=':
+			key, value := unescape(pair[:i]), unescape(pair[i+1:])
+			if key == "" {
+				return "", "", fmt.Errorf("%w: empty key in %q", ErrInvalidArgs, pair)
+			}
+			return key, value, nil
+		}
+	}
+	return "", "", fmt.Errorf("%w: %q is not a k=v pair", ErrInvalidArgs, pair)
+}
+
+func escape(s, special string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(special, s[i]) >= 0 {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+func unescape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+func sortedKeys(args Args) []string {
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
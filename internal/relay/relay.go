@@ -0,0 +1,39 @@
+// Package relay relays the data between a Conn and another connection.
+package relay
+
+import (
+	"net"
+
+	"github.com/gaukas/transportc"
+)
+
+// Pipe copies between the two connections until either one is closed, then
+// closes both. Each read from a Conn returns one message, so the buffer holds
+// the largest message.
+func Pipe(c1, c2 net.Conn) {
+	errs := make(chan error, 2)
+	go copyConn(c1, c2, errs)
+	go copyConn(c2, c1, errs)
+	<-errs
+
+	c1.Close() // skipcq: GSC-G104
+	c2.Close() // skipcq: GSC-G104
+	<-errs
+}
+
+func copyConn(dst, src net.Conn, errs chan<- error) {
+	buf := make([]byte, transportc.CONN_DEFAULT_MTU)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				errs <- err
+				return
+			}
+		}
+		if err != nil {
+			errs <- err
+			return
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Package signalspec creates a transportc.Signal from a one-line spec, as given on
+// the command line or in the arguments of a pluggable transport.
+package signalspec
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gaukas/transportc"
+)
+
+const (
+	COMPACT_PREFIX = "compact+"
+	MANUAL         = "manual"
+
+	// MANUAL_POLL_TIMEOUT bounds the wait for a token to be pasted, so dialing
+	// still times out.
+	MANUAL_POLL_TIMEOUT = time.Second
+)
+
+var (
+	ErrInvalidSpec = errors.New("invalid signal spec")
+)
+
+// Usage describes the supported specs, to be included in the help of a command.
+const Usage = `http://host/path, https://host/path  HTTPSignal to an HTTPSignalServer
+ws://host/path, wss://host/path      WebSocketSignal to a WebSocketSignalServer
+unix:/path/to/socket                 UnixSignal to a UnixSignalServer
+dir:/path/to/dir                     DirSignal in a shared directory
+dns://resolver:53/domain             DNSSignal to the DNSSignalServer of the domain
+manual                               ManualSignal, copying and pasting tokens on the terminal
+compact+<spec>                       any of the above exchanging compact SDPs`
+
+// Parse creates the Signal described by the spec. See Usage for the supported specs.
+func Parse(spec string) (transportc.Signal, error) {
+	if inner, ok := cutPrefix(spec, COMPACT_PREFIX); ok {
+		s, err := Parse(inner)
+		if err != nil {
+			return nil, err
+		}
+		return transportc.NewCompactSignal(s), nil
+	}
+
+	if spec == MANUAL {
+		return newManualSignal()
+	}
+	if path, ok := cutPrefix(spec, "unix:"); ok {
+		if path == "" {
+			return nil, fmt.Errorf("%w: %q has no socket path", ErrInvalidSpec, spec)
+		}
+		return transportc.NewUnixSignal(path), nil
+	}
+	if path, ok := cutPrefix(spec, "dir:"); ok {
+		if path == "" {
+			return nil, fmt.Errorf("%w: %q has no directory", ErrInvalidSpec, spec)
+		}
+		return transportc.NewDirSignal(path)
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSpec, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return transportc.NewHTTPSignal(spec), nil
+	case "ws", "wss":
+		return transportc.NewWebSocketSignal(spec), nil
+	case "dns":
+		domain := strings.Trim(u.Path, "/")
+		if u.Host == "" || domain == "" {
+			return nil, fmt.Errorf("%w: %q is not dns://resolver/domain", ErrInvalidSpec, spec)
+		}
+		resolver := u.Host
+		if u.Port() == "" {
+			resolver += ":53"
+		}
+		return transportc.NewDNSSignal(domain, resolver), nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported scheme in %q", ErrInvalidSpec, spec)
+	}
+}
+
+// cutPrefix is strings.CutPrefix, not available before Go 1.20.
+func cutPrefix(s, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+// manualSignal is a ManualSignal writing the tokens to stderr and reading them
+// from the terminal, leaving stdin to the data. Close closes the terminal.
+type manualSignal struct {
+	*transportc.ManualSignal
+	tty *os.File
+}
+
+func newManualSignal() (transportc.Signal, error) {
+	name := "/dev/tty"
+	if runtime.GOOS == "windows" {
+		name = "CONIN$"
+	}
+	tty, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: manual signaling needs a terminal: %v", ErrInvalidSpec, err)
+	}
+	ms := transportc.NewManualSignal(tty, os.Stderr)
+	ms.PollTimeout = MANUAL_POLL_TIMEOUT
+	return &manualSignal{ManualSignal: ms, tty: tty}, nil
+}
+
+func (ms *manualSignal) Close() error {
+	return ms.tty.Close()
+}
@@ -0,0 +1,379 @@
+// Package socks5 implements the SOCKS5 protocol as defined in RFC 1928, with the
+// username/password authentication of RFC 1929, as used by pluggable transports
+// to carry per-connection arguments.
+package socks5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+const (
+	VERSION = 0x05
+
+	AUTH_NONE          = 0x00
+	AUTH_USERPASS      = 0x02
+	AUTH_NO_ACCEPTABLE = 0xff
+
+	CMD_CONNECT       = 0x01
+	CMD_BIND          = 0x02
+	CMD_UDP_ASSOCIATE = 0x03
+
+	ATYP_IPV4   = 0x01
+	ATYP_DOMAIN = 0x03
+	ATYP_IPV6   = 0x04
+
+	REP_SUCCEEDED                  = 0x00
+	REP_GENERAL_FAILURE            = 0x01
+	REP_CONNECTION_NOT_ALLOWED     = 0x02
+	REP_NETWORK_UNREACHABLE        = 0x03
+	REP_HOST_UNREACHABLE           = 0x04
+	REP_CONNECTION_REFUSED         = 0x05
+	REP_TTL_EXPIRED                = 0x06
+	REP_COMMAND_NOT_SUPPORTED      = 0x07
+	REP_ADDRESS_TYPE_NOT_SUPPORTED = 0x08
+
+	userPassVersion = 0x01
+)
+
+var (
+	ErrVersion          = errors.New("socks5: unsupported version")
+	ErrNoAcceptableAuth = errors.New("socks5: no acceptable authentication method")
+	ErrAuthFailed       = errors.New("socks5: authentication failed")
+	ErrAddressType      = errors.New("socks5: unsupported address type")
+	ErrRequestFailed    = errors.New("socks5: request failed")
+	ErrShortDatagram    = errors.New("socks5: short UDP datagram")
+	ErrFragmented       = errors.New("socks5: fragmented UDP datagram")
+)
+
+// Request is a SOCKS5 request read by ReadRequest.
+type Request struct {
+	// Command is one of CMD_CONNECT, CMD_BIND or CMD_UDP_ASSOCIATE.
+	Command byte
+
+	// Addr is the destination address as host:port, with host being an IP address
+	// or a domain name.
+	Addr string
+
+	// Username and Password are the credentials sent by the client, if it
+	// authenticated with AUTH_USERPASS.
+	Username string
+	Password string
+
+	conn net.Conn
+}
+
+// ReadRequest negotiates the authentication with the client on conn and reads
+// its request. AUTH_USERPASS is preferred over AUTH_NONE if the client supports
+// both, and any credentials are accepted.
+//
+// The caller MUST reply to the request with Request.Reply.
+func ReadRequest(conn net.Conn) (*Request, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != VERSION {
+		return nil, ErrVersion
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return nil, err
+	}
+
+	var method byte = AUTH_NO_ACCEPTABLE
+	for _, m := range methods {
+		if m == AUTH_USERPASS {
+			method = AUTH_USERPASS
+			break
+		}
+		if m == AUTH_NONE {
+			method = AUTH_NONE
+		}
+	}
+	if _, err := conn.Write([]byte{VERSION, method}); err != nil {
+		return nil, err
+	}
+
+	req := &Request{conn: conn}
+	switch method {
+	case AUTH_USERPASS:
+		if err := req.readUserPass(); err != nil {
+			return nil, err
+		}
+	case AUTH_NO_ACCEPTABLE:
+		return nil, ErrNoAcceptableAuth
+	}
+
+	var reqHeader [3]byte
+	if _, err := io.ReadFull(conn, reqHeader[:]); err != nil {
+		return nil, err
+	}
+	if reqHeader[0] != VERSION {
+		return nil, ErrVersion
+	}
+	req.Command = reqHeader[1]
+
+	addr, err := readAddr(conn)
+	if err != nil {
+		if errors.Is(err, ErrAddressType) {
+			req.Reply(REP_ADDRESS_TYPE_NOT_SUPPORTED, nil) // skipcq: GSC-G104
+		}
+		return nil, err
+	}
+	req.Addr = addr
+	return req, nil
+}
+
+// readUserPass reads the username and password as defined in RFC 1929 and accepts them.
+func (req *Request) readUserPass() error {
+	var version [1]byte
+	if _, err := io.ReadFull(req.conn, version[:]); err != nil {
+		return err
+	}
+	if version[0] != userPassVersion {
+		return ErrAuthFailed
+	}
+
+	username, err := readString(req.conn)
+	if err != nil {
+		return err
+	}
+	password, err := readString(req.conn)
+	if err != nil {
+		return err
+	}
+	req.Username, req.Password = username, password
+
+	_, err = req.conn.Write([]byte{userPassVersion, 0x00})
+	return err
+}
+
+// Reply replies to the request with the code, one of the REP_* constants, and the
+// bound address, e.g. the local address of the connection to the destination or
+// the UDP relay. A nil addr is sent as 0.0.0.0:0.
+func (req *Request) Reply(rep byte, addr net.Addr) error {
+	reply := []byte{VERSION, rep, 0x00}
+	reply = AppendAddr(reply, addr)
+	_, err := req.conn.Write(reply)
+	return err
+}
+
+// AppendAddr appends the address in the SOCKS5 wire format to b. A nil addr is
+// appended as 0.0.0.0:0.
+func AppendAddr(b []byte, addr net.Addr) []byte {
+	var ip net.IP
+	var port int
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip, port = addr.IP, addr.Port
+	case *net.UDPAddr:
+		ip, port = addr.IP, addr.Port
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		b = append(b, ATYP_IPV4)
+		b = append(b, ip4...)
+	} else if ip16 := ip.To16(); ip16 != nil {
+		b = append(b, ATYP_IPV6)
+		b = append(b, ip16...)
+	} else {
+		b = append(b, ATYP_IPV4, 0, 0, 0, 0)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port))
+}
+
+// AppendHostPort appends the host:port address, with host being an IP address or
+// a domain name, in the SOCKS5 wire format to b.
+func AppendHostPort(b []byte, hostport string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portStr)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, ATYP_IPV4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, ATYP_IPV6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) == 0 || len(host) > 255 {
+			return nil, fmt.Errorf("invalid domain name %q", host)
+		}
+		b = append(b, ATYP_DOMAIN, byte(len(host)))
+		b = append(b, host...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// ParseAddr parses an address in the SOCKS5 wire format at the start of b and
+// returns it as host:port with the number of bytes parsed.
+func ParseAddr(b []byte) (string, int, error) {
+	r := bytes.NewReader(b)
+	addr, err := readAddr(r)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", 0, ErrShortDatagram
+		}
+		return "", 0, err
+	}
+	return addr, len(b) - r.Len(), nil
+}
+
+// readAddr reads an address in the SOCKS5 wire format and returns it as host:port.
+func readAddr(r io.Reader) (string, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", err
+	}
+
+	var host string
+	switch atyp[0] {
+	case ATYP_IPV4:
+		ip := make(net.IP, net.IPv4len)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case ATYP_IPV6:
+		ip := make(net.IP, net.IPv6len)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case ATYP_DOMAIN:
+		domain, err := readString(r)
+		if err != nil {
+			return "", err
+		}
+		host = domain
+	default:
+		return "", ErrAddressType
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// readString reads a string prefixed by its length in one byte.
+func readString(r io.Reader) (string, error) {
+	var length [1]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return "", err
+	}
+	s := make([]byte, length[0])
+	if _, err := io.ReadFull(r, s); err != nil {
+		return "", err
+	}
+	return string(s), nil
+}
+
+// ClientHandshake sends a request for the command and the destination address
+// host:port on conn to a SOCKS5 server, authenticating with the username and
+// password if username is not empty, and returns the bound address replied.
+func ClientHandshake(conn net.Conn, command byte, addr, username, password string) (string, error) {
+	method := byte(AUTH_NONE)
+	if username != "" {
+		method = AUTH_USERPASS
+	}
+	if _, err := conn.Write([]byte{VERSION, 1, method}); err != nil {
+		return "", err
+	}
+	var selected [2]byte
+	if _, err := io.ReadFull(conn, selected[:]); err != nil {
+		return "", err
+	}
+	if selected[0] != VERSION {
+		return "", ErrVersion
+	}
+	if selected[1] != method {
+		return "", ErrNoAcceptableAuth
+	}
+
+	if method == AUTH_USERPASS {
+		if len(username) > 255 || len(password) > 255 {
+			return "", fmt.Errorf("%w: credentials too long", ErrAuthFailed)
+		}
+		auth := []byte{userPassVersion, byte(len(username))}
+		auth = append(auth, username...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return "", err
+		}
+		var status [2]byte
+		if _, err := io.ReadFull(conn, status[:]); err != nil {
+			return "", err
+		}
+		if status[1] != 0x00 {
+			return "", ErrAuthFailed
+		}
+	}
+
+	req, err := AppendHostPort([]byte{VERSION, command, 0x00}, addr)
+	if err != nil {
+		return "", err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return "", err
+	}
+
+	var reply [3]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return "", err
+	}
+	if reply[0] != VERSION {
+		return "", ErrVersion
+	}
+	bound, err := readAddr(conn)
+	if err != nil {
+		return "", err
+	}
+	if reply[1] != REP_SUCCEEDED {
+		return "", fmt.Errorf("%w: reply code %d", ErrRequestFailed, reply[1])
+	}
+	return bound, nil
+}
+
+// ParseUDPDatagram parses a datagram relayed by a UDP ASSOCIATE request, made of
+// the header defined in RFC 1928 section 7 and the data, and returns the
+// destination or source address as host:port with the data. Fragmented datagrams
+// are rejected with ErrFragmented, fragmentation being optional and rarely used.
+func ParseUDPDatagram(b []byte) (string, []byte, error) {
+	if len(b) < 3 {
+		return "", nil, ErrShortDatagram
+	}
+	if b[2] != 0x00 {
+		return "", nil, ErrFragmented
+	}
+	addr, n, err := ParseAddr(b[3:])
+	if err != nil {
+		return "", nil, err
+	}
+	return addr, b[3+n:], nil
+}
+
+// AppendUDPDatagram appends the datagram of the data from or to the address
+// host:port, in the format parsed by ParseUDPDatagram, to b.
+func AppendUDPDatagram(b []byte, hostport string, data []byte) ([]byte, error) {
+	b, err := AppendHostPort(append(b, 0x00, 0x00, 0x00), hostport)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, data...), nil
+}
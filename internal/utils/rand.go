@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"crypto/rand"
+	"math"
+	"math/big"
+	mrand "math/rand"
+)
+
+// RandUint64 returns a random uint64 from crypto/rand,
+// falling back to math/rand if crypto/rand fails.
+func RandUint64() uint64 {
+	n := new(big.Int)
+	randID, err := rand.Int(rand.Reader, n.SetUint64(math.MaxUint64))
+	if err != nil { // fallback to math/rand if crypto/rand fails
+		return mrand.Uint64() // skipcq: GSC-G404
+	}
+	return randID.Uint64()
+}
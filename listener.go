@@ -2,12 +2,8 @@ package transportc
 
 import (
 	"context"
-	"crypto/rand"
 	"encoding/json"
 	"errors"
-	"math"
-	"math/big"
-	mrand "math/rand"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -36,6 +32,15 @@ type Listener struct {
 	logger  logging.Logger
 	signal  Signal
 	timeout time.Duration
+	events  *Events
+
+	// verifier verifies the remote DTLS certificate, nil if not configured
+	verifier *certificateVerifier
+
+	// psk authenticates the peer on every new PeerConnection, see startPSKHandshake
+	psk []byte
+
+	negotiated []NegotiatedDataChannel
 
 	runningStatus ListenerRunningStatus // Initialized at creation. Atomic. Access via sync/atomic methods only
 
@@ -44,8 +49,8 @@ type Listener struct {
 	configuration webrtc.Configuration
 
 	// WebRTC PeerConnection
-	mutex           sync.Mutex                        // mutex makes peerConnection thread-safe
-	peerConnections map[uint64]*webrtc.PeerConnection // PCID:PeerConnection pair
+	mutex           sync.Mutex                 // mutex makes peerConnection thread-safe
+	peerConnections map[uint64]*peerConnection // PCID:PeerConnection pair
 
 	// chan Conn for Accept
 	conns  chan net.Conn // Initialized at creation
@@ -75,9 +80,9 @@ func (l *Listener) Close() error {
 		l.mutex.Lock()
 		defer l.mutex.Unlock()
 		for _, pc := range l.peerConnections {
-			pc.Close()
+			pc.closeWithReason(ErrListenerClosed)
 		}
-		l.peerConnections = make(map[uint64]*webrtc.PeerConnection) // clear map
+		l.peerConnections = make(map[uint64]*peerConnection) // clear map
 		// close(l.conns)
 		close(l.closed)
 		return nil
@@ -114,7 +119,10 @@ func (l *Listener) startAcceptLoop() {
 			for atomic.LoadUint32(&l.runningStatus) == LISTENER_RUNNING { // Only accept new Offers if RUNNING
 				// Accept new Offer from signal
 				offerID, offer, err := l.signal.ReadOffer()
-				if err != nil {
+				if errors.Is(err, ErrSignalClosed) {
+					l.logger.Warnf("Stopped reading offers: %v", err)
+					return
+				} else if err != nil {
 					continue
 				}
 				// Create new PeerConnection in a goroutine
@@ -133,15 +141,16 @@ func (l *Listener) startAcceptLoop() {
 	}()
 }
 
-func (l *Listener) nextPeerConnection(ctx context.Context, offerID uint64, offer []byte) error {
+func (l *Listener) nextPeerConnection(ctx context.Context, offerID uint64, offer []byte) (err error) {
 	api := webrtc.NewAPI(webrtc.WithSettingEngine(l.settingEngine))
 
-	peerConnection, err := api.NewPeerConnection(l.configuration)
+	peerConnection, err := newPeerConnection(api, l.configuration, l.events, l.verifier)
 	if err != nil {
 		return err
 	}
+	l.events.offerReceived(peerConnection.PeerConnection, offerID)
 
-	pcwg := &sync.WaitGroup{}
+	active := &activeConns{}
 
 	// Get a random ID
 	id := l.nextPCID()
@@ -149,11 +158,21 @@ func (l *Listener) nextPeerConnection(ctx context.Context, offerID uint64, offer
 	l.peerConnections[id] = peerConnection
 	l.mutex.Unlock()
 
+	// The PeerConnection is not established on any error below
+	defer func() {
+		if err != nil {
+			l.mutex.Lock()
+			peerConnection.closeWithReason(err) // skipcq: GSC-G104
+			delete(l.peerConnections, id)
+			l.mutex.Unlock()
+		}
+	}()
+
 	peerConnection.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
 		// TODO: handle this better
 		if s > webrtc.PeerConnectionStateConnected {
 			l.mutex.Lock()
-			peerConnection.Close()
+			peerConnection.closeWithReason(stateCloseReason(s))
 			delete(l.peerConnections, id)
 			l.logger.Infof("User session closed, %d active sessions remain", len(l.peerConnections))
 			l.mutex.Unlock()
@@ -162,9 +181,9 @@ func (l *Listener) nextPeerConnection(ctx context.Context, offerID uint64, offer
 			l.logger.Infof("User session created, %d active sessions in total", len(l.peerConnections))
 			l.mutex.Unlock()
 			go utils.DelayedExecution(l.timeout, func() {
-				pcwg.Wait()
+				active.wait()
 				l.mutex.Lock()
-				peerConnection.Close()
+				peerConnection.closeWithReason(ErrPeerConnectionIdle)
 				l.logger.Infof("Closing user session due to idle... ")
 				delete(l.peerConnections, id)
 				l.mutex.Unlock()
@@ -173,48 +192,21 @@ func (l *Listener) nextPeerConnection(ctx context.Context, offerID uint64, offer
 	})
 
 	peerConnection.OnDataChannel(func(d *webrtc.DataChannel) {
-		conn := NewConn(nil, CONN_DEFAULT_CONCURRENCY)
-
-		d.OnOpen(func() {
-			// detach from wrapper
-			dc, err := d.Detach()
-			if err != nil {
-				return
-			} else {
-				conn.dataChannel = dc
-
-				// Set LocalAddr and RemoteAddr
-				if sctp := peerConnection.SCTP(); sctp != nil {
-					if dtls := sctp.Transport(); dtls != nil {
-						if ice := dtls.ICETransport(); ice != nil {
-							icePair, err := ice.GetSelectedCandidatePair()
-							if err != nil {
-								return
-							}
-							conn.localAddr = &Addr{
-								Hostname: icePair.Local.Address,
-								Port:     icePair.Local.Port,
-							}
-							conn.remoteAddr = &Addr{
-								Hostname: icePair.Remote.Address,
-								Port:     icePair.Remote.Port,
-							}
-						}
-					}
-				}
-				go conn.idleloop(l.timeout)
-				pcwg.Add(1)
-				l.conns <- conn
-			}
-		})
-
-		d.OnClose(func() {
-			// TODO: possibly tear down the PeerConnection if it is the last DataChannel?
-			conn.Close()
-			pcwg.Done()
-		})
+		l.handleDataChannel(peerConnection, active, d)
 	})
 
+	if err = peerConnection.startPSKHandshake(l.psk, false, l.logger); err != nil {
+		return err
+	}
+
+	for i := range l.negotiated {
+		d, err := peerConnection.CreateDataChannel(l.negotiated[i].Label, l.negotiated[i].dataChannelInit())
+		if err != nil {
+			return err
+		}
+		l.handleDataChannel(peerConnection, active, d)
+	}
+
 	var bChan chan bool = make(chan bool)
 
 	offerUnmarshal := webrtc.SessionDescription{} // skipcq: GO-W1027
@@ -223,11 +215,22 @@ func (l *Listener) nextPeerConnection(ctx context.Context, offerID uint64, offer
 		return err
 	}
 
+	// With a TrickleSignal, candidates are sent as gathered once the answer is submitted
+	ts, trickle := l.signal.(TrickleSignal)
+	candidates := &candidateBuffer{}
+	if trickle {
+		peerConnection.OnICECandidate(candidates.add)
+	}
+
 	err = peerConnection.SetRemoteDescription(offerUnmarshal)
 	if err != nil {
 		return err
 	}
 
+	if trickle {
+		go trickleCandidates(ts, peerConnection.PeerConnection, offerID, true)
+	}
+
 	// wait for local answer
 	go func(blockingChan chan bool) {
 		localDescription, err := peerConnection.CreateAnswer(nil)
@@ -235,7 +238,10 @@ func (l *Listener) nextPeerConnection(ctx context.Context, offerID uint64, offer
 			blockingChan <- false
 		}
 		// Create channel that is blocked until ICE Gathering is complete
-		gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
+		gatherComplete := webrtc.GatheringCompletePromise(peerConnection.PeerConnection)
+		if trickle {
+			gatherComplete = closedChan()
+		}
 
 		// Sets the LocalDescription, and starts our UDP listeners
 		err = peerConnection.SetLocalDescription(localDescription)
@@ -263,11 +269,117 @@ func (l *Listener) nextPeerConnection(ctx context.Context, offerID uint64, offer
 		if err != nil {
 			return err
 		}
+		l.events.answerSent(peerConnection.PeerConnection, offerID)
+
+		if trickle {
+			candidates.flush(func(candidate []byte) error {
+				return ts.Candidate(offerID, false, candidate)
+			})
+		}
 	}
 
 	return nil
 }
 
+// handleDataChannel delivers the DataChannel through Accept once it is opened.
+func (l *Listener) handleDataChannel(peerConnection *peerConnection, active *activeConns, d *webrtc.DataChannel) {
+	var mutex sync.Mutex // guards the fields below, set by OnOpen and OnClose
+	var conn *Conn       // set once the DataChannel is detached
+	var delivered bool   // whether conn is counted in active
+	var closed bool
+
+	d.OnOpen(func() {
+		l.events.dataChannelOpen(peerConnection.PeerConnection, d)
+		// detach from wrapper
+		dc, err := d.Detach()
+		if err != nil {
+			return
+		}
+		c := NewConn(dc, CONN_DEFAULT_CONCURRENCY)
+		mutex.Lock()
+		conn = c
+		mutex.Unlock()
+
+		if err := peerConnection.verifyRemoteCertificate(); err != nil {
+			l.logger.Warnf("Rejecting user session: %v", err)
+			c.Close() // skipcq: GSC-G104
+			peerConnection.closeWithReason(err)
+			return
+		}
+
+		// Set LocalAddr and RemoteAddr
+		if err := c.setAddrs(peerConnection.PeerConnection); err != nil {
+			c.Close() // skipcq: GSC-G104
+			return
+		}
+		// The PeerConnection is closed if the PSK handshake fails
+		if err := peerConnection.waitAuthenticated(context.Background()); err != nil {
+			c.Close() // skipcq: GSC-G104
+			return
+		}
+
+		mutex.Lock()
+		if closed {
+			mutex.Unlock()
+			c.Close() // skipcq: GSC-G104
+			return
+		}
+		active.add(1)
+		delivered = true
+		mutex.Unlock()
+		go c.idleloop(l.timeout)
+		l.conns <- c
+	})
+
+	d.OnClose(func() {
+		// TODO: possibly tear down the PeerConnection if it is the last DataChannel?
+		mutex.Lock()
+		closed = true
+		c, wasDelivered := conn, delivered
+		mutex.Unlock()
+		if c != nil {
+			c.Close()
+		}
+		if wasDelivered {
+			active.add(-1)
+		}
+		l.events.dataChannelClose(peerConnection.PeerConnection, d)
+	})
+}
+
+// activeConns counts the Conns delivered on a PeerConnection, so it is closed once
+// they are all closed. Unlike a sync.WaitGroup, it may be added to while waited on.
+type activeConns struct {
+	mutex sync.Mutex
+	count int
+	idle  chan struct{} // closed when count drops to 0 while waited on
+}
+
+func (a *activeConns) add(delta int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.count += delta
+	if a.count == 0 && a.idle != nil {
+		close(a.idle)
+		a.idle = nil
+	}
+}
+
+// wait blocks until no Conn is active.
+func (a *activeConns) wait() {
+	a.mutex.Lock()
+	if a.count == 0 {
+		a.mutex.Unlock()
+		return
+	}
+	if a.idle == nil {
+		a.idle = make(chan struct{})
+	}
+	idle := a.idle
+	a.mutex.Unlock()
+	<-idle
+}
+
 // randomize a uint64 for ID. Must not conflict with existing IDs.
 func (l *Listener) nextPCID() uint64 {
 	l.mutex.Lock()
@@ -275,13 +387,7 @@ func (l *Listener) nextPCID() uint64 {
 
 	var id uint64
 	for {
-		n := new(big.Int)
-		randID, err := rand.Int(rand.Reader, n.SetUint64(math.MaxUint64))
-		if err != nil { // fallback to math/rand if crypto/rand fails
-			id = mrand.Uint64() // skipcq: GSC-G404
-		} else {
-			id = randID.Uint64()
-		}
+		id = utils.RandUint64()
 
 		if _, ok := l.peerConnections[id]; !ok { // not found
 			break // okay to use this ID
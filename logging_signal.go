@@ -0,0 +1,125 @@
+package transportc
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/gaukas/logging"
+)
+
+// LoggingSignal wraps a Signal to log every call with its result and duration.
+//
+// Successful calls and reads with nothing available are logged at the debug level,
+// failed calls at the warning level.
+type LoggingSignal struct {
+	Signal
+
+	logger logging.Logger
+}
+
+// loggingTrickleSignal is a LoggingSignal wrapping a TrickleSignal.
+type loggingTrickleSignal struct {
+	*LoggingSignal
+	trickle TrickleSignal
+}
+
+// NewLoggingSignal wraps the Signal with a LoggingSignal logging to the logger,
+// usually the Logger set in Config. If the Signal implements TrickleSignal, so does
+// the returned Signal.
+func NewLoggingSignal(s Signal, logger logging.Logger) Signal {
+	if logger == nil {
+		logger = logging.DefaultStderrLogger(logging.LOG_WARN)
+	}
+
+	ls := &LoggingSignal{Signal: s, logger: logger}
+	if ts, ok := s.(TrickleSignal); ok {
+		return &loggingTrickleSignal{LoggingSignal: ls, trickle: ts}
+	}
+	return ls
+}
+
+// Offer implements Signal.Offer.
+func (ls *LoggingSignal) Offer(offer []byte) (uint64, error) {
+	start := time.Now()
+	offerID, err := ls.Signal.Offer(offer)
+	if err != nil {
+		ls.logger.Warnf("signal: Offer of %d bytes failed after %v: %v", len(offer), time.Since(start), err)
+	} else {
+		ls.logger.Debugf("signal: Offer of %d bytes submitted as offer %d in %v", len(offer), offerID, time.Since(start))
+	}
+	return offerID, err
+}
+
+// ReadOffer implements Signal.ReadOffer.
+func (ls *LoggingSignal) ReadOffer() (uint64, []byte, error) {
+	start := time.Now()
+	offerID, offer, err := ls.Signal.ReadOffer()
+	switch {
+	case errors.Is(err, ErrOfferNotReady):
+		ls.logger.Debugf("signal: ReadOffer not ready after %v", time.Since(start))
+	case err != nil:
+		ls.logger.Warnf("signal: ReadOffer failed after %v: %v", time.Since(start), err)
+	default:
+		ls.logger.Debugf("signal: ReadOffer read offer %d of %d bytes in %v", offerID, len(offer), time.Since(start))
+	}
+	return offerID, offer, err
+}
+
+// Answer implements Signal.Answer.
+func (ls *LoggingSignal) Answer(offerID uint64, answer []byte) error {
+	start := time.Now()
+	err := ls.Signal.Answer(offerID, answer)
+	if err != nil {
+		ls.logger.Warnf("signal: Answer of %d bytes to offer %d failed after %v: %v", len(answer), offerID, time.Since(start), err)
+	} else {
+		ls.logger.Debugf("signal: Answer of %d bytes to offer %d submitted in %v", len(answer), offerID, time.Since(start))
+	}
+	return err
+}
+
+// ReadAnswer implements Signal.ReadAnswer.
+func (ls *LoggingSignal) ReadAnswer(offerID uint64) ([]byte, error) {
+	start := time.Now()
+	answer, err := ls.Signal.ReadAnswer(offerID)
+	switch {
+	case errors.Is(err, ErrAnswerNotReady):
+		ls.logger.Debugf("signal: ReadAnswer of offer %d not ready after %v", offerID, time.Since(start))
+	case err != nil:
+		ls.logger.Warnf("signal: ReadAnswer of offer %d failed after %v: %v", offerID, time.Since(start), err)
+	default:
+		ls.logger.Debugf("signal: ReadAnswer of offer %d read %d bytes in %v", offerID, len(answer), time.Since(start))
+	}
+	return answer, err
+}
+
+// Candidate implements TrickleSignal.Candidate.
+func (lts *loggingTrickleSignal) Candidate(offerID uint64, fromOfferer bool, candidate []byte) error {
+	start := time.Now()
+	err := lts.trickle.Candidate(offerID, fromOfferer, candidate)
+	if err != nil {
+		lts.logger.Warnf("signal: Candidate %q of offer %d (from offerer: %t) failed after %v: %v", candidate, offerID, fromOfferer, time.Since(start), err)
+	} else {
+		lts.logger.Debugf("signal: Candidate %q of offer %d (from offerer: %t) submitted in %v", candidate, offerID, fromOfferer, time.Since(start))
+	}
+	return err
+}
+
+// ReadCandidate implements TrickleSignal.ReadCandidate.
+func (lts *loggingTrickleSignal) ReadCandidate(offerID uint64, fromOfferer bool) ([]byte, error) {
+	start := time.Now()
+	candidate, err := lts.trickle.ReadCandidate(offerID, fromOfferer)
+	switch {
+	case errors.Is(err, ErrCandidateNotReady):
+		lts.logger.Debugf("signal: ReadCandidate of offer %d (from offerer: %t) not ready after %v", offerID, fromOfferer, time.Since(start))
+	case errors.Is(err, io.EOF):
+		lts.logger.Debugf("signal: ReadCandidate of offer %d (from offerer: %t) reached the end of candidates", offerID, fromOfferer)
+	case err != nil:
+		lts.logger.Warnf("signal: ReadCandidate of offer %d (from offerer: %t) failed after %v: %v", offerID, fromOfferer, time.Since(start), err)
+	default:
+		lts.logger.Debugf("signal: ReadCandidate of offer %d (from offerer: %t) read %q in %v", offerID, fromOfferer, candidate, time.Since(start))
+	}
+	return candidate, err
+}
+
+var _ TrickleSignal = (*loggingTrickleSignal)(nil)
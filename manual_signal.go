@@ -0,0 +1,245 @@
+package transportc
+
+import (
+	"bufio"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gaukas/transportc/internal/utils"
+	"github.com/pion/webrtc/v3"
+)
+
+var (
+	ErrInvalidToken      = errors.New("invalid token")
+	ErrUnexpectedSDPType = errors.New("unexpected SDP type")
+)
+
+// TokenEncoding is the text encoding of the tokens generated by EncodeToken.
+type TokenEncoding uint8
+
+const (
+	// TokenBase64 is the unpadded URL-safe base64 encoding, the most compact one.
+	TokenBase64 TokenEncoding = iota
+
+	// TokenBase32 is the unpadded base32 encoding, case-insensitive and free of
+	// punctuation, thus easier to read out or type by hand.
+	TokenBase32
+)
+
+var tokenBase32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// EncodeToken minimizes the SDP to its ICE credentials, DTLS fingerprint and ICE
+// candidates and encodes it as a checksummed token to be copied and pasted by a human.
+//
+// Only DataChannel-only SDPs, i.e. those generated by Dialer and Listener, are supported.
+func EncodeToken(desc webrtc.SessionDescription, encoding TokenEncoding) (string, error) {
+	data, err := MarshalCompactSDP(desc)
+	if err != nil {
+		return "", err
+	}
+	data = binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+
+	switch encoding {
+	case TokenBase64:
+		return base64.RawURLEncoding.EncodeToString(data), nil
+	case TokenBase32:
+		return tokenBase32.EncodeToString(data), nil
+	default:
+		return "", fmt.Errorf("unknown token encoding %d", encoding)
+	}
+}
+
+// DecodeToken decodes a token generated by EncodeToken in either encoding and
+// rebuilds the full SDP. Whitespaces in the token are ignored.
+func DecodeToken(token string) (webrtc.SessionDescription, error) {
+	token = strings.Join(strings.Fields(token), "")
+
+	// A base32 token may also be valid base64, the checksum tells them apart.
+	var candidates [][]byte
+	if data, err := base64.RawURLEncoding.DecodeString(token); err == nil {
+		candidates = append(candidates, data)
+	}
+	if data, err := tokenBase32.DecodeString(strings.ToUpper(token)); err == nil {
+		candidates = append(candidates, data)
+	}
+
+	for _, data := range candidates {
+		if len(data) < 4 {
+			continue
+		}
+		payload, checksum := data[:len(data)-4], data[len(data)-4:]
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(checksum) {
+			continue
+		}
+
+		desc, err := UnmarshalCompactSDP(payload)
+		if err != nil {
+			return webrtc.SessionDescription{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		}
+		return desc, nil
+	}
+	return webrtc.SessionDescription{}, fmt.Errorf("%w: bad encoding or checksum", ErrInvalidToken)
+}
+
+// ManualSignal implements Signal for out-of-band signaling by a human, who copies
+// the token written to the output on one machine and pastes it to the input on the other.
+//
+// Offers and answers are exchanged one at a time: each token read is expected to answer
+// the last offer written (for the dialing side) or to be a new offer (for the listening side).
+type ManualSignal struct {
+	// Encoding is the encoding of the tokens written.
+	Encoding TokenEncoding
+
+	// PollTimeout is the max duration ReadOffer and ReadAnswer wait for a token to be
+	// pasted before returning ErrOfferNotReady or ErrAnswerNotReady. If 0, they block
+	// until a token is read.
+	PollTimeout time.Duration
+
+	out    io.Writer
+	tokens chan string
+	err    error // set before tokens is closed
+
+	mutex   sync.Mutex
+	offerID uint64 // the offer waiting for an answer
+}
+
+// NewManualSignal creates a new ManualSignal reading tokens line by line from in
+// and writing tokens to out, e.g. os.Stdin and os.Stdout.
+func NewManualSignal(in io.Reader, out io.Writer) *ManualSignal {
+	ms := &ManualSignal{
+		Encoding: TokenBase64,
+		out:      out,
+		tokens:   make(chan string),
+	}
+	go ms.readTokens(in)
+	return ms
+}
+
+// Offer implements Signal.Offer.
+// It writes the offer as a token to the output.
+func (ms *ManualSignal) Offer(offer []byte) (uint64, error) {
+	if err := ms.writeToken(offer); err != nil {
+		return 0, err
+	}
+
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.offerID = utils.RandUint64()
+	return ms.offerID, nil
+}
+
+// ReadOffer implements Signal.ReadOffer.
+// It reads the next token from the input as an offer.
+func (ms *ManualSignal) ReadOffer() (uint64, []byte, error) {
+	offer, err := ms.readToken(webrtc.SDPTypeOffer)
+	if err != nil {
+		if errors.Is(err, errTokenTimeout) {
+			return 0, nil, ErrOfferNotReady
+		}
+		return 0, nil, err
+	}
+	return utils.RandUint64(), offer, nil
+}
+
+// Answer implements Signal.Answer.
+// It writes the answer as a token to the output.
+func (ms *ManualSignal) Answer(_ uint64, answer []byte) error {
+	return ms.writeToken(answer)
+}
+
+// ReadAnswer implements Signal.ReadAnswer.
+// It reads the next token from the input as the answer to the last offer.
+func (ms *ManualSignal) ReadAnswer(offerID uint64) ([]byte, error) {
+	ms.mutex.Lock()
+	if offerID == 0 || offerID != ms.offerID {
+		ms.mutex.Unlock()
+		return nil, ErrInvalidOfferID
+	}
+	ms.mutex.Unlock()
+
+	answer, err := ms.readToken(webrtc.SDPTypeAnswer)
+	if err != nil {
+		if errors.Is(err, errTokenTimeout) {
+			return nil, ErrAnswerNotReady
+		}
+		return nil, err
+	}
+
+	ms.mutex.Lock()
+	ms.offerID = 0
+	ms.mutex.Unlock()
+	return answer, nil
+}
+
+var errTokenTimeout = errors.New("token timeout")
+
+func (ms *ManualSignal) writeToken(sdpJSON []byte) error {
+	var desc webrtc.SessionDescription
+	if err := json.Unmarshal(sdpJSON, &desc); err != nil {
+		return fmt.Errorf("failed to unmarshal SDP: %w", err)
+	}
+	token, err := EncodeToken(desc, ms.Encoding)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(ms.out, token)
+	return err
+}
+
+// readToken waits for the next token and returns the SDP of the expected type as JSON.
+// Once the input is exhausted, it returns ErrSignalClosed.
+func (ms *ManualSignal) readToken(expected webrtc.SDPType) ([]byte, error) {
+	var timeout <-chan time.Time
+	if ms.PollTimeout > 0 {
+		timer := time.NewTimer(ms.PollTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var token string
+	var ok bool
+	select {
+	case token, ok = <-ms.tokens:
+		if !ok {
+			return nil, fmt.Errorf("%w: %v", ErrSignalClosed, ms.err)
+		}
+	case <-timeout:
+		return nil, errTokenTimeout
+	}
+
+	desc, err := DecodeToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if desc.Type != expected {
+		return nil, fmt.Errorf("%w: %s, expecting %s", ErrUnexpectedSDPType, desc.Type, expected)
+	}
+	return json.Marshal(desc)
+}
+
+// readTokens reads non-empty lines from in until EOF.
+func (ms *ManualSignal) readTokens(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		ms.tokens <- line
+	}
+
+	ms.err = scanner.Err()
+	if ms.err == nil {
+		ms.err = io.EOF
+	}
+	close(ms.tokens)
+}
@@ -0,0 +1,222 @@
+package transportc
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// SignalMetrics collects the metrics of the Signals wrapped by NewMetricsSignal. It is
+// safe for concurrent use and the zero value is ready to use.
+type SignalMetrics struct {
+	mutex   sync.Mutex
+	stats   SignalStats
+	offered map[uint64]time.Time // offers submitted whose answer is not read yet, offerID:time
+}
+
+// SignalStats is a snapshot of SignalMetrics.
+type SignalStats struct {
+	// Offers, Answers and Candidates count the successful submissions.
+	Offers     uint64
+	Answers    uint64
+	Candidates uint64
+
+	// OffersRead, AnswersRead and CandidatesRead count the successful reads.
+	OffersRead     uint64
+	AnswersRead    uint64
+	CandidatesRead uint64
+
+	// NotReady counts the reads returning ErrOfferNotReady, ErrAnswerNotReady or
+	// ErrCandidateNotReady.
+	NotReady uint64
+
+	// Errors counts the failed calls, excluding the reads with nothing available.
+	Errors uint64
+
+	// Calls is the number of calls, CallLatencyTotal and CallLatencyMax their total
+	// and max duration.
+	Calls            uint64
+	CallLatencyTotal time.Duration
+	CallLatencyMax   time.Duration
+
+	// AnswersTimed is the number of answers read to offers submitted through the
+	// MetricsSignals sharing these metrics less than SIGNAL_ROUTE_TTL_DEFAULT before,
+	// AnswerLatencyTotal and AnswerLatencyMax the total and max duration from their
+	// Offer to the answer read.
+	AnswersTimed       uint64
+	AnswerLatencyTotal time.Duration
+	AnswerLatencyMax   time.Duration
+}
+
+// CallLatencyMean returns the mean duration of the calls.
+func (ss SignalStats) CallLatencyMean() time.Duration {
+	if ss.Calls == 0 {
+		return 0
+	}
+	return ss.CallLatencyTotal / time.Duration(ss.Calls)
+}
+
+// AnswerLatencyMean returns the mean duration from an Offer to its answer read.
+func (ss SignalStats) AnswerLatencyMean() time.Duration {
+	if ss.AnswersTimed == 0 {
+		return 0
+	}
+	return ss.AnswerLatencyTotal / time.Duration(ss.AnswersTimed)
+}
+
+// Stats returns a snapshot of the metrics.
+func (m *SignalMetrics) Stats() SignalStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.stats
+}
+
+// observe records a call, and calls count if it succeeded. notReady is the error
+// returned by a read with nothing available, if any.
+func (m *SignalMetrics) observe(start time.Time, err, notReady error, count func(stats *SignalStats)) {
+	latency := time.Since(start)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.stats.Calls++
+	m.stats.CallLatencyTotal += latency
+	if latency > m.stats.CallLatencyMax {
+		m.stats.CallLatencyMax = latency
+	}
+
+	switch {
+	case err == nil:
+		count(&m.stats)
+	case notReady != nil && errors.Is(err, notReady):
+		m.stats.NotReady++
+	default:
+		m.stats.Errors++
+	}
+}
+
+// offer records the time the offer was submitted.
+func (m *SignalMetrics) offer(offerID uint64, submitted time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.offered == nil {
+		m.offered = make(map[uint64]time.Time)
+	}
+	m.expire()
+	m.offered[offerID] = submitted
+}
+
+// answer records the latency from the offer to the answer read.
+func (m *SignalMetrics) answer(offerID uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.expire()
+	submitted, ok := m.offered[offerID]
+	if !ok { // not offered through a MetricsSignal sharing these metrics, or expired
+		return
+	}
+	delete(m.offered, offerID)
+
+	latency := time.Since(submitted)
+	m.stats.AnswersTimed++
+	m.stats.AnswerLatencyTotal += latency
+	if latency > m.stats.AnswerLatencyMax {
+		m.stats.AnswerLatencyMax = latency
+	}
+}
+
+// expire forgets the offers never answered. Caller MUST hold the mutex.
+func (m *SignalMetrics) expire() {
+	for id, t := range m.offered {
+		if time.Since(t) > SIGNAL_ROUTE_TTL_DEFAULT {
+			delete(m.offered, id)
+		}
+	}
+}
+
+// MetricsSignal wraps a Signal to collect the metrics of its calls.
+type MetricsSignal struct {
+	Signal
+
+	metrics *SignalMetrics
+}
+
+// metricsTrickleSignal is a MetricsSignal wrapping a TrickleSignal.
+type metricsTrickleSignal struct {
+	*MetricsSignal
+	trickle TrickleSignal
+}
+
+// NewMetricsSignal wraps the Signal with a MetricsSignal collecting into metrics,
+// which may be shared by multiple Signals. If the Signal implements TrickleSignal,
+// so does the returned Signal.
+func NewMetricsSignal(s Signal, metrics *SignalMetrics) Signal {
+	ms := &MetricsSignal{Signal: s, metrics: metrics}
+	if ts, ok := s.(TrickleSignal); ok {
+		return &metricsTrickleSignal{MetricsSignal: ms, trickle: ts}
+	}
+	return ms
+}
+
+// Offer implements Signal.Offer.
+func (ms *MetricsSignal) Offer(offer []byte) (uint64, error) {
+	start := time.Now()
+	offerID, err := ms.Signal.Offer(offer)
+	ms.metrics.observe(start, err, nil, func(stats *SignalStats) { stats.Offers++ })
+	if err == nil {
+		ms.metrics.offer(offerID, start)
+	}
+	return offerID, err
+}
+
+// ReadOffer implements Signal.ReadOffer.
+func (ms *MetricsSignal) ReadOffer() (uint64, []byte, error) {
+	start := time.Now()
+	offerID, offer, err := ms.Signal.ReadOffer()
+	ms.metrics.observe(start, err, ErrOfferNotReady, func(stats *SignalStats) { stats.OffersRead++ })
+	return offerID, offer, err
+}
+
+// Answer implements Signal.Answer.
+func (ms *MetricsSignal) Answer(offerID uint64, answer []byte) error {
+	start := time.Now()
+	err := ms.Signal.Answer(offerID, answer)
+	ms.metrics.observe(start, err, nil, func(stats *SignalStats) { stats.Answers++ })
+	return err
+}
+
+// ReadAnswer implements Signal.ReadAnswer.
+func (ms *MetricsSignal) ReadAnswer(offerID uint64) ([]byte, error) {
+	start := time.Now()
+	answer, err := ms.Signal.ReadAnswer(offerID)
+	ms.metrics.observe(start, err, ErrAnswerNotReady, func(stats *SignalStats) { stats.AnswersRead++ })
+	if err == nil {
+		ms.metrics.answer(offerID)
+	}
+	return answer, err
+}
+
+// Candidate implements TrickleSignal.Candidate.
+func (mts *metricsTrickleSignal) Candidate(offerID uint64, fromOfferer bool, candidate []byte) error {
+	start := time.Now()
+	err := mts.trickle.Candidate(offerID, fromOfferer, candidate)
+	mts.metrics.observe(start, err, nil, func(stats *SignalStats) { stats.Candidates++ })
+	return err
+}
+
+// ReadCandidate implements TrickleSignal.ReadCandidate.
+func (mts *metricsTrickleSignal) ReadCandidate(offerID uint64, fromOfferer bool) ([]byte, error) {
+	start := time.Now()
+	candidate, err := mts.trickle.ReadCandidate(offerID, fromOfferer)
+	if errors.Is(err, io.EOF) { // end of candidates
+		mts.metrics.observe(start, nil, nil, func(*SignalStats) {})
+		return candidate, err
+	}
+	mts.metrics.observe(start, err, ErrCandidateNotReady, func(stats *SignalStats) { stats.CandidatesRead++ })
+	return candidate, err
+}
+
+var _ TrickleSignal = (*metricsTrickleSignal)(nil)
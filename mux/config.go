@@ -0,0 +1,80 @@
+// Package mux multiplexes many logical streams over a single net.Conn,
+// such as a transportc.Conn backed by one DataChannel.
+//
+// Each Stream implements net.Conn with its own flow control, half-close and
+// deadlines. A Session sends keepalive frames periodically to detect a dead
+// underlying Conn, which also keeps an idle transportc.Conn from timing out.
+package mux
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// INITIAL_STREAM_WINDOW is the receive window every stream starts with
+	// on both ends. A larger window is granted with a window update.
+	INITIAL_STREAM_WINDOW uint32 = 256 * 1024
+
+	MAX_FRAME_SIZE_DEFAULT     = 16384
+	MAX_FRAME_SIZE_LIMIT       = 65535 - headerSize // a frame must fit in one DataChannel message
+	KEEPALIVE_INTERVAL_DEFAULT = 10 * time.Second
+	KEEPALIVE_TIMEOUT_DEFAULT  = 30 * time.Second
+	ACCEPT_BACKLOG_DEFAULT     = 256
+	MAX_STREAM_WINDOW_DEFAULT  = INITIAL_STREAM_WINDOW
+)
+
+var (
+	ErrInvalidConfig = errors.New("mux: invalid config")
+)
+
+// Config is the configuration of a Session.
+type Config struct {
+	// AcceptBacklog is the max number of streams opened by the remote peer
+	// but not yet accepted. Streams exceeding the backlog are reset.
+	AcceptBacklog int
+
+	// KeepAliveInterval is the interval between keepalive frames. Zero disables keepalive.
+	KeepAliveInterval time.Duration
+
+	// KeepAliveTimeout is the duration after which the Session is closed
+	// if nothing is received from the remote peer. Zero disables the timeout.
+	KeepAliveTimeout time.Duration
+
+	// MaxFrameSize is the max payload size of a single frame.
+	// MUST NOT exceed MAX_FRAME_SIZE_LIMIT.
+	MaxFrameSize int
+
+	// MaxStreamWindow is the receive window of each stream, i.e., the max
+	// number of bytes buffered for a stream before the reader consumes them.
+	// MUST NOT be smaller than INITIAL_STREAM_WINDOW.
+	MaxStreamWindow uint32
+}
+
+// DefaultConfig returns a Config with default values.
+func DefaultConfig() *Config {
+	return &Config{
+		AcceptBacklog:     ACCEPT_BACKLOG_DEFAULT,
+		KeepAliveInterval: KEEPALIVE_INTERVAL_DEFAULT,
+		KeepAliveTimeout:  KEEPALIVE_TIMEOUT_DEFAULT,
+		MaxFrameSize:      MAX_FRAME_SIZE_DEFAULT,
+		MaxStreamWindow:   MAX_STREAM_WINDOW_DEFAULT,
+	}
+}
+
+func (c *Config) validate() error {
+	if c.AcceptBacklog <= 0 {
+		return fmt.Errorf("%w: AcceptBacklog must be positive", ErrInvalidConfig)
+	}
+	if c.MaxFrameSize <= 0 || c.MaxFrameSize > MAX_FRAME_SIZE_LIMIT {
+		return fmt.Errorf("%w: MaxFrameSize must be in (0, %d]", ErrInvalidConfig, MAX_FRAME_SIZE_LIMIT)
+	}
+	if c.MaxStreamWindow < INITIAL_STREAM_WINDOW {
+		return fmt.Errorf("%w: MaxStreamWindow must be at least %d", ErrInvalidConfig, INITIAL_STREAM_WINDOW)
+	}
+	if c.KeepAliveTimeout != 0 && c.KeepAliveTimeout <= c.KeepAliveInterval {
+		return fmt.Errorf("%w: KeepAliveTimeout must be longer than KeepAliveInterval", ErrInvalidConfig)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	protocolVersion byte = 1
+
+	// | version(1) | command(1) | length(2) | stream ID(4) | payload(length) |
+	headerSize = 8
+)
+
+type command = byte
+
+const (
+	cmdSYN  command = iota // open a new stream
+	cmdPSH                 // push data
+	cmdUPD                 // window update, payload is a uint32 increment
+	cmdFIN                 // half-close, no more data from the sender
+	cmdRST                 // reset the stream
+	cmdPING                // keepalive request
+	cmdPONG                // keepalive response
+)
+
+var (
+	ErrInvalidFrame = errors.New("mux: invalid frame")
+)
+
+type frame struct {
+	cmd      command
+	streamID uint32
+	payload  []byte
+}
+
+// marshal encodes the frame into one buffer so it may be written with a single Write call,
+// i.e., a single message on a DataChannel.
+func (f *frame) marshal() []byte {
+	buf := make([]byte, headerSize+len(f.payload))
+	buf[0] = protocolVersion
+	buf[1] = f.cmd
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(f.payload)))
+	binary.BigEndian.PutUint32(buf[4:8], f.streamID)
+	copy(buf[headerSize:], f.payload)
+	return buf
+}
+
+// unmarshalFrame decodes the first frame in buf. It returns the number of bytes consumed,
+// or 0 if buf does not hold a complete frame yet.
+func unmarshalFrame(buf []byte) (*frame, int, error) {
+	if len(buf) < headerSize {
+		return nil, 0, nil
+	}
+	if buf[0] != protocolVersion || buf[1] > cmdPONG {
+		return nil, 0, ErrInvalidFrame
+	}
+	length := int(binary.BigEndian.Uint16(buf[2:4]))
+	if len(buf) < headerSize+length {
+		return nil, 0, nil
+	}
+	f := &frame{
+		cmd:      buf[1],
+		streamID: binary.BigEndian.Uint32(buf[4:8]),
+		payload:  make([]byte, length),
+	}
+	copy(f.payload, buf[headerSize:headerSize+length])
+	return f, headerSize + length, nil
+}
+
+func windowUpdatePayload(increment uint32) []byte {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, increment)
+	return payload
+}
@@ -0,0 +1,333 @@
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// readBufferSize is large enough to hold any DataChannel message so
+// transportc.Conn never returns io.ErrShortBuffer.
+const readBufferSize = 65536
+
+var (
+	ErrSessionClosed     = errors.New("mux: session closed")
+	ErrKeepAliveTimeout  = errors.New("mux: keepalive timeout")
+	ErrStreamIDExhausted = errors.New("mux: stream IDs exhausted")
+)
+
+// Session multiplexes Streams over a single net.Conn.
+//
+// Session implements net.Listener for the Streams opened by the remote peer.
+type Session struct {
+	conn   net.Conn
+	config *Config
+
+	writeMutex sync.Mutex // serializes frames written to conn
+
+	streamMutex  sync.Mutex
+	streams      map[uint32]*Stream
+	nextStreamID uint64 // odd for client, even for server
+
+	accepts chan *Stream
+
+	lastRecv atomic.Int64 // UnixNano of the last frame received
+
+	die       chan struct{}
+	dieOnce   sync.Once
+	dieErr    error
+	dieErrMtx sync.Mutex
+}
+
+// Client creates a Session on the dialing side of conn.
+//
+// If config is nil, DefaultConfig is used.
+func Client(conn net.Conn, config *Config) (*Session, error) {
+	return newSession(conn, config, 1)
+}
+
+// Server creates a Session on the accepting side of conn.
+//
+// If config is nil, DefaultConfig is used.
+func Server(conn net.Conn, config *Config) (*Session, error) {
+	return newSession(conn, config, 2)
+}
+
+func newSession(conn net.Conn, config *Config, firstStreamID uint64) (*Session, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	s := &Session{
+		conn:         conn,
+		config:       config,
+		streams:      make(map[uint32]*Stream),
+		nextStreamID: firstStreamID,
+		accepts:      make(chan *Stream, config.AcceptBacklog),
+		die:          make(chan struct{}),
+	}
+	s.lastRecv.Store(time.Now().UnixNano())
+
+	go s.recvLoop()
+	if config.KeepAliveInterval > 0 {
+		go s.keepaliveLoop()
+	}
+
+	return s, nil
+}
+
+// OpenStream opens a new Stream to the remote peer.
+//
+// It does not wait for the remote peer to accept the Stream, data written
+// before that is buffered by the remote peer within the stream window.
+func (s *Session) OpenStream() (*Stream, error) {
+	if s.IsClosed() {
+		return nil, ErrSessionClosed
+	}
+
+	s.streamMutex.Lock()
+	if s.nextStreamID > math.MaxUint32 {
+		s.streamMutex.Unlock()
+		return nil, ErrStreamIDExhausted
+	}
+	id := uint32(s.nextStreamID)
+	s.nextStreamID += 2
+	stream := newStream(s, id)
+	s.streams[id] = stream
+	s.streamMutex.Unlock()
+
+	if err := s.writeFrame(&frame{cmd: cmdSYN, streamID: id}); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	stream.grantWindow()
+
+	return stream, nil
+}
+
+// AcceptStream waits for and returns the next Stream opened by the remote peer.
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case stream := <-s.accepts:
+		stream.grantWindow()
+		return stream, nil
+	case <-s.die:
+		return nil, s.closeErr()
+	}
+}
+
+// Accept implements net.Listener.
+func (s *Session) Accept() (net.Conn, error) {
+	return s.AcceptStream()
+}
+
+// Addr implements net.Listener. It returns the local address of the underlying Conn.
+func (s *Session) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Close closes the Session, all its Streams and the underlying Conn.
+func (s *Session) Close() error {
+	if s.closeWithError(ErrSessionClosed) {
+		return nil
+	}
+	return ErrSessionClosed
+}
+
+// IsClosed returns whether the Session is closed.
+func (s *Session) IsClosed() bool {
+	select {
+	case <-s.die:
+		return true
+	default:
+		return false
+	}
+}
+
+// CloseChan returns a channel closed when the Session is closed.
+func (s *Session) CloseChan() <-chan struct{} {
+	return s.die
+}
+
+// NumStreams returns the number of Streams currently open.
+func (s *Session) NumStreams() int {
+	s.streamMutex.Lock()
+	defer s.streamMutex.Unlock()
+	return len(s.streams)
+}
+
+// closeWithError closes the Session, returning false if it is already closed.
+func (s *Session) closeWithError(err error) bool {
+	closed := false
+	s.dieOnce.Do(func() {
+		s.dieErrMtx.Lock()
+		s.dieErr = err
+		s.dieErrMtx.Unlock()
+		close(s.die)
+		s.conn.Close()
+
+		s.streamMutex.Lock()
+		for id := range s.streams {
+			delete(s.streams, id)
+		}
+		s.streamMutex.Unlock()
+		closed = true
+	})
+	return closed
+}
+
+func (s *Session) closeErr() error {
+	s.dieErrMtx.Lock()
+	defer s.dieErrMtx.Unlock()
+	return s.dieErr
+}
+
+func (s *Session) writeFrame(f *frame) error {
+	if s.IsClosed() {
+		return s.closeErr()
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	_, err := s.conn.Write(f.marshal())
+	if err != nil {
+		s.closeWithError(err)
+		return err
+	}
+	return nil
+}
+
+// writeFrameAsync writes a control frame without blocking the caller, so the
+// recvLoop never blocks on writing.
+func (s *Session) writeFrameAsync(f *frame) {
+	go s.writeFrame(f) // skipcq: GO-E1007
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.streamMutex.Lock()
+	delete(s.streams, id)
+	s.streamMutex.Unlock()
+}
+
+func (s *Session) getStream(id uint32) *Stream {
+	s.streamMutex.Lock()
+	defer s.streamMutex.Unlock()
+	return s.streams[id]
+}
+
+func (s *Session) recvLoop() {
+	buf := make([]byte, readBufferSize)
+	var pending []byte
+	for {
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			s.closeWithError(err)
+			return
+		}
+		s.lastRecv.Store(time.Now().UnixNano())
+		pending = append(pending, buf[:n]...)
+
+		for {
+			f, consumed, err := unmarshalFrame(pending)
+			if err != nil {
+				s.closeWithError(err)
+				return
+			}
+			if consumed == 0 {
+				break
+			}
+			pending = pending[consumed:]
+			if err := s.handleFrame(f); err != nil {
+				s.closeWithError(err)
+				return
+			}
+		}
+		if len(pending) == 0 {
+			pending = nil // release the backing array
+		}
+	}
+}
+
+func (s *Session) handleFrame(f *frame) error {
+	switch f.cmd {
+	case cmdPING:
+		s.writeFrameAsync(&frame{cmd: cmdPONG})
+		return nil
+	case cmdPONG:
+		return nil
+	case cmdSYN:
+		return s.handleSYN(f.streamID)
+	}
+
+	stream := s.getStream(f.streamID)
+	if stream == nil {
+		if f.cmd == cmdPSH {
+			// stream closed locally, ask the remote peer to stop sending
+			s.writeFrameAsync(&frame{cmd: cmdRST, streamID: f.streamID})
+		}
+		return nil
+	}
+
+	switch f.cmd {
+	case cmdPSH:
+		return stream.pushData(f.payload)
+	case cmdUPD:
+		if len(f.payload) != 4 {
+			return ErrInvalidFrame
+		}
+		stream.addSendWindow(binary.BigEndian.Uint32(f.payload))
+	case cmdFIN:
+		stream.remoteClose()
+	case cmdRST:
+		stream.reset()
+	}
+	return nil
+}
+
+func (s *Session) handleSYN(id uint32) error {
+	if uint64(id)%2 == s.nextStreamID%2 {
+		return ErrInvalidFrame // remote peer must not use our stream IDs
+	}
+
+	s.streamMutex.Lock()
+	if _, ok := s.streams[id]; ok {
+		s.streamMutex.Unlock()
+		return ErrInvalidFrame // duplicate SYN
+	}
+	stream := newStream(s, id)
+	s.streams[id] = stream
+	s.streamMutex.Unlock()
+
+	select {
+	case s.accepts <- stream:
+	default: // backlog full
+		s.removeStream(id)
+		s.writeFrameAsync(&frame{cmd: cmdRST, streamID: id})
+	}
+	return nil
+}
+
+func (s *Session) keepaliveLoop() {
+	ticker := time.NewTicker(s.config.KeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.die:
+			return
+		case <-ticker.C:
+			if s.config.KeepAliveTimeout > 0 && time.Since(time.Unix(0, s.lastRecv.Load())) > s.config.KeepAliveTimeout {
+				s.closeWithError(ErrKeepAliveTimeout)
+				return
+			}
+			s.writeFrameAsync(&frame{cmd: cmdPING})
+		}
+	}
+}
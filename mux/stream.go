@@ -0,0 +1,335 @@
+package mux
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrStreamReset    = errors.New("mux: stream reset by peer")
+	ErrWindowExceeded = errors.New("mux: stream receive window exceeded")
+)
+
+// Stream is a logical bidirectional stream in a Session. It implements net.Conn.
+type Stream struct {
+	id      uint32
+	session *Session
+
+	mutex        sync.Mutex
+	recvBuf      bytes.Buffer
+	recvWindow   uint32 // bytes the remote peer may still send before a window update
+	recvConsumed uint32 // bytes consumed by Read but not yet granted back
+	sendWindow   uint32 // bytes we may still send before a window update
+
+	remoteClosed bool // FIN received
+	localClosed  bool // FIN sent
+	closed       bool // Close called
+	wasReset     bool // RST received
+
+	readNotify  chan struct{}
+	writeNotify chan struct{}
+
+	readDeadline  deadline
+	writeDeadline deadline
+}
+
+func newStream(session *Session, id uint32) *Stream {
+	return &Stream{
+		id:            id,
+		session:       session,
+		recvWindow:    INITIAL_STREAM_WINDOW,
+		sendWindow:    INITIAL_STREAM_WINDOW,
+		readNotify:    make(chan struct{}, 1),
+		writeNotify:   make(chan struct{}, 1),
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+	}
+}
+
+// ID returns the stream ID. Streams opened by Client have odd IDs and
+// streams opened by Server have even IDs.
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// Read reads data from the stream. It returns io.EOF once the remote peer
+// half-closed the stream and all buffered data has been read.
+func (st *Stream) Read(p []byte) (int, error) {
+	for {
+		st.mutex.Lock()
+		if st.closed {
+			st.mutex.Unlock()
+			return 0, io.ErrClosedPipe
+		}
+		if st.recvBuf.Len() > 0 {
+			n, _ := st.recvBuf.Read(p)
+			st.recvConsumed += uint32(n)
+			var increment uint32
+			if st.recvConsumed >= st.session.config.MaxStreamWindow/2 {
+				increment = st.recvConsumed
+				st.recvWindow += increment
+				st.recvConsumed = 0
+			}
+			st.mutex.Unlock()
+
+			if increment > 0 {
+				st.session.writeFrame(&frame{cmd: cmdUPD, streamID: st.id, payload: windowUpdatePayload(increment)}) // skipcq: GSC-G104
+			}
+			return n, nil
+		}
+		switch {
+		case st.wasReset:
+			st.mutex.Unlock()
+			return 0, ErrStreamReset
+		case st.remoteClosed:
+			st.mutex.Unlock()
+			return 0, io.EOF
+		}
+		st.mutex.Unlock()
+
+		select {
+		case <-st.readNotify:
+		case <-st.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		case <-st.session.die:
+			return 0, st.session.closeErr()
+		}
+	}
+}
+
+// Write writes data to the stream. It blocks while the send window is exhausted
+// until the remote peer reads from the stream.
+func (st *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		st.mutex.Lock()
+		switch {
+		case st.closed, st.localClosed:
+			st.mutex.Unlock()
+			return written, io.ErrClosedPipe
+		case st.wasReset:
+			st.mutex.Unlock()
+			return written, ErrStreamReset
+		}
+		window := st.sendWindow
+		if window == 0 {
+			st.mutex.Unlock()
+			select {
+			case <-st.writeNotify:
+				continue
+			case <-st.writeDeadline.wait():
+				return written, os.ErrDeadlineExceeded
+			case <-st.session.die:
+				return written, st.session.closeErr()
+			}
+		}
+
+		n := len(p) - written
+		if n > st.session.config.MaxFrameSize {
+			n = st.session.config.MaxFrameSize
+		}
+		if uint32(n) > window {
+			n = int(window)
+		}
+		st.sendWindow -= uint32(n)
+		st.mutex.Unlock()
+
+		select {
+		case <-st.writeDeadline.wait():
+			return written, os.ErrDeadlineExceeded
+		default:
+		}
+
+		if err := st.session.writeFrame(&frame{cmd: cmdPSH, streamID: st.id, payload: p[written : written+n]}); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// CloseWrite half-closes the stream. The remote peer reads io.EOF once all
+// data written before is read, while the stream may still be read locally.
+func (st *Stream) CloseWrite() error {
+	st.mutex.Lock()
+	if st.localClosed || st.wasReset {
+		st.mutex.Unlock()
+		return nil
+	}
+	st.localClosed = true
+	st.mutex.Unlock()
+
+	return st.session.writeFrame(&frame{cmd: cmdFIN, streamID: st.id})
+}
+
+// Close closes the stream. It half-closes the stream if not done yet and
+// discards any data received afterwards.
+func (st *Stream) Close() error {
+	st.mutex.Lock()
+	if st.closed {
+		st.mutex.Unlock()
+		return io.ErrClosedPipe
+	}
+	st.closed = true
+	st.mutex.Unlock()
+
+	err := st.CloseWrite()
+	st.session.removeStream(st.id)
+	st.notify(st.readNotify)
+	st.notify(st.writeNotify)
+	return err
+}
+
+// LocalAddr returns the local address of the underlying Conn.
+func (st *Stream) LocalAddr() net.Addr {
+	return st.session.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the underlying Conn.
+func (st *Stream) RemoteAddr() net.Addr {
+	return st.session.conn.RemoteAddr()
+}
+
+// SetDeadline sets the deadline for future Read and Write calls.
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.readDeadline.set(t)
+	st.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for future Read calls.
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for future Write calls.
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.writeDeadline.set(t)
+	return nil
+}
+
+// grantWindow grants the remote peer the part of MaxStreamWindow
+// exceeding INITIAL_STREAM_WINDOW.
+func (st *Stream) grantWindow() {
+	increment := st.session.config.MaxStreamWindow - INITIAL_STREAM_WINDOW
+	if increment == 0 {
+		return
+	}
+	st.mutex.Lock()
+	st.recvWindow += increment
+	st.mutex.Unlock()
+	st.session.writeFrame(&frame{cmd: cmdUPD, streamID: st.id, payload: windowUpdatePayload(increment)}) // skipcq: GSC-G104
+}
+
+func (st *Stream) pushData(data []byte) error {
+	st.mutex.Lock()
+	if uint32(len(data)) > st.recvWindow {
+		st.mutex.Unlock()
+		return ErrWindowExceeded
+	}
+	st.recvWindow -= uint32(len(data))
+	if !st.closed {
+		st.recvBuf.Write(data)
+	}
+	st.mutex.Unlock()
+	st.notify(st.readNotify)
+	return nil
+}
+
+func (st *Stream) addSendWindow(increment uint32) {
+	st.mutex.Lock()
+	st.sendWindow += increment
+	st.mutex.Unlock()
+	st.notify(st.writeNotify)
+}
+
+func (st *Stream) remoteClose() {
+	st.mutex.Lock()
+	st.remoteClosed = true
+	st.mutex.Unlock()
+	st.notify(st.readNotify)
+}
+
+func (st *Stream) reset() {
+	st.mutex.Lock()
+	st.wasReset = true
+	st.mutex.Unlock()
+	st.session.removeStream(st.id)
+	st.notify(st.readNotify)
+	st.notify(st.writeNotify)
+}
+
+func (*Stream) notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// deadline is a resettable deadline whose wait channel is closed once expired.
+type deadline struct {
+	mutex  *sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{} // closed when the deadline expires
+}
+
+func makeDeadline() deadline {
+	return deadline{
+		mutex:  &sync.Mutex{},
+		cancel: make(chan struct{}),
+	}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // wait for the timer callback to close cancel
+	}
+	d.timer = nil
+
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
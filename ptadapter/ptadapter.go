@@ -0,0 +1,259 @@
+// Package ptadapter adapts transportc to the Go API of the Pluggable Transports
+// specification, version 2.1: a Transport, configured from JSON, with Dial on the
+// client side and Listen on the server side returning a net.Conn and a
+// net.Listener, so transportc drops into frameworks built on that API.
+package ptadapter
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gaukas/transportc"
+	"github.com/gaukas/transportc/internal/signalspec"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	TRANSPORT_NAME = "transportc"
+	DEFAULT_LABEL  = "transportc"
+)
+
+var (
+	ErrInvalidConfig   = errors.New("invalid transport config")
+	ErrTransportClosed = errors.New("transport closed")
+)
+
+// Config is the JSON configuration of a Transport, shared by the client and the
+// server, e.g.
+//
+//	{"signal": "https://rendezvous.example.com/signal", "ice-servers": ["stun:stun.l.google.com:19302"], "psk": "00112233"}
+type Config struct {
+	// CertFile and KeyFile are the PEM files of the persistent DTLS certificate,
+	// see transportc.LoadCertificateFile. If empty, a certificate is generated for
+	// every PeerConnection.
+	CertFile string `json:"cert-file,omitempty"`
+	KeyFile  string `json:"key-file,omitempty"`
+
+	// ICEServers are the URLs of the STUN and TURN servers, e.g. stun:stun.l.google.com:19302
+	ICEServers []string `json:"ice-servers,omitempty"`
+
+	// Label is the label of the DataChannels dialed. If empty, DEFAULT_LABEL is used.
+	Label string `json:"label,omitempty"`
+
+	// PreSharedKey is the hex-encoded key sealing the signaling messages and
+	// authenticating every PeerConnection, see transportc.Config.PreSharedKey.
+	PreSharedKey string `json:"psk,omitempty"`
+
+	// RemoteFingerprints is the allowlist of the remote DTLS certificate
+	// fingerprints, see transportc.Config.RemoteFingerprints.
+	RemoteFingerprints []string `json:"remote-fingerprints,omitempty"`
+
+	// ReusePeerConnection dials every Conn on the same PeerConnection, see
+	// transportc.Config.ReusePeerConnection.
+	ReusePeerConnection bool `json:"reuse-peer-connection,omitempty"`
+
+	// Signal is the spec of the Signal, e.g. https://rendezvous.example.com/signal
+	// or dir:/var/lib/transportc, optionally prefixed with compact+.
+	Signal string `json:"signal"`
+
+	// Timeout is the idle timeout of the Conns, e.g. 30m: a Conn without any Write
+	// for that long is closed, see transportc.Config.Timeout. If empty, the Listener
+	// uses transportc.DEFAULT_ACCEPT_TIMEOUT (10s) and the Dialer has none.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// ParseConfig parses the JSON configuration. Unknown fields are rejected.
+func ParseConfig(data []byte) (*Config, error) {
+	var config Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	return &config, nil
+}
+
+// TransportConfig builds the transportc.Config described by the configuration.
+// The returned io.Closer closes the Signal, if it needs to be.
+func (c *Config) TransportConfig() (*transportc.Config, io.Closer, error) {
+	if c.Signal == "" {
+		return nil, nil, fmt.Errorf("%w: no signal", ErrInvalidConfig)
+	}
+	s, err := signalspec.Parse(c.Signal)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	closer, _ := s.(io.Closer)
+
+	config, err := c.transportConfig(s)
+	if err != nil {
+		if closer != nil {
+			closer.Close() // skipcq: GSC-G104
+		}
+		return nil, nil, err
+	}
+	return config, closer, nil
+}
+
+// transportConfig builds the transportc.Config around the Signal s.
+func (c *Config) transportConfig(s transportc.Signal) (*transportc.Config, error) {
+	var err error
+	config := &transportc.Config{
+		RemoteFingerprints:  c.RemoteFingerprints,
+		ReusePeerConnection: c.ReusePeerConnection,
+		Signal:              s,
+	}
+
+	if c.PreSharedKey != "" {
+		psk, err := hex.DecodeString(c.PreSharedKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w: psk: %v", ErrInvalidConfig, err)
+		}
+		config.PreSharedKey = psk
+		config.Signal = transportc.NewSecureSignalPSK(s, psk)
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		config.Certificate, err = transportc.LoadCertificateFile(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+	}
+
+	if c.Timeout != "" {
+		config.Timeout, err = time.ParseDuration(c.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("%w: timeout: %v", ErrInvalidConfig, err)
+		}
+	}
+
+	for _, url := range c.ICEServers {
+		config.WebRTCConfiguration.ICEServers = append(config.WebRTCConfiguration.ICEServers, webrtc.ICEServer{URLs: []string{url}})
+	}
+	return config, nil
+}
+
+// Transport is a pluggable transport dialing and listening with a
+// transportc.Config.
+type Transport struct {
+	config *transportc.Config
+	label  string
+	signal io.Closer // nil if the Signal needs not be closed
+
+	mutex     sync.Mutex
+	closed    bool
+	dialer    *transportc.Dialer
+	listeners []*transportc.Listener
+}
+
+// NewTransport creates a new Transport dialing DataChannels with the label,
+// DEFAULT_LABEL if empty.
+func NewTransport(config *transportc.Config, label string) *Transport {
+	if label == "" {
+		label = DEFAULT_LABEL
+	}
+	return &Transport{
+		config: config,
+		label:  label,
+	}
+}
+
+// NewTransportFromJSON creates a new Transport from the JSON configuration, see Config.
+func NewTransportFromJSON(data []byte) (*Transport, error) {
+	c, err := ParseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	config, closer, err := c.TransportConfig()
+	if err != nil {
+		return nil, err
+	}
+	t := NewTransport(config, c.Label)
+	t.signal = closer
+	return t, nil
+}
+
+// Dial dials a new Conn to the server. The Dialer is created on the first call.
+func (t *Transport) Dial() (net.Conn, error) {
+	return t.DialContext(context.Background())
+}
+
+// DialContext dials a new Conn to the server using the provided context.
+func (t *Transport) DialContext(ctx context.Context) (net.Conn, error) {
+	t.mutex.Lock()
+	if t.closed {
+		t.mutex.Unlock()
+		return nil, ErrTransportClosed
+	}
+	if t.dialer == nil {
+		dialer, err := t.config.NewDialer()
+		if err != nil {
+			t.mutex.Unlock()
+			return nil, err
+		}
+		t.dialer = dialer
+	}
+	dialer := t.dialer
+	t.mutex.Unlock()
+
+	return dialer.DialContext(ctx, t.label)
+}
+
+// Listen starts a new Listener accepting Conns from the clients.
+func (t *Transport) Listen() (net.Listener, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return nil, ErrTransportClosed
+	}
+
+	listener, err := t.config.NewListener()
+	if err != nil {
+		return nil, err
+	}
+	if err := listener.Start(); err != nil {
+		return nil, err
+	}
+	t.listeners = append(t.listeners, listener)
+	return &transportListener{Listener: listener, addr: &transportc.Addr{Hostname: t.label}}, nil
+}
+
+// Close closes the Dialer, the Listeners and the Signal of the Transport.
+func (t *Transport) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return ErrTransportClosed
+	}
+	t.closed = true
+
+	if t.dialer != nil {
+		t.dialer.Close() // skipcq: GSC-G104
+	}
+	for _, listener := range t.listeners {
+		listener.Close() // skipcq: GSC-G104
+	}
+	if t.signal != nil {
+		t.signal.Close() // skipcq: GSC-G104
+	}
+	return nil
+}
+
+// transportListener gives the Listener an address, as expected by the frameworks
+// logging or comparing it.
+type transportListener struct {
+	*transportc.Listener
+	addr net.Addr
+}
+
+func (tl *transportListener) Addr() net.Addr {
+	return tl.addr
+}
@@ -0,0 +1,154 @@
+package transportc
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	RETRY_SIGNAL_ATTEMPTS_DEFAULT    = 4
+	RETRY_SIGNAL_BACKOFF_DEFAULT     = 200 * time.Millisecond
+	RETRY_SIGNAL_MAX_BACKOFF_DEFAULT = 5 * time.Second
+)
+
+// RetrySignal wraps a Signal to retry the calls failing with a transient error,
+// see IsTransientSignalError, with exponential backoff.
+//
+// A retried Offer may submit the same offer more than once if the first attempt
+// reached the rendezvous, in which case the extra offers expire unanswered.
+type RetrySignal struct {
+	Signal
+
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// retryTrickleSignal is a RetrySignal wrapping a TrickleSignal.
+type retryTrickleSignal struct {
+	*RetrySignal
+	trickle TrickleSignal
+}
+
+// NewRetrySignal wraps the Signal with a RetrySignal making up to attempts attempts
+// of each call, waiting backoff before the first retry and doubling it after every
+// retry up to maxBackoff. Zero values are replaced by RETRY_SIGNAL_ATTEMPTS_DEFAULT,
+// RETRY_SIGNAL_BACKOFF_DEFAULT and RETRY_SIGNAL_MAX_BACKOFF_DEFAULT. If the Signal
+// implements TrickleSignal, so does the returned Signal.
+func NewRetrySignal(s Signal, attempts int, backoff, maxBackoff time.Duration) Signal {
+	if attempts <= 0 {
+		attempts = RETRY_SIGNAL_ATTEMPTS_DEFAULT
+	}
+	if backoff <= 0 {
+		backoff = RETRY_SIGNAL_BACKOFF_DEFAULT
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = RETRY_SIGNAL_MAX_BACKOFF_DEFAULT
+	}
+
+	rs := &RetrySignal{
+		Signal:     s,
+		attempts:   attempts,
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+	}
+	if ts, ok := s.(TrickleSignal); ok {
+		return &retryTrickleSignal{RetrySignal: rs, trickle: ts}
+	}
+	return rs
+}
+
+// IsTransientSignalError reports whether the error returned by a Signal may go away
+// by retrying, i.e. a network error, a disconnection or an HTTP status telling so:
+// a server error, 408 Request Timeout or 429 Too Many Requests.
+// ErrOfferNotReady, ErrAnswerNotReady and ErrCandidateNotReady are not errors to retry.
+func IsTransientSignalError(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError ||
+			statusErr.StatusCode == http.StatusRequestTimeout ||
+			statusErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, ErrSignalDisconnected) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Offer implements Signal.Offer.
+func (rs *RetrySignal) Offer(offer []byte) (offerID uint64, err error) {
+	rs.retry(func() error {
+		offerID, err = rs.Signal.Offer(offer)
+		return err
+	})
+	return offerID, err
+}
+
+// ReadOffer implements Signal.ReadOffer.
+func (rs *RetrySignal) ReadOffer() (offerID uint64, offer []byte, err error) {
+	rs.retry(func() error {
+		offerID, offer, err = rs.Signal.ReadOffer()
+		return err
+	})
+	return offerID, offer, err
+}
+
+// Answer implements Signal.Answer.
+func (rs *RetrySignal) Answer(offerID uint64, answer []byte) (err error) {
+	rs.retry(func() error {
+		err = rs.Signal.Answer(offerID, answer)
+		return err
+	})
+	return err
+}
+
+// ReadAnswer implements Signal.ReadAnswer.
+func (rs *RetrySignal) ReadAnswer(offerID uint64) (answer []byte, err error) {
+	rs.retry(func() error {
+		answer, err = rs.Signal.ReadAnswer(offerID)
+		return err
+	})
+	return answer, err
+}
+
+// Candidate implements TrickleSignal.Candidate.
+func (rts *retryTrickleSignal) Candidate(offerID uint64, fromOfferer bool, candidate []byte) (err error) {
+	rts.retry(func() error {
+		err = rts.trickle.Candidate(offerID, fromOfferer, candidate)
+		return err
+	})
+	return err
+}
+
+// ReadCandidate implements TrickleSignal.ReadCandidate.
+func (rts *retryTrickleSignal) ReadCandidate(offerID uint64, fromOfferer bool) (candidate []byte, err error) {
+	rts.retry(func() error {
+		candidate, err = rts.trickle.ReadCandidate(offerID, fromOfferer)
+		return err
+	})
+	return candidate, err
+}
+
+// retry calls f until it succeeds, fails with an error not transient or all the
+// attempts are made.
+func (rs *RetrySignal) retry(f func() error) {
+	backoff := rs.backoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || !IsTransientSignalError(err) || attempt >= rs.attempts {
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > rs.maxBackoff {
+			backoff = rs.maxBackoff
+		}
+	}
+}
+
+var _ TrickleSignal = (*retryTrickleSignal)(nil)
@@ -0,0 +1,476 @@
+package transportc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/pion/ice/v2"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	COMPACT_SDP_VERSION = 1
+	SCTP_PORT_DEFAULT   = 5000
+)
+
+var (
+	ErrUnsupportedSDP    = errors.New("unsupported SDP")
+	ErrInvalidCompactSDP = errors.New("invalid compact SDP")
+)
+
+// compact SDP flags
+const (
+	compactEndOfCandidates = 1 << 0
+	compactSetupShift      = 1 // 2 bits, index into compactSetupRoles
+)
+
+var compactSetupRoles = []string{"actpass", "active", "passive"}
+
+// compact candidate flags
+const (
+	compactCandidateTypeMask    = 0x03 // index into compactCandidateTypes
+	compactCandidateTCP         = 1 << 2
+	compactCandidateTCPTypeMask = 0x18 // ice.TCPType << 3
+	compactCandidateRelated     = 1 << 5
+	compactCandidateHostname    = 1 << 6
+)
+
+var compactCandidateTypes = []ice.CandidateType{
+	ice.CandidateTypeHost,
+	ice.CandidateTypeServerReflexive,
+	ice.CandidateTypePeerReflexive,
+	ice.CandidateTypeRelay,
+}
+
+// compactCandidate is an ICE candidate for component 1, the only one used by DataChannels.
+type compactCandidate struct {
+	typ         ice.CandidateType
+	tcp         bool
+	tcpType     ice.TCPType
+	priority    uint32
+	address     string
+	port        uint16
+	relatedAddr string // empty if not present
+	relatedPort uint16
+}
+
+// compactSessionDescription keeps only what is needed to connect to a peer offering
+// or answering a DataChannel-only session: the ICE credentials, the DTLS fingerprint
+// and role, and the ICE candidates. Everything else is rebuilt with pion defaults.
+type compactSessionDescription struct {
+	sdpType         webrtc.SDPType
+	setup           string
+	mid             string
+	iceUfrag        string
+	icePwd          string
+	fingerprintHash string
+	fingerprint     []byte
+	sctpPort        uint16
+	candidates      []compactCandidate
+	endOfCandidates bool
+}
+
+// MarshalCompactSDP strips a DataChannel-only SDP, e.g. one generated by Dialer or
+// Listener, down to its ICE credentials, DTLS fingerprint and role, and ICE candidates,
+// and encodes them in a compact binary form, typically a couple hundred bytes at most.
+func MarshalCompactSDP(desc webrtc.SessionDescription) ([]byte, error) {
+	csd, err := minimizeSessionDescription(desc)
+	if err != nil {
+		return nil, err
+	}
+	return csd.MarshalBinary()
+}
+
+// UnmarshalCompactSDP decodes the compact binary form generated by MarshalCompactSDP
+// and rebuilds an equivalent SDP.
+func UnmarshalCompactSDP(data []byte) (webrtc.SessionDescription, error) {
+	csd := &compactSessionDescription{}
+	if err := csd.UnmarshalBinary(data); err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+	return csd.sessionDescription(), nil
+}
+
+// minimizeSessionDescription extracts the compact form of a DataChannel-only SDP.
+func minimizeSessionDescription(desc webrtc.SessionDescription) (*compactSessionDescription, error) {
+	parsed := &sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(desc.SDP)); err != nil {
+		return nil, fmt.Errorf("failed to parse SDP: %w", err)
+	}
+	if len(parsed.MediaDescriptions) != 1 || parsed.MediaDescriptions[0].MediaName.Media != "application" {
+		return nil, fmt.Errorf("%w: expecting exactly one application media section", ErrUnsupportedSDP)
+	}
+	media := parsed.MediaDescriptions[0]
+
+	// attribute looks up the media-level attribute, then the session-level one
+	attribute := func(key string) (string, bool) {
+		if value, ok := media.Attribute(key); ok {
+			return value, true
+		}
+		return parsed.Attribute(key)
+	}
+
+	csd := &compactSessionDescription{
+		sdpType:  desc.Type,
+		sctpPort: SCTP_PORT_DEFAULT,
+	}
+
+	var ok bool
+	if csd.iceUfrag, ok = attribute("ice-ufrag"); !ok {
+		return nil, fmt.Errorf("%w: missing ice-ufrag", ErrUnsupportedSDP)
+	}
+	if csd.icePwd, ok = attribute("ice-pwd"); !ok {
+		return nil, fmt.Errorf("%w: missing ice-pwd", ErrUnsupportedSDP)
+	}
+	csd.mid, _ = media.Attribute("mid")
+
+	csd.setup, ok = attribute("setup")
+	if !ok {
+		csd.setup = "actpass"
+	}
+	if indexOf(compactSetupRoles, csd.setup) < 0 {
+		return nil, fmt.Errorf("%w: setup %s", ErrUnsupportedSDP, csd.setup)
+	}
+
+	fingerprint, ok := attribute("fingerprint")
+	if !ok {
+		return nil, fmt.Errorf("%w: missing fingerprint", ErrUnsupportedSDP)
+	}
+	parts := strings.Fields(fingerprint)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%w: fingerprint %s", ErrUnsupportedSDP, fingerprint)
+	}
+	csd.fingerprintHash = parts[0]
+	digest, err := hex.DecodeString(strings.ReplaceAll(parts[1], ":", ""))
+	if err != nil {
+		return nil, fmt.Errorf("%w: fingerprint %s", ErrUnsupportedSDP, fingerprint)
+	}
+	csd.fingerprint = digest
+
+	if sctpPort, ok := media.Attribute("sctp-port"); ok {
+		if _, err := fmt.Sscanf(sctpPort, "%d", &csd.sctpPort); err != nil {
+			return nil, fmt.Errorf("%w: sctp-port %s", ErrUnsupportedSDP, sctpPort)
+		}
+	}
+
+	for _, attr := range media.Attributes {
+		switch attr.Key {
+		case "end-of-candidates":
+			csd.endOfCandidates = true
+		case "candidate":
+			candidate, err := ice.UnmarshalCandidate(attr.Value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse candidate: %w", err)
+			}
+			if candidate.Component() != 1 {
+				continue // RTCP component is not used with bundled DataChannels
+			}
+			csd.candidates = append(csd.candidates, newCompactCandidate(candidate))
+		}
+	}
+
+	return csd, nil
+}
+
+func newCompactCandidate(candidate ice.Candidate) compactCandidate {
+	cc := compactCandidate{
+		typ:      candidate.Type(),
+		tcp:      candidate.NetworkType().IsTCP(),
+		tcpType:  candidate.TCPType(),
+		priority: candidate.Priority(),
+		address:  candidate.Address(),
+		port:     uint16(candidate.Port()),
+	}
+	if related := candidate.RelatedAddress(); related != nil {
+		cc.relatedAddr = related.Address
+		cc.relatedPort = uint16(related.Port)
+	}
+	return cc
+}
+
+// sessionDescription rebuilds the full SDP.
+func (csd *compactSessionDescription) sessionDescription() webrtc.SessionDescription {
+	var b strings.Builder
+	fmt.Fprintf(&b, "v=0\r\n")
+	fmt.Fprintf(&b, "o=- %d 1 IN IP4 0.0.0.0\r\n", binary.BigEndian.Uint32(csd.fingerprint)) // stable session ID
+	fmt.Fprintf(&b, "s=-\r\n")
+	fmt.Fprintf(&b, "t=0 0\r\n")
+	fmt.Fprintf(&b, "a=fingerprint:%s %s\r\n", csd.fingerprintHash, fingerprintString(csd.fingerprint))
+	fmt.Fprintf(&b, "a=group:BUNDLE %s\r\n", csd.mid)
+	fmt.Fprintf(&b, "m=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\n")
+	fmt.Fprintf(&b, "c=IN IP4 0.0.0.0\r\n")
+	fmt.Fprintf(&b, "a=setup:%s\r\n", csd.setup)
+	fmt.Fprintf(&b, "a=mid:%s\r\n", csd.mid)
+	fmt.Fprintf(&b, "a=sendrecv\r\n")
+	fmt.Fprintf(&b, "a=sctp-port:%d\r\n", csd.sctpPort)
+	fmt.Fprintf(&b, "a=ice-ufrag:%s\r\n", csd.iceUfrag)
+	fmt.Fprintf(&b, "a=ice-pwd:%s\r\n", csd.icePwd)
+	for i, c := range csd.candidates {
+		fmt.Fprintf(&b, "a=candidate:%s\r\n", c.marshal(i))
+	}
+	if csd.endOfCandidates {
+		fmt.Fprintf(&b, "a=end-of-candidates\r\n")
+	}
+
+	return webrtc.SessionDescription{
+		Type: csd.sdpType,
+		SDP:  b.String(),
+	}
+}
+
+// marshal returns the candidate attribute value, with the index as the foundation.
+func (c compactCandidate) marshal(index int) string {
+	network := "udp"
+	if c.tcp {
+		network = "tcp"
+	}
+	value := fmt.Sprintf("%d 1 %s %d %s %d typ %s", index+1, network, c.priority, c.address, c.port, c.typ)
+	if c.relatedAddr != "" {
+		value += fmt.Sprintf(" raddr %s rport %d", c.relatedAddr, c.relatedPort)
+	}
+	if c.tcp && c.tcpType != ice.TCPTypeUnspecified {
+		value += " tcptype " + c.tcpType.String()
+	}
+	return value
+}
+
+// MarshalBinary encodes the compact SDP as:
+//
+//	version(1) | type(1) | flags(1) | mid | ice-ufrag | ice-pwd | hash | fingerprint |
+//	sctp-port(2) | count(1) | candidates...
+//
+// where strings are prefixed with their 1-byte length, and each candidate as:
+//
+//	flags(1) | priority(4) | address | port(2) [ | related address | related port(2) ]
+//
+// where addresses are prefixed with their 1-byte length: 4 or 16 for IP addresses,
+// or the hostname length if the hostname flag is set.
+func (csd *compactSessionDescription) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(COMPACT_SDP_VERSION)
+	buf.WriteByte(byte(csd.sdpType))
+
+	flags := byte(indexOf(compactSetupRoles, csd.setup)) << compactSetupShift
+	if csd.endOfCandidates {
+		flags |= compactEndOfCandidates
+	}
+	buf.WriteByte(flags)
+
+	for _, s := range []string{csd.mid, csd.iceUfrag, csd.icePwd, csd.fingerprintHash, string(csd.fingerprint)} {
+		if err := writeString8(&buf, s); err != nil {
+			return nil, err
+		}
+	}
+	binary.Write(&buf, binary.BigEndian, csd.sctpPort) // skipcq: GSC-G104
+
+	if len(csd.candidates) > 255 {
+		return nil, fmt.Errorf("%w: too many candidates", ErrUnsupportedSDP)
+	}
+	buf.WriteByte(byte(len(csd.candidates)))
+	for _, c := range csd.candidates {
+		if err := c.writeTo(&buf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func (c compactCandidate) writeTo(buf *bytes.Buffer) error {
+	typ := indexOfCandidateType(c.typ)
+	if typ < 0 {
+		return fmt.Errorf("%w: candidate type %s", ErrUnsupportedSDP, c.typ)
+	}
+	flags := byte(typ)
+	if c.tcp {
+		flags |= compactCandidateTCP | byte(c.tcpType<<3)&compactCandidateTCPTypeMask
+	}
+	if c.relatedAddr != "" {
+		flags |= compactCandidateRelated
+	}
+	ip := net.ParseIP(c.address)
+	if ip == nil {
+		flags |= compactCandidateHostname
+	}
+	buf.WriteByte(flags)
+	binary.Write(buf, binary.BigEndian, c.priority) // skipcq: GSC-G104
+
+	if err := writeAddress(buf, c.address); err != nil {
+		return err
+	}
+	binary.Write(buf, binary.BigEndian, c.port) // skipcq: GSC-G104
+
+	if c.relatedAddr != "" {
+		if err := writeAddress(buf, c.relatedAddr); err != nil {
+			return err
+		}
+		binary.Write(buf, binary.BigEndian, c.relatedPort) // skipcq: GSC-G104
+	}
+	return nil
+}
+
+// UnmarshalBinary decodes the compact SDP encoded by MarshalBinary.
+func (csd *compactSessionDescription) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+
+	var header [3]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return ErrInvalidCompactSDP
+	}
+	if header[0] != COMPACT_SDP_VERSION {
+		return fmt.Errorf("%w: unknown version %d", ErrInvalidCompactSDP, header[0])
+	}
+	csd.sdpType = webrtc.SDPType(header[1])
+	if csd.sdpType.String() == webrtc.ErrUnknownType.Error() {
+		return fmt.Errorf("%w: unknown SDP type %d", ErrInvalidCompactSDP, header[1])
+	}
+	setup := int(header[2]>>compactSetupShift) & 0x03
+	if setup >= len(compactSetupRoles) {
+		return fmt.Errorf("%w: unknown setup role %d", ErrInvalidCompactSDP, setup)
+	}
+	csd.setup = compactSetupRoles[setup]
+	csd.endOfCandidates = header[2]&compactEndOfCandidates != 0
+
+	var fingerprint string
+	for _, s := range []*string{&csd.mid, &csd.iceUfrag, &csd.icePwd, &csd.fingerprintHash, &fingerprint} {
+		var err error
+		if *s, err = readString8(r); err != nil {
+			return err
+		}
+	}
+	csd.fingerprint = []byte(fingerprint)
+	if len(csd.fingerprint) < 4 {
+		return fmt.Errorf("%w: fingerprint too short", ErrInvalidCompactSDP)
+	}
+
+	if err := binary.Read(r, binary.BigEndian, &csd.sctpPort); err != nil {
+		return ErrInvalidCompactSDP
+	}
+
+	count, err := r.ReadByte()
+	if err != nil {
+		return ErrInvalidCompactSDP
+	}
+	csd.candidates = make([]compactCandidate, count)
+	for i := range csd.candidates {
+		if err := csd.candidates[i].readFrom(r); err != nil {
+			return err
+		}
+	}
+
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: trailing bytes", ErrInvalidCompactSDP)
+	}
+	return nil
+}
+
+func (c *compactCandidate) readFrom(r *bytes.Reader) error {
+	flags, err := r.ReadByte()
+	if err != nil {
+		return ErrInvalidCompactSDP
+	}
+	c.typ = compactCandidateTypes[flags&compactCandidateTypeMask]
+	c.tcp = flags&compactCandidateTCP != 0
+	c.tcpType = ice.TCPType((flags & compactCandidateTCPTypeMask) >> 3)
+
+	if err := binary.Read(r, binary.BigEndian, &c.priority); err != nil {
+		return ErrInvalidCompactSDP
+	}
+	if c.address, err = readAddress(r, flags&compactCandidateHostname != 0); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.BigEndian, &c.port); err != nil {
+		return ErrInvalidCompactSDP
+	}
+
+	if flags&compactCandidateRelated != 0 {
+		if c.relatedAddr, err = readAddress(r, false); err != nil {
+			return err
+		}
+		if err := binary.Read(r, binary.BigEndian, &c.relatedPort); err != nil {
+			return ErrInvalidCompactSDP
+		}
+	}
+	return nil
+}
+
+func writeString8(buf *bytes.Buffer, s string) error {
+	if len(s) > 255 {
+		return fmt.Errorf("%w: %q too long", ErrUnsupportedSDP, s)
+	}
+	buf.WriteByte(byte(len(s)))
+	buf.WriteString(s)
+	return nil
+}
+
+func readString8(r *bytes.Reader) (string, error) {
+	n, err := r.ReadByte()
+	if err != nil {
+		return "", ErrInvalidCompactSDP
+	}
+	s := make([]byte, n)
+	if _, err := io.ReadFull(r, s); err != nil {
+		return "", ErrInvalidCompactSDP
+	}
+	return string(s), nil
+}
+
+// writeAddress writes an IP address in its shortest binary form, or a hostname as is.
+func writeAddress(buf *bytes.Buffer, address string) error {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return writeString8(buf, address)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return writeString8(buf, string(ip))
+}
+
+func readAddress(r *bytes.Reader, hostname bool) (string, error) {
+	s, err := readString8(r)
+	if err != nil {
+		return "", err
+	}
+	if hostname {
+		return s, nil
+	}
+	if len(s) != net.IPv4len && len(s) != net.IPv6len {
+		return "", fmt.Errorf("%w: invalid IP address", ErrInvalidCompactSDP)
+	}
+	return net.IP(s).String(), nil
+}
+
+// fingerprintString formats the digest as colon-separated uppercase hex.
+func fingerprintString(digest []byte) string {
+	hexDigest := strings.ToUpper(hex.EncodeToString(digest))
+	parts := make([]string, 0, len(digest))
+	for i := 0; i < len(hexDigest); i += 2 {
+		parts = append(parts, hexDigest[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+func indexOf(list []string, s string) int {
+	for i := range list {
+		if list[i] == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexOfCandidateType(typ ice.CandidateType) int {
+	for i := range compactCandidateTypes {
+		if compactCandidateTypes[i] == typ {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,376 @@
+package transportc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	SECURE_SIGNAL_VERSION         = 1
+	SECURE_SIGNAL_MAX_AGE_DEFAULT = 2 * time.Minute
+	SECURE_SIGNAL_SESSION_TTL     = 10 * time.Minute
+)
+
+var (
+	ErrSignalAuthentication = errors.New("signal message failed authentication")
+	ErrSignalReplay         = errors.New("signal message stale or replayed")
+	ErrUnknownSignalPeer    = errors.New("signal message from unknown peer")
+)
+
+// secure envelope modes
+const (
+	secureModePSK byte = 1
+	secureModeBox byte = 2
+)
+
+// secure message kinds, sealed with the payload. The candidates of either
+// peer are told apart, so none is accepted when reflected back to its sender.
+const (
+	secureKindOffer byte = iota + 1
+	secureKindAnswer
+	secureKindOffererCandidate
+	secureKindAnswererCandidate
+)
+
+const (
+	secureNonceSize  = 24
+	secureKeySize    = 32
+	secureHeaderSize = 1 + 8 + secureNonceSize // kind | timestamp | binding, sealed
+)
+
+// SecureSignal wraps a Signal to seal each offer and answer (and candidate, if the
+// Signal implements TrickleSignal) in an authenticated and encrypted envelope, so that
+// the rendezvous can neither read nor substitute them, e.g. to swap the DTLS fingerprint
+// and MITM the DataChannel.
+//
+// Messages are sealed with NaCl secretbox under a pre-shared key, or NaCl box between
+// key pairs. Answers and candidates are bound to the offer they belong to and to the
+// peer sending them, and each message is accepted at most once within
+// SECURE_SIGNAL_MAX_AGE_DEFAULT of its creation, and never by its sender.
+// Both peers MUST use a SecureSignal in the same mode.
+type SecureSignal struct {
+	Signal
+
+	psk        *[secureKeySize]byte
+	publicKey  *[secureKeySize]byte
+	privateKey *[secureKeySize]byte
+	peerKeys   []*[secureKeySize]byte
+
+	mutex    sync.Mutex
+	sessions map[uint64]*secureSession           // offerID:session
+	seen     map[[secureNonceSize]byte]time.Time // nonce:expiry of messages accepted
+}
+
+// secureSession binds the messages following an offer to that offer.
+type secureSession struct {
+	binding [secureNonceSize]byte // nonce of the offer
+	peerKey *[secureKeySize]byte  // box mode only
+	expires time.Time
+}
+
+// secureTrickleSignal is a SecureSignal wrapping a TrickleSignal.
+type secureTrickleSignal struct {
+	*SecureSignal
+	trickle TrickleSignal
+}
+
+// NewSecureSignalPSK wraps the Signal with a SecureSignal sealing messages under
+// a key derived from the pre-shared key. If the Signal implements TrickleSignal,
+// so does the returned Signal.
+func NewSecureSignalPSK(s Signal, psk []byte) Signal {
+	key := sha256.Sum256(psk)
+	ss := newSecureSignal(s)
+	ss.psk = &key
+	return ss.wrap()
+}
+
+// NewSecureSignalBox wraps the Signal with a SecureSignal sealing messages between
+// the key pair and the peer public keys, generated with GenerateSignalKeyPair.
+// If the Signal implements TrickleSignal, so does the returned Signal.
+//
+// Only messages from one of the peer public keys are accepted. Offers are sealed
+// to the first peer public key, answers to the public key of the offerer.
+func NewSecureSignalBox(s Signal, publicKey, privateKey *[32]byte, peerPublicKeys ...*[32]byte) Signal {
+	ss := newSecureSignal(s)
+	ss.publicKey = publicKey
+	ss.privateKey = privateKey
+	ss.peerKeys = peerPublicKeys
+	return ss.wrap()
+}
+
+// GenerateSignalKeyPair generates a key pair for NewSecureSignalBox.
+func GenerateSignalKeyPair() (publicKey, privateKey *[32]byte, err error) {
+	return box.GenerateKey(rand.Reader)
+}
+
+func newSecureSignal(s Signal) *SecureSignal {
+	return &SecureSignal{
+		Signal:   s,
+		sessions: make(map[uint64]*secureSession),
+		seen:     make(map[[secureNonceSize]byte]time.Time),
+	}
+}
+
+func (ss *SecureSignal) wrap() Signal {
+	if ts, ok := ss.Signal.(TrickleSignal); ok {
+		return &secureTrickleSignal{SecureSignal: ss, trickle: ts}
+	}
+	return ss
+}
+
+// Offer implements Signal.Offer.
+func (ss *SecureSignal) Offer(offer []byte) (uint64, error) {
+	var peerKey *[secureKeySize]byte
+	if ss.privateKey != nil {
+		if len(ss.peerKeys) == 0 {
+			return 0, fmt.Errorf("%w: no peer public key to seal the offer to", ErrUnknownSignalPeer)
+		}
+		peerKey = ss.peerKeys[0]
+	}
+
+	envelope, nonce, err := ss.seal(secureKindOffer, [secureNonceSize]byte{}, peerKey, offer)
+	if err != nil {
+		return 0, err
+	}
+
+	offerID, err := ss.Signal.Offer(envelope)
+	if err != nil {
+		return 0, err
+	}
+	ss.putSession(offerID, nonce, peerKey)
+	return offerID, nil
+}
+
+// ReadOffer implements Signal.ReadOffer.
+func (ss *SecureSignal) ReadOffer() (uint64, []byte, error) {
+	offerID, envelope, err := ss.Signal.ReadOffer()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	offer, nonce, peerKey, err := ss.open(secureKindOffer, nil, envelope)
+	if err != nil {
+		return 0, nil, err
+	}
+	ss.putSession(offerID, nonce, peerKey)
+	return offerID, offer, nil
+}
+
+// Answer implements Signal.Answer.
+func (ss *SecureSignal) Answer(offerID uint64, answer []byte) error {
+	session, err := ss.session(offerID)
+	if err != nil {
+		return err
+	}
+	envelope, _, err := ss.seal(secureKindAnswer, session.binding, session.peerKey, answer)
+	if err != nil {
+		return err
+	}
+	return ss.Signal.Answer(offerID, envelope)
+}
+
+// ReadAnswer implements Signal.ReadAnswer.
+func (ss *SecureSignal) ReadAnswer(offerID uint64) ([]byte, error) {
+	session, err := ss.session(offerID)
+	if err != nil {
+		return nil, err
+	}
+	envelope, err := ss.Signal.ReadAnswer(offerID)
+	if err != nil {
+		return nil, err
+	}
+	answer, _, _, err := ss.open(secureKindAnswer, session, envelope)
+	return answer, err
+}
+
+// Candidate implements TrickleSignal.Candidate.
+// The end of candidates is passed through as is.
+func (sts *secureTrickleSignal) Candidate(offerID uint64, fromOfferer bool, candidate []byte) error {
+	if candidate == nil {
+		return sts.trickle.Candidate(offerID, fromOfferer, nil)
+	}
+
+	session, err := sts.session(offerID)
+	if err != nil {
+		return err
+	}
+	envelope, _, err := sts.seal(candidateKind(fromOfferer), session.binding, session.peerKey, candidate)
+	if err != nil {
+		return err
+	}
+	return sts.trickle.Candidate(offerID, fromOfferer, envelope)
+}
+
+// ReadCandidate implements TrickleSignal.ReadCandidate.
+func (sts *secureTrickleSignal) ReadCandidate(offerID uint64, fromOfferer bool) ([]byte, error) {
+	session, err := sts.session(offerID)
+	if err != nil {
+		return nil, err
+	}
+	envelope, err := sts.trickle.ReadCandidate(offerID, fromOfferer)
+	if err != nil {
+		return nil, err
+	}
+	candidate, _, _, err := sts.open(candidateKind(fromOfferer), session, envelope)
+	return candidate, err
+}
+
+func candidateKind(fromOfferer bool) byte {
+	if fromOfferer {
+		return secureKindOffererCandidate
+	}
+	return secureKindAnswererCandidate
+}
+
+// seal seals the message in an envelope:
+//
+//	version(1) | mode(1) | [sender public key(32)] | nonce(24) | sealed
+//
+// where sealed is the kind(1), timestamp(8) and binding(24) followed by the message.
+func (ss *SecureSignal) seal(kind byte, binding [secureNonceSize]byte, peerKey *[secureKeySize]byte, msg []byte) ([]byte, [secureNonceSize]byte, error) {
+	var nonce [secureNonceSize]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, nonce, err
+	}
+	// Own messages are never accepted, e.g. reflected by the rendezvous
+	if err := ss.checkReplay(nonce, time.Now()); err != nil {
+		return nil, nonce, err
+	}
+
+	plaintext := make([]byte, 0, secureHeaderSize+len(msg))
+	plaintext = append(plaintext, kind)
+	plaintext = binary.BigEndian.AppendUint64(plaintext, uint64(time.Now().Unix()))
+	plaintext = append(plaintext, binding[:]...)
+	plaintext = append(plaintext, msg...)
+
+	envelope := []byte{SECURE_SIGNAL_VERSION}
+	if ss.psk != nil {
+		envelope = append(envelope, secureModePSK)
+		envelope = append(envelope, nonce[:]...)
+		return secretbox.Seal(envelope, plaintext, &nonce, ss.psk), nonce, nil
+	}
+	envelope = append(envelope, secureModeBox)
+	envelope = append(envelope, ss.publicKey[:]...)
+	envelope = append(envelope, nonce[:]...)
+	return box.Seal(envelope, plaintext, &nonce, peerKey, ss.privateKey), nonce, nil
+}
+
+// open authenticates and decrypts the envelope of the expected kind, bound to the session
+// if not nil. It returns the message, the nonce and the public key of the sender.
+func (ss *SecureSignal) open(kind byte, session *secureSession, envelope []byte) ([]byte, [secureNonceSize]byte, *[secureKeySize]byte, error) {
+	var nonce [secureNonceSize]byte
+	if len(envelope) < 2 || envelope[0] != SECURE_SIGNAL_VERSION {
+		return nil, nonce, nil, ErrSignalAuthentication
+	}
+
+	mode, rest := envelope[1], envelope[2:]
+	var peerKey *[secureKeySize]byte
+	var plaintext []byte
+	var ok bool
+	switch {
+	case mode == secureModePSK && ss.psk != nil:
+		if len(rest) < secureNonceSize {
+			return nil, nonce, nil, ErrSignalAuthentication
+		}
+		copy(nonce[:], rest)
+		plaintext, ok = secretbox.Open(nil, rest[secureNonceSize:], &nonce, ss.psk)
+	case mode == secureModeBox && ss.privateKey != nil:
+		if len(rest) < secureKeySize+secureNonceSize {
+			return nil, nonce, nil, ErrSignalAuthentication
+		}
+		peerKey = ss.knownPeerKey(rest[:secureKeySize])
+		if peerKey == nil {
+			return nil, nonce, nil, ErrUnknownSignalPeer
+		}
+		if session != nil && session.peerKey != peerKey {
+			return nil, nonce, nil, ErrUnknownSignalPeer
+		}
+		copy(nonce[:], rest[secureKeySize:])
+		plaintext, ok = box.Open(nil, rest[secureKeySize+secureNonceSize:], &nonce, peerKey, ss.privateKey)
+	}
+	if !ok || len(plaintext) < secureHeaderSize || plaintext[0] != kind {
+		return nil, nonce, nil, ErrSignalAuthentication
+	}
+
+	var binding [secureNonceSize]byte
+	copy(binding[:], plaintext[9:secureHeaderSize])
+	if session != nil && binding != session.binding {
+		return nil, nonce, nil, ErrSignalAuthentication
+	}
+
+	timestamp := time.Unix(int64(binary.BigEndian.Uint64(plaintext[1:9])), 0)
+	if err := ss.checkReplay(nonce, timestamp); err != nil {
+		return nil, nonce, nil, err
+	}
+	return plaintext[secureHeaderSize:], nonce, peerKey, nil
+}
+
+// checkReplay rejects messages out of the max age (in either direction, to tolerate
+// clock skew) or accepted before.
+func (ss *SecureSignal) checkReplay(nonce [secureNonceSize]byte, timestamp time.Time) error {
+	now := time.Now()
+	if timestamp.Before(now.Add(-SECURE_SIGNAL_MAX_AGE_DEFAULT)) || timestamp.After(now.Add(SECURE_SIGNAL_MAX_AGE_DEFAULT)) {
+		return ErrSignalReplay
+	}
+
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	for n, expires := range ss.seen {
+		if now.After(expires) {
+			delete(ss.seen, n)
+		}
+	}
+	if _, ok := ss.seen[nonce]; ok {
+		return ErrSignalReplay
+	}
+	ss.seen[nonce] = timestamp.Add(2 * SECURE_SIGNAL_MAX_AGE_DEFAULT)
+	return nil
+}
+
+func (ss *SecureSignal) knownPeerKey(key []byte) *[secureKeySize]byte {
+	for _, peerKey := range ss.peerKeys {
+		if bytes.Equal(peerKey[:], key) {
+			return peerKey
+		}
+	}
+	return nil
+}
+
+func (ss *SecureSignal) putSession(offerID uint64, binding [secureNonceSize]byte, peerKey *[secureKeySize]byte) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	now := time.Now()
+	for id, session := range ss.sessions {
+		if now.After(session.expires) {
+			delete(ss.sessions, id)
+		}
+	}
+	ss.sessions[offerID] = &secureSession{
+		binding: binding,
+		peerKey: peerKey,
+		expires: now.Add(SECURE_SIGNAL_SESSION_TTL),
+	}
+}
+
+func (ss *SecureSignal) session(offerID uint64) (*secureSession, error) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	session, ok := ss.sessions[offerID]
+	if !ok {
+		return nil, ErrInvalidOfferID
+	}
+	return session, nil
+}
+
+var _ TrickleSignal = (*secureTrickleSignal)(nil)
@@ -1,15 +1,15 @@
 package transportc
 
 import (
-	"crypto/rand"
+	"context"
 	"errors"
-	"math"
-	"math/big"
-	mrand "math/rand"
-	"sync"
 	"time"
 )
 
+const (
+	DEBUG_SIGNAL_READ_TIMEOUT_DEFAULT = 5 * time.Second
+)
+
 var (
 	// ErrOfferNotReady is returned by ReadOffer when no offer is available.
 	ErrOfferNotReady = errors.New("offer not ready")
@@ -20,6 +20,10 @@ var (
 	// ErrAnswerNotReady is returned by ReadAnswer when the offerID is valid but
 	// an associated answer is not received yet.
 	ErrAnswerNotReady = errors.New("answer not ready")
+
+	// ErrCandidateNotReady is returned by ReadCandidate when the offerID is valid but
+	// no new candidate is received yet.
+	ErrCandidateNotReady = errors.New("candidate not ready")
 )
 
 // Signal defines the interface for signalling, i.e., exchanging SDP offers and answers
@@ -34,7 +38,8 @@ type Signal interface {
 	// ReadOffer reads the next SDP offer from the answerer.
 	//
 	// If no offer is available, ReadOffer may block until an offer is available
-	// or return ErrOfferNotReady.
+	// or return ErrOfferNotReady. Once no offer can be read anymore, it returns
+	// ErrSignalClosed, possibly wrapped, and the Listener stops reading offers.
 	ReadOffer() (offerID uint64, offer []byte, err error)
 
 	// Answer submits a SDP answer generated by answerer to be read by the offerer.
@@ -50,86 +55,82 @@ type Signal interface {
 	ReadAnswer(offerID uint64) ([]byte, error)
 }
 
+// TrickleSignal is implemented by Signals also capable of exchanging ICE candidates
+// after the offer and answer, i.e., Trickle ICE as defined in RFC 8838.
+//
+// If the Signal set in Config implements TrickleSignal, Dialer and Listener submit
+// the SDP offer/answer without waiting for the ICE gathering to complete and
+// exchange the candidates as they are gathered.
+type TrickleSignal interface {
+	Signal
+
+	// Candidate submits an ICE candidate gathered by the offerer (if fromOfferer is set)
+	// or the answerer of the offer to be read by the other peer.
+	//
+	// A nil candidate indicates the end of candidates.
+	Candidate(offerID uint64, fromOfferer bool, candidate []byte) error
+
+	// ReadCandidate reads the next ICE candidate for the offer submitted by the offerer
+	// (if fromOfferer is set) or the answerer.
+	//
+	// It returns io.EOF after the end of candidates. If no candidate is available,
+	// ReadCandidate may block until one is available or return ErrCandidateNotReady.
+	ReadCandidate(offerID uint64, fromOfferer bool) ([]byte, error)
+}
+
 // DebugSignal implements a minimalistic signaling method used for debugging purposes.
+//
+// Offers and answers not read within the TTL (SIGNAL_OFFER_TTL_DEFAULT unless changed
+// with SetTTL) expire.
 type DebugSignal struct {
-	offers      chan offer
-	answers     map[uint64][]byte
-	answerMutex sync.Mutex
-}
+	// ReadTimeout is the max duration ReadAnswer waits for the answer before
+	// returning ErrAnswerNotReady.
+	ReadTimeout time.Duration
 
-type offer struct {
-	id   uint64
-	body []byte
+	store *memorySignalStore
 }
 
-// NewDebugSignal creates a new DebugSignal.
+// NewDebugSignal creates a new DebugSignal queuing up to bufferSize offers not read
+// yet, or unlimited if bufferSize is 0.
 func NewDebugSignal(bufferSize int) *DebugSignal {
+	store := newMemorySignalStore(SIGNAL_OFFER_TTL_DEFAULT)
+	store.capacity = bufferSize
 	return &DebugSignal{
-		offers:  make(chan offer, bufferSize),
-		answers: make(map[uint64][]byte),
+		ReadTimeout: DEBUG_SIGNAL_READ_TIMEOUT_DEFAULT,
+		store:       store,
 	}
 }
 
+// SetTTL sets the TTL of the offers and answers submitted from now on.
+func (ds *DebugSignal) SetTTL(ttl time.Duration) {
+	ds.store.setTTL(ttl)
+}
+
 // Offer implements Signal.Offer.
-// It writes the SDP offer to offers channel.
+// It queues the SDP offer without blocking, or returns ErrOfferQueueFull if
+// bufferSize offers are not read yet.
 func (ds *DebugSignal) Offer(offerBody []byte) (uint64, error) {
-	var id uint64
-	n := new(big.Int)
-	randID, err := rand.Int(rand.Reader, n.SetUint64(math.MaxUint64))
-	if err != nil { // fallback to math/rand if crypto/rand fails
-		id = mrand.Uint64() // skipcq: GSC-G404
-	} else {
-		id = randID.Uint64()
-	}
-
-	ds.offers <- offer{
-		id:   id,
-		body: offerBody,
-	}
-	return id, nil
+	return ds.store.PutOffer(offerBody)
 }
 
 // ReadOffer implements Signal.ReadOffer
-// It reads the SDP offer from offers channel.
+// It returns the next SDP offer queued, or ErrOfferNotReady if none.
 func (ds *DebugSignal) ReadOffer() (uint64, []byte, error) {
-	if len(ds.offers) == 0 {
-		return 0, nil, ErrOfferNotReady
-	}
-	offer := <-ds.offers
-	return offer.id, offer.body, nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // don't wait for an offer
+	return ds.store.TakeOffer(ctx)
 }
 
 // Answer implements Signal.Answer.
-// It writes the SDP answer to answers channel.
+// It stores the SDP answer to an offer read.
 func (ds *DebugSignal) Answer(offerID uint64, answer []byte) error {
-	ds.answerMutex.Lock()
-	defer ds.answerMutex.Unlock()
-
-	// make sure the offerID is unique
-	if _, ok := ds.answers[offerID]; ok {
-		return ErrInvalidOfferID // offerID already used
-	}
-
-	ds.answers[offerID] = answer
-	return nil
+	return ds.store.PutAnswer(offerID, answer)
 }
 
 // ReadAnswer implements Signal.ReadAnswer
-// It reads the SDP answer from answers channel.
+// It waits up to ReadTimeout for the SDP answer before returning ErrAnswerNotReady.
 func (ds *DebugSignal) ReadAnswer(offerID uint64) ([]byte, error) {
-	ds.answerMutex.Lock()
-	defer ds.answerMutex.Unlock()
-
-	answer, ok := ds.answers[offerID]
-	for !ok { // block until the answer is available
-		ds.answerMutex.Unlock()
-		// return ErrAnswerNotReady // an alternative non-blocking behavior
-		time.Sleep(time.Millisecond * 50)
-		ds.answerMutex.Lock()
-		answer, ok = ds.answers[offerID]
-	}
-	// delete the answer so it can't be used again
-	delete(ds.answers, offerID)
-
-	return answer, nil
+	ctx, cancel := context.WithTimeout(context.Background(), ds.ReadTimeout)
+	defer cancel()
+	return ds.store.TakeAnswer(ctx, offerID)
 }
@@ -0,0 +1,213 @@
+package transportc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// SIGNAL_ROUTE_TTL_DEFAULT is how long Signal wrappers keep the state of an offer,
+	// e.g. the backend of a SignalChain it was submitted to.
+	SIGNAL_ROUTE_TTL_DEFAULT = 10 * time.Minute
+)
+
+var (
+	ErrNoSignalBackend = errors.New("no signal backend")
+)
+
+// SignalChain tries multiple Signal backends in order, e.g. a WebSocketSignal falling
+// back to an HTTPSignal where WebSocket is blocked.
+//
+// Offer submits the offer to the first backend accepting it and the answer is read
+// from the same backend. ReadOffer reads from the backends in order and returns the
+// first offer available, which is answered through the backend it was read from.
+// Since the backends are read one after another, long-polling backends SHOULD have
+// a short PollTimeout.
+type SignalChain struct {
+	backends []Signal
+
+	mutex    sync.Mutex
+	offers   map[uint64]*signalRoute // offers submitted, offerID:route
+	received map[uint64]*signalRoute // offers read, offerID:route
+}
+
+// signalRoute is the backend an offer was submitted to or read from.
+type signalRoute struct {
+	backend Signal
+	done    bool // answer read (offers) or submitted (received)
+	expires time.Time
+}
+
+// signalTrickleChain is a SignalChain of TrickleSignals. The candidates are exchanged
+// through the backend of the offer.
+type signalTrickleChain struct {
+	*SignalChain
+}
+
+// NewSignalChain creates a SignalChain of the backends, tried in order. If all
+// backends implement TrickleSignal, so does the returned Signal.
+func NewSignalChain(backends ...Signal) Signal {
+	sc := &SignalChain{
+		backends: backends,
+		offers:   make(map[uint64]*signalRoute),
+		received: make(map[uint64]*signalRoute),
+	}
+	if len(backends) == 0 {
+		return sc
+	}
+	for _, backend := range backends {
+		if _, ok := backend.(TrickleSignal); !ok {
+			return sc
+		}
+	}
+	return &signalTrickleChain{SignalChain: sc}
+}
+
+// Offer implements Signal.Offer.
+// It submits the offer to the first backend accepting it.
+func (sc *SignalChain) Offer(offer []byte) (uint64, error) {
+	err := ErrNoSignalBackend
+	for i, backend := range sc.backends {
+		var offerID uint64
+		offerID, err = backend.Offer(offer)
+		if err != nil {
+			err = fmt.Errorf("signal backend %d: %w", i, err)
+			continue
+		}
+
+		sc.mutex.Lock()
+		sc.expire()
+		sc.offers[offerID] = &signalRoute{
+			backend: backend,
+			expires: time.Now().Add(SIGNAL_ROUTE_TTL_DEFAULT),
+		}
+		sc.mutex.Unlock()
+		return offerID, nil
+	}
+	return 0, err
+}
+
+// ReadOffer implements Signal.ReadOffer.
+// It returns the first offer available from the backends in order. ErrOfferNotReady
+// is returned if any backend has no offer available and the others failed.
+func (sc *SignalChain) ReadOffer() (uint64, []byte, error) {
+	err := ErrNoSignalBackend
+	notReady := false
+	for i, backend := range sc.backends {
+		offerID, offer, readErr := backend.ReadOffer()
+		if readErr != nil {
+			if errors.Is(readErr, ErrOfferNotReady) {
+				notReady = true
+			} else {
+				err = fmt.Errorf("signal backend %d: %w", i, readErr)
+			}
+			continue
+		}
+
+		sc.mutex.Lock()
+		sc.expire()
+		sc.received[offerID] = &signalRoute{
+			backend: backend,
+			expires: time.Now().Add(SIGNAL_ROUTE_TTL_DEFAULT),
+		}
+		sc.mutex.Unlock()
+		return offerID, offer, nil
+	}
+
+	if notReady {
+		return 0, nil, ErrOfferNotReady
+	}
+	return 0, nil, err
+}
+
+// Answer implements Signal.Answer.
+// It submits the answer to the backend the offer was read from.
+func (sc *SignalChain) Answer(offerID uint64, answer []byte) error {
+	sc.mutex.Lock()
+	route, ok := sc.received[offerID]
+	ok = ok && !route.done
+	sc.mutex.Unlock()
+	if !ok {
+		return ErrInvalidOfferID
+	}
+
+	err := route.backend.Answer(offerID, answer)
+	if err == nil || errors.Is(err, ErrInvalidOfferID) {
+		sc.mutex.Lock()
+		route.done = true
+		sc.mutex.Unlock()
+	}
+	return err
+}
+
+// ReadAnswer implements Signal.ReadAnswer.
+// It reads the answer from the backend the offer was submitted to.
+func (sc *SignalChain) ReadAnswer(offerID uint64) ([]byte, error) {
+	sc.mutex.Lock()
+	route, ok := sc.offers[offerID]
+	ok = ok && !route.done
+	sc.mutex.Unlock()
+	if !ok {
+		return nil, ErrInvalidOfferID
+	}
+
+	answer, err := route.backend.ReadAnswer(offerID)
+	if err == nil || errors.Is(err, ErrInvalidOfferID) {
+		sc.mutex.Lock()
+		route.done = true
+		sc.mutex.Unlock()
+	}
+	return answer, err
+}
+
+// Candidate implements TrickleSignal.Candidate.
+func (stc *signalTrickleChain) Candidate(offerID uint64, fromOfferer bool, candidate []byte) error {
+	backend, err := stc.backend(offerID)
+	if err != nil {
+		return err
+	}
+	return backend.Candidate(offerID, fromOfferer, candidate)
+}
+
+// ReadCandidate implements TrickleSignal.ReadCandidate.
+func (stc *signalTrickleChain) ReadCandidate(offerID uint64, fromOfferer bool) ([]byte, error) {
+	backend, err := stc.backend(offerID)
+	if err != nil {
+		return nil, err
+	}
+	return backend.ReadCandidate(offerID, fromOfferer)
+}
+
+// backend returns the backend the offer was submitted to or read from.
+func (stc *signalTrickleChain) backend(offerID uint64) (TrickleSignal, error) {
+	stc.mutex.Lock()
+	defer stc.mutex.Unlock()
+
+	route, ok := stc.offers[offerID]
+	if !ok {
+		route, ok = stc.received[offerID]
+	}
+	if !ok {
+		return nil, ErrInvalidOfferID
+	}
+	return route.backend.(TrickleSignal), nil
+}
+
+// expire removes all expired routes. Caller MUST hold the mutex.
+func (sc *SignalChain) expire() {
+	now := time.Now()
+	for offerID, route := range sc.offers {
+		if !now.Before(route.expires) {
+			delete(sc.offers, offerID)
+		}
+	}
+	for offerID, route := range sc.received {
+		if !now.Before(route.expires) {
+			delete(sc.received, offerID)
+		}
+	}
+}
+
+var _ TrickleSignal = (*signalTrickleChain)(nil)
@@ -0,0 +1,308 @@
+package transportc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gaukas/transportc/internal/utils"
+)
+
+const (
+	SIGNAL_OFFER_TTL_DEFAULT   = 60 * time.Second
+	SIGNAL_STORE_POLL_INTERVAL = 50 * time.Millisecond
+)
+
+var (
+	// ErrOfferQueueFull is returned by Offer when too many offers are not read yet.
+	ErrOfferQueueFull = errors.New("offer queue full")
+)
+
+// SignalStore stores the offers and answers relayed by a signaling server, i.e.
+// HTTPSignalServer and WebSocketSignalServer.
+//
+// An offer is pending until taken, then claimed until answered. The store decides
+// when offers and answers not consumed expire.
+type SignalStore interface {
+	// PutOffer stores a new offer and returns its ID.
+	PutOffer(offer []byte) (uint64, error)
+
+	// TakeOffer returns the next pending offer and marks it claimed. It blocks until
+	// an offer is available or ctx is done, in which case ErrOfferNotReady is returned.
+	TakeOffer(ctx context.Context) (uint64, []byte, error)
+
+	// PutAnswer stores the answer to a claimed offer, or returns ErrInvalidOfferID
+	// if the offer is not claimed.
+	PutAnswer(offerID uint64, answer []byte) error
+
+	// TakeAnswer returns and removes the answer to the offer. It blocks until the
+	// answer is available or ctx is done, in which case ErrAnswerNotReady is returned.
+	TakeAnswer(ctx context.Context, offerID uint64) ([]byte, error)
+}
+
+// NewMemorySignalStore creates a SignalStore keeping the offers and answers in memory
+// for the TTL. If ttl is 0, SIGNAL_OFFER_TTL_DEFAULT is used.
+func NewMemorySignalStore(ttl time.Duration) SignalStore {
+	return newMemorySignalStore(ttl)
+}
+
+// NewSignalStore creates a SignalStore on top of a Signal, e.g. a DirSignal to keep
+// the offers and answers on disk across restarts of the server. The Signal expires
+// the offers and answers on its own.
+//
+// TakeOffer and TakeAnswer poll the Signal until ctx is done.
+func NewSignalStore(s Signal) SignalStore {
+	return &signalStore{signal: s}
+}
+
+type signalStore struct {
+	signal Signal
+}
+
+func (ss *signalStore) PutOffer(offer []byte) (uint64, error) {
+	return ss.signal.Offer(offer)
+}
+
+func (ss *signalStore) TakeOffer(ctx context.Context) (uint64, []byte, error) {
+	for {
+		id, offer, err := ss.signal.ReadOffer()
+		if !errors.Is(err, ErrOfferNotReady) {
+			return id, offer, err
+		}
+		if !pollWait(ctx) {
+			return 0, nil, ErrOfferNotReady
+		}
+	}
+}
+
+func (ss *signalStore) PutAnswer(offerID uint64, answer []byte) error {
+	return ss.signal.Answer(offerID, answer)
+}
+
+func (ss *signalStore) TakeAnswer(ctx context.Context, offerID uint64) ([]byte, error) {
+	for {
+		answer, err := ss.signal.ReadAnswer(offerID)
+		if !errors.Is(err, ErrAnswerNotReady) {
+			return answer, err
+		}
+		if !pollWait(ctx) {
+			return nil, ErrAnswerNotReady
+		}
+	}
+}
+
+// pollWait waits SIGNAL_STORE_POLL_INTERVAL and returns false if ctx is done first.
+func pollWait(ctx context.Context) bool {
+	timer := time.NewTimer(SIGNAL_STORE_POLL_INTERVAL)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// memorySignalStore keeps offers and answers in memory for signaling servers and
+// in-process Signals.
+//
+// An offer is pending until read, then claimed until answered. Offers and answers
+// not consumed within the TTL expire.
+type memorySignalStore struct {
+	ttl      time.Duration
+	capacity int // max number of pending offers, 0 for unlimited
+
+	mutex   sync.Mutex
+	pending []storedMessage          // offers not read yet, in order of arrival
+	claimed map[uint64]time.Time     // offers read but not answered, offerID:expiry
+	answers map[uint64]storedMessage // answers not read yet
+	changed chan struct{}            // closed and replaced on every update
+}
+
+type storedMessage struct {
+	id      uint64
+	body    []byte
+	expires time.Time
+}
+
+func newMemorySignalStore(ttl time.Duration) *memorySignalStore {
+	if ttl <= 0 {
+		ttl = SIGNAL_OFFER_TTL_DEFAULT
+	}
+	return &memorySignalStore{
+		ttl:     ttl,
+		claimed: make(map[uint64]time.Time),
+		answers: make(map[uint64]storedMessage),
+		changed: make(chan struct{}),
+	}
+}
+
+// setTTL sets the TTL of the offers and answers stored from now on.
+func (s *memorySignalStore) setTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = SIGNAL_OFFER_TTL_DEFAULT
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ttl = ttl
+}
+
+// PutOffer stores a new offer and returns its ID. It returns ErrOfferQueueFull if
+// the capacity is reached.
+func (s *memorySignalStore) PutOffer(offer []byte) (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire()
+
+	if s.capacity > 0 && len(s.pending) >= s.capacity {
+		return 0, ErrOfferQueueFull
+	}
+
+	var id uint64
+	for {
+		id = utils.RandUint64()
+		if !s.knownID(id) {
+			break
+		}
+	}
+
+	s.pending = append(s.pending, storedMessage{
+		id:      id,
+		body:    offer,
+		expires: time.Now().Add(s.ttl),
+	})
+	s.notify()
+	return id, nil
+}
+
+// TakeOffer returns the next pending offer and marks it claimed. It blocks until
+// an offer is available or ctx is done, in which case ErrOfferNotReady is returned.
+func (s *memorySignalStore) TakeOffer(ctx context.Context) (uint64, []byte, error) {
+	for {
+		s.mutex.Lock()
+		s.expire()
+		if len(s.pending) > 0 {
+			offer := s.pending[0]
+			s.pending = s.pending[1:]
+			s.claimed[offer.id] = time.Now().Add(s.ttl)
+			s.mutex.Unlock()
+			return offer.id, offer.body, nil
+		}
+		changed := s.changed
+		s.mutex.Unlock()
+
+		if !s.wait(ctx, changed) {
+			return 0, nil, ErrOfferNotReady
+		}
+	}
+}
+
+// PutAnswer stores the answer to a claimed offer.
+func (s *memorySignalStore) PutAnswer(offerID uint64, answer []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire()
+
+	if _, ok := s.claimed[offerID]; !ok {
+		return ErrInvalidOfferID
+	}
+	delete(s.claimed, offerID)
+	s.answers[offerID] = storedMessage{
+		id:      offerID,
+		body:    answer,
+		expires: time.Now().Add(s.ttl),
+	}
+	s.notify()
+	return nil
+}
+
+// TakeAnswer returns and removes the answer to the offer. It blocks until the answer
+// is available or ctx is done, in which case ErrAnswerNotReady is returned.
+func (s *memorySignalStore) TakeAnswer(ctx context.Context, offerID uint64) ([]byte, error) {
+	for {
+		s.mutex.Lock()
+		s.expire()
+		if answer, ok := s.answers[offerID]; ok {
+			delete(s.answers, offerID)
+			s.mutex.Unlock()
+			return answer.body, nil
+		}
+		if !s.knownID(offerID) {
+			s.mutex.Unlock()
+			return nil, ErrInvalidOfferID
+		}
+		changed := s.changed
+		s.mutex.Unlock()
+
+		if !s.wait(ctx, changed) {
+			return nil, ErrAnswerNotReady
+		}
+	}
+}
+
+// wait returns true if changed is closed before ctx is done.
+func (s *memorySignalStore) wait(ctx context.Context, changed chan struct{}) bool {
+	s.mutex.Lock()
+	ttl := s.ttl
+	s.mutex.Unlock()
+
+	timer := time.NewTimer(ttl) // wake up to expire stale entries
+	defer timer.Stop()
+	select {
+	case <-changed:
+		return true
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// knownID reports whether the offerID is pending, claimed or answered.
+// Caller MUST hold the mutex.
+func (s *memorySignalStore) knownID(offerID uint64) bool {
+	if _, ok := s.claimed[offerID]; ok {
+		return true
+	}
+	if _, ok := s.answers[offerID]; ok {
+		return true
+	}
+	for _, offer := range s.pending {
+		if offer.id == offerID {
+			return true
+		}
+	}
+	return false
+}
+
+// expire removes all expired entries. Caller MUST hold the mutex.
+func (s *memorySignalStore) expire() {
+	now := time.Now()
+
+	pending := s.pending[:0]
+	for _, offer := range s.pending {
+		if now.Before(offer.expires) {
+			pending = append(pending, offer)
+		}
+	}
+	s.pending = pending
+
+	for id, expires := range s.claimed {
+		if !now.Before(expires) {
+			delete(s.claimed, id)
+		}
+	}
+
+	for id, answer := range s.answers {
+		if !now.Before(answer.expires) {
+			delete(s.answers, id)
+		}
+	}
+}
+
+// notify wakes up all waiters. Caller MUST hold the mutex.
+func (s *memorySignalStore) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
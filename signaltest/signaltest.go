@@ -0,0 +1,516 @@
+// Package signaltest provides a conformance test suite for implementations of
+// transportc.Signal.
+package signaltest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+)
+
+const (
+	PAYLOAD_SIZE_DEFAULT       = 1024
+	LARGE_PAYLOAD_SIZE_DEFAULT = 32 * 1024
+	CONCURRENCY_DEFAULT        = 16
+	TIMEOUT_DEFAULT            = 10 * time.Second
+
+	// BLOCKING_WINDOW is how long a ReadOffer or ReadAnswer call with nothing to read
+	// may take to return ErrOfferNotReady or ErrAnswerNotReady before it is considered
+	// blocking until something is available.
+	BLOCKING_WINDOW = 500 * time.Millisecond
+
+	pollInterval = 10 * time.Millisecond
+)
+
+// Suite is the conformance test suite of a Signal implementation.
+//
+// Signal implementations differ in whether ReadOffer and ReadAnswer block or return
+// ErrOfferNotReady/ErrAnswerNotReady, and the suite accepts both. Everything else is
+// expected to behave the same:
+//   - The offer ID returned by Offer is unique and the one returned by ReadOffer.
+//   - Each offer is read exactly once and each answer is read exactly once, by the
+//     peer which submitted the offer.
+//   - Answer and ReadAnswer return ErrInvalidOfferID for offer IDs never submitted,
+//     already answered (Answer) or whose answer was already read (ReadAnswer).
+//   - Offers and answers are delivered intact, regardless of their size, and the
+//     Signal is safe for concurrent use.
+type Suite struct {
+	// Concurrency is the number of offers in flight in the concurrency test.
+	// If 0, CONCURRENCY_DEFAULT is used.
+	Concurrency int
+
+	// Equal reports whether the payload read is the payload submitted. Signals
+	// re-encoding the SDP, e.g. CompactSignal, should compare the SDP semantically.
+	// It is called concurrently and MUST NOT call t.Fatal. If nil, bytes.Equal is used.
+	Equal func(t *testing.T, submitted, read []byte) bool
+
+	// LargePayloadSize is the size of the offer and answer in the large payload test.
+	// If 0, LARGE_PAYLOAD_SIZE_DEFAULT is used.
+	LargePayloadSize int
+
+	// LocalOfferIDs is set if the offer IDs are only meaningful to the peer which got
+	// them, e.g. ManualSignal where the ID is not part of the token exchanged. The
+	// checks of offer IDs across peers are skipped.
+	LocalOfferIDs bool
+
+	// NewSignals creates the Signals of an offerer and an answerer connected to a new
+	// rendezvous. Both may be the same Signal. Use t.Cleanup to release the resources.
+	NewSignals func(t *testing.T) (offerer, answerer transportc.Signal)
+
+	// Payload returns the i-th offer (or answer) payload of approximately size bytes.
+	// Payloads MUST be distinct for distinct i. Signals accepting only SDP, e.g.
+	// ManualSignal, should return a JSON-encoded webrtc.SessionDescription of the
+	// right type. If nil, random bytes are used.
+	Payload func(t *testing.T, offer bool, i int, size int) []byte
+
+	// SingleOffer is set if the Signal handles only one offer at a time, e.g.
+	// ManualSignal. The tests with multiple offers in flight are skipped.
+	SingleOffer bool
+
+	// Timeout is the max duration any call expected to succeed may take.
+	// If 0, TIMEOUT_DEFAULT is used.
+	Timeout time.Duration
+}
+
+// TestSignal runs the conformance test suite with the default options against the
+// Signals created by newSignals.
+func TestSignal(t *testing.T, newSignals func(t *testing.T) (offerer, answerer transportc.Signal)) {
+	(&Suite{NewSignals: newSignals}).Run(t)
+}
+
+// Run runs all the conformance tests as subtests of t.
+func (s *Suite) Run(t *testing.T) {
+	if s.NewSignals == nil {
+		t.Fatal("signaltest: NewSignals not set")
+	}
+
+	t.Run("OfferAnswer", s.testOfferAnswer)
+	t.Run("NotReady", s.testNotReady)
+	t.Run("UniqueOfferIDs", s.testUniqueOfferIDs)
+	t.Run("InvalidOfferID", s.testInvalidOfferID)
+	t.Run("DoubleAnswer", s.testDoubleAnswer)
+	t.Run("Concurrency", s.testConcurrency)
+	t.Run("LargePayload", s.testLargePayload)
+}
+
+func (s *Suite) testOfferAnswer(t *testing.T) {
+	offerer, answerer := s.NewSignals(t)
+	s.exchange(t, offerer, answerer, 0, PAYLOAD_SIZE_DEFAULT)
+}
+
+func (s *Suite) testNotReady(t *testing.T) {
+	offerer, answerer := s.NewSignals(t)
+
+	// ReadOffer with no offer submitted
+	offerResults := make(chan readResult, 1)
+	go func() {
+		var r readResult
+		r.offerID, r.payload, r.err = answerer.ReadOffer()
+		offerResults <- r
+	}()
+	offerBlocked := s.returnsNotReady(t, "ReadOffer", offerResults, transportc.ErrOfferNotReady)
+
+	offerID, offer := s.offer(t, offerer, 0, PAYLOAD_SIZE_DEFAULT)
+	var r readResult
+	if offerBlocked {
+		r = s.unblocked(t, "ReadOffer", offerResults)
+	}
+	if !offerBlocked || errors.Is(r.err, transportc.ErrOfferNotReady) {
+		r.offerID, r.payload = s.readOffer(t, answerer)
+	} else if r.err != nil {
+		t.Fatalf("ReadOffer error: %v", r.err)
+	}
+	s.checkOffer(t, offerID, offer, r.offerID, r.payload)
+
+	// ReadAnswer with no answer submitted
+	answerResults := make(chan readResult, 1)
+	go func() {
+		var r readResult
+		r.payload, r.err = offerer.ReadAnswer(offerID)
+		answerResults <- r
+	}()
+	answerBlocked := s.returnsNotReady(t, "ReadAnswer", answerResults, transportc.ErrAnswerNotReady)
+
+	answer := s.answer(t, answerer, r.offerID, 0, PAYLOAD_SIZE_DEFAULT)
+	r = readResult{}
+	if answerBlocked {
+		r = s.unblocked(t, "ReadAnswer", answerResults)
+	}
+	if !answerBlocked || errors.Is(r.err, transportc.ErrAnswerNotReady) {
+		r.payload = s.readAnswer(t, offerer, offerID)
+	} else if r.err != nil {
+		t.Fatalf("ReadAnswer error: %v", r.err)
+	}
+	s.checkPayload(t, "Answer", answer, r.payload)
+}
+
+func (s *Suite) testUniqueOfferIDs(t *testing.T) {
+	if s.SingleOffer {
+		t.Skip("Signal handles only one offer at a time")
+	}
+	offerer, answerer := s.NewSignals(t)
+
+	n := s.concurrency()
+	offers := make(map[uint64][]byte, n)
+	for i := 0; i < n; i++ {
+		offerID, offer := s.offer(t, offerer, i, PAYLOAD_SIZE_DEFAULT)
+		if _, ok := offers[offerID]; ok {
+			t.Fatalf("Offer returned duplicate offer ID %d", offerID)
+		}
+		offers[offerID] = offer
+	}
+
+	for i := 0; i < n; i++ {
+		offerID, offer := s.readOffer(t, answerer)
+		if s.LocalOfferIDs {
+			continue
+		}
+		submitted, ok := offers[offerID]
+		if !ok {
+			t.Fatalf("ReadOffer returned unknown or already read offer ID %d", offerID)
+		}
+		s.checkPayload(t, "Offer", submitted, offer)
+		delete(offers, offerID)
+	}
+}
+
+func (s *Suite) testInvalidOfferID(t *testing.T) {
+	offerer, answerer := s.NewSignals(t)
+	unknownID := s.unknownOfferID(t)
+
+	if !s.LocalOfferIDs {
+		answer := s.payload(t, false, 0, PAYLOAD_SIZE_DEFAULT)
+		var err error
+		s.call(t, "Answer", func() {
+			err = answerer.Answer(unknownID, answer)
+		})
+		if !errors.Is(err, transportc.ErrInvalidOfferID) {
+			t.Fatalf("Answer to unknown offer ID returned %v, expecting ErrInvalidOfferID", err)
+		}
+	}
+
+	var err error
+	s.call(t, "ReadAnswer", func() {
+		_, err = offerer.ReadAnswer(unknownID)
+	})
+	if !errors.Is(err, transportc.ErrInvalidOfferID) {
+		t.Fatalf("ReadAnswer of unknown offer ID returned %v, expecting ErrInvalidOfferID", err)
+	}
+}
+
+func (s *Suite) testDoubleAnswer(t *testing.T) {
+	offerer, answerer := s.NewSignals(t)
+
+	offerID, offer := s.offer(t, offerer, 0, PAYLOAD_SIZE_DEFAULT)
+	readID, readOffer := s.readOffer(t, answerer)
+	s.checkOffer(t, offerID, offer, readID, readOffer)
+	answer := s.answer(t, answerer, readID, 0, PAYLOAD_SIZE_DEFAULT)
+
+	if !s.LocalOfferIDs {
+		secondAnswer := s.payload(t, false, 1, PAYLOAD_SIZE_DEFAULT)
+		var err error
+		s.call(t, "Answer", func() {
+			err = answerer.Answer(readID, secondAnswer)
+		})
+		if !errors.Is(err, transportc.ErrInvalidOfferID) {
+			t.Fatalf("Second Answer to the same offer returned %v, expecting ErrInvalidOfferID", err)
+		}
+	}
+
+	s.checkPayload(t, "Answer", answer, s.readAnswer(t, offerer, offerID))
+
+	// The answer is read only once
+	var err error
+	s.call(t, "ReadAnswer", func() {
+		_, err = offerer.ReadAnswer(offerID)
+	})
+	if !errors.Is(err, transportc.ErrInvalidOfferID) {
+		t.Fatalf("ReadAnswer of an answer already read returned %v, expecting ErrInvalidOfferID", err)
+	}
+}
+
+func (s *Suite) testConcurrency(t *testing.T) {
+	if s.SingleOffer {
+		t.Skip("Signal handles only one offer at a time")
+	}
+	offerer, answerer := s.NewSignals(t)
+
+	n := s.concurrency()
+	offers := make([][]byte, n)
+	answers := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		offers[i] = s.payload(t, true, i, PAYLOAD_SIZE_DEFAULT)
+		answers[i] = s.payload(t, false, i, PAYLOAD_SIZE_DEFAULT)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	deadline := time.Now().Add(s.timeout())
+
+	// Answerers read concurrently and answer each offer with the answer of the same index
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			offerID, offer, err := pollOffer(answerer, deadline)
+			if err != nil {
+				errs <- fmt.Errorf("ReadOffer error: %w", err)
+				return
+			}
+			for j := range offers {
+				if s.equal(t, offers[j], offer) {
+					if err := answerer.Answer(offerID, answers[j]); err != nil {
+						errs <- fmt.Errorf("Answer error: %w", err)
+					}
+					return
+				}
+			}
+			errs <- errors.New("ReadOffer returned an offer never submitted")
+		}()
+	}
+
+	// Offerers submit concurrently and each expects its own answer
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			offerID, err := offerer.Offer(offers[i])
+			if err != nil {
+				errs <- fmt.Errorf("Offer error: %w", err)
+				return
+			}
+			answer, err := pollAnswer(offerer, offerID, deadline)
+			if err != nil {
+				errs <- fmt.Errorf("ReadAnswer error: %w", err)
+				return
+			}
+			if !s.equal(t, answers[i], answer) {
+				errs <- fmt.Errorf("ReadAnswer of offer %d returned the answer to another offer", i)
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * s.timeout()):
+		t.Fatalf("%d concurrent offers not answered within %v", n, 2*s.timeout())
+	}
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func (s *Suite) testLargePayload(t *testing.T) {
+	offerer, answerer := s.NewSignals(t)
+	s.exchange(t, offerer, answerer, 0, s.largePayloadSize())
+}
+
+type readResult struct {
+	offerID uint64
+	payload []byte
+	err     error
+}
+
+// exchange submits, reads and checks an offer and its answer.
+func (s *Suite) exchange(t *testing.T, offerer, answerer transportc.Signal, i, size int) {
+	offerID, offer := s.offer(t, offerer, i, size)
+	readID, readOffer := s.readOffer(t, answerer)
+	s.checkOffer(t, offerID, offer, readID, readOffer)
+
+	answer := s.answer(t, answerer, readID, i, size)
+	s.checkPayload(t, "Answer", answer, s.readAnswer(t, offerer, offerID))
+}
+
+func (s *Suite) offer(t *testing.T, offerer transportc.Signal, i, size int) (uint64, []byte) {
+	offer := s.payload(t, true, i, size)
+	var offerID uint64
+	var err error
+	s.call(t, "Offer", func() {
+		offerID, err = offerer.Offer(offer)
+	})
+	if err != nil {
+		t.Fatalf("Offer error: %v", err)
+	}
+	return offerID, offer
+}
+
+func (s *Suite) answer(t *testing.T, answerer transportc.Signal, offerID uint64, i, size int) []byte {
+	answer := s.payload(t, false, i, size)
+	var err error
+	s.call(t, "Answer", func() {
+		err = answerer.Answer(offerID, answer)
+	})
+	if err != nil {
+		t.Fatalf("Answer error: %v", err)
+	}
+	return answer
+}
+
+// readOffer reads the next offer, retrying while ErrOfferNotReady is returned.
+func (s *Suite) readOffer(t *testing.T, answerer transportc.Signal) (uint64, []byte) {
+	var offerID uint64
+	var offer []byte
+	var err error
+	s.call(t, "ReadOffer", func() {
+		offerID, offer, err = pollOffer(answerer, time.Now().Add(s.timeout()))
+	})
+	if err != nil {
+		t.Fatalf("ReadOffer error: %v", err)
+	}
+	return offerID, offer
+}
+
+// readAnswer reads the answer, retrying while ErrAnswerNotReady is returned.
+func (s *Suite) readAnswer(t *testing.T, offerer transportc.Signal, offerID uint64) []byte {
+	var answer []byte
+	var err error
+	s.call(t, "ReadAnswer", func() {
+		answer, err = pollAnswer(offerer, offerID, time.Now().Add(s.timeout()))
+	})
+	if err != nil {
+		t.Fatalf("ReadAnswer error: %v", err)
+	}
+	return answer
+}
+
+func pollOffer(answerer transportc.Signal, deadline time.Time) (uint64, []byte, error) {
+	for {
+		offerID, offer, err := answerer.ReadOffer()
+		if !errors.Is(err, transportc.ErrOfferNotReady) || time.Now().After(deadline) {
+			return offerID, offer, err
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+func pollAnswer(offerer transportc.Signal, offerID uint64, deadline time.Time) ([]byte, error) {
+	for {
+		answer, err := offerer.ReadAnswer(offerID)
+		if !errors.Is(err, transportc.ErrAnswerNotReady) || time.Now().After(deadline) {
+			return answer, err
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// returnsNotReady reports whether the read with nothing to read is blocking, after
+// checking it returned notReady otherwise.
+func (s *Suite) returnsNotReady(t *testing.T, name string, results chan readResult, notReady error) (blocking bool) {
+	select {
+	case r := <-results:
+		if !errors.Is(r.err, notReady) {
+			t.Fatalf("%s with nothing to read returned (%v, %v), expecting %v or blocking", name, r.payload, r.err, notReady)
+		}
+		return false
+	case <-time.After(BLOCKING_WINDOW):
+		return true
+	}
+}
+
+// unblocked waits for the blocking read to return once something is available.
+func (s *Suite) unblocked(t *testing.T, name string, results chan readResult) readResult {
+	select {
+	case r := <-results:
+		return r
+	case <-time.After(s.timeout()):
+		t.Fatalf("Blocking %s not returning within %v once available", name, s.timeout())
+		return readResult{}
+	}
+}
+
+func (s *Suite) checkOffer(t *testing.T, offerID uint64, offer []byte, readID uint64, readOffer []byte) {
+	if !s.LocalOfferIDs && readID != offerID {
+		t.Fatalf("ReadOffer returned offer ID %d, expecting %d", readID, offerID)
+	}
+	s.checkPayload(t, "Offer", offer, readOffer)
+}
+
+func (s *Suite) checkPayload(t *testing.T, name string, submitted, read []byte) {
+	if !s.equal(t, submitted, read) {
+		t.Fatalf("%s of %d bytes read does not match %s of %d bytes submitted", name, len(read), name, len(submitted))
+	}
+}
+
+// call fails the test if f does not return within the timeout.
+func (s *Suite) call(t *testing.T, name string, f func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(s.timeout()):
+		t.Fatalf("%s blocked for %v", name, s.timeout())
+	}
+}
+
+// unknownOfferID returns an offer ID not submitted to any rendezvous.
+func (*Suite) unknownOfferID(t *testing.T) uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		t.Fatal(err)
+	}
+	return binary.BigEndian.Uint64(b[:]) | 1 // never 0
+}
+
+func (s *Suite) payload(t *testing.T, offer bool, i, size int) []byte {
+	if s.Payload != nil {
+		return s.Payload(t, offer, i, size)
+	}
+
+	kind := "answer"
+	if offer {
+		kind = "offer"
+	}
+	payload := []byte(fmt.Sprintf("%s %d ", kind, i))
+	if len(payload) < size {
+		random := make([]byte, size-len(payload))
+		if _, err := rand.Read(random); err != nil {
+			t.Fatal(err)
+		}
+		payload = append(payload, random...)
+	}
+	return payload
+}
+
+func (s *Suite) equal(t *testing.T, submitted, read []byte) bool {
+	if s.Equal != nil {
+		return s.Equal(t, submitted, read)
+	}
+	return bytes.Equal(submitted, read)
+}
+
+func (s *Suite) concurrency() int {
+	if s.Concurrency > 0 {
+		return s.Concurrency
+	}
+	return CONCURRENCY_DEFAULT
+}
+
+func (s *Suite) largePayloadSize() int {
+	if s.LargePayloadSize > 0 {
+		return s.LargePayloadSize
+	}
+	return LARGE_PAYLOAD_SIZE_DEFAULT
+}
+
+func (s *Suite) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return TIMEOUT_DEFAULT
+}
@@ -0,0 +1,59 @@
+package transportc_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestTransportcBench(t *testing.T) {
+	bin := buildCommand(t, "transportc")
+
+	signal := "dir:" + t.TempDir()
+	startCommand(t, bin, "bench", "-server", "-signal", signal)
+
+	var stdout bytes.Buffer
+	bench := exec.Command(bin, "bench", "-signal", signal, "-json", "-duration", "500ms", "-pings", "10",
+		"-sizes", "64,4096", "-channels", "1,2", "-modes", "reliable,unreliable")
+	bench.Stdout = &stdout
+	bench.Stderr = os.Stderr
+	if err := bench.Run(); err != nil {
+		t.Fatalf("Benchmark failed: %v", err)
+	}
+
+	var report struct {
+		Results []struct {
+			Mode             string  `json:"mode"`
+			Channels         int     `json:"channels"`
+			Size             int     `json:"size"`
+			MessagesReceived uint64  `json:"messages_received"`
+			Loss             float64 `json:"loss"`
+			Throughput       float64 `json:"throughput_bytes_per_second"`
+			Latency          struct {
+				Samples int     `json:"samples"`
+				P50     float64 `json:"p50"`
+				P99     float64 `json:"p99"`
+			} `json:"latency_ms"`
+			Error string `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("Invalid JSON output: %v\n%s", err, stdout.Bytes())
+	}
+	if len(report.Results) != 8 {
+		t.Fatalf("Got %d results, expected one per combination, i.e. 8", len(report.Results))
+	}
+	for _, r := range report.Results {
+		if r.Error != "" {
+			t.Fatalf("%s with %d DataChannels and %d-byte messages failed: %s", r.Mode, r.Channels, r.Size, r.Error)
+		}
+		if r.MessagesReceived == 0 || r.Throughput <= 0 || r.Latency.Samples == 0 || r.Latency.P50 > r.Latency.P99 {
+			t.Fatalf("Unexpected result %+v", r)
+		}
+		if r.Mode == "reliable" && r.Loss != 0 {
+			t.Fatalf("Reliable DataChannels lost %.2f%% of the messages", 100*r.Loss)
+		}
+	}
+}
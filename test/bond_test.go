@@ -0,0 +1,175 @@
+package transportc_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+)
+
+func TestBondedConn(t *testing.T) {
+	config := &transportc.Config{
+		Signal: transportc.NewDebugSignal(8),
+	}
+
+	// Setup a listener to accept the connection first
+	listener, err := config.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Start()
+	bondListener := transportc.NewBondListener(listener)
+	defer bondListener.Close()
+
+	// Two paths, each on its own PeerConnection
+	dialer, err := config.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer2, err := config.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bondDialer, err := transportc.NewBondDialer(dialer, dialer2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bondDialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	cConn, err := bondDialer.DialContext(ctx, "RANDOM_LABEL")
+	if err != nil {
+		t.Fatalf("DialContext error: %v", err)
+	}
+	cBond := cConn.(*transportc.BondedConn)
+	if n := cBond.NumPaths(); n != 2 {
+		t.Fatalf("BondedConn has %d paths, expected 2", n)
+	}
+
+	sConn, err := bondListener.Accept()
+	if err != nil {
+		t.Fatalf("Accept error: %v", err)
+	}
+	defer sConn.Close() // skipcq: GO-S2307
+
+	// The BondedConn is accepted with its first path, wait for the other one
+	sBond := sConn.(*transportc.BondedConn)
+	for sBond.NumPaths() != 2 {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Accepted BondedConn has %d paths, expected 2", sBond.NumPaths())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	msg := make([]byte, 2*1024*1024)
+	rand.Read(msg)
+
+	recvChan := make(chan []byte)
+	go func() {
+		recv, _ := io.ReadAll(sConn)
+		recvChan <- recv
+	}()
+
+	// Write the first half over both paths
+	_, err = cConn.Write(msg[:len(msg)/2])
+	if err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+
+	// Kill the second path by closing its PeerConnection
+	dialer2.Close()
+
+	// Write the second half, which must survive on the remaining path
+	_, err = cConn.Write(msg[len(msg)/2:])
+	if err != nil {
+		t.Fatalf("Write error after losing a path: %v", err)
+	}
+	if n := cBond.NumPaths(); n != 1 {
+		t.Fatalf("BondedConn has %d paths, expected 1", n)
+	}
+	cConn.Close()
+
+	select {
+	case recv := <-recvChan:
+		if !bytes.Equal(recv, msg) {
+			t.Fatalf("Received %d bytes not matching the %d bytes sent", len(recv), len(msg))
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timeout waiting for the bonded stream")
+	}
+}
+
+func TestBondedConnReceiveWindow(t *testing.T) {
+	config := &transportc.Config{
+		Signal: transportc.NewDebugSignal(8),
+	}
+
+	listener, err := config.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Start()
+	bondListener := transportc.NewBondListener(listener)
+	defer bondListener.Close()
+
+	dialer, err := config.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bondDialer, err := transportc.NewBondDialer(dialer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bondDialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	cConn, err := bondDialer.DialContext(ctx, "RANDOM_LABEL")
+	if err != nil {
+		t.Fatalf("DialContext error: %v", err)
+	}
+	defer cConn.Close()
+
+	sConn, err := bondListener.Accept()
+	if err != nil {
+		t.Fatalf("Accept error: %v", err)
+	}
+	defer sConn.Close() // skipcq: GO-S2307
+
+	// Nothing reads: the writer stalls once a window is in flight and another is unread
+	msg := make([]byte, 4*transportc.BOND_SEND_WINDOW)
+	rand.Read(msg)
+	var written int
+	for written < len(msg) {
+		cConn.SetWriteDeadline(time.Now().Add(2 * time.Second)) // skipcq: GSC-G104
+		n, err := cConn.Write(msg[written : written+transportc.BOND_SEGMENT_SIZE])
+		written += n
+		if err != nil {
+			break
+		}
+	}
+	if written == len(msg) {
+		t.Fatal("Write of 4 windows succeeded with no reader")
+	}
+	if written > 2*transportc.BOND_SEND_WINDOW {
+		t.Fatalf("Wrote %d bytes with no reader, expected at most %d", written, 2*transportc.BOND_SEND_WINDOW)
+	}
+
+	// Everything written is delivered once read
+	recv := make([]byte, written)
+	sConn.SetReadDeadline(time.Now().Add(20 * time.Second)) // skipcq: GSC-G104
+	if _, err = io.ReadFull(sConn, recv); err != nil {
+		t.Fatalf("ReadFull error: %v", err)
+	}
+	if !bytes.Equal(recv, msg[:written]) {
+		t.Fatalf("Received %d bytes not matching the %d bytes sent", len(recv), written)
+	}
+}
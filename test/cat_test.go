@@ -0,0 +1,92 @@
+package transportc_test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestTransportcCat(t *testing.T) {
+	bin := buildCommand(t, "transportc")
+
+	t.Run("Ordered", func(t *testing.T) {
+		payload := make([]byte, 1<<20)
+		rand.Read(payload) // skipcq: GSC-G104
+		testCat(t, bin, payload)
+	})
+	t.Run("Unordered", func(t *testing.T) {
+		// A single message, as unordered messages may be reordered
+		testCat(t, bin, []byte("HELLO UNORDERED"), "-unordered")
+	})
+}
+
+// testCat sends a greeting from the listening cat to the dialing cat, which then
+// sends the payload and closes the Conn on EOF.
+func testCat(t *testing.T, bin string, payload []byte, args ...string) {
+	args = append([]string{"cat", "-signal", "dir:" + t.TempDir()}, args...)
+
+	var received bytes.Buffer
+	listen := exec.Command(bin, append(args, "-l")...)
+	listen.Stdin = bytes.NewReader([]byte("HELLO\n"))
+	listen.Stdout = &received
+	listen.Stderr = os.Stderr
+	if err := listen.Start(); err != nil {
+		t.Fatal(err)
+	}
+	listenDone := make(chan error, 1)
+	go func() { listenDone <- listen.Wait() }()
+	defer listen.Process.Kill() // skipcq: GSC-G104
+
+	dial := exec.Command(bin, append(args, "-N")...)
+	stdin, err := dial.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := dial.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial.Stderr = os.Stderr
+	if err := dial.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer dial.Process.Kill() // skipcq: GSC-G104
+
+	greeting := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(stdout).ReadString('\n')
+		greeting <- line
+	}()
+	select {
+	case line := <-greeting:
+		if line != "HELLO\n" {
+			t.Fatalf("Received greeting %q, expected %q", line, "HELLO\n")
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatalf("No greeting received")
+	}
+
+	if _, err := stdin.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	stdin.Close()
+
+	select {
+	case err := <-listenDone:
+		if err != nil {
+			t.Fatalf("Listening cat failed: %v", err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatalf("Listening cat did not exit once the Conn closed")
+	}
+	if !bytes.Equal(received.Bytes(), payload) {
+		t.Fatalf("Received %d bytes, not matching the %d bytes sent", received.Len(), len(payload))
+	}
+	if err := dial.Wait(); err != nil {
+		t.Fatalf("Dialing cat failed: %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package transportc_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+	"github.com/pion/webrtc/v3"
+)
+
+// persistentCertificate generates a certificate, saves it as PEM and loads it back.
+func persistentCertificate(t *testing.T) (*webrtc.Certificate, string) {
+	certPEM, keyPEM, err := transportc.GenerateCertificatePEM()
+	if err != nil {
+		t.Fatalf("GenerateCertificatePEM error: %v", err)
+	}
+	certificate, err := transportc.LoadCertificatePEM(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("LoadCertificatePEM error: %v", err)
+	}
+	fingerprint, err := transportc.CertificateFingerprint(certificate)
+	if err != nil {
+		t.Fatalf("CertificateFingerprint error: %v", err)
+	}
+	return certificate, fingerprint
+}
+
+func TestCertificatePinning(t *testing.T) {
+	listenerCert, listenerFingerprint := persistentCertificate(t)
+	dialerCert, dialerFingerprint := persistentCertificate(t)
+
+	signal := transportc.NewDebugSignal(8)
+
+	verified := make(chan string, 1)
+	listenerConfig := &transportc.Config{
+		Certificate:        listenerCert,
+		RemoteFingerprints: []string{dialerFingerprint},
+		Signal:             signal,
+	}
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	dialerConfig := &transportc.Config{
+		Certificate: dialerCert,
+		// The fingerprint may be in lowercase and without colons
+		RemoteFingerprints: []string{strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(listenerFingerprint, "sha-256 "), ":", ""))},
+		VerifyRemoteFingerprint: func(fingerprint string) error {
+			verified <- fingerprint
+			return nil
+		},
+		Signal: signal,
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	cConn, err := dialer.DialContext(ctx, "RANDOM_LABEL")
+	if err != nil {
+		t.Fatalf("DialContext error: %v", err)
+	}
+	defer cConn.Close() // skipcq: GO-S2307
+
+	if fingerprint := <-verified; fingerprint != listenerFingerprint {
+		t.Fatalf("VerifyRemoteFingerprint called with %s, expecting %s", fingerprint, listenerFingerprint)
+	}
+
+	sConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept error: %v", err)
+	}
+	defer sConn.Close() // skipcq: GO-S2307
+
+	_, err = cConn.Write([]byte("Hello"))
+	if err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	buf := make([]byte, 1024)
+	n, err := sConn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if string(buf[:n]) != "Hello" {
+		t.Fatalf("Read returned %s", string(buf[:n]))
+	}
+}
+
+func TestCertificatePinningDialerRejects(t *testing.T) {
+	_, otherFingerprint := persistentCertificate(t)
+
+	signal := transportc.NewDebugSignal(8)
+	listenerConfig := &transportc.Config{
+		Signal: signal,
+	}
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	// The listener does not present the pinned certificate, e.g. a MITM
+	dialerConfig := &transportc.Config{
+		RemoteFingerprints: []string{otherFingerprint},
+		Signal:             signal,
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	_, err = dialer.DialContext(ctx, "RANDOM_LABEL")
+	if !errors.Is(err, transportc.ErrRemoteCertificateRejected) {
+		t.Fatalf("DialContext should fail with ErrRemoteCertificateRejected, got %v", err)
+	}
+}
+
+func TestCertificatePinningListenerRejects(t *testing.T) {
+	signal := transportc.NewDebugSignal(8)
+
+	closeReasons := make(chan error, 1)
+	listenerConfig := &transportc.Config{
+		Events: &transportc.Events{
+			OnPeerConnectionClose: func(_ *webrtc.PeerConnection, reason error) {
+				closeReasons <- reason
+			},
+		},
+		VerifyRemoteFingerprint: func(fingerprint string) error {
+			return errors.New("unknown client")
+		},
+		Signal: signal,
+	}
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	dialerConfig := &transportc.Config{
+		Signal: signal,
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	go dialer.DialContext(ctx, "RANDOM_LABEL") // skipcq: GSC-G104
+
+	select {
+	case reason := <-closeReasons:
+		if !errors.Is(reason, transportc.ErrRemoteCertificateRejected) {
+			t.Fatalf("PeerConnection closed for %v, expecting ErrRemoteCertificateRejected", reason)
+		}
+	case <-ctx.Done():
+		t.Fatal("PeerConnection from unknown client not closed")
+	}
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := listener.Accept()
+		accepted <- err
+	}()
+	select {
+	case err := <-accepted:
+		t.Fatalf("Accept should not deliver a connection from unknown client, got error %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestLoadCertificatePEMInvalid(t *testing.T) {
+	_, keyPEM, err := transportc.GenerateCertificatePEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCertPEM, _, err := transportc.GenerateCertificatePEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transportc.LoadCertificatePEM(otherCertPEM, keyPEM); err == nil {
+		t.Fatal("LoadCertificatePEM should fail with mismatched certificate and key")
+	}
+}
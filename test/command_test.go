@@ -0,0 +1,140 @@
+package transportc_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildCommand builds the command in cmd/ into a temporary directory and returns
+// the path of the binary.
+func buildCommand(t *testing.T, name string) string {
+	if testing.Short() {
+		t.Skipf("skipping building %s in short mode", name)
+	}
+
+	bin := filepath.Join(t.TempDir(), name)
+	build := exec.Command("go", "build", "-o", bin, "github.com/gaukas/transportc/cmd/"+name)
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("Error building %s: %v\n%s", name, err, out)
+	}
+	return bin
+}
+
+// freeAddr returns a loopback TCP address with a port free at the time of the call.
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// startCommand runs the binary with the args until the end of the test.
+func startCommand(t *testing.T, bin string, args ...string) {
+	cmd := exec.Command(bin, args...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill() // skipcq: GSC-G104
+		cmd.Wait()         // skipcq: GSC-G104
+	})
+}
+
+// waitStderr runs the binary with the args until the end of the test and waits
+// for a line containing substr on its stderr.
+func waitStderr(t *testing.T, substr string, bin string, args ...string) {
+	cmd := exec.Command(bin, args...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill() // skipcq: GSC-G104
+		cmd.Wait()         // skipcq: GSC-G104
+	})
+
+	found := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(io.TeeReader(stderr, os.Stderr))
+		for scanner.Scan() {
+			if strings.Contains(scanner.Text(), substr) {
+				close(found)
+				break
+			}
+		}
+		io.Copy(io.Discard, stderr) // skipcq: GSC-G104
+	}()
+	select {
+	case <-found:
+	case <-time.After(20 * time.Second):
+		t.Fatalf("No line containing %q on the stderr of %s", substr, filepath.Base(bin))
+	}
+}
+
+// waitListening waits for a TCP listener on the address.
+func waitListening(t *testing.T, addr string) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Nothing listening on %s: %v", addr, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// startEchoServer runs a TCP server echoing back on the address until the end of
+// the test and returns its address.
+func startEchoServer(t *testing.T, addr string) string {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn) // skipcq: GSC-G104
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// checkEcho writes the message on conn and checks it is echoed back.
+func checkEcho(t *testing.T, conn net.Conn, msg []byte) {
+	conn.SetDeadline(time.Now().Add(10 * time.Second)) // skipcq: GSC-G104
+	if _, err := conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	echo := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, echo); err != nil {
+		t.Fatalf("Error reading echo: %v", err)
+	}
+	if !bytes.Equal(echo, msg) {
+		t.Fatalf("Echo %q does not match %q", echo, msg)
+	}
+}
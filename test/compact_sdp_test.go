@@ -0,0 +1,276 @@
+package transportc_test
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+	"github.com/pion/ice/v2"
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+// sdpEssentials is what a DataChannel-only session needs from an SDP.
+type sdpEssentials struct {
+	Type            webrtc.SDPType
+	Setup           string
+	Mid             string
+	ICEUfrag        string
+	ICEPwd          string
+	Fingerprint     string
+	SCTPPort        string
+	Candidates      []string
+	EndOfCandidates bool
+}
+
+func essentials(t *testing.T, desc webrtc.SessionDescription) sdpEssentials {
+	e, err := parseEssentials(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func parseEssentials(desc webrtc.SessionDescription) (sdpEssentials, error) {
+	parsed := &sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(desc.SDP)); err != nil {
+		return sdpEssentials{}, fmt.Errorf("failed to parse SDP: %w", err)
+	}
+	if len(parsed.MediaDescriptions) != 1 {
+		return sdpEssentials{}, fmt.Errorf("SDP has %d media sections", len(parsed.MediaDescriptions))
+	}
+	media := parsed.MediaDescriptions[0]
+	attribute := func(key string) string {
+		if value, ok := media.Attribute(key); ok {
+			return value
+		}
+		value, _ := parsed.Attribute(key)
+		return value
+	}
+
+	e := sdpEssentials{
+		Type:        desc.Type,
+		Setup:       attribute("setup"),
+		Mid:         attribute("mid"),
+		ICEUfrag:    attribute("ice-ufrag"),
+		ICEPwd:      attribute("ice-pwd"),
+		Fingerprint: strings.ToUpper(attribute("fingerprint")),
+		SCTPPort:    attribute("sctp-port"),
+	}
+	for _, attr := range media.Attributes {
+		switch attr.Key {
+		case "end-of-candidates":
+			e.EndOfCandidates = true
+		case "candidate":
+			c, err := ice.UnmarshalCandidate(attr.Value)
+			if err != nil {
+				return sdpEssentials{}, fmt.Errorf("failed to parse candidate %s: %w", attr.Value, err)
+			}
+			if c.Component() != 1 {
+				continue
+			}
+			related := ""
+			if r := c.RelatedAddress(); r != nil {
+				related = r.String()
+			}
+			// Foundations are not preserved
+			e.Candidates = append(e.Candidates, fmt.Sprintf("%s %s %s %s %d %d %s",
+				c.NetworkType(), c.Type(), c.TCPType(), c.Address(), c.Port(), c.Priority(), related))
+		}
+	}
+	return e, nil
+}
+
+func assertCompactRoundTrip(t *testing.T, desc webrtc.SessionDescription) {
+	compact, err := transportc.MarshalCompactSDP(desc)
+	if err != nil {
+		t.Fatalf("MarshalCompactSDP error: %v", err)
+	}
+	if len(compact) >= len(desc.SDP)/3 {
+		t.Fatalf("Compact SDP of %d bytes is not compact for SDP of %d bytes", len(compact), len(desc.SDP))
+	}
+
+	restored, err := transportc.UnmarshalCompactSDP(compact)
+	if err != nil {
+		t.Fatalf("UnmarshalCompactSDP error: %v", err)
+	}
+
+	want, got := essentials(t, desc), essentials(t, restored)
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("Restored SDP does not match:\nwant %+v\ngot  %+v", want, got)
+	}
+}
+
+func TestCompactSDPRoundTrip(t *testing.T) {
+	offerer, offer := gatheredOffer(t)
+	defer offerer.Close()
+	assertCompactRoundTrip(t, offer)
+
+	answerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer answerer.Close()
+	if err := answerer.SetRemoteDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	answer, err := answerer.CreateAnswer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(answerer)
+	if err := answerer.SetLocalDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+	<-gatherComplete
+	assertCompactRoundTrip(t, *answerer.LocalDescription())
+
+	// The restored answer is accepted by the offerer
+	compact, err := transportc.MarshalCompactSDP(*answerer.LocalDescription())
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := transportc.UnmarshalCompactSDP(compact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := offerer.SetRemoteDescription(restored); err != nil {
+		t.Fatalf("SetRemoteDescription with restored answer error: %v", err)
+	}
+}
+
+func TestCompactSDPRoundTripTrickle(t *testing.T) {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	if _, err := pc.CreateDataChannel("RANDOM_LABEL", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Offer without any candidate nor end-of-candidates
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCompactRoundTrip(t, offer)
+}
+
+func TestCompactSDPRoundTripCandidates(t *testing.T) {
+	offerer, offer := gatheredOffer(t)
+	defer offerer.Close()
+
+	// Candidates of all kinds pion may generate
+	extra := []string{
+		"a=candidate:1 1 udp 1694498815 203.0.113.7 61234 typ srflx raddr 192.168.1.2 rport 51234",
+		"a=candidate:2 1 udp 16777215 198.51.100.9 3478 typ relay raddr 203.0.113.7 rport 61234",
+		"a=candidate:3 1 tcp 1671430143 2001:db8::1 9 typ host tcptype active",
+		"a=candidate:4 1 tcp 1671430143 192.168.1.2 443 typ host tcptype passive",
+		"a=candidate:5 1 udp 2130706431 0b8e3d9a-0f4c-4c8e-9a3e-2f4f0b8e3d9a.local 50000 typ host",
+		"a=candidate:6 1 udp 1845501695 203.0.113.8 50001 typ prflx raddr 0.0.0.0 rport 0",
+	}
+	offer.SDP = strings.Replace(offer.SDP, "a=end-of-candidates", strings.Join(extra, "\r\n")+"\r\na=end-of-candidates", 1)
+
+	compact, err := transportc.MarshalCompactSDP(offer)
+	if err != nil {
+		t.Fatalf("MarshalCompactSDP error: %v", err)
+	}
+	restored, err := transportc.UnmarshalCompactSDP(compact)
+	if err != nil {
+		t.Fatalf("UnmarshalCompactSDP error: %v", err)
+	}
+	want, got := essentials(t, offer), essentials(t, restored)
+	if len(want.Candidates) < len(extra) {
+		t.Fatalf("Extra candidates not parsed, got %d candidates", len(want.Candidates))
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("Restored SDP does not match:\nwant %+v\ngot  %+v", want, got)
+	}
+}
+
+func TestCompactSignalDialContext(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		signal func(t *testing.T) (listenerSignal, dialerSignal transportc.Signal)
+	}{
+		{
+			name: "DebugSignal",
+			signal: func(t *testing.T) (transportc.Signal, transportc.Signal) {
+				s := transportc.NewCompactSignal(transportc.NewDebugSignal(8))
+				return s, s
+			},
+		},
+		{
+			name: "WebSocketSignal",
+			signal: func(t *testing.T) (transportc.Signal, transportc.Signal) {
+				server := httptest.NewServer(transportc.NewWebSocketSignalServer(0))
+				t.Cleanup(server.Close)
+				listenerSignal := transportc.NewWebSocketSignal(webSocketURL(server))
+				t.Cleanup(func() { listenerSignal.Close() })
+				dialerSignal := transportc.NewWebSocketSignal(webSocketURL(server))
+				t.Cleanup(func() { dialerSignal.Close() })
+				return transportc.NewCompactSignal(listenerSignal), transportc.NewCompactSignal(dialerSignal)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			listenerSignal, dialerSignal := tc.signal(t)
+			if _, ok := dialerSignal.(transportc.TrickleSignal); ok != (tc.name == "WebSocketSignal") {
+				t.Fatalf("CompactSignal should implement TrickleSignal iff the wrapped Signal does")
+			}
+
+			listenerConfig := &transportc.Config{
+				Signal: listenerSignal,
+			}
+			listener, err := listenerConfig.NewListener()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+			listener.Start()
+
+			dialerConfig := &transportc.Config{
+				Signal: dialerSignal,
+			}
+			dialer, err := dialerConfig.NewDialer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dialer.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel() // cancel the context to make sure it is done
+
+			cConn, err := dialer.DialContext(ctx, "RANDOM_LABEL")
+			if err != nil {
+				t.Fatalf("DialContext error: %v", err)
+			}
+			defer cConn.Close() // skipcq: GO-S2307
+
+			sConn, err := listener.Accept()
+			if err != nil {
+				t.Fatalf("Accept error: %v", err)
+			}
+			defer sConn.Close() // skipcq: GO-S2307
+
+			_, err = cConn.Write([]byte("Hello"))
+			if err != nil {
+				t.Fatalf("Write error: %v", err)
+			}
+			buf := make([]byte, 1024)
+			n, err := sConn.Read(buf)
+			if err != nil {
+				t.Fatalf("Read error: %v", err)
+			}
+			if string(buf[:n]) != "Hello" {
+				t.Fatalf("Read returned %s", string(buf[:n]))
+			}
+		})
+	}
+}
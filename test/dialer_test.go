@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/gaukas/transportc"
+	"github.com/pion/webrtc/v3"
 )
 
 // Negative Test for Dialer.DialContext with an expired context
@@ -209,3 +210,46 @@ func BenchmarkSingleDialerDialing(b *testing.B) {
 		c.Close()
 	}
 }
+
+// Negative Test for Dialer.DialContext with a peer lost while waiting for the DataChannel
+func TestDialContextPeerLost(t *testing.T) {
+	listenerConfig := &transportc.Config{
+		Signal: transportc.NewDebugSignal(8),
+		Events: &transportc.Events{
+			// The listener goes away before the DataChannel opens
+			OnDTLSHandshakeComplete: func(pc *webrtc.PeerConnection) {
+				go pc.Close() // skipcq: GSC-G104
+			},
+		},
+	}
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	dialerConfig := &transportc.Config{
+		Signal: listenerConfig.Signal,
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	timeStart := time.Now()
+	conn, err := dialer.DialContext(ctx, "RANDOM_LABEL")
+	if conn != nil {
+		conn.Close()
+	}
+	if err == nil {
+		t.Fatal("DialContext should fail as the peer is lost")
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("DialContext returned after the context is done (%v) instead of when the PeerConnection closed: %v", time.Since(timeStart), err)
+	}
+}
@@ -0,0 +1,169 @@
+package transportc_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+)
+
+func TestDirSignal(t *testing.T) {
+	dir := t.TempDir()
+
+	// Two DirSignals on the same directory, as if in two processes
+	offerer, err := transportc.NewDirSignal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answerer, err := transportc.NewDirSignal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offerer.PollTimeout, answerer.PollTimeout = 0, 0
+
+	_, _, err = answerer.ReadOffer()
+	if !errors.Is(err, transportc.ErrOfferNotReady) {
+		t.Fatalf("ReadOffer without offer should fail with ErrOfferNotReady, got %v", err)
+	}
+
+	offerID, err := offerer.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+
+	_, err = offerer.ReadAnswer(offerID)
+	if !errors.Is(err, transportc.ErrAnswerNotReady) {
+		t.Fatalf("ReadAnswer before answer should fail with ErrAnswerNotReady, got %v", err)
+	}
+
+	oid, offer, err := answerer.ReadOffer()
+	if err != nil {
+		t.Fatalf("Error reading offer: %v", err)
+	}
+	if oid != offerID || !bytes.Equal(offer, []byte("OFFER")) {
+		t.Fatalf("Offer output does not match offer input")
+	}
+
+	// Polling ReadAnswer returns once the answer is written
+	offerer.PollTimeout = 5 * time.Second
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		answerer.Answer(offerID, []byte("ANSWER")) // skipcq: GSC-G104
+	}()
+	answer, err := offerer.ReadAnswer(offerID)
+	if err != nil {
+		t.Fatalf("Error reading answer: %v", err)
+	}
+	if !bytes.Equal(answer, []byte("ANSWER")) {
+		t.Fatalf("Answer output does not match answer input")
+	}
+
+	err = answerer.Answer(offerID, []byte("ANSWER"))
+	if !errors.Is(err, transportc.ErrInvalidOfferID) {
+		t.Fatalf("Answering twice should fail with ErrInvalidOfferID, got %v", err)
+	}
+
+	_, err = offerer.ReadAnswer(offerID)
+	if !errors.Is(err, transportc.ErrInvalidOfferID) {
+		t.Fatalf("Reading answer twice should fail with ErrInvalidOfferID, got %v", err)
+	}
+
+	// Nothing is left behind once the answer is read
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("Signal directory has %d files left, expected 0", len(entries))
+	}
+}
+
+func TestDirSignalOfferExpiry(t *testing.T) {
+	ds, err := transportc.NewDirSignal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds.PollTimeout = 0
+	ds.TTL = 100 * time.Millisecond
+
+	offerID, err := ds.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	_, _, err = ds.ReadOffer()
+	if !errors.Is(err, transportc.ErrOfferNotReady) {
+		t.Fatalf("ReadOffer should fail with ErrOfferNotReady after expiry, got %v", err)
+	}
+
+	_, err = ds.ReadAnswer(offerID)
+	if !errors.Is(err, transportc.ErrInvalidOfferID) {
+		t.Fatalf("ReadAnswer should fail with ErrInvalidOfferID after expiry, got %v", err)
+	}
+}
+
+func TestDirSignalDialContext(t *testing.T) {
+	dir := t.TempDir()
+
+	listenerSignal, err := transportc.NewDirSignal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listenerConfig := &transportc.Config{
+		Signal: listenerSignal,
+	}
+
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	dialerSignal, err := transportc.NewDirSignal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialerConfig := &transportc.Config{
+		Signal: dialerSignal,
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	cConn, err := dialer.DialContext(ctx, "RANDOM_LABEL")
+	if err != nil {
+		t.Fatalf("DialContext error: %v", err)
+	}
+	defer cConn.Close() // skipcq: GO-S2307
+
+	sConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept error: %v", err)
+	}
+	defer sConn.Close() // skipcq: GO-S2307
+
+	_, err = cConn.Write([]byte("Hello"))
+	if err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	buf := make([]byte, 1024)
+	n, err := sConn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if string(buf[:n]) != "Hello" {
+		t.Fatalf("Read returned %s", string(buf[:n]))
+	}
+}
@@ -0,0 +1,142 @@
+package transportc_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+)
+
+const dnsSignalDomain = "t.example.com"
+
+// startDNSSignalServer runs a DNSSignalServer on a local UDP port and returns its address.
+func startDNSSignalServer(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go transportc.NewDNSSignalServer(dnsSignalDomain, 0).Serve(conn) // skipcq: GSC-G104
+	return conn.LocalAddr().String()
+}
+
+func TestDNSSignal(t *testing.T) {
+	ds := transportc.NewDNSSignal(dnsSignalDomain, startDNSSignalServer(t))
+	ds.PollTimeout = 0
+
+	_, _, err := ds.ReadOffer()
+	if !errors.Is(err, transportc.ErrOfferNotReady) {
+		t.Fatalf("ReadOffer without offer should fail with ErrOfferNotReady, got %v", err)
+	}
+
+	// Large enough to take multiple queries and responses
+	offerBody := make([]byte, 4096)
+	rand.Read(offerBody)
+	offerID, err := ds.Offer(offerBody)
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+
+	_, err = ds.ReadAnswer(offerID)
+	if !errors.Is(err, transportc.ErrAnswerNotReady) {
+		t.Fatalf("ReadAnswer before answer should fail with ErrAnswerNotReady, got %v", err)
+	}
+
+	oid, offer, err := ds.ReadOffer()
+	if err != nil {
+		t.Fatalf("Error reading offer: %v", err)
+	}
+	if oid != offerID || !bytes.Equal(offer, offerBody) {
+		t.Fatalf("Offer output does not match offer input")
+	}
+
+	// Polling ReadAnswer returns once the answer is uploaded
+	ds.PollTimeout = 5 * time.Second
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		ds.Answer(offerID, []byte("ANSWER")) // skipcq: GSC-G104
+	}()
+	answer, err := ds.ReadAnswer(offerID)
+	if err != nil {
+		t.Fatalf("Error reading answer: %v", err)
+	}
+	if !bytes.Equal(answer, []byte("ANSWER")) {
+		t.Fatalf("Answer output does not match answer input")
+	}
+
+	err = ds.Answer(offerID, []byte("ANSWER"))
+	if !errors.Is(err, transportc.ErrInvalidOfferID) {
+		t.Fatalf("Answering twice should fail with ErrInvalidOfferID, got %v", err)
+	}
+
+	_, err = ds.ReadAnswer(offerID)
+	if !errors.Is(err, transportc.ErrInvalidOfferID) {
+		t.Fatalf("Reading answer twice should fail with ErrInvalidOfferID, got %v", err)
+	}
+}
+
+func TestDNSSignalWrongDomain(t *testing.T) {
+	ds := transportc.NewDNSSignal("other.example.com", startDNSSignalServer(t))
+
+	_, err := ds.Offer([]byte("OFFER"))
+	if !errors.Is(err, transportc.ErrInvalidDNSResponse) {
+		t.Fatalf("Offer under a domain not served should fail with ErrInvalidDNSResponse, got %v", err)
+	}
+}
+
+func TestDNSSignalDialContext(t *testing.T) {
+	resolver := startDNSSignalServer(t)
+
+	listenerConfig := &transportc.Config{
+		Signal: transportc.NewCompactSignal(transportc.NewDNSSignal(dnsSignalDomain, resolver)),
+	}
+
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	dialerConfig := &transportc.Config{
+		Signal: transportc.NewCompactSignal(transportc.NewDNSSignal(dnsSignalDomain, resolver)),
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	cConn, err := dialer.DialContext(ctx, "RANDOM_LABEL")
+	if err != nil {
+		t.Fatalf("DialContext error: %v", err)
+	}
+	defer cConn.Close() // skipcq: GO-S2307
+
+	sConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept error: %v", err)
+	}
+	defer sConn.Close() // skipcq: GO-S2307
+
+	_, err = cConn.Write([]byte("Hello"))
+	if err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	buf := make([]byte, 1024)
+	n, err := sConn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if string(buf[:n]) != "Hello" {
+		t.Fatalf("Read returned %s", string(buf[:n]))
+	}
+}
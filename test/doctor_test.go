@@ -0,0 +1,198 @@
+package transportc_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+
+	"github.com/pion/stun"
+)
+
+// startSTUNServer answers the STUN Binding requests on a local UDP port with
+// the source address as XOR-MAPPED-ADDRESS, and returns the port.
+func startSTUNServer(t *testing.T) int {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			req := &stun.Message{Raw: append([]byte{}, buf[:n]...)}
+			if req.Decode() != nil || req.Type != stun.BindingRequest {
+				continue
+			}
+			res, err := stun.Build(stun.NewTransactionIDSetter(req.TransactionID), stun.BindingSuccess,
+				&stun.XORMappedAddress{IP: from.IP, Port: from.Port}, stun.Fingerprint)
+			if err != nil {
+				continue
+			}
+			conn.WriteToUDP(res.Raw, from) // skipcq: GSC-G104
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+type doctorReport struct {
+	Candidates []struct {
+		Type    string `json:"type"`
+		Address string `json:"address"`
+	} `json:"candidates"`
+	Interfaces []struct {
+		Name    string `json:"name"`
+		Allowed bool   `json:"allowed"`
+	} `json:"interfaces"`
+	NAT *struct {
+		Mapping string `json:"mapping"`
+		Results []struct {
+			Mapped string `json:"mapped"`
+			Error  string `json:"error"`
+		} `json:"stun"`
+	} `json:"nat"`
+	Negotiation struct {
+		Connected bool `json:"connected"`
+		Pairs     []struct {
+			State     string `json:"state"`
+			Nominated bool   `json:"nominated"`
+		} `json:"pairs"`
+		Succeeded int    `json:"succeeded"`
+		Error     string `json:"error"`
+	} `json:"negotiation"`
+}
+
+// runDoctor runs transportc doctor with -json and decodes the report.
+func runDoctor(t *testing.T, bin string, args ...string) *doctorReport {
+	var stdout bytes.Buffer
+	doctor := exec.Command(bin, append([]string{"doctor", "-json"}, args...)...)
+	doctor.Stdout = &stdout
+	doctor.Stderr = os.Stderr
+	if err := doctor.Run(); err != nil {
+		t.Fatalf("Doctor failed: %v\n%s", err, stdout.Bytes())
+	}
+	var report doctorReport
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("Invalid JSON output: %v\n%s", err, stdout.Bytes())
+	}
+	return &report
+}
+
+// checkNegotiation checks the test negotiation connected over a nominated
+// candidate pair that succeeded.
+func checkNegotiation(t *testing.T, report *doctorReport) {
+	n := report.Negotiation
+	if !n.Connected || n.Succeeded == 0 {
+		t.Fatalf("Test negotiation failed: %+v", n)
+	}
+	for _, p := range n.Pairs {
+		if p.Nominated && p.State == "succeeded" {
+			return
+		}
+	}
+	t.Fatalf("No nominated candidate pair succeeded: %+v", n.Pairs)
+}
+
+func TestTransportcDoctor(t *testing.T) {
+	bin := buildCommand(t, "transportc")
+
+	iface := gatheringInterface(t)
+	stun1, stun2 := startSTUNServer(t), startSTUNServer(t)
+	report := runDoctor(t, bin, "-network", "udp4", "-interface", iface,
+		"-ice", "stun:127.0.0.1:"+strconv.Itoa(stun1), "-ice", "stun:127.0.0.1:"+strconv.Itoa(stun2))
+
+	if len(report.Candidates) == 0 {
+		t.Fatalf("No local candidates gathered")
+	}
+	for _, c := range report.Candidates {
+		if c.Type == "host" && net.ParseIP(hostOf(t, c.Address)).To4() == nil {
+			t.Fatalf("Host candidate %s not over udp4", c.Address)
+		}
+	}
+	for _, i := range report.Interfaces {
+		if i.Allowed != (i.Name == iface) {
+			t.Fatalf("Interface %s allowed: %t, expected only %s", i.Name, i.Allowed, iface)
+		}
+	}
+
+	if report.NAT == nil || len(report.NAT.Results) != 2 {
+		t.Fatalf("Expected results of the two STUN servers, got %+v", report.NAT)
+	}
+	for _, r := range report.NAT.Results {
+		if r.Error != "" || r.Mapped == "" {
+			t.Fatalf("STUN server failed: %+v", r)
+		}
+	}
+	// The STUN servers are on the host, so the mapped address is the local one
+	if report.NAT.Mapping != "none" {
+		t.Fatalf("Mapping %q, expected none", report.NAT.Mapping)
+	}
+
+	checkNegotiation(t, report)
+}
+
+func TestTransportcDoctorPeer(t *testing.T) {
+	bin := buildCommand(t, "transportc")
+
+	signal := "dir:" + t.TempDir()
+	var stdout bytes.Buffer
+	listener := exec.Command(bin, "doctor", "-json", "-l", "-signal", signal)
+	listener.Stdout = &stdout
+	listener.Stderr = os.Stderr
+	if err := listener.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Process.Kill() }) // skipcq: GSC-G104
+
+	checkNegotiation(t, runDoctor(t, bin, "-signal", signal))
+
+	if err := listener.Wait(); err != nil {
+		t.Fatalf("Listening doctor failed: %v", err)
+	}
+	var report doctorReport
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		t.Fatalf("Invalid JSON output: %v\n%s", err, stdout.Bytes())
+	}
+	checkNegotiation(t, &report)
+}
+
+// gatheringInterface returns the name of an interface ICE gathers candidates on,
+// i.e. up and not loopback with an IPv4 address.
+func gatheringInterface(t *testing.T) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				return iface.Name
+			}
+		}
+	}
+	t.Skip("No interface to gather candidates on")
+	return ""
+}
+
+func hostOf(t *testing.T, addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host
+}
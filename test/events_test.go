@@ -0,0 +1,185 @@
+package transportc_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+	"github.com/pion/webrtc/v3"
+)
+
+// eventRecorder records the events fired for later verification.
+type eventRecorder struct {
+	mutex  sync.Mutex
+	fired  map[string]int
+	reason error
+}
+
+func (r *eventRecorder) record(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.fired[name]++
+}
+
+func (r *eventRecorder) count(name string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.fired[name]
+}
+
+func (r *eventRecorder) events() *transportc.Events {
+	return &transportc.Events{
+		OnOfferSent: func(*webrtc.PeerConnection, uint64) {
+			r.record("OfferSent")
+		},
+		OnOfferReceived: func(*webrtc.PeerConnection, uint64) {
+			r.record("OfferReceived")
+		},
+		OnAnswerSent: func(*webrtc.PeerConnection, uint64) {
+			r.record("AnswerSent")
+		},
+		OnAnswerReceived: func(*webrtc.PeerConnection, uint64) {
+			r.record("AnswerReceived")
+		},
+		OnICEConnectionStateChange: func(*webrtc.PeerConnection, webrtc.ICEConnectionState) {
+			r.record("ICEConnectionStateChange")
+		},
+		OnSelectedCandidatePairChange: func(*webrtc.PeerConnection, *webrtc.ICECandidatePair) {
+			r.record("SelectedCandidatePairChange")
+		},
+		OnDTLSHandshakeComplete: func(*webrtc.PeerConnection) {
+			r.record("DTLSHandshakeComplete")
+		},
+		OnDataChannelOpen: func(*webrtc.PeerConnection, *webrtc.DataChannel) {
+			r.record("DataChannelOpen")
+		},
+		OnDataChannelClose: func(*webrtc.PeerConnection, *webrtc.DataChannel) {
+			r.record("DataChannelClose")
+		},
+		OnPeerConnectionClose: func(_ *webrtc.PeerConnection, reason error) {
+			r.mutex.Lock()
+			r.reason = reason
+			r.mutex.Unlock()
+			r.record("PeerConnectionClose")
+		},
+	}
+}
+
+func TestEvents(t *testing.T) {
+	signal := transportc.NewDebugSignal(8)
+	dialerEvents := &eventRecorder{fired: make(map[string]int)}
+	listenerEvents := &eventRecorder{fired: make(map[string]int)}
+
+	listenerConfig := &transportc.Config{
+		Signal: signal,
+		Events: listenerEvents.events(),
+	}
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	dialerConfig := &transportc.Config{
+		Signal: signal,
+		Events: dialerEvents.events(),
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	cConn, err := dialer.DialContext(ctx, "RANDOM_LABEL")
+	if err != nil {
+		t.Fatalf("DialContext error: %v", err)
+	}
+	defer cConn.Close() // skipcq: GO-S2307
+
+	sConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept error: %v", err)
+	}
+	defer sConn.Close() // skipcq: GO-S2307
+
+	for _, name := range []string{"OfferSent", "AnswerReceived", "ICEConnectionStateChange", "SelectedCandidatePairChange", "DTLSHandshakeComplete", "DataChannelOpen"} {
+		if dialerEvents.count(name) == 0 {
+			t.Errorf("Dialer did not fire %s", name)
+		}
+	}
+	for _, name := range []string{"OfferReceived", "AnswerSent", "ICEConnectionStateChange", "SelectedCandidatePairChange", "DTLSHandshakeComplete", "DataChannelOpen"} {
+		if listenerEvents.count(name) == 0 {
+			t.Errorf("Listener did not fire %s", name)
+		}
+	}
+
+	dialer.Close()
+	dialer.Close() // closing twice must not fire the event twice
+
+	time.Sleep(100 * time.Millisecond) // wait for the handlers to be called
+	if n := dialerEvents.count("PeerConnectionClose"); n != 1 {
+		t.Fatalf("Dialer fired PeerConnectionClose %d times", n)
+	}
+	if !errors.Is(dialerEvents.reason, transportc.ErrDialerClosed) {
+		t.Fatalf("Unexpected PeerConnectionClose reason: %v", dialerEvents.reason)
+	}
+}
+
+func TestDTLSHandshakeCompleteRemoteCertificate(t *testing.T) {
+	signal := transportc.NewDebugSignal(8)
+
+	// Reading the remote certificate locks the DTLSTransport, which must not
+	// deadlock the handshake.
+	remoteCerts := make(chan []byte, 2)
+	events := &transportc.Events{
+		OnDTLSHandshakeComplete: func(pc *webrtc.PeerConnection) {
+			remoteCerts <- pc.SCTP().Transport().GetRemoteCertificate()
+		},
+	}
+
+	listenerConfig := &transportc.Config{
+		Signal: signal,
+		Events: events,
+	}
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	dialerConfig := &transportc.Config{
+		Signal: signal,
+		Events: events,
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cConn, err := dialer.DialContext(ctx, "RANDOM_LABEL")
+	if err != nil {
+		t.Fatalf("DialContext error: %v", err)
+	}
+	defer cConn.Close() // skipcq: GO-S2307
+
+	for i := 0; i < 2; i++ {
+		select {
+		case cert := <-remoteCerts:
+			if len(cert) == 0 {
+				t.Fatalf("No remote certificate once the DTLS handshake completed")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("OnDTLSHandshakeComplete not fired on both ends")
+		}
+	}
+}
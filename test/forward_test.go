@@ -0,0 +1,94 @@
+package transportc_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/gaukas/transportc/internal/cliconfig"
+)
+
+func TestParseForwardSpec(t *testing.T) {
+	for _, test := range []struct {
+		spec   string
+		listen string
+		target string
+	}{
+		{"8080:db:5432", "localhost:8080", "db:5432"},
+		{"127.0.0.1:8080:db:5432", "127.0.0.1:8080", "db:5432"},
+		{":8080:db:5432", ":8080", "db:5432"},
+		{"[::1]:8080:[2001:db8::1]:5432", "[::1]:8080", "[2001:db8::1]:5432"},
+		{"8080:[2001:db8::1]:5432", "localhost:8080", "[2001:db8::1]:5432"},
+		{"[]:8080:db:5432", ":8080", "db:5432"},
+		{"0:localhost:22", "localhost:0", "localhost:22"},
+	} {
+		spec, err := cliconfig.ParseForwardSpec(test.spec)
+		if err != nil {
+			t.Fatalf("ParseForwardSpec(%q) error: %v", test.spec, err)
+		}
+		if spec.Listen != test.listen || spec.Target != test.target {
+			t.Fatalf("ParseForwardSpec(%q) listens on %s to %s, expected %s to %s", test.spec, spec.Listen, spec.Target, test.listen, test.target)
+		}
+	}
+
+	for _, spec := range []string{
+		"",
+		"8080",
+		"8080:db",
+		"8080:db:5432:",
+		"a:8080:db:5432:1",
+		"http:db:5432",
+		"8080:db:",
+		"8080:db:65536",
+		"8080:db:-1",
+		"99999:db:5432",
+		"8080::5432",
+		"[::1:8080:db:5432",
+		"[::1]8080:db:5432",
+		"::1:8080:db:5432",
+		"8080[::1]:db:5432",
+	} {
+		if _, err := cliconfig.ParseForwardSpec(spec); !errors.Is(err, cliconfig.ErrInvalidForward) {
+			t.Fatalf("ParseForwardSpec(%q) should fail with ErrInvalidForward, got %v", spec, err)
+		}
+	}
+}
+
+func TestTransportcForward(t *testing.T) {
+	bin := buildCommand(t, "transportc")
+
+	signal := "dir:" + t.TempDir()
+	startCommand(t, bin, "forward", "-server", "-signal", signal)
+
+	echo := startEchoServer(t, "127.0.0.1:0")
+	local, remote := freeAddr(t), freeAddr(t)
+	_, localOnly, _ := net.SplitHostPort(freeAddr(t))
+	startCommand(t, bin, "forward", "-signal", signal,
+		"-L", local+":"+echo,
+		"-L", localOnly+":"+echo, // bound to localhost
+		"-R", remote+":"+echo,
+	)
+
+	for _, addr := range []string{local, net.JoinHostPort("localhost", localOnly), remote} {
+		waitListening(t, addr)
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkEcho(t, conn, []byte("HELLO THROUGH "+addr))
+		conn.Close()
+	}
+}
+
+func TestTransportcForwardRemoteListenFailed(t *testing.T) {
+	bin := buildCommand(t, "transportc")
+
+	signal := "dir:" + t.TempDir()
+	startCommand(t, bin, "forward", "-server", "-signal", signal)
+
+	// The address to listen on the peer is taken
+	taken := startEchoServer(t, "127.0.0.1:0")
+	waitStderr(t, "remote forward on "+taken+": peer failed to forward", bin, "forward", "-signal", signal,
+		"-R", taken+":"+startEchoServer(t, "127.0.0.1:0"),
+	)
+}
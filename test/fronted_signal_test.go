@@ -0,0 +1,163 @@
+package transportc_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gaukas/transportc"
+)
+
+const (
+	frontedServerName = "front.example.com"
+	frontedHost       = "rendezvous.example.com"
+)
+
+// startFrontServer runs a TLS server standing for the CDN, forwarding to an
+// HTTPSignalServer only the requests with the Host header of the rendezvous.
+// It returns the server and a function returning the SNIs received.
+func startFrontServer(t *testing.T) (*httptest.Server, func() []string) {
+	rendezvous := transportc.NewHTTPSignalServer(0)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != frontedHost {
+			http.Error(w, "unknown host", http.StatusMisdirectedRequest)
+			return
+		}
+		rendezvous.ServeHTTP(w, r)
+	}))
+
+	var mutex sync.Mutex
+	var serverNames []string
+	server.TLS = &tls.Config{ // skipcq: GO-S1020
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			mutex.Lock()
+			serverNames = append(serverNames, hello.ServerName)
+			mutex.Unlock()
+			return nil, nil
+		},
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return server, func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), serverNames...)
+	}
+}
+
+func certPool(server *httptest.Server) *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	return pool
+}
+
+func TestFrontedHTTPSignal(t *testing.T) {
+	server, serverNames := startFrontServer(t)
+
+	hs, err := transportc.NewFrontedHTTPSignal(&transportc.FrontingConfig{
+		FrontURL:   server.URL,
+		Host:       frontedHost,
+		RootCAs:    certPool(server),
+		ServerName: frontedServerName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.PollTimeout = 0
+
+	offerID, err := hs.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+	oid, offer, err := hs.ReadOffer()
+	if err != nil {
+		t.Fatalf("Error reading offer: %v", err)
+	}
+	if oid != offerID || !bytes.Equal(offer, []byte("OFFER")) {
+		t.Fatalf("Offer output does not match offer input")
+	}
+	if err := hs.Answer(offerID, []byte("ANSWER")); err != nil {
+		t.Fatalf("Error answering offer: %v", err)
+	}
+	answer, err := hs.ReadAnswer(offerID)
+	if err != nil {
+		t.Fatalf("Error reading answer: %v", err)
+	}
+	if !bytes.Equal(answer, []byte("ANSWER")) {
+		t.Fatalf("Answer output does not match answer input")
+	}
+
+	names := serverNames()
+	if len(names) == 0 {
+		t.Fatalf("No TLS handshake seen by the front server")
+	}
+	for _, name := range names {
+		if name != frontedServerName {
+			t.Fatalf("Front server received SNI %q, expected %q", name, frontedServerName)
+		}
+	}
+
+	// Without fronting, the front server does not know the rendezvous
+	direct := transportc.NewHTTPSignal(server.URL)
+	direct.Client = server.Client()
+	if _, err := direct.Offer([]byte("OFFER")); !errors.Is(err, transportc.ErrUnexpectedStatus) {
+		t.Fatalf("Offer without the Host header should fail with ErrUnexpectedStatus, got %v", err)
+	}
+}
+
+func TestFrontedHTTPSignalFingerprint(t *testing.T) {
+	server, _ := startFrontServer(t)
+
+	var dials atomic.Int32
+	hs, err := transportc.NewFrontedHTTPSignal(&transportc.FrontingConfig{
+		DialTLSContext: func(ctx context.Context, network, addr string, config *tls.Config) (net.Conn, error) {
+			dials.Add(1)
+			if config.ServerName != frontedServerName {
+				t.Errorf("DialTLSContext called with ServerName %q, expected %q", config.ServerName, frontedServerName)
+			}
+			if config.MaxVersion != tls.VersionTLS12 {
+				t.Errorf("DialTLSContext called with MaxVersion %x, expected %x", config.MaxVersion, tls.VersionTLS12)
+			}
+			return (&tls.Dialer{Config: config}).DialContext(ctx, network, addr)
+		},
+		Fingerprint: &transportc.TLSFingerprint{
+			CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			MaxVersion:   tls.VersionTLS12,
+			NextProtos:   []string{"http/1.1"},
+		},
+		FrontURL:   server.URL,
+		Host:       frontedHost,
+		RootCAs:    certPool(server),
+		ServerName: frontedServerName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := hs.Offer([]byte("OFFER")); err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+	if dials.Load() == 0 {
+		t.Fatalf("DialTLSContext was not called")
+	}
+}
+
+func TestFrontedHTTPSignalInvalidConfig(t *testing.T) {
+	for _, config := range []*transportc.FrontingConfig{
+		{FrontURL: "http://front.example.com/signal", Host: frontedHost},
+		{FrontURL: "https://front.example.com/signal"},
+	} {
+		if _, err := transportc.NewFrontedHTTPSignal(config); !errors.Is(err, transportc.ErrInvalidFrontingConfig) {
+			t.Fatalf("NewFrontedHTTPSignal(%+v) should fail with ErrInvalidFrontingConfig, got %v", config, err)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package transportc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestPreSharedKeyHandshake(t *testing.T) {
+	signal := transportc.NewDebugSignal(8)
+
+	listenerConfig := &transportc.Config{
+		PreSharedKey: []byte("PSK"),
+		Signal:       signal,
+	}
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	dialerConfig := &transportc.Config{
+		PreSharedKey: []byte("PSK"),
+		Signal:       signal,
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	cConn, err := dialer.DialContext(ctx, "RANDOM_LABEL")
+	if err != nil {
+		t.Fatalf("DialContext error: %v", err)
+	}
+	defer cConn.Close() // skipcq: GO-S2307
+
+	sConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept error: %v", err)
+	}
+	defer sConn.Close() // skipcq: GO-S2307
+
+	// The handshake messages must not leak into the Conn
+	_, err = cConn.Write([]byte("Hello"))
+	if err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	buf := make([]byte, 1024)
+	n, err := sConn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if string(buf[:n]) != "Hello" {
+		t.Fatalf("Read returned %s", string(buf[:n]))
+	}
+
+	_, err = sConn.Write([]byte("World"))
+	if err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	n, err = cConn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if string(buf[:n]) != "World" {
+		t.Fatalf("Read returned %s", string(buf[:n]))
+	}
+}
+
+func TestPreSharedKeyHandshakeMismatch(t *testing.T) {
+	signal := transportc.NewDebugSignal(8)
+
+	closeReasons := make(chan error, 1)
+	listenerConfig := &transportc.Config{
+		Events: &transportc.Events{
+			OnPeerConnectionClose: func(_ *webrtc.PeerConnection, reason error) {
+				select {
+				case closeReasons <- reason:
+				default:
+				}
+			},
+		},
+		PreSharedKey: []byte("PSK"),
+		Signal:       signal,
+	}
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	dialerConfig := &transportc.Config{
+		PreSharedKey: []byte("NOT PSK"),
+		Signal:       signal,
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	_, err = dialer.DialContext(ctx, "RANDOM_LABEL")
+	if !errors.Is(err, transportc.ErrHandshakeFailed) {
+		t.Fatalf("DialContext should fail with ErrHandshakeFailed, got %v", err)
+	}
+
+	select {
+	case reason := <-closeReasons:
+		if !errors.Is(reason, transportc.ErrHandshakeFailed) {
+			t.Fatalf("PeerConnection closed for %v, expecting ErrHandshakeFailed", reason)
+		}
+	case <-ctx.Done():
+		t.Fatal("PeerConnection failing the handshake not closed")
+	}
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := listener.Accept()
+		accepted <- err
+	}()
+	select {
+	case err := <-accepted:
+		t.Fatalf("Accept should not deliver a connection failing the handshake, got error %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestPreSharedKeyHandshakeMissing(t *testing.T) {
+	signal := transportc.NewDebugSignal(8)
+
+	// The listener requires a PSK the dialer does not have
+	listenerConfig := &transportc.Config{
+		PreSharedKey: []byte("PSK"),
+		Signal:       signal,
+	}
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	dialerConfig := &transportc.Config{
+		Signal: signal,
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	cConn, err := dialer.DialContext(ctx, "RANDOM_LABEL")
+	if err != nil {
+		t.Fatalf("DialContext error: %v", err)
+	}
+	defer cConn.Close() // skipcq: GO-S2307
+
+	// The listener drops the PeerConnection once the handshake times out
+	_, err = cConn.Write([]byte("Hello, this is not a challenge"))
+	if err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	cConn.SetReadDeadline(time.Now().Add(transportc.PSK_HANDSHAKE_TIMEOUT_DEFAULT + 5*time.Second)) // skipcq: GSC-G104
+	buf := make([]byte, 1024)
+	n, err := cConn.Read(buf)
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Conn failing the handshake not closed by the listener")
+	}
+	if err == nil {
+		t.Fatalf("Read returned %d bytes, the handshake must not leak into the Conn", n)
+	}
+}
+
+func TestPreSharedKeyHandshakeUnreliable(t *testing.T) {
+	var maxRetransmits uint16 = 0
+	signal := transportc.NewDebugSignal(8)
+	negotiated := []transportc.NegotiatedDataChannel{
+		{Label: "UNRELIABLE_LABEL", ID: 100, Unordered: true, MaxRetransmits: &maxRetransmits},
+	}
+
+	closeReasons := make(chan error, 1)
+	listenerConfig := &transportc.Config{
+		Events: &transportc.Events{
+			OnPeerConnectionClose: func(_ *webrtc.PeerConnection, reason error) {
+				select {
+				case closeReasons <- reason:
+				default:
+				}
+			},
+		},
+		NegotiatedDataChannels: negotiated,
+		PreSharedKey:           []byte("PSK"),
+		Signal:                 signal,
+	}
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	dialerConfig := &transportc.Config{
+		NegotiatedDataChannels: negotiated,
+		PreSharedKey:           []byte("PSK"),
+		ReusePeerConnection:    true,
+		Signal:                 signal,
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	// The handshake is done once per PeerConnection, not on the unreliable DataChannel
+	cConn, err := dialer.DialNegotiatedContext(ctx, 100)
+	if err != nil {
+		t.Fatalf("DialNegotiatedContext error: %v", err)
+	}
+	defer cConn.Close() // skipcq: GO-S2307
+
+	sConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept error: %v", err)
+	}
+	defer sConn.Close() // skipcq: GO-S2307
+
+	_, err = cConn.Write([]byte("Hello"))
+	if err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	buf := make([]byte, 1024)
+	n, err := sConn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if string(buf[:n]) != "Hello" {
+		t.Fatalf("Read returned %s", string(buf[:n]))
+	}
+
+	// More Conns on the authenticated PeerConnection
+	cConn2, err := dialer.DialContext(ctx, "RANDOM_LABEL")
+	if err != nil {
+		t.Fatalf("DialContext error: %v", err)
+	}
+	defer cConn2.Close() // skipcq: GO-S2307
+
+	sConn2, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept error: %v", err)
+	}
+	defer sConn2.Close() // skipcq: GO-S2307
+
+	_, err = cConn2.Write([]byte("World"))
+	if err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	n, err = sConn2.Read(buf)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if string(buf[:n]) != "World" {
+		t.Fatalf("Read returned %s", string(buf[:n]))
+	}
+
+	select {
+	case reason := <-closeReasons:
+		t.Fatalf("PeerConnection closed for %v", reason)
+	default:
+	}
+}
+
+func TestPreSharedKeyReservedDataChannelID(t *testing.T) {
+	config := &transportc.Config{
+		NegotiatedDataChannels: []transportc.NegotiatedDataChannel{
+			{Label: "NEGOTIATED_LABEL", ID: transportc.PSK_HANDSHAKE_DATACHANNEL_ID},
+		},
+		Signal: transportc.NewDebugSignal(8),
+	}
+	if _, err := config.NewDialer(); !errors.Is(err, transportc.ErrReservedDataChannelID) {
+		t.Fatalf("NewDialer should fail with ErrReservedDataChannelID, got %v", err)
+	}
+	if _, err := config.NewListener(); !errors.Is(err, transportc.ErrReservedDataChannelID) {
+		t.Fatalf("NewListener should fail with ErrReservedDataChannelID, got %v", err)
+	}
+}
+
+// newPSKHandshakeDataChannel creates the PSK handshake DataChannel on pc, queuing
+// the messages received into recv.
+func newPSKHandshakeDataChannel(t *testing.T, pc *webrtc.PeerConnection, recv chan []byte) (*webrtc.DataChannel, chan struct{}) {
+	ordered, negotiated := true, true
+	id := uint16(transportc.PSK_HANDSHAKE_DATACHANNEL_ID)
+	dc, err := pc.CreateDataChannel(transportc.PSK_HANDSHAKE_DATACHANNEL_LABEL, &webrtc.DataChannelInit{
+		Ordered:    &ordered,
+		Negotiated: &negotiated,
+		ID:         &id,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened := make(chan struct{})
+	dc.OnOpen(func() { close(opened) })
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) { recv <- msg.Data })
+	return dc, opened
+}
+
+// relayMessages sends the messages received on the other end to dc once opened.
+func relayMessages(dc *webrtc.DataChannel, opened chan struct{}, recv chan []byte) {
+	<-opened
+	for msg := range recv {
+		dc.Send(msg) // skipcq: GSC-G104
+	}
+}
+
+// startPSKRelay runs a man in the middle between the dialer and the listener: it
+// answers the offer of the dialer on dialerSignal and offers to the listener on
+// listenerSignal, with a PeerConnection of its own toward each of them, and relays
+// the messages of the PSK handshake DataChannel unchanged between the two DTLS
+// sessions.
+func startPSKRelay(t *testing.T, ctx context.Context, dialerSignal, listenerSignal transportc.Signal) {
+	toDialer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	toListener, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		toDialer.Close()   // skipcq: GSC-G104
+		toListener.Close() // skipcq: GSC-G104
+	})
+
+	fromDialer, fromListener := make(chan []byte, 8), make(chan []byte, 8)
+	dialerDC, dialerOpened := newPSKHandshakeDataChannel(t, toDialer, fromDialer)
+	listenerDC, listenerOpened := newPSKHandshakeDataChannel(t, toListener, fromListener)
+	go relayMessages(listenerDC, listenerOpened, fromDialer)
+	go relayMessages(dialerDC, dialerOpened, fromListener)
+
+	go func() {
+		// Offer to the listener
+		offer, err := toListener.CreateOffer(nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		gathered := webrtc.GatheringCompletePromise(toListener)
+		if err := toListener.SetLocalDescription(offer); err != nil {
+			t.Error(err)
+			return
+		}
+		<-gathered
+		offerBytes, _ := json.Marshal(toListener.LocalDescription())
+		offerID, err := listenerSignal.Offer(offerBytes)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		// Answer the dialer
+		dialerOfferID, dialerOffer := readOffer(ctx, dialerSignal)
+		var sdp webrtc.SessionDescription
+		json.Unmarshal(dialerOffer, &sdp) // skipcq: GSC-G104
+		if err := toDialer.SetRemoteDescription(sdp); err != nil {
+			t.Error(err)
+			return
+		}
+		answer, err := toDialer.CreateAnswer(nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		gathered = webrtc.GatheringCompletePromise(toDialer)
+		if err := toDialer.SetLocalDescription(answer); err != nil {
+			t.Error(err)
+			return
+		}
+		<-gathered
+		answerBytes, _ := json.Marshal(toDialer.LocalDescription())
+		if err := dialerSignal.Answer(dialerOfferID, answerBytes); err != nil {
+			t.Error(err)
+			return
+		}
+
+		for ctx.Err() == nil {
+			listenerAnswer, err := listenerSignal.ReadAnswer(offerID)
+			if err != nil {
+				time.Sleep(50 * time.Millisecond)
+				continue
+			}
+			json.Unmarshal(listenerAnswer, &sdp) // skipcq: GSC-G104
+			toListener.SetRemoteDescription(sdp) // skipcq: GSC-G104
+			return
+		}
+	}()
+}
+
+func readOffer(ctx context.Context, signal transportc.Signal) (uint64, []byte) {
+	for ctx.Err() == nil {
+		offerID, offer, err := signal.ReadOffer()
+		if err == nil {
+			return offerID, offer
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return 0, nil
+}
+
+func TestPreSharedKeyHandshakeRelayed(t *testing.T) {
+	dialerSignal, listenerSignal := transportc.NewDebugSignal(8), transportc.NewDebugSignal(8)
+
+	listenerConfig := &transportc.Config{
+		PreSharedKey: []byte("PSK"),
+		Signal:       listenerSignal,
+	}
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	dialerConfig := &transportc.Config{
+		PreSharedKey: []byte("PSK"),
+		Signal:       dialerSignal,
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	// The DTLS fingerprints seen by the two ends differ, so the relayed
+	// responses are rejected
+	startPSKRelay(t, ctx, dialerSignal, listenerSignal)
+	_, err = dialer.DialContext(ctx, "RANDOM_LABEL")
+	if !errors.Is(err, transportc.ErrHandshakeFailed) {
+		t.Fatalf("DialContext through a relay should fail with ErrHandshakeFailed, got %v", err)
+	}
+}
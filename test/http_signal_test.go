@@ -0,0 +1,147 @@
+package transportc_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+)
+
+func TestHTTPSignal(t *testing.T) {
+	server := httptest.NewServer(transportc.NewHTTPSignalServer(0))
+	defer server.Close()
+
+	hs := transportc.NewHTTPSignal(server.URL)
+	hs.PollTimeout = 100 * time.Millisecond
+
+	_, _, err := hs.ReadOffer()
+	if !errors.Is(err, transportc.ErrOfferNotReady) {
+		t.Fatalf("ReadOffer without offer should fail with ErrOfferNotReady, got %v", err)
+	}
+
+	offerID, err := hs.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+
+	_, err = hs.ReadAnswer(offerID)
+	if !errors.Is(err, transportc.ErrAnswerNotReady) {
+		t.Fatalf("ReadAnswer before answer should fail with ErrAnswerNotReady, got %v", err)
+	}
+
+	oid, offer, err := hs.ReadOffer()
+	if err != nil {
+		t.Fatalf("Error reading offer: %v", err)
+	}
+	if oid != offerID || !bytes.Equal(offer, []byte("OFFER")) {
+		t.Fatalf("Offer output does not match offer input")
+	}
+
+	// Long-polling ReadAnswer returns as soon as the answer is submitted
+	hs.PollTimeout = 5 * time.Second
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		hs.Answer(offerID, []byte("ANSWER")) // skipcq: GSC-G104
+	}()
+	answer, err := hs.ReadAnswer(offerID)
+	if err != nil {
+		t.Fatalf("Error reading answer: %v", err)
+	}
+	if !bytes.Equal(answer, []byte("ANSWER")) {
+		t.Fatalf("Answer output does not match answer input")
+	}
+
+	err = hs.Answer(offerID, []byte("ANSWER"))
+	if !errors.Is(err, transportc.ErrInvalidOfferID) {
+		t.Fatalf("Answering twice should fail with ErrInvalidOfferID, got %v", err)
+	}
+
+	_, err = hs.ReadAnswer(offerID)
+	if !errors.Is(err, transportc.ErrInvalidOfferID) {
+		t.Fatalf("Reading answer twice should fail with ErrInvalidOfferID, got %v", err)
+	}
+}
+
+func TestHTTPSignalOfferExpiry(t *testing.T) {
+	server := httptest.NewServer(transportc.NewHTTPSignalServer(100 * time.Millisecond))
+	defer server.Close()
+
+	hs := transportc.NewHTTPSignal(server.URL)
+	hs.PollTimeout = 0
+
+	offerID, err := hs.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	_, _, err = hs.ReadOffer()
+	if !errors.Is(err, transportc.ErrOfferNotReady) {
+		t.Fatalf("ReadOffer should fail with ErrOfferNotReady after expiry, got %v", err)
+	}
+
+	_, err = hs.ReadAnswer(offerID)
+	if !errors.Is(err, transportc.ErrInvalidOfferID) {
+		t.Fatalf("ReadAnswer should fail with ErrInvalidOfferID after expiry, got %v", err)
+	}
+}
+
+func TestHTTPSignalDialContext(t *testing.T) {
+	server := httptest.NewServer(transportc.NewHTTPSignalServer(0))
+	defer server.Close()
+
+	listenerSignal := transportc.NewHTTPSignal(server.URL)
+	listenerSignal.PollTimeout = time.Second
+	listenerConfig := &transportc.Config{
+		Signal: listenerSignal,
+	}
+
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	dialerConfig := &transportc.Config{
+		Signal: transportc.NewHTTPSignal(server.URL),
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	cConn, err := dialer.DialContext(ctx, "RANDOM_LABEL")
+	if err != nil {
+		t.Fatalf("DialContext error: %v", err)
+	}
+	defer cConn.Close() // skipcq: GO-S2307
+
+	sConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept error: %v", err)
+	}
+	defer sConn.Close() // skipcq: GO-S2307
+
+	_, err = cConn.Write([]byte("Hello"))
+	if err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	buf := make([]byte, 1024)
+	n, err := sConn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if string(buf[:n]) != "Hello" {
+		t.Fatalf("Read returned %s", string(buf[:n]))
+	}
+}
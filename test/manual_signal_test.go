@@ -0,0 +1,196 @@
+package transportc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+	"github.com/pion/webrtc/v3"
+)
+
+// gatheredOffer returns a DataChannel-only offer with all candidates gathered.
+func gatheredOffer(t *testing.T) (*webrtc.PeerConnection, webrtc.SessionDescription) {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pc.CreateDataChannel("RANDOM_LABEL", nil); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gatherComplete
+	return pc, *pc.LocalDescription()
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+	offerer, offer := gatheredOffer(t)
+	defer offerer.Close()
+
+	for _, encoding := range []transportc.TokenEncoding{transportc.TokenBase64, transportc.TokenBase32} {
+		token, err := transportc.EncodeToken(offer, encoding)
+		if err != nil {
+			t.Fatalf("EncodeToken error: %v", err)
+		}
+		if len(token) >= len(offer.SDP)/2 {
+			t.Fatalf("Token of %d bytes is not compact for SDP of %d bytes", len(token), len(offer.SDP))
+		}
+
+		if encoding == transportc.TokenBase32 {
+			token = strings.ToLower(token) // base32 tokens are case-insensitive
+		}
+		decoded, err := transportc.DecodeToken(token)
+		if err != nil {
+			t.Fatalf("DecodeToken error: %v", err)
+		}
+		if decoded.Type != webrtc.SDPTypeOffer {
+			t.Fatalf("Decoded SDP type %s, expecting offer", decoded.Type)
+		}
+
+		// The rebuilt offer is accepted and answered by a PeerConnection
+		answerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := answerer.SetRemoteDescription(decoded); err != nil {
+			t.Fatalf("SetRemoteDescription with decoded offer error: %v", err)
+		}
+		if _, err := answerer.CreateAnswer(nil); err != nil {
+			t.Fatalf("CreateAnswer for decoded offer error: %v", err)
+		}
+		answerer.Close()
+	}
+}
+
+func TestTokenChecksum(t *testing.T) {
+	offerer, offer := gatheredOffer(t)
+	defer offerer.Close()
+
+	token, err := transportc.EncodeToken(offer, transportc.TokenBase64)
+	if err != nil {
+		t.Fatalf("EncodeToken error: %v", err)
+	}
+
+	// Change a single character in the middle of the token
+	i := len(token) / 2
+	replacement := "A"
+	if token[i] == 'A' {
+		replacement = "B"
+	}
+	tampered := token[:i] + replacement + token[i+1:]
+
+	_, err = transportc.DecodeToken(tampered)
+	if !errors.Is(err, transportc.ErrInvalidToken) {
+		t.Fatalf("DecodeToken of tampered token should fail with ErrInvalidToken, got %v", err)
+	}
+
+	_, err = transportc.DecodeToken(token[:len(token)-1])
+	if !errors.Is(err, transportc.ErrInvalidToken) {
+		t.Fatalf("DecodeToken of truncated token should fail with ErrInvalidToken, got %v", err)
+	}
+}
+
+func TestManualSignalDialContext(t *testing.T) {
+	// The tokens written by each side are "pasted" to the other side
+	dialerIn, listenerOut := io.Pipe()
+	listenerIn, dialerOut := io.Pipe()
+	defer dialerIn.Close()
+	defer listenerIn.Close()
+
+	listenerConfig := &transportc.Config{
+		Signal: transportc.NewManualSignal(listenerIn, listenerOut),
+	}
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	dialerSignal := transportc.NewManualSignal(dialerIn, dialerOut)
+	dialerSignal.Encoding = transportc.TokenBase32
+	dialerConfig := &transportc.Config{
+		Signal: dialerSignal,
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	cConn, err := dialer.DialContext(ctx, "RANDOM_LABEL")
+	if err != nil {
+		t.Fatalf("DialContext error: %v", err)
+	}
+	defer cConn.Close() // skipcq: GO-S2307
+
+	sConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept error: %v", err)
+	}
+	defer sConn.Close() // skipcq: GO-S2307
+
+	_, err = cConn.Write([]byte("Hello"))
+	if err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	buf := make([]byte, 1024)
+	n, err := sConn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if string(buf[:n]) != "Hello" {
+		t.Fatalf("Read returned %s", string(buf[:n]))
+	}
+}
+
+// countingSignal counts the calls to ReadOffer.
+type countingSignal struct {
+	transportc.Signal
+	readOffers atomic.Int64
+}
+
+func (s *countingSignal) ReadOffer() (uint64, []byte, error) {
+	s.readOffers.Add(1)
+	return s.Signal.ReadOffer()
+}
+
+func TestManualSignalEOF(t *testing.T) {
+	ms := transportc.NewManualSignal(strings.NewReader(""), io.Discard)
+	for i := 0; i < 2; i++ {
+		if _, _, err := ms.ReadOffer(); !errors.Is(err, transportc.ErrSignalClosed) {
+			t.Fatalf("ReadOffer after EOF should fail with ErrSignalClosed, got %v", err)
+		}
+	}
+
+	// The Listener stops reading offers instead of spinning on the error
+	signal := &countingSignal{Signal: transportc.NewManualSignal(strings.NewReader(""), io.Discard)}
+	listenerConfig := &transportc.Config{
+		Signal: signal,
+	}
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	time.Sleep(500 * time.Millisecond)
+	if n := signal.readOffers.Load(); n != 1 {
+		t.Fatalf("ReadOffer called %d times after EOF, expected 1", n)
+	}
+}
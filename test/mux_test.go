@@ -0,0 +1,229 @@
+package transportc_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+	"github.com/gaukas/transportc/mux"
+)
+
+// echoStreams echoes back everything read from each accepted stream until EOF.
+func echoStreams(session *mux.Session) {
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer stream.Close()
+			io.Copy(stream, stream) // skipcq: GSC-G104
+		}()
+	}
+}
+
+// roundTripStream writes msg into a new stream, half-closes it and verifies the echo.
+func roundTripStream(session *mux.Session, msg []byte) error {
+	stream, err := session.OpenStream()
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := stream.Write(msg)
+		if err == nil {
+			err = stream.CloseWrite()
+		}
+		errChan <- err
+	}()
+
+	echo, err := io.ReadAll(stream)
+	if err != nil {
+		return err
+	}
+	if err := <-errChan; err != nil {
+		return err
+	}
+	if !bytes.Equal(echo, msg) {
+		return errors.New("echo mismatch")
+	}
+	return nil
+}
+
+func TestMuxPipe(t *testing.T) {
+	cPipe, sPipe := net.Pipe()
+
+	client, err := mux.Client(cPipe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server, err := mux.Server(sPipe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go echoStreams(server)
+
+	// Larger than the stream window to exercise flow control
+	longMsg := make([]byte, 4*mux.INITIAL_STREAM_WINDOW)
+	rand.Read(longMsg)
+	if err := roundTripStream(client, longMsg); err != nil {
+		t.Fatalf("Round trip of long message error: %v", err)
+	}
+
+	// Many concurrent short-lived streams
+	var wg sync.WaitGroup
+	errs := make(chan error, 1000)
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- roundTripStream(client, []byte{byte(i), byte(i >> 8)})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Round trip error: %v", err)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond) // wait for the server side to close
+	if n := client.NumStreams(); n != 0 {
+		t.Fatalf("Client has %d streams left open", n)
+	}
+	if n := server.NumStreams(); n != 0 {
+		t.Fatalf("Server has %d streams left open", n)
+	}
+}
+
+func TestMuxStreamReadDeadline(t *testing.T) {
+	cPipe, sPipe := net.Pipe()
+
+	client, err := mux.Client(cPipe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server, err := mux.Server(sPipe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	stream, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	stream.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = stream.Read(make([]byte, 16))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read should fail with os.ErrDeadlineExceeded, got %v", err)
+	}
+}
+
+func TestMuxKeepAliveTimeout(t *testing.T) {
+	cPipe, sPipe := net.Pipe()
+	defer sPipe.Close()
+	go io.Copy(io.Discard, sPipe) // skipcq: GSC-G104 // remote peer never responds
+
+	config := mux.DefaultConfig()
+	config.KeepAliveInterval = 20 * time.Millisecond
+	config.KeepAliveTimeout = 100 * time.Millisecond
+
+	client, err := mux.Client(cPipe, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-client.CloseChan():
+	case <-time.After(time.Second):
+		t.Fatal("Session not closed after keepalive timeout")
+	}
+
+	_, err = client.AcceptStream()
+	if !errors.Is(err, mux.ErrKeepAliveTimeout) {
+		t.Fatalf("AcceptStream should fail with ErrKeepAliveTimeout, got %v", err)
+	}
+}
+
+func TestMuxConn(t *testing.T) {
+	config := &transportc.Config{
+		Signal: transportc.NewDebugSignal(8),
+	}
+
+	// Setup a listener to accept the connection first
+	listener, err := config.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+	listener.Start()
+
+	dialer, err := config.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	cConn, err := dialer.DialContext(ctx, "RANDOM_LABEL")
+	if err != nil {
+		t.Fatalf("DialContext error: %v", err)
+	}
+
+	sConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept error: %v", err)
+	}
+
+	client, err := mux.Client(cConn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	server, err := mux.Server(sConn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go echoStreams(server)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 1000)
+	for i := 0; i < 1000; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- roundTripStream(client, []byte{byte(i), byte(i >> 8)})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Round trip error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package transportc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+	"github.com/pion/webrtc/v3"
+)
+
+// openedChannels records the DataChannels opened on one end.
+type openedChannels struct {
+	mutex    sync.Mutex
+	channels map[uint16]string // ID to "label ordered maxRetransmits"
+}
+
+func (o *openedChannels) events() *transportc.Events {
+	o.channels = make(map[uint16]string)
+	return &transportc.Events{
+		OnDataChannelOpen: func(_ *webrtc.PeerConnection, dc *webrtc.DataChannel) {
+			o.mutex.Lock()
+			defer o.mutex.Unlock()
+			var maxRetransmits interface{} = "nil"
+			if dc.MaxRetransmits() != nil {
+				maxRetransmits = *dc.MaxRetransmits()
+			}
+			o.channels[*dc.ID()] = fmt.Sprintf("%s %t %v", dc.Label(), dc.Ordered(), maxRetransmits)
+		},
+	}
+}
+
+func (o *openedChannels) get() map[uint16]string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	channels := make(map[uint16]string, len(o.channels))
+	for id, desc := range o.channels {
+		channels[id] = desc
+	}
+	return channels
+}
+
+func TestDialNegotiated(t *testing.T) {
+	var maxRetransmits uint16 = 0
+	signal := transportc.NewDebugSignal(8)
+	negotiated := []transportc.NegotiatedDataChannel{
+		{Label: "NEGOTIATED_LABEL", ID: 100},
+		{Label: "NEGOTIATED_LABEL_2", ID: 102, Unordered: true, MaxRetransmits: &maxRetransmits},
+	}
+	var listenerChannels, dialerChannels openedChannels
+
+	// Setup a listener to accept the connection first
+	listenerConfig := &transportc.Config{
+		Signal:                 signal,
+		NegotiatedDataChannels: negotiated,
+		Events:                 listenerChannels.events(),
+	}
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+	listener.Start()
+
+	dialerConfig := &transportc.Config{
+		Signal:                 signal,
+		NegotiatedDataChannels: negotiated,
+		ReusePeerConnection:    true,
+		Events:                 dialerChannels.events(),
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	_, err = dialer.DialNegotiatedContext(ctx, 7)
+	if !errors.Is(err, transportc.ErrUnknownDataChannelID) {
+		t.Fatalf("DialNegotiatedContext with unknown ID should fail with ErrUnknownDataChannelID, got %v", err)
+	}
+
+	cConns := make(map[uint16]net.Conn)
+	for _, n := range negotiated {
+		cConn, err := dialer.DialNegotiatedContext(ctx, n.ID)
+		if err != nil {
+			t.Fatalf("DialNegotiatedContext error: %v", err)
+		}
+		defer cConn.Close() // skipcq: GO-S2307
+		cConns[n.ID] = cConn
+	}
+
+	_, err = dialer.DialNegotiatedContext(ctx, 100)
+	if !errors.Is(err, transportc.ErrDataChannelInUse) {
+		t.Fatalf("Second DialNegotiatedContext should fail with ErrDataChannelInUse, got %v", err)
+	}
+
+	for id, cConn := range cConns {
+		if _, err := cConn.Write([]byte(fmt.Sprintf("Hello %d", id))); err != nil {
+			t.Fatalf("Write error: %v", err)
+		}
+	}
+
+	// Both pre-negotiated DataChannels are delivered through Accept, and each
+	// echoes the message written on its own channel
+	received := make(map[string]bool)
+	buf := make([]byte, 1024)
+	for i := 0; i < len(negotiated); i++ {
+		sConn, err := listener.Accept()
+		if err != nil {
+			t.Fatalf("Accept error: %v", err)
+		}
+		defer sConn.Close() // skipcq: GO-S2307
+
+		sConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := sConn.Read(buf)
+		if err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+		received[string(buf[:n])] = true
+		if _, err := sConn.Write(buf[:n]); err != nil {
+			t.Fatalf("Write error: %v", err)
+		}
+	}
+	for id, cConn := range cConns {
+		hello := fmt.Sprintf("Hello %d", id)
+		if !received[hello] {
+			t.Fatalf("Listener did not receive %q, got %v", hello, received)
+		}
+		cConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := cConn.Read(buf)
+		if err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+		if string(buf[:n]) != hello {
+			t.Fatalf("DataChannel %d echoed %q, expected %q", id, buf[:n], hello)
+		}
+	}
+
+	// The DataChannels have the same ID, label and reliability on both ends
+	dialerOpened, listenerOpened := dialerChannels.get(), listenerChannels.get()
+	if len(dialerOpened) != len(negotiated) || !reflect.DeepEqual(dialerOpened, listenerOpened) {
+		t.Fatalf("DataChannels opened by the dialer %v and the listener %v do not match", dialerOpened, listenerOpened)
+	}
+	for _, n := range negotiated {
+		if desc := dialerOpened[n.ID]; !strings.HasPrefix(desc, n.Label+" ") {
+			t.Fatalf("DataChannel %d opened as %q, expected label %q", n.ID, desc, n.Label)
+		}
+	}
+	if desc := dialerOpened[102]; desc != "NEGOTIATED_LABEL_2 false 0" {
+		t.Fatalf("DataChannel 102 opened as %q, expected unordered without retransmission", desc)
+	}
+
+	// Once closed, the DataChannel is dialed again on a new PeerConnection
+	cConns[100].Close()
+	cConn, err := dialer.DialNegotiatedContext(ctx, 100)
+	if err != nil {
+		t.Fatalf("DialNegotiatedContext after Close error: %v", err)
+	}
+	cConn.Close()
+}
+
+func TestNegotiatedDataChannelsDuplicateID(t *testing.T) {
+	config := &transportc.Config{
+		NegotiatedDataChannels: []transportc.NegotiatedDataChannel{
+			{Label: "NEGOTIATED_LABEL", ID: 100},
+			{Label: "NEGOTIATED_LABEL_2", ID: 100},
+		},
+	}
+
+	_, err := config.NewDialer()
+	if !errors.Is(err, transportc.ErrDuplicateDataChannelID) {
+		t.Fatalf("NewDialer should fail with ErrDuplicateDataChannelID, got %v", err)
+	}
+
+	_, err = config.NewListener()
+	if !errors.Is(err, transportc.ErrDuplicateDataChannelID) {
+		t.Fatalf("NewListener should fail with ErrDuplicateDataChannelID, got %v", err)
+	}
+}
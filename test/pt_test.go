@@ -0,0 +1,214 @@
+package transportc_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc/internal/pt"
+	"github.com/gaukas/transportc/internal/socks5"
+)
+
+func TestPTClientArgs(t *testing.T) {
+	args := pt.Args{
+		"signal": {"https://example.com/a;b=c"},
+		"ice":    {"stun:stun1.example.com:3478", "stun:stun2.example.com:3478"},
+		"psk":    {strings.Repeat("ab", 150)}, // spills over the password
+		`k\=`:    {`v\`},
+	}
+	username, password, err := pt.EncodeClientArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(username) > 255 || len(password) > 255 {
+		t.Fatalf("Credentials of %d and %d bytes exceed 255 bytes", len(username), len(password))
+	}
+
+	parsed, err := pt.ParseClientArgs(username, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, args) {
+		t.Fatalf("Parsed args %v do not match encoded args %v", parsed, args)
+	}
+
+	parsed, err = pt.ParseClientArgs(`a=1;b=x\;y`, "\x00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := parsed.Get("b"); v != "x;y" {
+		t.Fatalf("Escaped value parsed as %q", v)
+	}
+
+	for _, bad := range []string{"novalue", "=v", `a=1\`} {
+		if _, err := pt.ParseClientArgs(bad, ""); !errors.Is(err, pt.ErrInvalidArgs) {
+			t.Fatalf("ParseClientArgs(%q) should fail with ErrInvalidArgs, got %v", bad, err)
+		}
+	}
+
+	if _, _, err := pt.EncodeClientArgs(pt.Args{"k": {strings.Repeat("v", 512)}}); !errors.Is(err, pt.ErrInvalidArgs) {
+		t.Fatalf("Encoding args exceeding the credentials should fail with ErrInvalidArgs, got %v", err)
+	}
+}
+
+func TestPTServerTransportOptions(t *testing.T) {
+	options, err := pt.ParseServerTransportOptions(`transportc:signal=dir:/tmp/a\;b;transportc:ice=stun:example.com;other:k=v`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]pt.Args{
+		"transportc": {"signal": {"dir:/tmp/a;b"}, "ice": {"stun:example.com"}},
+		"other":      {"k": {"v"}},
+	}
+	if !reflect.DeepEqual(options, expected) {
+		t.Fatalf("Parsed options %v do not match %v", options, expected)
+	}
+
+	if _, err := pt.ParseServerTransportOptions("k=v"); !errors.Is(err, pt.ErrInvalidArgs) {
+		t.Fatalf("Option without transport name should fail with ErrInvalidArgs, got %v", err)
+	}
+}
+
+// startManagedProxy runs the transportc-pt binary with the environment and returns
+// the fields of the line starting with keyword, once the line done is read.
+func startManagedProxy(t *testing.T, bin string, env []string, keyword, done string) []string {
+	cmd := exec.Command(bin)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		stdin.Close() // TOR_PT_EXIT_ON_STDIN_CLOSE
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("Managed proxy exited with %v", err)
+		}
+	})
+
+	var fields []string
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		t.Logf("%s", line)
+		if strings.HasPrefix(line, keyword+" ") {
+			fields = strings.Fields(line)
+		}
+		if strings.HasSuffix(line, "-ERROR") || strings.Contains(line, "-ERROR ") {
+			t.Fatalf("Managed proxy reported %q", line)
+		}
+		if line == done {
+			go io.Copy(io.Discard, stdout) // skipcq: GSC-G104
+			if fields == nil {
+				t.Fatalf("No %s line before %s", keyword, done)
+			}
+			return fields
+		}
+	}
+	t.Fatalf("Managed proxy exited before %s: %v", done, scanner.Err())
+	return nil
+}
+
+// startPTBridge runs a server and a client managed proxy, the server with the
+// ServerTransportOptions, and returns the fingerprint the server advertises and
+// a function dialing the bridge through the client.
+func startPTBridge(t *testing.T, options string) (string, func(args pt.Args) (net.Conn, error)) {
+	bin := buildCommand(t, "transportc-pt")
+	dir := t.TempDir()
+
+	// The ORPort echoes back
+	orPort := startEchoServer(t, "127.0.0.1:0")
+
+	common := []string{
+		"TOR_PT_MANAGED_TRANSPORT_VER=1",
+		"TOR_PT_EXIT_ON_STDIN_CLOSE=1",
+	}
+	smethod := startManagedProxy(t, bin, append([]string{
+		"TOR_PT_STATE_LOCATION=" + filepath.Join(dir, "server"),
+		"TOR_PT_ORPORT=" + orPort,
+		"TOR_PT_SERVER_BINDADDR=transportc-127.0.0.1:0",
+		"TOR_PT_SERVER_TRANSPORTS=transportc",
+		"TOR_PT_SERVER_TRANSPORT_OPTIONS=" + options,
+	}, common...), "SMETHOD", "SMETHODS DONE")
+	if len(smethod) != 4 || smethod[1] != "transportc" || !strings.HasPrefix(smethod[3], "ARGS:dtls-fingerprint=") {
+		t.Fatalf("Unexpected SMETHOD line %q", smethod)
+	}
+	bridgeAddr := smethod[2]
+	fingerprint := strings.TrimPrefix(smethod[3], "ARGS:dtls-fingerprint=")
+
+	cmethod := startManagedProxy(t, bin, append([]string{
+		"TOR_PT_STATE_LOCATION=" + filepath.Join(dir, "client"),
+		"TOR_PT_CLIENT_TRANSPORTS=transportc",
+	}, common...), "CMETHOD", "CMETHODS DONE")
+	if len(cmethod) != 4 || cmethod[1] != "transportc" || cmethod[2] != "socks5" {
+		t.Fatalf("Unexpected CMETHOD line %q", cmethod)
+	}
+
+	dial := func(args pt.Args) (net.Conn, error) {
+		conn, err := net.Dial("tcp", cmethod[3])
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(30 * time.Second)) // skipcq: GSC-G104
+		username, password, err := pt.EncodeClientArgs(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := socks5.ClientHandshake(conn, socks5.CMD_CONNECT, bridgeAddr, username, password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	return fingerprint, dial
+}
+
+func TestPTManagedProxy(t *testing.T) {
+	fingerprint, dial := startPTBridge(t, "transportc:psk=00112233")
+
+	conn, err := dial(pt.Args{"dtls-fingerprint": {fingerprint}, "psk": {"00112233"}})
+	if err != nil {
+		t.Fatalf("Error connecting through the managed proxy: %v", err)
+	}
+	defer conn.Close()
+
+	checkEcho(t, conn, []byte("HELLO THROUGH THE BRIDGE"))
+
+	// A wrong fingerprint is rejected
+	if _, err := dial(pt.Args{"dtls-fingerprint": {strings.Repeat("00:", 31) + "00"}, "psk": {"00112233"}}); !errors.Is(err, socks5.ErrRequestFailed) {
+		t.Fatalf("Connecting with a wrong fingerprint should fail with ErrRequestFailed, got %v", err)
+	}
+}
+
+func TestPTManagedProxyTimeout(t *testing.T) {
+	fingerprint, dial := startPTBridge(t, "transportc:timeout=1s")
+
+	conn, err := dial(pt.Args{"dtls-fingerprint": {fingerprint}})
+	if err != nil {
+		t.Fatalf("Error connecting through the managed proxy: %v", err)
+	}
+	defer conn.Close()
+
+	checkEcho(t, conn, []byte("HELLO THROUGH THE BRIDGE"))
+
+	// The server closes the Conn idle for longer than the timeout option
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second)) // skipcq: GSC-G104
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("Idle connection should be closed, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package transportc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc/ptadapter"
+)
+
+func TestPTAdapterTransport(t *testing.T) {
+	config, err := json.Marshal(map[string]interface{}{
+		"signal":  "dir:" + t.TempDir(),
+		"psk":     "00112233445566778899aabbccddeeff",
+		"label":   "pt",
+		"timeout": "10s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := ptadapter.NewTransportFromJSON(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := ptadapter.NewTransportFromJSON(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// The transports are used through the standard interfaces only
+	var listener net.Listener
+	listener, err = server.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listener.Addr() == nil {
+		t.Fatalf("Listener has no address")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var cConn net.Conn
+	cConn, err = client.DialContext(ctx)
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	defer cConn.Close()
+
+	sConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Error accepting: %v", err)
+	}
+	defer sConn.Close()
+
+	msg := []byte("HELLO PT")
+	if _, err := cConn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := sConn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], msg) {
+		t.Fatalf("Received %q, expected %q", buf[:n], msg)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Dial(); !errors.Is(err, ptadapter.ErrTransportClosed) {
+		t.Fatalf("Dial after Close should fail with ErrTransportClosed, got %v", err)
+	}
+}
+
+func TestPTAdapterInvalidConfig(t *testing.T) {
+	for _, config := range []string{
+		`{}`,
+		`{"signal": "ftp://example.com"}`,
+		`{"signal": "https://example.com/signal", "psk": "not hex"}`,
+		`{"signal": "https://example.com/signal", "timeout": "soon"}`,
+		`{"signal": "https://example.com/signal", "unknown": true}`,
+		`{"signal": "https://example.com/signal", "cert-file": "/nonexistent/cert.pem"}`,
+		`not json`,
+	} {
+		if _, err := ptadapter.NewTransportFromJSON([]byte(config)); !errors.Is(err, ptadapter.ErrInvalidConfig) {
+			t.Fatalf("NewTransportFromJSON(%s) should fail with ErrInvalidConfig, got %v", config, err)
+		}
+	}
+}
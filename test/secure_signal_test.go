@@ -0,0 +1,345 @@
+package transportc_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+)
+
+// tamperingSignal flips a bit in every offer, like a malicious rendezvous.
+type tamperingSignal struct {
+	transportc.Signal
+}
+
+func (ts *tamperingSignal) Offer(offer []byte) (uint64, error) {
+	tampered := append([]byte{}, offer...)
+	tampered[len(tampered)-1] ^= 1
+	return ts.Signal.Offer(tampered)
+}
+
+// replayingSignal records every offer and answer read so they can be replayed.
+type replayingSignal struct {
+	transportc.Signal
+	offers  [][]byte
+	answers [][]byte
+}
+
+func (rs *replayingSignal) ReadOffer() (uint64, []byte, error) {
+	offerID, offer, err := rs.Signal.ReadOffer()
+	if err == nil {
+		rs.offers = append(rs.offers, offer)
+	}
+	return offerID, offer, err
+}
+
+func (rs *replayingSignal) ReadAnswer(offerID uint64) ([]byte, error) {
+	answer, err := rs.Signal.ReadAnswer(offerID)
+	if err == nil {
+		rs.answers = append(rs.answers, answer)
+	}
+	return answer, err
+}
+
+func TestSecureSignalPSK(t *testing.T) {
+	ds := transportc.NewDebugSignal(8)
+	offerer := transportc.NewSecureSignalPSK(ds, []byte("PSK"))
+	answerer := transportc.NewSecureSignalPSK(ds, []byte("PSK"))
+
+	offerID, err := offerer.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+	oid, offer, err := answerer.ReadOffer()
+	if err != nil {
+		t.Fatalf("Error reading offer: %v", err)
+	}
+	if oid != offerID || !bytes.Equal(offer, []byte("OFFER")) {
+		t.Fatalf("Offer output does not match offer input")
+	}
+
+	if err := answerer.Answer(offerID, []byte("ANSWER")); err != nil {
+		t.Fatalf("Error answering: %v", err)
+	}
+	answer, err := offerer.ReadAnswer(offerID)
+	if err != nil {
+		t.Fatalf("Error reading answer: %v", err)
+	}
+	if !bytes.Equal(answer, []byte("ANSWER")) {
+		t.Fatalf("Answer output does not match answer input")
+	}
+
+	// Offers sealed under another PSK are rejected
+	intruder := transportc.NewSecureSignalPSK(ds, []byte("NOT PSK"))
+	if _, err := intruder.Offer([]byte("OFFER")); err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+	_, _, err = answerer.ReadOffer()
+	if !errors.Is(err, transportc.ErrSignalAuthentication) {
+		t.Fatalf("ReadOffer of offer under another PSK should fail with ErrSignalAuthentication, got %v", err)
+	}
+}
+
+func TestSecureSignalTampered(t *testing.T) {
+	ds := transportc.NewDebugSignal(8)
+	offerer := transportc.NewSecureSignalPSK(&tamperingSignal{ds}, []byte("PSK"))
+	answerer := transportc.NewSecureSignalPSK(ds, []byte("PSK"))
+
+	if _, err := offerer.Offer([]byte("OFFER")); err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+	_, _, err := answerer.ReadOffer()
+	if !errors.Is(err, transportc.ErrSignalAuthentication) {
+		t.Fatalf("ReadOffer of tampered offer should fail with ErrSignalAuthentication, got %v", err)
+	}
+}
+
+func TestSecureSignalReplayed(t *testing.T) {
+	ds := transportc.NewDebugSignal(8)
+	rendezvous := &replayingSignal{Signal: ds}
+	offerer := transportc.NewSecureSignalPSK(rendezvous, []byte("PSK"))
+	answerer := transportc.NewSecureSignalPSK(rendezvous, []byte("PSK"))
+
+	offerID, err := offerer.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+	if _, _, err := answerer.ReadOffer(); err != nil {
+		t.Fatalf("Error reading offer: %v", err)
+	}
+	if err := answerer.Answer(offerID, []byte("ANSWER")); err != nil {
+		t.Fatalf("Error answering: %v", err)
+	}
+	if _, err := offerer.ReadAnswer(offerID); err != nil {
+		t.Fatalf("Error reading answer: %v", err)
+	}
+
+	// The same offer replayed is rejected
+	if _, err := ds.Offer(rendezvous.offers[0]); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = answerer.ReadOffer()
+	if !errors.Is(err, transportc.ErrSignalReplay) {
+		t.Fatalf("ReadOffer of replayed offer should fail with ErrSignalReplay, got %v", err)
+	}
+
+	// An answer replayed to another offer is rejected
+	offerID, err = offerer.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+	if _, _, err := answerer.ReadOffer(); err != nil {
+		t.Fatalf("Error reading offer: %v", err)
+	}
+	if err := ds.Answer(offerID, rendezvous.answers[0]); err != nil {
+		t.Fatal(err)
+	}
+	_, err = offerer.ReadAnswer(offerID)
+	if !errors.Is(err, transportc.ErrSignalAuthentication) {
+		t.Fatalf("ReadAnswer of answer to another offer should fail with ErrSignalAuthentication, got %v", err)
+	}
+}
+
+func TestSecureSignalBoxUnknownPeer(t *testing.T) {
+	listenerPub, listenerPriv, err := transportc.GenerateSignalKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialerPub, dialerPriv, err := transportc.GenerateSignalKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := transportc.GenerateSignalKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := transportc.NewDebugSignal(8)
+	offerer := transportc.NewSecureSignalBox(ds, dialerPub, dialerPriv, listenerPub)
+	answerer := transportc.NewSecureSignalBox(ds, listenerPub, listenerPriv, otherPub) // dialer not allowed
+
+	if _, err := offerer.Offer([]byte("OFFER")); err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+	_, _, err = answerer.ReadOffer()
+	if !errors.Is(err, transportc.ErrUnknownSignalPeer) {
+		t.Fatalf("ReadOffer from unknown peer should fail with ErrUnknownSignalPeer, got %v", err)
+	}
+}
+
+func TestSecureSignalDialContext(t *testing.T) {
+	listenerPub, listenerPriv, err := transportc.GenerateSignalKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialerPub, dialerPriv, err := transportc.GenerateSignalKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		signal func(t *testing.T) (listenerSignal, dialerSignal transportc.Signal)
+	}{
+		{
+			name: "PSK",
+			signal: func(t *testing.T) (transportc.Signal, transportc.Signal) {
+				ds := transportc.NewDebugSignal(8)
+				return transportc.NewSecureSignalPSK(ds, []byte("PSK")), transportc.NewSecureSignalPSK(ds, []byte("PSK"))
+			},
+		},
+		{
+			name: "Box",
+			signal: func(t *testing.T) (transportc.Signal, transportc.Signal) {
+				server := httptest.NewServer(transportc.NewWebSocketSignalServer(0))
+				t.Cleanup(server.Close)
+				listenerSignal := transportc.NewWebSocketSignal(webSocketURL(server))
+				t.Cleanup(func() { listenerSignal.Close() })
+				dialerSignal := transportc.NewWebSocketSignal(webSocketURL(server))
+				t.Cleanup(func() { dialerSignal.Close() })
+				return transportc.NewSecureSignalBox(listenerSignal, listenerPub, listenerPriv, dialerPub),
+					transportc.NewSecureSignalBox(dialerSignal, dialerPub, dialerPriv, listenerPub)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			listenerSignal, dialerSignal := tc.signal(t)
+
+			listenerConfig := &transportc.Config{
+				Signal: listenerSignal,
+			}
+			listener, err := listenerConfig.NewListener()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+			listener.Start()
+
+			dialerConfig := &transportc.Config{
+				Signal: dialerSignal,
+			}
+			dialer, err := dialerConfig.NewDialer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer dialer.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel() // cancel the context to make sure it is done
+
+			cConn, err := dialer.DialContext(ctx, "RANDOM_LABEL")
+			if err != nil {
+				t.Fatalf("DialContext error: %v", err)
+			}
+			defer cConn.Close() // skipcq: GO-S2307
+
+			sConn, err := listener.Accept()
+			if err != nil {
+				t.Fatalf("Accept error: %v", err)
+			}
+			defer sConn.Close() // skipcq: GO-S2307
+
+			_, err = cConn.Write([]byte("Hello"))
+			if err != nil {
+				t.Fatalf("Write error: %v", err)
+			}
+			buf := make([]byte, 1024)
+			n, err := sConn.Read(buf)
+			if err != nil {
+				t.Fatalf("Read error: %v", err)
+			}
+			if string(buf[:n]) != "Hello" {
+				t.Fatalf("Read returned %s", string(buf[:n]))
+			}
+		})
+	}
+}
+
+// candidateKey identifies the candidates of one peer for an offer.
+type candidateKey struct {
+	offerID     uint64
+	fromOfferer bool
+}
+
+// trickleDebugSignal adds trickled candidates to a DebugSignal. If reflecting,
+// like a malicious rendezvous, every candidate is delivered back to its sender
+// as if from the other peer.
+type trickleDebugSignal struct {
+	transportc.Signal
+	reflecting bool
+
+	mutex      sync.Mutex
+	candidates map[candidateKey][][]byte
+}
+
+func (ts *trickleDebugSignal) Candidate(offerID uint64, fromOfferer bool, candidate []byte) error {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	key := candidateKey{offerID, fromOfferer != ts.reflecting}
+	ts.candidates[key] = append(ts.candidates[key], candidate)
+	return nil
+}
+
+func (ts *trickleDebugSignal) ReadCandidate(offerID uint64, fromOfferer bool) ([]byte, error) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	key := candidateKey{offerID, fromOfferer}
+	if len(ts.candidates[key]) == 0 {
+		return nil, transportc.ErrCandidateNotReady
+	}
+	candidate := ts.candidates[key][0]
+	ts.candidates[key] = ts.candidates[key][1:]
+	return candidate, nil
+}
+
+func TestSecureSignalCandidates(t *testing.T) {
+	for _, reflecting := range []bool{false, true} {
+		ts := &trickleDebugSignal{
+			Signal:     transportc.NewDebugSignal(8),
+			reflecting: reflecting,
+			candidates: make(map[candidateKey][][]byte),
+		}
+		offerer := transportc.NewSecureSignalPSK(ts, []byte("PSK")).(transportc.TrickleSignal)
+		answerer := transportc.NewSecureSignalPSK(ts, []byte("PSK")).(transportc.TrickleSignal)
+
+		offerID, err := offerer.Offer([]byte("OFFER"))
+		if err != nil {
+			t.Fatalf("Error making offer: %v", err)
+		}
+		if _, _, err := answerer.ReadOffer(); err != nil {
+			t.Fatalf("Error reading offer: %v", err)
+		}
+
+		for _, peer := range []struct {
+			sender, receiver transportc.TrickleSignal
+			fromOfferer      bool
+		}{
+			{offerer, answerer, true},
+			{answerer, offerer, false},
+		} {
+			if err := peer.sender.Candidate(offerID, peer.fromOfferer, []byte("CANDIDATE")); err != nil {
+				t.Fatalf("Error sending candidate: %v", err)
+			}
+			if reflecting {
+				// The candidate comes back to its sender as if from the other peer
+				_, err := peer.sender.ReadCandidate(offerID, !peer.fromOfferer)
+				if !errors.Is(err, transportc.ErrSignalAuthentication) {
+					t.Fatalf("ReadCandidate of reflected candidate should fail with ErrSignalAuthentication, got %v", err)
+				}
+				continue
+			}
+			candidate, err := peer.receiver.ReadCandidate(offerID, peer.fromOfferer)
+			if err != nil {
+				t.Fatalf("Error reading candidate: %v", err)
+			}
+			if !bytes.Equal(candidate, []byte("CANDIDATE")) {
+				t.Fatalf("Candidate output does not match candidate input")
+			}
+		}
+	}
+}
@@ -3,6 +3,7 @@ package transportc_test
 import (
 	"bytes"
 	"crypto/rand"
+	"errors"
 	"testing"
 	"time"
 
@@ -78,3 +79,101 @@ func TestDebugSignal(t *testing.T) {
 	}
 	close(chanAnswer)
 }
+
+func TestDebugSignalQueueFull(t *testing.T) {
+	ds := transportc.NewDebugSignal(2)
+
+	for i := 0; i < 2; i++ {
+		if _, err := ds.Offer([]byte("OFFER")); err != nil {
+			t.Fatalf("Error making offer: %v", err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ds.Offer([]byte("OFFER"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, transportc.ErrOfferQueueFull) {
+			t.Fatalf("Offer to a full queue should fail with ErrOfferQueueFull, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Offer to a full queue blocked")
+	}
+
+	// Reading an offer makes room for another
+	if _, _, err := ds.ReadOffer(); err != nil {
+		t.Fatalf("Error reading offer: %v", err)
+	}
+	if _, err := ds.Offer([]byte("OFFER")); err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+}
+
+func TestDebugSignalExpiry(t *testing.T) {
+	ds := transportc.NewDebugSignal(1)
+	ds.SetTTL(100 * time.Millisecond)
+
+	// An offer not read expires and leaves room in the queue
+	if _, err := ds.Offer([]byte("OFFER")); err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, _, err := ds.ReadOffer(); !errors.Is(err, transportc.ErrOfferNotReady) {
+		t.Fatalf("ReadOffer of an expired offer should return ErrOfferNotReady, got %v", err)
+	}
+
+	// An answer not read expires
+	offerID, err := ds.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+	if _, _, err := ds.ReadOffer(); err != nil {
+		t.Fatalf("Error reading offer: %v", err)
+	}
+	if err := ds.Answer(offerID, []byte("ANSWER")); err != nil {
+		t.Fatalf("Error answering: %v", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, err := ds.ReadAnswer(offerID); !errors.Is(err, transportc.ErrInvalidOfferID) {
+		t.Fatalf("ReadAnswer of an expired answer should fail with ErrInvalidOfferID, got %v", err)
+	}
+}
+
+func TestDebugSignalReadAnswerTimeout(t *testing.T) {
+	ds := transportc.NewDebugSignal(1)
+	ds.ReadTimeout = 100 * time.Millisecond
+
+	offerID, err := ds.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+	if _, _, err := ds.ReadOffer(); err != nil {
+		t.Fatalf("Error reading offer: %v", err)
+	}
+
+	start := time.Now()
+	if _, err := ds.ReadAnswer(offerID); !errors.Is(err, transportc.ErrAnswerNotReady) {
+		t.Fatalf("ReadAnswer with no answer should return ErrAnswerNotReady, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < ds.ReadTimeout || elapsed > time.Second {
+		t.Fatalf("ReadAnswer returned after %v, expecting %v", elapsed, ds.ReadTimeout)
+	}
+
+	// ReadAnswer returns as soon as the answer is submitted
+	ds.ReadTimeout = 5 * time.Second
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ds.Answer(offerID, []byte("ANSWER")) // skipcq: GSC-G104
+	}()
+	start = time.Now()
+	answer, err := ds.ReadAnswer(offerID)
+	if err != nil {
+		t.Fatalf("Error reading answer: %v", err)
+	}
+	if string(answer) != "ANSWER" || time.Since(start) > time.Second {
+		t.Fatalf("ReadAnswer returned %s after %v", answer, time.Since(start))
+	}
+}
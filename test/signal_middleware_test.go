@@ -0,0 +1,420 @@
+package transportc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+	"github.com/gaukas/transportc/signaltest"
+)
+
+// unreachableSignal returns an HTTPSignal whose server is down.
+func unreachableSignal(t *testing.T) transportc.Signal {
+	server := httptest.NewServer(transportc.NewHTTPSignalServer(0))
+	server.Close()
+	return transportc.NewHTTPSignal(server.URL)
+}
+
+// flakySignal fails the first failures calls of each method (or only method, if set) with err.
+type flakySignal struct {
+	transportc.Signal
+	err      error
+	failures int
+	method   string
+
+	mutex sync.Mutex
+	calls map[string]int
+}
+
+func (fs *flakySignal) fail(method string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if fs.calls == nil {
+		fs.calls = make(map[string]int)
+	}
+	fs.calls[method]++
+	if (fs.method == "" || fs.method == method) && fs.calls[method] <= fs.failures {
+		return fs.err
+	}
+	return nil
+}
+
+func (fs *flakySignal) Offer(offer []byte) (uint64, error) {
+	if err := fs.fail("Offer"); err != nil {
+		return 0, err
+	}
+	return fs.Signal.Offer(offer)
+}
+
+func (fs *flakySignal) ReadAnswer(offerID uint64) ([]byte, error) {
+	if err := fs.fail("ReadAnswer"); err != nil {
+		return nil, err
+	}
+	return fs.Signal.ReadAnswer(offerID)
+}
+
+// recordingLogger records the lines logged at each level.
+type recordingLogger struct {
+	mutex sync.Mutex
+	lines map[string][]string
+}
+
+func (rl *recordingLogger) log(level, format string, args ...interface{}) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	if rl.lines == nil {
+		rl.lines = make(map[string][]string)
+	}
+	rl.lines[level] = append(rl.lines[level], fmt.Sprintf(format, args...))
+}
+
+func (rl *recordingLogger) Debugf(format string, args ...interface{}) {
+	rl.log("debug", format, args...)
+}
+
+func (rl *recordingLogger) Infof(format string, args ...interface{}) {
+	rl.log("info", format, args...)
+}
+
+func (rl *recordingLogger) Warnf(format string, args ...interface{}) {
+	rl.log("warn", format, args...)
+}
+
+func (rl *recordingLogger) Errorf(format string, args ...interface{}) {
+	rl.log("error", format, args...)
+}
+
+func (rl *recordingLogger) Fatalf(format string, args ...interface{}) {
+	rl.log("fatal", format, args...)
+}
+
+func (rl *recordingLogger) logged(level, substr string) bool {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	for _, line := range rl.lines[level] {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSignalMiddlewareConformance(t *testing.T) {
+	newDebugSignal := func() transportc.Signal {
+		return transportc.NewDebugSignal(2 * signaltest.CONCURRENCY_DEFAULT)
+	}
+
+	for _, tc := range []struct {
+		name string
+		wrap func(t *testing.T, s transportc.Signal) transportc.Signal
+	}{
+		{
+			name: "SignalChain",
+			wrap: func(t *testing.T, s transportc.Signal) transportc.Signal {
+				return transportc.NewSignalChain(unreachableSignal(t), s)
+			},
+		},
+		{
+			name: "RetrySignal",
+			wrap: func(t *testing.T, s transportc.Signal) transportc.Signal {
+				return transportc.NewRetrySignal(&flakySignal{Signal: s, err: transportc.ErrSignalDisconnected, failures: 1}, 0, time.Millisecond, 0)
+			},
+		},
+		{
+			name: "LoggingSignal",
+			wrap: func(t *testing.T, s transportc.Signal) transportc.Signal {
+				return transportc.NewLoggingSignal(s, &recordingLogger{})
+			},
+		},
+		{
+			name: "MetricsSignal",
+			wrap: func(t *testing.T, s transportc.Signal) transportc.Signal {
+				return transportc.NewMetricsSignal(s, &transportc.SignalMetrics{})
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			signaltest.TestSignal(t, func(t *testing.T) (transportc.Signal, transportc.Signal) {
+				ds := newDebugSignal()
+				return tc.wrap(t, ds), tc.wrap(t, ds)
+			})
+		})
+	}
+}
+
+func TestSignalChainFallback(t *testing.T) {
+	primary, secondary := transportc.NewDebugSignal(8), transportc.NewDebugSignal(8)
+	offerer := transportc.NewSignalChain(&flakySignal{Signal: primary, err: errors.New("blocked"), failures: 1, method: "Offer"}, secondary)
+	answerer := transportc.NewSignalChain(primary, secondary)
+
+	// The first offer falls back to the secondary backend, the second goes to the primary
+	firstID, err := offerer.Offer([]byte("FIRST"))
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+	secondID, err := offerer.Offer([]byte("SECOND"))
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+
+	// Backends are read in order
+	for _, want := range []struct {
+		id    uint64
+		offer string
+	}{{secondID, "SECOND"}, {firstID, "FIRST"}} {
+		offerID, offer, err := answerer.ReadOffer()
+		if err != nil {
+			t.Fatalf("Error reading offer: %v", err)
+		}
+		if offerID != want.id || string(offer) != want.offer {
+			t.Fatalf("ReadOffer returned %d %s, expecting %d %s", offerID, offer, want.id, want.offer)
+		}
+		if err := answerer.Answer(offerID, []byte("ANSWER "+want.offer)); err != nil {
+			t.Fatalf("Error answering: %v", err)
+		}
+	}
+	if _, _, err := answerer.ReadOffer(); !errors.Is(err, transportc.ErrOfferNotReady) {
+		t.Fatalf("ReadOffer with no offer should return ErrOfferNotReady, got %v", err)
+	}
+
+	// Answers are read from the backend each offer was submitted to
+	for offerID, want := range map[uint64]string{firstID: "ANSWER FIRST", secondID: "ANSWER SECOND"} {
+		answer, err := offerer.ReadAnswer(offerID)
+		if err != nil {
+			t.Fatalf("Error reading answer: %v", err)
+		}
+		if string(answer) != want {
+			t.Fatalf("ReadAnswer returned %s, expecting %s", answer, want)
+		}
+	}
+
+	// All backends failing
+	_, err = transportc.NewSignalChain(unreachableSignal(t), unreachableSignal(t)).Offer([]byte("OFFER"))
+	if err == nil {
+		t.Fatal("Offer should fail when all backends fail")
+	}
+	if _, err := transportc.NewSignalChain().Offer([]byte("OFFER")); !errors.Is(err, transportc.ErrNoSignalBackend) {
+		t.Fatalf("Offer with no backend should fail with ErrNoSignalBackend, got %v", err)
+	}
+}
+
+func TestSignalChainDialContext(t *testing.T) {
+	server := httptest.NewServer(transportc.NewWebSocketSignalServer(0))
+	defer server.Close()
+	listenerWebSocket := transportc.NewWebSocketSignal(webSocketURL(server))
+	defer listenerWebSocket.Close()
+	dialerWebSocket := transportc.NewWebSocketSignal(webSocketURL(server))
+	defer dialerWebSocket.Close()
+
+	// A WebSocketSignal falling back to itself keeps Trickle ICE
+	dialerSignal := transportc.NewSignalChain(dialerWebSocket, dialerWebSocket)
+	if _, ok := dialerSignal.(transportc.TrickleSignal); !ok {
+		t.Fatal("SignalChain of TrickleSignals should implement TrickleSignal")
+	}
+	if _, ok := transportc.NewSignalChain(unreachableSignal(t), dialerWebSocket).(transportc.TrickleSignal); ok {
+		t.Fatal("SignalChain of a Signal not implementing TrickleSignal should not implement TrickleSignal")
+	}
+
+	listenerConfig := &transportc.Config{
+		Signal: transportc.NewSignalChain(listenerWebSocket),
+	}
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	dialerConfig := &transportc.Config{
+		Signal: dialerSignal,
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	cConn, err := dialer.DialContext(ctx, "RANDOM_LABEL")
+	if err != nil {
+		t.Fatalf("DialContext error: %v", err)
+	}
+	defer cConn.Close() // skipcq: GO-S2307
+
+	sConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept error: %v", err)
+	}
+	defer sConn.Close() // skipcq: GO-S2307
+}
+
+func TestRetrySignal(t *testing.T) {
+	ds := transportc.NewDebugSignal(8)
+
+	// Transient errors are retried
+	flaky := &flakySignal{Signal: ds, err: fmt.Errorf("offer not acknowledged: %w", transportc.ErrSignalDisconnected), failures: 2}
+	rs := transportc.NewRetrySignal(flaky, 3, time.Millisecond, 0)
+	offerID, err := rs.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatalf("Offer should succeed on the third attempt, got %v", err)
+	}
+	if flaky.calls["Offer"] != 3 {
+		t.Fatalf("Offer attempted %d times, expecting 3", flaky.calls["Offer"])
+	}
+
+	// Not beyond the max attempts
+	flaky.calls = nil
+	rs = transportc.NewRetrySignal(flaky, 2, time.Millisecond, 0)
+	if _, err := rs.Offer([]byte("OFFER")); !errors.Is(err, transportc.ErrSignalDisconnected) {
+		t.Fatalf("Offer should fail after 2 attempts, got %v", err)
+	}
+	if flaky.calls["Offer"] != 2 {
+		t.Fatalf("Offer attempted %d times, expecting 2", flaky.calls["Offer"])
+	}
+
+	// Errors not transient are not retried, nor is an answer not ready
+	invalid := &flakySignal{Signal: ds, err: transportc.ErrInvalidOfferID, failures: 1}
+	rs = transportc.NewRetrySignal(invalid, 3, time.Millisecond, 0)
+	if _, err := rs.ReadAnswer(offerID); !errors.Is(err, transportc.ErrInvalidOfferID) {
+		t.Fatalf("ReadAnswer should fail with ErrInvalidOfferID, got %v", err)
+	}
+	notReady := &flakySignal{Signal: ds, err: transportc.ErrAnswerNotReady, failures: 1}
+	rs = transportc.NewRetrySignal(notReady, 3, time.Millisecond, 0)
+	if _, err := rs.ReadAnswer(offerID); err != transportc.ErrAnswerNotReady {
+		t.Fatalf("ReadAnswer should return ErrAnswerNotReady as is, got %v", err)
+	}
+	if invalid.calls["ReadAnswer"] != 1 || notReady.calls["ReadAnswer"] != 1 {
+		t.Fatalf("ReadAnswer retried on an error not transient")
+	}
+
+	// Network errors are transient
+	if !transportc.IsTransientSignalError(func() error { _, err := unreachableSignal(t).Offer([]byte("OFFER")); return err }()) {
+		t.Fatal("Connection refused should be a transient error")
+	}
+
+	// HTTP statuses are transient only if retrying may succeed
+	for _, tc := range []struct {
+		statusCode int
+		transient  bool
+	}{
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusRequestTimeout, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusBadRequest, false},
+		{http.StatusForbidden, false},
+		{http.StatusRequestEntityTooLarge, false},
+	} {
+		err := fmt.Errorf("ReadOffer: %w", &transportc.StatusError{StatusCode: tc.statusCode, Status: http.StatusText(tc.statusCode)})
+		if transportc.IsTransientSignalError(err) != tc.transient {
+			t.Errorf("IsTransientSignalError(%d) should be %t", tc.statusCode, tc.transient)
+		}
+		if !errors.Is(err, transportc.ErrUnexpectedStatus) {
+			t.Errorf("StatusError %d should be ErrUnexpectedStatus", tc.statusCode)
+		}
+	}
+}
+
+func TestLoggingSignal(t *testing.T) {
+	logger := &recordingLogger{}
+	ls := transportc.NewLoggingSignal(transportc.NewDebugSignal(8), logger)
+
+	offerID, err := ls.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !logger.logged("debug", fmt.Sprintf("submitted as offer %d", offerID)) {
+		t.Fatalf("Offer not logged at debug level: %v", logger.lines)
+	}
+
+	if err := ls.Answer(offerID+1, []byte("ANSWER")); err == nil {
+		t.Fatal("Answer to unknown offer should fail")
+	}
+	if !logger.logged("warn", transportc.ErrInvalidOfferID.Error()) {
+		t.Fatalf("Failed Answer not logged at warning level: %v", logger.lines)
+	}
+
+	if _, _, err := ls.ReadOffer(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ls.ReadOffer(); !errors.Is(err, transportc.ErrOfferNotReady) {
+		t.Fatal(err)
+	}
+	if !logger.logged("debug", "ReadOffer not ready") || logger.logged("warn", "ReadOffer") {
+		t.Fatalf("ReadOffer not ready should be logged at debug level only: %v", logger.lines)
+	}
+}
+
+func TestMetricsSignal(t *testing.T) {
+	metrics := &transportc.SignalMetrics{}
+	ds := transportc.NewDebugSignal(8)
+	ms := transportc.NewMetricsSignal(ds, metrics)
+
+	offerID, err := ms.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ms.ReadOffer(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ms.ReadOffer(); !errors.Is(err, transportc.ErrOfferNotReady) {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := ms.Answer(offerID, []byte("ANSWER")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Answer(offerID, []byte("ANSWER")); err == nil {
+		t.Fatal("Second Answer should fail")
+	}
+	if _, err := ms.ReadAnswer(offerID); err != nil {
+		t.Fatal(err)
+	}
+
+	// An answer to an offer submitted elsewhere is counted but not timed
+	otherID, err := ds.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ds.ReadOffer(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.Answer(otherID, []byte("ANSWER")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ms.ReadAnswer(otherID); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := metrics.Stats()
+	want := transportc.SignalStats{
+		Offers:       1,
+		Answers:      1,
+		OffersRead:   1,
+		AnswersRead:  2,
+		AnswersTimed: 1,
+		NotReady:     1,
+		Errors:       1,
+		Calls:        7,
+	}
+	got := stats
+	got.CallLatencyTotal, got.CallLatencyMax, got.AnswerLatencyTotal, got.AnswerLatencyMax = 0, 0, 0, 0
+	if got != want {
+		t.Fatalf("Stats returned %+v, expecting %+v", got, want)
+	}
+	if stats.AnswerLatencyMean() < 10*time.Millisecond || stats.AnswerLatencyMax != stats.AnswerLatencyTotal {
+		t.Fatalf("Answer latency of %v (max %v) is not from Offer to ReadAnswer", stats.AnswerLatencyMean(), stats.AnswerLatencyMax)
+	}
+	if stats.CallLatencyMean() <= 0 || stats.CallLatencyMax > stats.CallLatencyTotal {
+		t.Fatalf("Invalid call latency: mean %v, max %v, total %v", stats.CallLatencyMean(), stats.CallLatencyMax, stats.CallLatencyTotal)
+	}
+}
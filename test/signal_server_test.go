@@ -0,0 +1,140 @@
+package transportc_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+)
+
+// signalRequest sends a request to the signaling server and returns the status.
+func signalRequest(t *testing.T, method, url, token string) int {
+	req, err := http.NewRequest(method, url, bytes.NewReader([]byte("OFFER")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// exchangeSignal checks an offer and an answer go through from the offerer to
+// the answerer and back.
+func exchangeSignal(t *testing.T, offerer, answerer transportc.Signal) {
+	offerID, err := offerer.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+	readID, offer, err := answerer.ReadOffer()
+	if err != nil {
+		t.Fatalf("Error reading offer: %v", err)
+	}
+	if readID != offerID || !bytes.Equal(offer, []byte("OFFER")) {
+		t.Fatalf("Offer output does not match offer input")
+	}
+	if err := answerer.Answer(readID, []byte("ANSWER")); err != nil {
+		t.Fatalf("Error answering: %v", err)
+	}
+	answer, err := offerer.ReadAnswer(offerID)
+	if err != nil {
+		t.Fatalf("Error reading answer: %v", err)
+	}
+	if !bytes.Equal(answer, []byte("ANSWER")) {
+		t.Fatalf("Answer output does not match answer input")
+	}
+}
+
+func TestTransportcSignal(t *testing.T) {
+	bin := buildCommand(t, "transportc-signal")
+
+	addr := freeAddr(t)
+	base := "http://" + addr
+	startCommand(t, bin, "-listen", addr, "-storage", "disk", "-dir", t.TempDir(),
+		"-listener", "alice", "-registration-token", "TOKEN", "-rate", "0")
+	waitListening(t, addr)
+
+	resp, err := http.Get(base + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var health struct {
+		Status    string `json:"status"`
+		Storage   string `json:"storage"`
+		Listeners int    `json:"listeners"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&health)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || health.Status != "ok" || health.Storage != "disk" || health.Listeners != 1 {
+		t.Fatalf("Unexpected health %d %+v", resp.StatusCode, health)
+	}
+
+	// The HTTP and WebSocket endpoints of a namespace share the offers and answers
+	newSignals := func(path string) (*transportc.HTTPSignal, *transportc.WebSocketSignal) {
+		hs := transportc.NewHTTPSignal(base + path)
+		hs.PollTimeout = 5 * time.Second
+		ws := transportc.NewWebSocketSignal("ws://" + addr + path + "ws")
+		ws.PollTimeout = 5 * time.Second
+		t.Cleanup(func() { ws.Close() })
+		return hs, ws
+	}
+	hs, ws := newSignals("/")
+	exchangeSignal(t, hs, ws)
+	hs, ws = newSignals("/listeners/alice/")
+	exchangeSignal(t, ws, hs)
+
+	// Registration at runtime
+	bob := base + "/listeners/bob"
+	if status := signalRequest(t, http.MethodPost, bob+"/offer", ""); status != http.StatusNotFound {
+		t.Fatalf("Offer to an unregistered listener returned %d, expected 404", status)
+	}
+	if status := signalRequest(t, http.MethodPut, bob, "WRONG"); status != http.StatusUnauthorized {
+		t.Fatalf("Registration with a wrong token returned %d, expected 401", status)
+	}
+	if status := signalRequest(t, http.MethodPut, base+"/listeners/Not_Valid", "TOKEN"); status != http.StatusBadRequest {
+		t.Fatalf("Registration of an invalid name returned %d, expected 400", status)
+	}
+	if status := signalRequest(t, http.MethodPut, bob, "TOKEN"); status != http.StatusCreated {
+		t.Fatalf("Registration returned %d, expected 201", status)
+	}
+	hs, ws = newSignals("/listeners/bob/")
+	exchangeSignal(t, hs, ws)
+	if status := signalRequest(t, http.MethodDelete, bob, "TOKEN"); status != http.StatusNoContent {
+		t.Fatalf("Unregistration returned %d, expected 204", status)
+	}
+	if status := signalRequest(t, http.MethodPost, bob+"/offer", ""); status != http.StatusNotFound {
+		t.Fatalf("Offer to an unregistered listener returned %d, expected 404", status)
+	}
+}
+
+func TestTransportcSignalRateLimit(t *testing.T) {
+	bin := buildCommand(t, "transportc-signal")
+
+	addr := freeAddr(t)
+	startCommand(t, bin, "-listen", addr, "-rate", "0.1", "-burst", "3")
+	waitListening(t, addr)
+
+	for i := 0; i < 3; i++ {
+		if status := signalRequest(t, http.MethodPost, "http://"+addr+"/offer", ""); status != http.StatusOK {
+			t.Fatalf("Request %d within the burst returned %d, expected 200", i, status)
+		}
+	}
+	if status := signalRequest(t, http.MethodPost, "http://"+addr+"/offer", ""); status != http.StatusTooManyRequests {
+		t.Fatalf("Request over the burst returned %d, expected 429", status)
+	}
+	// The health endpoint is not limited
+	if status := signalRequest(t, http.MethodGet, "http://"+addr+"/healthz", ""); status != http.StatusOK {
+		t.Fatalf("Health check returned %d, expected 200", status)
+	}
+}
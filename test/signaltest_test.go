@@ -0,0 +1,290 @@
+package transportc_test
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+	"github.com/gaukas/transportc/signaltest"
+	"github.com/pion/webrtc/v3"
+)
+
+// sdpPayload returns a DataChannel-only SDP with enough host candidates to be of
+// approximately size bytes, as accepted by ManualSignal and CompactSignal.
+func sdpPayload(t *testing.T, offer bool, i, size int) []byte {
+	digest := make([]byte, 32)
+	if _, err := rand.Read(digest); err != nil {
+		t.Fatal(err)
+	}
+	fingerprint := make([]string, len(digest))
+	for j, b := range digest {
+		fingerprint[j] = strings.ToUpper(hex.EncodeToString([]byte{b}))
+	}
+
+	desc := webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer}
+	setup := "active"
+	if offer {
+		desc.Type, setup = webrtc.SDPTypeOffer, "actpass"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("v=0\r\no=- 1 2 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n")
+	fmt.Fprintf(&sb, "a=fingerprint:sha-256 %s\r\n", strings.Join(fingerprint, ":"))
+	sb.WriteString("a=group:BUNDLE 0\r\nm=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\nc=IN IP4 0.0.0.0\r\n")
+	fmt.Fprintf(&sb, "a=setup:%s\r\na=mid:0\r\na=sendrecv\r\na=sctp-port:5000\r\n", setup)
+	fmt.Fprintf(&sb, "a=ice-ufrag:ufrag%04d\r\na=ice-pwd:%s\r\n", i, hex.EncodeToString(digest[:12]))
+	for j := 0; j < 255 && (j == 0 || sb.Len() < size); j++ {
+		fmt.Fprintf(&sb, "a=candidate:%d 1 udp 2130706431 10.%d.%d.%d %d typ host\r\n", j, i%256, j/256, j%256, 50000+j)
+	}
+	sb.WriteString("a=end-of-candidates\r\n")
+	desc.SDP = sb.String()
+
+	payload, err := json.Marshal(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+// sameSDP compares the JSON-encoded SDPs semantically.
+func sameSDP(t *testing.T, submitted, read []byte) bool {
+	var want, got webrtc.SessionDescription
+	if err := json.Unmarshal(submitted, &want); err != nil {
+		t.Errorf("Invalid SDP submitted: %v", err)
+		return false
+	}
+	if err := json.Unmarshal(read, &got); err != nil {
+		t.Errorf("Invalid SDP read: %v", err)
+		return false
+	}
+	wantEssentials, err := parseEssentials(want)
+	if err != nil {
+		t.Errorf("Invalid SDP submitted: %v", err)
+		return false
+	}
+	gotEssentials, err := parseEssentials(got)
+	if err != nil {
+		t.Errorf("Invalid SDP read: %v", err)
+		return false
+	}
+	return reflect.DeepEqual(wantEssentials, gotEssentials)
+}
+
+func TestSignalConformance(t *testing.T) {
+	psk := []byte("PSK")
+	offererPub, offererPriv, err := transportc.GenerateSignalKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	answererPub, answererPriv, err := transportc.GenerateSignalKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newHTTPSignals := func(t *testing.T) (*transportc.HTTPSignal, *transportc.HTTPSignal) {
+		server := httptest.NewServer(transportc.NewHTTPSignalServer(0))
+		t.Cleanup(server.Close)
+		offerer, answerer := transportc.NewHTTPSignal(server.URL), transportc.NewHTTPSignal(server.URL)
+		offerer.PollTimeout, answerer.PollTimeout = time.Second, time.Second
+		return offerer, answerer
+	}
+	newDirSignalStore := func(t *testing.T) transportc.SignalStore {
+		ds, err := transportc.NewDirSignal(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds.PollTimeout = 100 * time.Millisecond
+		return transportc.NewSignalStore(ds)
+	}
+	newWebSocketSignals := func(t *testing.T) (*transportc.WebSocketSignal, *transportc.WebSocketSignal) {
+		server := httptest.NewServer(transportc.NewWebSocketSignalServer(0))
+		t.Cleanup(server.Close)
+		offerer, answerer := transportc.NewWebSocketSignal(webSocketURL(server)), transportc.NewWebSocketSignal(webSocketURL(server))
+		t.Cleanup(func() { offerer.Close() })
+		t.Cleanup(func() { answerer.Close() })
+		offerer.PollTimeout, answerer.PollTimeout = time.Second, time.Second
+		return offerer, answerer
+	}
+
+	for _, tc := range []struct {
+		name  string
+		suite *signaltest.Suite
+	}{
+		{
+			name: "DebugSignal",
+			suite: &signaltest.Suite{
+				NewSignals: func(t *testing.T) (transportc.Signal, transportc.Signal) {
+					ds := transportc.NewDebugSignal(2 * signaltest.CONCURRENCY_DEFAULT)
+					return ds, ds
+				},
+			},
+		},
+		{
+			name: "HTTPSignal",
+			suite: &signaltest.Suite{
+				NewSignals: func(t *testing.T) (transportc.Signal, transportc.Signal) {
+					return newHTTPSignals(t)
+				},
+			},
+		},
+		{
+			name: "FrontedHTTPSignal",
+			suite: &signaltest.Suite{
+				NewSignals: func(t *testing.T) (transportc.Signal, transportc.Signal) {
+					server, _ := startFrontServer(t)
+					config := &transportc.FrontingConfig{
+						FrontURL:   server.URL,
+						Host:       frontedHost,
+						RootCAs:    certPool(server),
+						ServerName: frontedServerName,
+					}
+					offerer, err := transportc.NewFrontedHTTPSignal(config)
+					if err != nil {
+						t.Fatal(err)
+					}
+					answerer, err := transportc.NewFrontedHTTPSignal(config)
+					if err != nil {
+						t.Fatal(err)
+					}
+					offerer.PollTimeout, answerer.PollTimeout = time.Second, time.Second
+					return offerer, answerer
+				},
+			},
+		},
+		{
+			name: "WebSocketSignal",
+			suite: &signaltest.Suite{
+				NewSignals: func(t *testing.T) (transportc.Signal, transportc.Signal) {
+					return newWebSocketSignals(t)
+				},
+			},
+		},
+		{
+			name: "HTTPSignalDirStore",
+			suite: &signaltest.Suite{
+				NewSignals: func(t *testing.T) (transportc.Signal, transportc.Signal) {
+					server := httptest.NewServer(transportc.NewHTTPSignalServerWithStore(newDirSignalStore(t)))
+					t.Cleanup(server.Close)
+					offerer, answerer := transportc.NewHTTPSignal(server.URL), transportc.NewHTTPSignal(server.URL)
+					offerer.PollTimeout, answerer.PollTimeout = time.Second, time.Second
+					return offerer, answerer
+				},
+			},
+		},
+		{
+			name: "WebSocketSignalDirStore",
+			suite: &signaltest.Suite{
+				NewSignals: func(t *testing.T) (transportc.Signal, transportc.Signal) {
+					server := httptest.NewServer(transportc.NewWebSocketSignalServerWithStore(newDirSignalStore(t), 0))
+					t.Cleanup(server.Close)
+					offerer, answerer := transportc.NewWebSocketSignal(webSocketURL(server)), transportc.NewWebSocketSignal(webSocketURL(server))
+					t.Cleanup(func() { offerer.Close() })
+					t.Cleanup(func() { answerer.Close() })
+					offerer.PollTimeout, answerer.PollTimeout = time.Second, time.Second
+					return offerer, answerer
+				},
+			},
+		},
+		{
+			name: "DirSignal",
+			suite: &signaltest.Suite{
+				NewSignals: func(t *testing.T) (transportc.Signal, transportc.Signal) {
+					dir := t.TempDir()
+					offerer, err := transportc.NewDirSignal(dir)
+					if err != nil {
+						t.Fatal(err)
+					}
+					answerer, err := transportc.NewDirSignal(dir)
+					if err != nil {
+						t.Fatal(err)
+					}
+					return offerer, answerer
+				},
+			},
+		},
+		{
+			name: "UnixSignal",
+			suite: &signaltest.Suite{
+				NewSignals: func(t *testing.T) (transportc.Signal, transportc.Signal) {
+					socketPath := filepath.Join(t.TempDir(), "signal.sock")
+					server, err := transportc.ListenUnixSignal(socketPath, 0)
+					if err != nil {
+						t.Fatal(err)
+					}
+					t.Cleanup(func() { server.Close() })
+					offerer, answerer := transportc.NewUnixSignal(socketPath), transportc.NewUnixSignal(socketPath)
+					offerer.PollTimeout, answerer.PollTimeout = time.Second, time.Second
+					return offerer, answerer
+				},
+			},
+		},
+		{
+			name: "DNSSignal",
+			suite: &signaltest.Suite{
+				NewSignals: func(t *testing.T) (transportc.Signal, transportc.Signal) {
+					resolver := startDNSSignalServer(t)
+					return transportc.NewDNSSignal(dnsSignalDomain, resolver), transportc.NewDNSSignal(dnsSignalDomain, resolver)
+				},
+			},
+		},
+		{
+			name: "ManualSignal",
+			suite: &signaltest.Suite{
+				Equal:         sameSDP,
+				LocalOfferIDs: true,
+				NewSignals: func(t *testing.T) (transportc.Signal, transportc.Signal) {
+					offererIn, answererOut := io.Pipe()
+					answererIn, offererOut := io.Pipe()
+					t.Cleanup(func() {
+						offererOut.Close()
+						answererOut.Close()
+					})
+					return transportc.NewManualSignal(offererIn, offererOut), transportc.NewManualSignal(answererIn, answererOut)
+				},
+				Payload:     sdpPayload,
+				SingleOffer: true,
+			},
+		},
+		{
+			name: "CompactSignal",
+			suite: &signaltest.Suite{
+				Equal: sameSDP,
+				NewSignals: func(t *testing.T) (transportc.Signal, transportc.Signal) {
+					offerer, answerer := newWebSocketSignals(t)
+					return transportc.NewCompactSignal(offerer), transportc.NewCompactSignal(answerer)
+				},
+				Payload: sdpPayload,
+			},
+		},
+		{
+			name: "SecureSignalPSK",
+			suite: &signaltest.Suite{
+				NewSignals: func(t *testing.T) (transportc.Signal, transportc.Signal) {
+					ds := transportc.NewDebugSignal(2 * signaltest.CONCURRENCY_DEFAULT)
+					return transportc.NewSecureSignalPSK(ds, psk), transportc.NewSecureSignalPSK(ds, psk)
+				},
+			},
+		},
+		{
+			name: "SecureSignalBox",
+			suite: &signaltest.Suite{
+				NewSignals: func(t *testing.T) (transportc.Signal, transportc.Signal) {
+					offerer, answerer := newHTTPSignals(t)
+					return transportc.NewSecureSignalBox(offerer, offererPub, offererPriv, answererPub),
+						transportc.NewSecureSignalBox(answerer, answererPub, answererPriv, offererPub)
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, tc.suite.Run)
+	}
+}
@@ -0,0 +1,192 @@
+package transportc_test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc/internal/socks5"
+)
+
+func TestSOCKS5Datagram(t *testing.T) {
+	for _, addr := range []string{"192.0.2.1:53", "[2001:db8::1]:53", "example.com:53"} {
+		datagram, err := socks5.AppendUDPDatagram(nil, addr, []byte("DATA"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, data, err := socks5.ParseUDPDatagram(datagram)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != addr || !bytes.Equal(data, []byte("DATA")) {
+			t.Fatalf("Parsed datagram to %s with %q, expected %s with %q", parsed, data, addr, "DATA")
+		}
+
+		if _, _, err := socks5.ParseUDPDatagram(datagram[:len(datagram)-6]); !errors.Is(err, socks5.ErrShortDatagram) {
+			t.Fatalf("Parsing a truncated datagram should fail with ErrShortDatagram, got %v", err)
+		}
+		datagram[2] = 1
+		if _, _, err := socks5.ParseUDPDatagram(datagram); !errors.Is(err, socks5.ErrFragmented) {
+			t.Fatalf("Parsing a fragment should fail with ErrFragmented, got %v", err)
+		}
+	}
+}
+
+// dialSOCKS connects to the destination through the SOCKS5 proxy.
+func dialSOCKS(t *testing.T, proxy, addr string) (net.Conn, error) {
+	conn, err := net.Dial("tcp", proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second)) // skipcq: GSC-G104
+	if _, err := socks5.ClientHandshake(conn, socks5.CMD_CONNECT, addr, "", ""); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func TestTransportcSOCKS(t *testing.T) {
+	bin := buildCommand(t, "transportc-socks")
+
+	common := []string{"-signal", "dir:" + t.TempDir(), "-psk", "00112233"}
+	startCommand(t, bin, append([]string{"server"}, common...)...)
+	proxy := freeAddr(t)
+	startCommand(t, bin, append([]string{"client", "-listen", proxy}, common...)...)
+	waitListening(t, proxy)
+
+	echo := startEchoServer(t, "127.0.0.1:0")
+	_, port, _ := net.SplitHostPort(echo)
+	targets := []string{echo, net.JoinHostPort("localhost", port)}
+	if ln, err := net.Listen("tcp", "[::1]:0"); err == nil {
+		ln.Close()
+		targets = append(targets, startEchoServer(t, "[::1]:0"))
+	}
+
+	for _, target := range targets {
+		conn, err := dialSOCKS(t, proxy, target)
+		if err != nil {
+			t.Fatalf("Error connecting to %s: %v", target, err)
+		}
+		checkEcho(t, conn, []byte("HELLO "+target))
+		conn.Close()
+	}
+
+	// The server reports the failure to connect
+	if _, err := dialSOCKS(t, proxy, freeAddr(t)); !errors.Is(err, socks5.ErrRequestFailed) {
+		t.Fatalf("Connecting to a closed port should fail with ErrRequestFailed, got %v", err)
+	}
+}
+
+// startUDPEchoServer echoes back the datagrams received on a local UDP port.
+func startUDPEchoServer(t *testing.T) string {
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { echo.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := echo.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteToUDP(buf[:n], addr) // skipcq: GSC-G104
+		}
+	}()
+	return echo.LocalAddr().String()
+}
+
+// checkUDPAssociate requests a UDP association from the SOCKS5 proxy and checks
+// a datagram is echoed back by the echo server through it. The association lasts
+// as long as the returned control connection.
+func checkUDPAssociate(t *testing.T, proxy, echo string) net.Conn {
+	control, err := net.Dial("tcp", proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { control.Close() })
+	control.SetDeadline(time.Now().Add(30 * time.Second)) // skipcq: GSC-G104
+	relayAddr, err := socks5.ClientHandshake(control, socks5.CMD_UDP_ASSOCIATE, "0.0.0.0:0", "", "")
+	if err != nil {
+		t.Fatalf("UDP ASSOCIATE failed: %v", err)
+	}
+
+	udp, err := net.Dial("udp", relayAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	datagram, err := socks5.AppendUDPDatagram(nil, echo, []byte("PING"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The datagrams are relayed unreliably, so retry until echoed back
+	buf := make([]byte, 1500)
+	deadline := time.Now().Add(20 * time.Second)
+	for {
+		if time.Now().After(deadline) {
+			t.Fatalf("No datagram echoed back")
+		}
+		if _, err := udp.Write(datagram); err != nil {
+			t.Fatal(err)
+		}
+		udp.SetReadDeadline(time.Now().Add(time.Second)) // skipcq: GSC-G104
+		n, err := udp.Read(buf)
+		if err != nil {
+			continue
+		}
+
+		src, data, err := socks5.ParseUDPDatagram(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if src != echo || !bytes.Equal(data, []byte("PING")) {
+			t.Fatalf("Received datagram from %s with %q, expected %s with %q", src, data, echo, "PING")
+		}
+		return control
+	}
+}
+
+func TestTransportcSOCKSUDPAssociate(t *testing.T) {
+	bin := buildCommand(t, "transportc-socks")
+
+	common := []string{"-signal", "dir:" + t.TempDir()}
+	startCommand(t, bin, append([]string{"server"}, common...)...)
+	proxy := freeAddr(t)
+	startCommand(t, bin, append([]string{"client", "-listen", proxy}, common...)...)
+	waitListening(t, proxy)
+
+	checkUDPAssociate(t, proxy, startUDPEchoServer(t))
+}
+
+func TestTransportcSOCKSUDPAssociateAfterIdle(t *testing.T) {
+	bin := buildCommand(t, "transportc-socks")
+
+	common := []string{"-signal", "dir:" + t.TempDir()}
+	startCommand(t, bin, append([]string{"server", "-timeout", "1s"}, common...)...)
+	proxy := freeAddr(t)
+	startCommand(t, bin, append([]string{"client", "-listen", proxy}, common...)...)
+	waitListening(t, proxy)
+
+	echo := startUDPEchoServer(t)
+	checkUDPAssociate(t, proxy, echo).Close()
+
+	// A busy stream keeps the PeerConnection up while the server closes the idle
+	// datagram channel
+	stream, err := dialSOCKS(t, proxy, startEchoServer(t, "127.0.0.1:0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	for i := 0; i < 10; i++ {
+		checkEcho(t, stream, []byte("KEEPALIVE"))
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	checkUDPAssociate(t, proxy, echo)
+}
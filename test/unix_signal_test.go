@@ -0,0 +1,160 @@
+package transportc_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+)
+
+func TestUnixSignal(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "signal.sock")
+	server, err := transportc.ListenUnixSignal(socketPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	us := transportc.NewUnixSignal(socketPath)
+	us.PollTimeout = 100 * time.Millisecond
+
+	_, _, err = us.ReadOffer()
+	if !errors.Is(err, transportc.ErrOfferNotReady) {
+		t.Fatalf("ReadOffer without offer should fail with ErrOfferNotReady, got %v", err)
+	}
+
+	offerID, err := us.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+
+	oid, offer, err := us.ReadOffer()
+	if err != nil {
+		t.Fatalf("Error reading offer: %v", err)
+	}
+	if oid != offerID || !bytes.Equal(offer, []byte("OFFER")) {
+		t.Fatalf("Offer output does not match offer input")
+	}
+
+	if err := us.Answer(offerID, []byte("ANSWER")); err != nil {
+		t.Fatalf("Error answering offer: %v", err)
+	}
+	answer, err := us.ReadAnswer(offerID)
+	if err != nil {
+		t.Fatalf("Error reading answer: %v", err)
+	}
+	if !bytes.Equal(answer, []byte("ANSWER")) {
+		t.Fatalf("Answer output does not match answer input")
+	}
+
+	if _, err := transportc.ListenUnixSignal(socketPath, 0); err == nil {
+		t.Fatalf("Listening on a socket in use should fail")
+	}
+
+	if err := server.Close(); err != nil {
+		t.Fatalf("Error closing server: %v", err)
+	}
+	if _, err := os.Stat(socketPath); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Socket should be removed once the server is closed, got %v", err)
+	}
+}
+
+func TestUnixSignalStaleSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "signal.sock")
+
+	// Leave a socket file behind as a crashed process would
+	stale, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	server, err := transportc.ListenUnixSignal(socketPath, 0)
+	if err != nil {
+		t.Fatalf("Listening on a stale socket should succeed, got %v", err)
+	}
+	defer server.Close()
+
+	if _, err := transportc.NewUnixSignal(socketPath).Offer([]byte("OFFER")); err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+}
+
+func TestUnixSignalNotSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "signal.sock")
+	if err := os.WriteFile(socketPath, []byte("DATA"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := transportc.ListenUnixSignal(socketPath, 0); err == nil {
+		t.Fatal("Listening on a regular file should fail")
+	}
+	if data, err := os.ReadFile(socketPath); err != nil || string(data) != "DATA" {
+		t.Fatalf("Regular file should be left untouched, got %q, %v", data, err)
+	}
+}
+
+func TestUnixSignalDialContext(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "signal.sock")
+	server, err := transportc.ListenUnixSignal(socketPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	listenerSignal := transportc.NewUnixSignal(socketPath)
+	listenerSignal.PollTimeout = time.Second
+	listenerConfig := &transportc.Config{
+		Signal: listenerSignal,
+	}
+
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	dialerConfig := &transportc.Config{
+		Signal: transportc.NewUnixSignal(socketPath),
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	cConn, err := dialer.DialContext(ctx, "RANDOM_LABEL")
+	if err != nil {
+		t.Fatalf("DialContext error: %v", err)
+	}
+	defer cConn.Close() // skipcq: GO-S2307
+
+	sConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept error: %v", err)
+	}
+	defer sConn.Close() // skipcq: GO-S2307
+
+	_, err = cConn.Write([]byte("Hello"))
+	if err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	buf := make([]byte, 1024)
+	n, err := sConn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if string(buf[:n]) != "Hello" {
+		t.Fatalf("Read returned %s", string(buf[:n]))
+	}
+}
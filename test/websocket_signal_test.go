@@ -0,0 +1,340 @@
+package transportc_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gaukas/transportc"
+)
+
+func webSocketURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestWebSocketSignal(t *testing.T) {
+	server := httptest.NewServer(transportc.NewWebSocketSignalServer(0))
+	defer server.Close()
+
+	offerer := transportc.NewWebSocketSignal(webSocketURL(server))
+	defer offerer.Close()
+	offerer.PollTimeout = 100 * time.Millisecond
+
+	answerer := transportc.NewWebSocketSignal(webSocketURL(server))
+	defer answerer.Close()
+	answerer.PollTimeout = 100 * time.Millisecond
+
+	_, _, err := answerer.ReadOffer()
+	if !errors.Is(err, transportc.ErrOfferNotReady) {
+		t.Fatalf("ReadOffer without offer should fail with ErrOfferNotReady, got %v", err)
+	}
+
+	offerID, err := offerer.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+
+	_, err = offerer.ReadAnswer(offerID)
+	if !errors.Is(err, transportc.ErrAnswerNotReady) {
+		t.Fatalf("ReadAnswer before answer should fail with ErrAnswerNotReady, got %v", err)
+	}
+
+	// Candidates trickled before the answerer reads the offer are queued by the server
+	if err := offerer.Candidate(offerID, true, []byte("CANDIDATE")); err != nil {
+		t.Fatalf("Error sending candidate: %v", err)
+	}
+	if err := offerer.Candidate(offerID, true, nil); err != nil {
+		t.Fatalf("Error ending candidates: %v", err)
+	}
+
+	answerer.PollTimeout = time.Second
+	oid, offer, err := answerer.ReadOffer()
+	if err != nil {
+		t.Fatalf("Error reading offer: %v", err)
+	}
+	if oid != offerID || !bytes.Equal(offer, []byte("OFFER")) {
+		t.Fatalf("Offer output does not match offer input")
+	}
+
+	candidate, err := answerer.ReadCandidate(offerID, true)
+	if err != nil {
+		t.Fatalf("Error reading candidate: %v", err)
+	}
+	if !bytes.Equal(candidate, []byte("CANDIDATE")) {
+		t.Fatalf("Candidate output does not match candidate input")
+	}
+	_, err = answerer.ReadCandidate(offerID, true)
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("ReadCandidate after end of candidates should fail with io.EOF, got %v", err)
+	}
+
+	// The answer is pushed to the offerer as soon as it is submitted
+	offerer.PollTimeout = 5 * time.Second
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		answerer.Answer(offerID, []byte("ANSWER")) // skipcq: GSC-G104
+	}()
+	answer, err := offerer.ReadAnswer(offerID)
+	if err != nil {
+		t.Fatalf("Error reading answer: %v", err)
+	}
+	if !bytes.Equal(answer, []byte("ANSWER")) {
+		t.Fatalf("Answer output does not match answer input")
+	}
+
+	err = answerer.Answer(offerID, []byte("ANSWER"))
+	if !errors.Is(err, transportc.ErrInvalidOfferID) {
+		t.Fatalf("Answering twice should fail with ErrInvalidOfferID, got %v", err)
+	}
+
+	_, err = offerer.ReadAnswer(offerID)
+	if !errors.Is(err, transportc.ErrInvalidOfferID) {
+		t.Fatalf("Reading answer twice should fail with ErrInvalidOfferID, got %v", err)
+	}
+}
+
+func TestWebSocketSignalReconnect(t *testing.T) {
+	server := httptest.NewServer(transportc.NewWebSocketSignalServer(0))
+	defer server.Close()
+
+	offerer := transportc.NewWebSocketSignal(webSocketURL(server))
+	defer offerer.Close()
+
+	answerer := transportc.NewWebSocketSignal(webSocketURL(server))
+	defer answerer.Close()
+	answerer.PollTimeout = 100 * time.Millisecond
+
+	_, _, err := answerer.ReadOffer() // request an offer
+	if !errors.Is(err, transportc.ErrOfferNotReady) {
+		t.Fatalf("ReadOffer without offer should fail with ErrOfferNotReady, got %v", err)
+	}
+
+	offerID, err := offerer.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+
+	answerer.PollTimeout = time.Second
+	if _, _, err := answerer.ReadOffer(); err != nil {
+		t.Fatalf("Error reading offer: %v", err)
+	}
+
+	// Break all WebSockets, both clients reconnect automatically
+	server.CloseClientConnections()
+
+	if err := answerer.Answer(offerID, []byte("ANSWER")); err != nil {
+		t.Fatalf("Error answering after reconnection: %v", err)
+	}
+
+	offerer.PollTimeout = 5 * time.Second
+	answer, err := offerer.ReadAnswer(offerID)
+	if err != nil {
+		t.Fatalf("Error reading answer after reconnection: %v", err)
+	}
+	if !bytes.Equal(answer, []byte("ANSWER")) {
+		t.Fatalf("Answer output does not match answer input")
+	}
+
+	// The answerer still gets the offers requested after reconnection
+	offerID, err = offerer.Offer([]byte("OFFER2"))
+	if err != nil {
+		t.Fatalf("Error making offer after reconnection: %v", err)
+	}
+	oid, offer, err := answerer.ReadOffer()
+	if err != nil {
+		t.Fatalf("Error reading offer after reconnection: %v", err)
+	}
+	if oid != offerID || !bytes.Equal(offer, []byte("OFFER2")) {
+		t.Fatalf("Offer output does not match offer input")
+	}
+}
+
+func TestWebSocketSignalDialContext(t *testing.T) {
+	server := httptest.NewServer(transportc.NewWebSocketSignalServer(0))
+	defer server.Close()
+
+	listenerSignal := transportc.NewWebSocketSignal(webSocketURL(server))
+	defer listenerSignal.Close()
+	listenerConfig := &transportc.Config{
+		Signal: listenerSignal,
+	}
+
+	listener, err := listenerConfig.NewListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.Start()
+
+	dialerSignal := transportc.NewWebSocketSignal(webSocketURL(server))
+	defer dialerSignal.Close()
+	dialerConfig := &transportc.Config{
+		Signal: dialerSignal,
+	}
+	dialer, err := dialerConfig.NewDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel() // cancel the context to make sure it is done
+
+	cConn, err := dialer.DialContext(ctx, "RANDOM_LABEL")
+	if err != nil {
+		t.Fatalf("DialContext error: %v", err)
+	}
+	defer cConn.Close() // skipcq: GO-S2307
+
+	sConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept error: %v", err)
+	}
+	defer sConn.Close() // skipcq: GO-S2307
+
+	_, err = cConn.Write([]byte("Hello"))
+	if err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	buf := make([]byte, 1024)
+	n, err := sConn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if string(buf[:n]) != "Hello" {
+		t.Fatalf("Read returned %s", string(buf[:n]))
+	}
+}
+
+// gatedSignalStore holds the first TakeOffer until the gate is closed and then
+// takes the offer even if its context is done, as if the offer was taken right
+// before the listening client disconnected.
+type gatedSignalStore struct {
+	transportc.SignalStore
+	gate  chan struct{}
+	taken chan struct{}
+	once  sync.Once
+}
+
+func (s *gatedSignalStore) TakeOffer(ctx context.Context) (uint64, []byte, error) {
+	first := false
+	s.once.Do(func() { first = true })
+	if !first {
+		return s.SignalStore.TakeOffer(ctx)
+	}
+	<-s.gate
+	defer close(s.taken)
+	return s.SignalStore.TakeOffer(context.Background())
+}
+
+func TestWebSocketSignalOfferToDisconnectedListener(t *testing.T) {
+	store := &gatedSignalStore{
+		SignalStore: transportc.NewMemorySignalStore(0),
+		gate:        make(chan struct{}),
+		taken:       make(chan struct{}),
+	}
+	server := httptest.NewServer(transportc.NewWebSocketSignalServerWithStore(store, 0))
+	defer server.Close()
+
+	// The first listener is gone by the time an offer is taken for it
+	gone := transportc.NewWebSocketSignal(webSocketURL(server))
+	gone.PollTimeout = 100 * time.Millisecond
+	_, _, err := gone.ReadOffer()
+	if !errors.Is(err, transportc.ErrOfferNotReady) {
+		t.Fatalf("ReadOffer without offer should fail with ErrOfferNotReady, got %v", err)
+	}
+	gone.Close()
+	time.Sleep(100 * time.Millisecond) // wait for the server to drop the connection
+
+	offerer := transportc.NewWebSocketSignal(webSocketURL(server))
+	defer offerer.Close()
+	offerID, err := offerer.Offer([]byte("OFFER"))
+	if err != nil {
+		t.Fatalf("Error making offer: %v", err)
+	}
+	close(store.gate)
+	<-store.taken
+
+	// The offer goes to the listener connected instead
+	answerer := transportc.NewWebSocketSignal(webSocketURL(server))
+	defer answerer.Close()
+	answerer.PollTimeout = 5 * time.Second
+	oid, offer, err := answerer.ReadOffer()
+	if err != nil {
+		t.Fatalf("Error reading offer: %v", err)
+	}
+	if oid != offerID || !bytes.Equal(offer, []byte("OFFER")) {
+		t.Fatalf("Offer output does not match offer input")
+	}
+	if err := answerer.Answer(oid, []byte("ANSWER")); err != nil {
+		t.Fatalf("Error answering: %v", err)
+	}
+
+	offerer.PollTimeout = 5 * time.Second
+	answer, err := offerer.ReadAnswer(offerID)
+	if err != nil {
+		t.Fatalf("Error reading answer: %v", err)
+	}
+	if !bytes.Equal(answer, []byte("ANSWER")) {
+		t.Fatalf("Answer output does not match answer input")
+	}
+}
+
+func TestWebSocketSignalManyOffers(t *testing.T) {
+	server := httptest.NewServer(transportc.NewWebSocketSignalServer(0))
+	defer server.Close()
+
+	offerer := transportc.NewWebSocketSignal(webSocketURL(server))
+	defer offerer.Close()
+
+	answerer := transportc.NewWebSocketSignal(webSocketURL(server))
+	defer answerer.Close()
+	answerer.PollTimeout = 100 * time.Millisecond
+
+	_, _, err := answerer.ReadOffer() // request an offer
+	if !errors.Is(err, transportc.ErrOfferNotReady) {
+		t.Fatalf("ReadOffer without offer should fail with ErrOfferNotReady, got %v", err)
+	}
+
+	// More offers than the answerer buffers must not stall its acknowledgements
+	offerIDs := make([]uint64, 4*transportc.WEBSOCKET_SIGNAL_CANDIDATE_BACKLOG)
+	for i := range offerIDs {
+		if offerIDs[i], err = offerer.Offer([]byte("OFFER")); err != nil {
+			t.Fatalf("Error making offer: %v", err)
+		}
+	}
+
+	answerer.PollTimeout = 5 * time.Second
+	for _, offerID := range offerIDs {
+		oid, _, err := answerer.ReadOffer()
+		if err != nil {
+			t.Fatalf("Error reading offer: %v", err)
+		}
+		if oid != offerID {
+			t.Fatalf("ReadOffer returned offer %d, expected %d", oid, offerID)
+		}
+		if err := answerer.Answer(oid, []byte("ANSWER")); err != nil {
+			t.Fatalf("Error answering: %v", err)
+		}
+	}
+
+	// Candidates are only read for the offers made or read
+	_, err = answerer.ReadCandidate(offerIDs[len(offerIDs)-1]+1, true)
+	if !errors.Is(err, transportc.ErrInvalidOfferID) {
+		t.Fatalf("ReadCandidate of unknown offer should fail with ErrInvalidOfferID, got %v", err)
+	}
+	_, err = answerer.ReadCandidate(offerIDs[0], false)
+	if !errors.Is(err, transportc.ErrInvalidOfferID) {
+		t.Fatalf("ReadCandidate from the answerer of an offer read should fail with ErrInvalidOfferID, got %v", err)
+	}
+	_, err = answerer.ReadAnswer(offerIDs[0])
+	if !errors.Is(err, transportc.ErrInvalidOfferID) {
+		t.Fatalf("ReadAnswer of an offer read should fail with ErrInvalidOfferID, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package transportc
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// candidateBuffer holds the local ICE candidates gathered before the offer/answer
+// is submitted, as the offer ID is not known until then.
+type candidateBuffer struct {
+	mutex   sync.Mutex
+	pending [][]byte
+	send    func(candidate []byte) error
+}
+
+// add is set as the OnICECandidate handler. A nil candidate indicates the end of candidates.
+func (cb *candidateBuffer) add(c *webrtc.ICECandidate) {
+	var candidate []byte
+	if c != nil {
+		var err error
+		candidate, err = json.Marshal(c.ToJSON())
+		if err != nil {
+			return
+		}
+	}
+
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if cb.send == nil {
+		cb.pending = append(cb.pending, candidate)
+		return
+	}
+	cb.send(candidate) // skipcq: GSC-G104
+}
+
+// flush sends all pending candidates and any candidate gathered afterwards with send.
+func (cb *candidateBuffer) flush(send func(candidate []byte) error) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	for _, candidate := range cb.pending {
+		send(candidate) // skipcq: GSC-G104
+	}
+	cb.pending = nil
+	cb.send = send
+}
+
+// trickleCandidates reads the remote ICE candidates from the TrickleSignal and adds
+// them to the PeerConnection until the end of candidates or the PeerConnection is closed.
+func trickleCandidates(ts TrickleSignal, pc *webrtc.PeerConnection, offerID uint64, fromOfferer bool) {
+	for pc.ConnectionState() < webrtc.PeerConnectionStateDisconnected {
+		candidate, err := ts.ReadCandidate(offerID, fromOfferer)
+		if errors.Is(err, ErrCandidateNotReady) {
+			continue
+		} else if err != nil { // io.EOF included
+			return
+		}
+
+		var candidateInit webrtc.ICECandidateInit
+		if err := json.Unmarshal(candidate, &candidateInit); err != nil {
+			continue
+		}
+		pc.AddICECandidate(candidateInit) // skipcq: GSC-G104
+
+	}
+}
+
+// closedChan returns a closed channel, in place of a GatheringCompletePromise
+// when not waiting for the ICE gathering.
+func closedChan() <-chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}
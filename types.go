@@ -48,3 +48,33 @@ func (*Addr) Network() string {
 func (a *Addr) String() string {
 	return fmt.Sprintf("%s:%d", a.Hostname, a.Port)
 }
+
+// NegotiatedDataChannel describes a DataChannel negotiated out-of-band.
+// Both Dialer and Listener create it with the same ID once a PeerConnection
+// is set up, so it is ready as soon as SCTP is up without any in-band
+// DCEP (RFC 8832) open message.
+type NegotiatedDataChannel struct {
+	Label string
+	ID    uint16
+
+	// Unordered allows the messages to be delivered out of order.
+	Unordered bool
+
+	// MaxPacketLifeTime and MaxRetransmits limits the retransmission of messages.
+	// At most one of them may be set. If neither is set, the DataChannel is reliable.
+	MaxPacketLifeTime *uint16
+	MaxRetransmits    *uint16
+}
+
+func (n *NegotiatedDataChannel) dataChannelInit() *webrtc.DataChannelInit {
+	ordered := !n.Unordered
+	negotiated := true
+	id := n.ID
+	return &webrtc.DataChannelInit{
+		Ordered:           &ordered,
+		MaxPacketLifeTime: n.MaxPacketLifeTime,
+		MaxRetransmits:    n.MaxRetransmits,
+		Negotiated:        &negotiated,
+		ID:                &id,
+	}
+}
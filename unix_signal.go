@@ -0,0 +1,95 @@
+package transportc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// UnixSignal implements Signal as a client of a UnixSignalServer listening on a
+// Unix domain socket on the same host, e.g. exposed to a sidecar container.
+//
+// It speaks the same protocol as HTTPSignal over the socket, see HTTPSignalServer.
+type UnixSignal struct {
+	*HTTPSignal
+
+	socketPath string
+}
+
+// NewUnixSignal creates a new UnixSignal connecting to the UnixSignalServer
+// listening on the socket at socketPath.
+func NewUnixSignal(socketPath string) *UnixSignal {
+	dialer := &net.Dialer{}
+	hs := NewHTTPSignal("http://unix/")
+	hs.Client = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+	return &UnixSignal{
+		HTTPSignal: hs,
+		socketPath: socketPath,
+	}
+}
+
+// SocketPath returns the path of the socket the UnixSignal connects to.
+func (us *UnixSignal) SocketPath() string {
+	return us.socketPath
+}
+
+// UnixSignalServer serves an HTTPSignalServer on a Unix domain socket as the
+// rendezvous for UnixSignal clients.
+type UnixSignalServer struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+// ListenUnixSignal creates a UnixSignalServer listening on a new socket at socketPath.
+// A stale socket left at socketPath by a previous process is replaced, any other
+// file is left untouched. If ttl is 0, SIGNAL_OFFER_TTL_DEFAULT is used.
+//
+// The socket file is removed when the UnixSignalServer is closed.
+func ListenUnixSignal(socketPath string, ttl time.Duration) (*UnixSignalServer, error) {
+	if info, err := os.Lstat(socketPath); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", socketPath)
+		}
+		if conn, err := net.Dial("unix", socketPath); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is in use", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on socket: %w", err)
+	}
+
+	uss := &UnixSignalServer{
+		listener: listener,
+		server: &http.Server{
+			Handler:           NewHTTPSignalServer(ttl),
+			ReadHeaderTimeout: HTTP_SIGNAL_POLL_DEFAULT,
+		},
+	}
+	go uss.server.Serve(listener) // skipcq: GSC-G104
+	return uss, nil
+}
+
+// Addr returns the address of the socket.
+func (uss *UnixSignalServer) Addr() net.Addr {
+	return uss.listener.Addr()
+}
+
+// Close closes the socket and all the connections of the clients.
+func (uss *UnixSignalServer) Close() error {
+	return uss.server.Close()
+}
@@ -0,0 +1,890 @@
+package transportc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gaukas/transportc/internal/utils"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	WEBSOCKET_SIGNAL_TIMEOUT_DEFAULT   = 10 * time.Second
+	WEBSOCKET_SIGNAL_POLL_DEFAULT      = time.Second
+	WEBSOCKET_SIGNAL_RECONNECT_MIN     = 100 * time.Millisecond
+	WEBSOCKET_SIGNAL_RECONNECT_MAX     = 5 * time.Second
+	WEBSOCKET_SIGNAL_CANDIDATE_BACKLOG = 64
+	WEBSOCKET_SIGNAL_OFFER_BACKLOG     = 8 // offers pushed and not read yet
+	WEBSOCKET_SIGNAL_ACK_BACKLOG       = 64
+	WEBSOCKET_SIGNAL_SESSION_TTL       = 3 * SIGNAL_OFFER_TTL_DEFAULT // how long the answer and candidates of an offer are accepted
+)
+
+var (
+	ErrSignalClosed       = errors.New("signal closed")
+	ErrSignalDisconnected = errors.New("signal disconnected")
+)
+
+const (
+	wsSignalHello     = "hello"     // client → server, identifies the client across reconnections
+	wsSignalListen    = "listen"    // client → server, requests the next offer
+	wsSignalOffer     = "offer"     // client → server: submits an offer; server → client: pushes an offer requested
+	wsSignalAnswer    = "answer"    // client → server: submits an answer; server → client: pushes an answer
+	wsSignalCandidate = "candidate" // relayed between the offerer and the answerer
+	wsSignalAck       = "ack"       // server → client, acknowledges an offer or answer
+)
+
+// wsSignalMessage is the JSON message exchanged between WebSocketSignal and WebSocketSignalServer.
+type wsSignalMessage struct {
+	Type        string `json:"type"`
+	Token       string `json:"token,omitempty"`
+	Seq         uint64 `json:"seq,omitempty"`
+	ID          uint64 `json:"id,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+	FromOfferer bool   `json:"from_offerer,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+type wsCandidateKey struct {
+	offerID     uint64
+	fromOfferer bool
+}
+
+// wsPendingAnswer accepts the answer to an offer submitted by this client until it expires.
+type wsPendingAnswer struct {
+	answer  chan wsSignalMessage
+	expires time.Time
+}
+
+// wsPendingCandidates accepts the candidates of the peer of an offer this client
+// submitted or read until it expires.
+type wsPendingCandidates struct {
+	candidates chan []byte
+	expires    time.Time
+}
+
+// WebSocketSignal implements TrickleSignal over a persistent WebSocket to a
+// WebSocketSignalServer, which pushes offers to listeners and answers to dialers
+// as soon as they are available.
+//
+// The WebSocket is reconnected automatically once broken.
+type WebSocketSignal struct {
+	url   string
+	token string
+
+	// Timeout is the max duration to wait for the server to acknowledge an offer or answer.
+	Timeout time.Duration
+
+	// PollTimeout is the max duration ReadOffer, ReadAnswer and ReadCandidate block
+	// before returning ErrOfferNotReady, ErrAnswerNotReady or ErrCandidateNotReady.
+	PollTimeout time.Duration
+
+	mutex      sync.Mutex
+	conn       *websocket.Conn
+	connected  chan struct{} // closed once connected, replaced when disconnected
+	broken     chan struct{} // closed once disconnected, replaced when connected
+	seq        uint64
+	acks       map[uint64]chan wsSignalMessage
+	readers    int // ReadOffer calls waiting
+	requested  int // offers requested and not pushed yet
+	answers    map[uint64]wsPendingAnswer
+	candidates map[wsCandidateKey]wsPendingCandidates
+
+	offers    chan wsSignalMessage
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewWebSocketSignal creates a new WebSocketSignal connecting to the
+// WebSocketSignalServer at the URL, e.g. wss://example.com/signal
+func NewWebSocketSignal(url string) *WebSocketSignal {
+	ws := &WebSocketSignal{
+		url:         url,
+		token:       fmt.Sprintf("%016x%016x", utils.RandUint64(), utils.RandUint64()),
+		Timeout:     WEBSOCKET_SIGNAL_TIMEOUT_DEFAULT,
+		PollTimeout: WEBSOCKET_SIGNAL_POLL_DEFAULT,
+		connected:   make(chan struct{}),
+		acks:        make(map[uint64]chan wsSignalMessage),
+		answers:     make(map[uint64]wsPendingAnswer),
+		candidates:  make(map[wsCandidateKey]wsPendingCandidates),
+		offers:      make(chan wsSignalMessage, WEBSOCKET_SIGNAL_OFFER_BACKLOG),
+		closed:      make(chan struct{}),
+	}
+	go ws.run()
+	return ws
+}
+
+// Offer implements Signal.Offer.
+// It submits the offer and waits for the server to assign its ID.
+func (ws *WebSocketSignal) Offer(offer []byte) (uint64, error) {
+	ack, err := ws.request(wsSignalMessage{Type: wsSignalOffer, Body: offer})
+	if err != nil {
+		return 0, err
+	}
+
+	ws.mutex.Lock()
+	ws.expire()
+	expires := time.Now().Add(WEBSOCKET_SIGNAL_SESSION_TTL)
+	ws.answers[ack.ID] = wsPendingAnswer{answer: make(chan wsSignalMessage, 1), expires: expires}
+	ws.addCandidates(wsCandidateKey{offerID: ack.ID, fromOfferer: false}, expires)
+	ws.mutex.Unlock()
+	return ack.ID, nil
+}
+
+// ReadOffer implements Signal.ReadOffer.
+// It requests an offer, unless enough are requested for the ReadOffer calls waiting,
+// and waits for the server to push one.
+func (ws *WebSocketSignal) ReadOffer() (uint64, []byte, error) {
+	select {
+	case offer := <-ws.offers:
+		return offer.ID, offer.Body, nil
+	default:
+	}
+
+	// Requested again on reconnection if not pushed by then
+	ws.mutex.Lock()
+	ws.readers++
+	request := ws.requestOffer()
+	ws.mutex.Unlock()
+	defer func() {
+		ws.mutex.Lock()
+		ws.readers--
+		ws.mutex.Unlock()
+	}()
+	if request {
+		if err := ws.write(wsSignalMessage{Type: wsSignalListen}); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	timer := time.NewTimer(ws.PollTimeout)
+	defer timer.Stop()
+	select {
+	case offer := <-ws.offers:
+		return offer.ID, offer.Body, nil
+	case <-timer.C:
+		return 0, nil, ErrOfferNotReady
+	case <-ws.closed:
+		return 0, nil, ErrSignalClosed
+	}
+}
+
+// Answer implements Signal.Answer.
+func (ws *WebSocketSignal) Answer(offerID uint64, answer []byte) error {
+	_, err := ws.request(wsSignalMessage{Type: wsSignalAnswer, ID: offerID, Body: answer})
+	return err
+}
+
+// ReadAnswer implements Signal.ReadAnswer.
+// It waits for the answer pushed for an offer submitted by this WebSocketSignal.
+func (ws *WebSocketSignal) ReadAnswer(offerID uint64) ([]byte, error) {
+	ws.mutex.Lock()
+	pending, ok := ws.answers[offerID]
+	ws.mutex.Unlock()
+	if !ok {
+		return nil, ErrInvalidOfferID
+	}
+
+	timer := time.NewTimer(ws.PollTimeout)
+	defer timer.Stop()
+	select {
+	case answer := <-pending.answer:
+		ws.mutex.Lock()
+		delete(ws.answers, offerID)
+		ws.mutex.Unlock()
+		if answer.Error != "" {
+			return nil, messageError(answer.Error)
+		}
+		return answer.Body, nil
+	case <-timer.C:
+		return nil, ErrAnswerNotReady
+	case <-ws.closed:
+		return nil, ErrSignalClosed
+	}
+}
+
+// Candidate implements TrickleSignal.Candidate.
+func (ws *WebSocketSignal) Candidate(offerID uint64, fromOfferer bool, candidate []byte) error {
+	return ws.write(wsSignalMessage{
+		Type:        wsSignalCandidate,
+		ID:          offerID,
+		FromOfferer: fromOfferer,
+		Body:        candidate,
+	})
+}
+
+// ReadCandidate implements TrickleSignal.ReadCandidate.
+func (ws *WebSocketSignal) ReadCandidate(offerID uint64, fromOfferer bool) ([]byte, error) {
+	key := wsCandidateKey{offerID: offerID, fromOfferer: fromOfferer}
+	ws.mutex.Lock()
+	pending, ok := ws.candidates[key]
+	ws.mutex.Unlock()
+	if !ok {
+		return nil, ErrInvalidOfferID
+	}
+
+	timer := time.NewTimer(ws.PollTimeout)
+	defer timer.Stop()
+	select {
+	case candidate := <-pending.candidates:
+		if len(candidate) == 0 { // end of candidates
+			ws.mutex.Lock()
+			delete(ws.candidates, key)
+			ws.mutex.Unlock()
+			return nil, io.EOF
+		}
+		return candidate, nil
+	case <-timer.C:
+		return nil, ErrCandidateNotReady
+	case <-ws.closed:
+		return nil, ErrSignalClosed
+	}
+}
+
+// Close closes the WebSocket and stops reconnecting.
+func (ws *WebSocketSignal) Close() error {
+	ws.closeOnce.Do(func() {
+		close(ws.closed)
+		ws.mutex.Lock()
+		if ws.conn != nil {
+			ws.conn.Close()
+		}
+		ws.mutex.Unlock()
+	})
+	return nil
+}
+
+// run keeps the WebSocket connected until closed.
+func (ws *WebSocketSignal) run() {
+	backoff := WEBSOCKET_SIGNAL_RECONNECT_MIN
+	for {
+		select {
+		case <-ws.closed:
+			return
+		default:
+		}
+
+		conn, err := ws.dial()
+		if err != nil {
+			select {
+			case <-ws.closed:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > WEBSOCKET_SIGNAL_RECONNECT_MAX {
+				backoff = WEBSOCKET_SIGNAL_RECONNECT_MAX
+			}
+			continue
+		}
+		backoff = WEBSOCKET_SIGNAL_RECONNECT_MIN
+
+		ws.mutex.Lock()
+		ws.conn = conn
+		ws.broken = make(chan struct{})
+		close(ws.connected)
+		ws.mutex.Unlock()
+
+		ws.readLoop(conn)
+		ws.disconnect(conn)
+	}
+}
+
+// dial connects to the server, identifies this client and requests the offers not pushed yet again.
+func (ws *WebSocketSignal) dial() (*websocket.Conn, error) {
+	origin := "http" + strings.TrimPrefix(ws.url, "ws")
+	conn, err := websocket.Dial(ws.url, "", origin)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := websocket.JSON.Send(conn, wsSignalMessage{Type: wsSignalHello, Token: ws.token}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	ws.mutex.Lock()
+	requested := ws.requested
+	ws.mutex.Unlock()
+	for i := 0; i < requested; i++ {
+		if err := websocket.JSON.Send(conn, wsSignalMessage{Type: wsSignalListen}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// disconnect marks conn as broken if it is the current connection.
+func (ws *WebSocketSignal) disconnect(conn *websocket.Conn) {
+	conn.Close()
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	if ws.conn == conn {
+		ws.conn = nil
+		ws.connected = make(chan struct{})
+		close(ws.broken)
+	}
+}
+
+func (ws *WebSocketSignal) readLoop(conn *websocket.Conn) {
+	for {
+		var msg wsSignalMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case wsSignalAck:
+			ws.mutex.Lock()
+			ackChan, ok := ws.acks[msg.Seq]
+			delete(ws.acks, msg.Seq)
+			ws.mutex.Unlock()
+			if ok {
+				ackChan <- msg
+			}
+		case wsSignalOffer:
+			key := wsCandidateKey{offerID: msg.ID, fromOfferer: true}
+			ws.mutex.Lock()
+			if ws.requested > 0 {
+				ws.requested--
+			}
+			ws.expire()
+			ws.addCandidates(key, time.Now().Add(WEBSOCKET_SIGNAL_SESSION_TTL))
+			ws.mutex.Unlock()
+			// Never block on the offers, acknowledgements and answers would be stuck behind
+			select {
+			case ws.offers <- msg:
+			default: // not requested
+				ws.mutex.Lock()
+				delete(ws.candidates, key)
+				ws.mutex.Unlock()
+			}
+			ws.mutex.Lock()
+			request := ws.requestOffer()
+			ws.mutex.Unlock()
+			if request {
+				go ws.write(wsSignalMessage{Type: wsSignalListen}) // skipcq: GSC-G104
+			}
+		case wsSignalAnswer:
+			ws.mutex.Lock()
+			pending, ok := ws.answers[msg.ID]
+			ws.mutex.Unlock()
+			if !ok { // not offered by this client, or expired
+				continue
+			}
+			select {
+			case pending.answer <- msg:
+			default: // duplicate answer
+			}
+		case wsSignalCandidate:
+			ws.mutex.Lock()
+			pending, ok := ws.candidates[wsCandidateKey{offerID: msg.ID, fromOfferer: msg.FromOfferer}]
+			ws.mutex.Unlock()
+			if !ok { // not offered or read by this client, or expired
+				continue
+			}
+			select {
+			case pending.candidates <- msg.Body:
+			default: // backlog full
+			}
+		}
+	}
+}
+
+// write sends the message, waiting up to Timeout for the WebSocket to be (re)connected.
+func (ws *WebSocketSignal) write(msg wsSignalMessage) error {
+	_, err := ws.send(msg)
+	return err
+}
+
+// send is write returning the channel closed once the WebSocket used is broken.
+func (ws *WebSocketSignal) send(msg wsSignalMessage) (<-chan struct{}, error) {
+	timer := time.NewTimer(ws.Timeout)
+	defer timer.Stop()
+	for {
+		ws.mutex.Lock()
+		conn, connected, broken := ws.conn, ws.connected, ws.broken
+		ws.mutex.Unlock()
+
+		if conn != nil {
+			err := websocket.JSON.Send(conn, msg)
+			if err == nil {
+				return broken, nil
+			}
+			ws.disconnect(conn)
+			continue
+		}
+
+		select {
+		case <-connected:
+		case <-timer.C:
+			return nil, ErrSignalDisconnected
+		case <-ws.closed:
+			return nil, ErrSignalClosed
+		}
+	}
+}
+
+// request sends the message and waits for the server to acknowledge it.
+// The message is sent again if the WebSocket breaks before the acknowledgement,
+// the server acknowledges a retransmitted message without processing it twice.
+func (ws *WebSocketSignal) request(msg wsSignalMessage) (wsSignalMessage, error) {
+	ackChan := make(chan wsSignalMessage, 1)
+	ws.mutex.Lock()
+	ws.seq++
+	msg.Seq = ws.seq
+	ws.acks[msg.Seq] = ackChan
+	ws.mutex.Unlock()
+
+	defer func() {
+		ws.mutex.Lock()
+		delete(ws.acks, msg.Seq)
+		ws.mutex.Unlock()
+	}()
+
+	timer := time.NewTimer(ws.Timeout)
+	defer timer.Stop()
+	for {
+		broken, err := ws.send(msg)
+		if err != nil {
+			return wsSignalMessage{}, err
+		}
+
+		select {
+		case ack := <-ackChan:
+			if ack.Error != "" {
+				return ack, messageError(ack.Error)
+			}
+			return ack, nil
+		case <-broken:
+		case <-timer.C:
+			return wsSignalMessage{}, fmt.Errorf("%s not acknowledged: %w", msg.Type, ErrSignalDisconnected)
+		case <-ws.closed:
+			return wsSignalMessage{}, ErrSignalClosed
+		}
+	}
+}
+
+// requestOffer reports whether to request one more offer for the ReadOffer calls
+// waiting, counting it as requested. Caller MUST hold the mutex.
+func (ws *WebSocketSignal) requestOffer() bool {
+	if ws.requested >= ws.readers-len(ws.offers) || ws.requested >= WEBSOCKET_SIGNAL_OFFER_BACKLOG {
+		return false
+	}
+	ws.requested++
+	return true
+}
+
+// addCandidates accepts the candidates for the key until expires, unless already accepted.
+// Caller MUST hold the mutex.
+func (ws *WebSocketSignal) addCandidates(key wsCandidateKey, expires time.Time) {
+	if _, ok := ws.candidates[key]; !ok {
+		ws.candidates[key] = wsPendingCandidates{
+			candidates: make(chan []byte, WEBSOCKET_SIGNAL_CANDIDATE_BACKLOG),
+			expires:    expires,
+		}
+	}
+}
+
+// expire stops accepting the answers and candidates of the offers expired.
+// Caller MUST hold the mutex.
+func (ws *WebSocketSignal) expire() {
+	now := time.Now()
+	for id, pending := range ws.answers {
+		if now.After(pending.expires) {
+			delete(ws.answers, id)
+		}
+	}
+	for key, pending := range ws.candidates {
+		if now.After(pending.expires) {
+			delete(ws.candidates, key)
+		}
+	}
+}
+
+// messageError maps the error string received from the server to the Signal errors.
+func messageError(msg string) error {
+	switch msg {
+	case ErrInvalidOfferID.Error():
+		return ErrInvalidOfferID
+	case ErrOfferQueueFull.Error():
+		return ErrOfferQueueFull
+	}
+	return errors.New(msg)
+}
+
+// WebSocketSignalServer is an http.Handler serving as the rendezvous for WebSocketSignal
+// clients. It pushes offers to the clients requesting them and answers to the clients offering,
+// and relays the trickled ICE candidates between them.
+//
+// Offers not read or answered and answers not read within the TTL expire. Messages
+// for a client temporarily disconnected are queued until it reconnects.
+type WebSocketSignalServer struct {
+	store SignalStore
+	ttl   time.Duration
+
+	mutex    sync.Mutex
+	clients  map[string]*wsSignalClient
+	routes   map[uint64]*wsSignalRoute
+	requeued []storedMessage // offers taken for a client that disconnected
+	changed  chan struct{}   // closed and replaced when an offer is requeued
+}
+
+// wsSignalClient is a client identified by its token, surviving reconnections.
+type wsSignalClient struct {
+	mutex    sync.Mutex
+	conn     *websocket.Conn
+	outbox   []wsSignalMessage          // queued while disconnected
+	acked    map[uint64]wsSignalMessage // recent acknowledgements by seq, for retransmitted requests
+	lastSeen time.Time
+}
+
+// wsSignalRoute tracks the peers of an offer to relay candidates between them.
+type wsSignalRoute struct {
+	offerer  *wsSignalClient
+	answerer *wsSignalClient
+	pending  []wsSignalMessage // candidates from the offerer before an answerer is known
+	expires  time.Time
+}
+
+// NewWebSocketSignalServer creates a new WebSocketSignalServer. If ttl is 0, SIGNAL_OFFER_TTL_DEFAULT is used.
+func NewWebSocketSignalServer(ttl time.Duration) *WebSocketSignalServer {
+	return NewWebSocketSignalServerWithStore(newMemorySignalStore(ttl), ttl)
+}
+
+// NewWebSocketSignalServerWithStore creates a new WebSocketSignalServer keeping the
+// offers and answers in the store. The TTL, SIGNAL_OFFER_TTL_DEFAULT if 0, bounds
+// how long the candidates of an offer are relayed and the messages for a
+// disconnected client are queued.
+func NewWebSocketSignalServerWithStore(store SignalStore, ttl time.Duration) *WebSocketSignalServer {
+	if ttl <= 0 {
+		ttl = SIGNAL_OFFER_TTL_DEFAULT
+	}
+	return &WebSocketSignalServer{
+		store:   store,
+		ttl:     ttl,
+		clients: make(map[string]*wsSignalClient),
+		routes:  make(map[uint64]*wsSignalRoute),
+		changed: make(chan struct{}),
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (wss *WebSocketSignalServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	websocket.Server{Handler: wss.handleConn}.ServeHTTP(w, r)
+}
+
+func (wss *WebSocketSignalServer) handleConn(conn *websocket.Conn) {
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(WEBSOCKET_SIGNAL_TIMEOUT_DEFAULT))
+	var hello wsSignalMessage
+	if err := websocket.JSON.Receive(conn, &hello); err != nil || hello.Type != wsSignalHello || hello.Token == "" {
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	client := wss.client(hello.Token)
+	client.connect(conn)
+	defer client.disconnect(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	requests := make(chan struct{}, WEBSOCKET_SIGNAL_OFFER_BACKLOG)
+	listening := false
+	for {
+		var msg wsSignalMessage
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			return
+		}
+
+		switch msg.Type {
+		case wsSignalListen:
+			select {
+			case requests <- struct{}{}:
+			default: // more requests than offers the client buffers
+			}
+			if !listening {
+				listening = true
+				go wss.pushOffers(ctx, client, requests)
+			}
+		case wsSignalOffer:
+			if client.reack(msg.Seq) {
+				continue
+			}
+			id, err := wss.store.PutOffer(msg.Body)
+			if err != nil {
+				client.ack(wsSignalMessage{Type: wsSignalAck, Seq: msg.Seq, Error: err.Error()})
+				continue
+			}
+			wss.mutex.Lock()
+			wss.routes[id] = &wsSignalRoute{
+				offerer: client,
+				expires: time.Now().Add(3 * wss.ttl),
+			}
+			wss.mutex.Unlock()
+			client.ack(wsSignalMessage{Type: wsSignalAck, Seq: msg.Seq, ID: id})
+			go wss.pushAnswer(id, client)
+		case wsSignalAnswer:
+			if client.reack(msg.Seq) {
+				continue
+			}
+			ack := wsSignalMessage{Type: wsSignalAck, Seq: msg.Seq, ID: msg.ID}
+			if err := wss.store.PutAnswer(msg.ID, msg.Body); err != nil {
+				ack.Error = err.Error()
+			}
+			client.ack(ack)
+		case wsSignalCandidate:
+			wss.relayCandidate(msg)
+		}
+	}
+}
+
+// pushOffers pushes an offer to the listening client for each request received
+// until ctx is done. Offers are never queued for a disconnected client: an offer
+// taken for a client found disconnected is requeued for the other listening clients.
+func (wss *WebSocketSignalServer) pushOffers(ctx context.Context, client *wsSignalClient, requests <-chan struct{}) {
+	for {
+		select {
+		case <-requests:
+		case <-ctx.Done():
+			return
+		}
+
+		offer, err := wss.nextOffer(ctx)
+		for err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			offer, err = wss.nextOffer(ctx)
+		}
+
+		if !client.sendConnected(wsSignalMessage{Type: wsSignalOffer, ID: offer.id, Body: offer.body}) {
+			wss.requeue(offer)
+			return
+		}
+
+		wss.mutex.Lock()
+		var pending []wsSignalMessage
+		if route, ok := wss.routes[offer.id]; ok {
+			route.answerer = client
+			pending = route.pending
+			route.pending = nil
+		}
+		wss.mutex.Unlock()
+
+		for _, candidate := range pending {
+			client.send(candidate)
+		}
+	}
+}
+
+// nextOffer returns the next requeued offer not expired, or else takes the next
+// offer from the store. It blocks until either is available or ctx is done.
+func (wss *WebSocketSignalServer) nextOffer(ctx context.Context) (storedMessage, error) {
+	for {
+		wss.mutex.Lock()
+		now := time.Now()
+		for len(wss.requeued) > 0 {
+			offer := wss.requeued[0]
+			wss.requeued = wss.requeued[1:]
+			if now.Before(offer.expires) {
+				wss.mutex.Unlock()
+				return offer, nil
+			}
+		}
+		changed := wss.changed
+		wss.mutex.Unlock()
+
+		// Stop waiting on the store once an offer is requeued
+		takeCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-changed:
+				cancel()
+			case <-takeCtx.Done():
+			}
+		}()
+		id, body, err := wss.store.TakeOffer(takeCtx)
+		cancel()
+		if err == nil {
+			return storedMessage{id: id, body: body, expires: time.Now().Add(wss.ttl)}, nil
+		}
+		if ctx.Err() != nil {
+			return storedMessage{}, err
+		}
+		select {
+		case <-changed:
+		default:
+			return storedMessage{}, err
+		}
+	}
+}
+
+// requeue hands an offer not delivered over to the other listening clients,
+// keeping its ID so the offering client still gets the answer.
+func (wss *WebSocketSignalServer) requeue(offer storedMessage) {
+	wss.mutex.Lock()
+	defer wss.mutex.Unlock()
+	wss.requeued = append(wss.requeued, offer)
+	close(wss.changed)
+	wss.changed = make(chan struct{})
+}
+
+// pushAnswer waits for the answer to the offer and pushes it to the offering client.
+func (wss *WebSocketSignalServer) pushAnswer(offerID uint64, client *wsSignalClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*wss.ttl)
+	defer cancel()
+
+	msg := wsSignalMessage{Type: wsSignalAnswer, ID: offerID}
+	answer, err := wss.store.TakeAnswer(ctx, offerID)
+	if err != nil {
+		msg.Error = ErrInvalidOfferID.Error() // expired
+	} else {
+		msg.Body = answer
+	}
+	client.send(msg)
+}
+
+func (wss *WebSocketSignalServer) relayCandidate(msg wsSignalMessage) {
+	wss.mutex.Lock()
+	route, ok := wss.routes[msg.ID]
+	if !ok {
+		wss.mutex.Unlock()
+		return
+	}
+
+	target := route.offerer
+	if msg.FromOfferer {
+		target = route.answerer
+		if target == nil {
+			route.pending = append(route.pending, msg)
+			wss.mutex.Unlock()
+			return
+		}
+	}
+	wss.mutex.Unlock()
+
+	target.send(msg)
+}
+
+// client returns the client with the token, creating one if not found.
+// Stale clients and routes are expired.
+func (wss *WebSocketSignalServer) client(token string) *wsSignalClient {
+	wss.mutex.Lock()
+	defer wss.mutex.Unlock()
+
+	now := time.Now()
+	for t, c := range wss.clients {
+		if c.expired(now, wss.ttl) {
+			delete(wss.clients, t)
+		}
+	}
+	for id, route := range wss.routes {
+		if now.After(route.expires) {
+			delete(wss.routes, id)
+		}
+	}
+
+	client, ok := wss.clients[token]
+	if !ok {
+		client = &wsSignalClient{acked: make(map[uint64]wsSignalMessage)}
+		wss.clients[token] = client
+	}
+	return client
+}
+
+// connect sets conn as the current connection and flushes the queued messages.
+func (c *wsSignalClient) connect(conn *websocket.Conn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.conn = conn
+	outbox := c.outbox
+	c.outbox = nil
+	for i, msg := range outbox {
+		if err := websocket.JSON.Send(conn, msg); err != nil {
+			c.conn = nil
+			c.outbox = outbox[i:]
+			return
+		}
+	}
+}
+
+func (c *wsSignalClient) disconnect(conn *websocket.Conn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn == conn {
+		c.conn = nil
+		c.lastSeen = time.Now()
+	}
+}
+
+// send sends the message or queues it until the client reconnects.
+func (c *wsSignalClient) send(msg wsSignalMessage) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.sendLocked(msg) {
+		c.outbox = append(c.outbox, msg)
+	}
+}
+
+// sendConnected sends the message if the client is connected, without queuing
+// it otherwise, and returns whether it was sent.
+func (c *wsSignalClient) sendConnected(msg wsSignalMessage) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.sendLocked(msg)
+}
+
+// sendLocked sends the message on the current connection, dropping the
+// connection on error. Caller MUST hold the mutex.
+func (c *wsSignalClient) sendLocked(msg wsSignalMessage) bool {
+	if c.conn == nil {
+		return false
+	}
+	if err := websocket.JSON.Send(c.conn, msg); err == nil {
+		return true
+	}
+	c.conn.Close()
+	c.conn = nil
+	c.lastSeen = time.Now()
+	return false
+}
+
+// ack sends the acknowledgement and remembers it for retransmitted requests.
+func (c *wsSignalClient) ack(msg wsSignalMessage) {
+	c.mutex.Lock()
+	c.acked[msg.Seq] = msg
+	delete(c.acked, msg.Seq-WEBSOCKET_SIGNAL_ACK_BACKLOG)
+	c.mutex.Unlock()
+	c.send(msg)
+}
+
+// reack sends the acknowledgement again if the request was already processed.
+func (c *wsSignalClient) reack(seq uint64) bool {
+	c.mutex.Lock()
+	msg, ok := c.acked[seq]
+	c.mutex.Unlock()
+	if ok {
+		c.send(msg)
+	}
+	return ok
+}
+
+func (c *wsSignalClient) expired(now time.Time, ttl time.Duration) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn == nil && !c.lastSeen.IsZero() && now.Sub(c.lastSeen) > ttl
+}
+
+var _ TrickleSignal = (*WebSocketSignal)(nil)